@@ -0,0 +1,188 @@
+package gelf
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultQueueSize = 10000
+	// defaultWorkerFlushInterval batches the writes of the workers when no
+	// gelf_flush_interval is set
+	defaultWorkerFlushInterval = "100ms"
+	queuePolicyBlock           = "block"
+	queuePolicyDrop            = "drop"
+)
+
+// asyncWriter hands messages to gelf_workers goroutines, each writing to
+// Graylog over its own connections with the writer of newWriter, so a slow
+// Graylog doesn't hold up the router and the writes of the workers overlap.
+// Each worker has a share of the gelf_queue_size queue; the messages of a
+// container always go to the same worker, which keeps their order. When the
+// queue of a worker is full, gelf_queue_policy blocks until there is room,
+// or drops the message.
+type asyncWriter struct {
+	workers []*asyncWorker
+	block   bool
+	metrics *router.Metrics
+	// next is the worker of the next message without a container
+	next int
+	// dropped counts the messages dropped since the queues were last full
+	dropped int
+	once    sync.Once
+	closed  error
+}
+
+// asyncWorker is a goroutine writing the messages of its queue
+type asyncWorker struct {
+	queue  chan *gelf.Message
+	writer messageWriter
+	done   chan struct{}
+}
+
+// asyncWorkers returns the number of workers of the gelf_workers option of
+// route, 0 when messages are written synchronously
+func asyncWorkers(route *router.Route) (int, error) {
+	s := httpclient.Option(route, "gelf_workers", "GELF_WORKERS")
+	if s == "" {
+		return 0, nil
+	}
+	workers, err := strconv.Atoi(s)
+	if err != nil || workers < 0 {
+		return 0, errors.New("gelf: bad gelf_workers: " + s)
+	}
+	if workers > 0 {
+		if transport := route.AdapterTransport("udp"); transport != "tcp" && transport != "tls" {
+			return 0, errors.New("gelf: gelf_workers needs the tcp or tls transport, not " + transport)
+		}
+	}
+	return workers, nil
+}
+
+// newAsyncWriter returns the writer of workers goroutines with writers of
+// newWriter. Each worker gets a copy of route, with its own directory in
+// gelf_buffer_dir beyond the first one.
+func newAsyncWriter(route *router.Route, workers int, newWriter func(*router.Route) (messageWriter, error)) (*asyncWriter, error) {
+	w := &asyncWriter{block: true, metrics: route.Metrics()}
+	switch s := httpclient.Option(route, "gelf_queue_policy", "GELF_QUEUE_POLICY"); s {
+	case "", queuePolicyBlock:
+	case queuePolicyDrop:
+		w.block = false
+	default:
+		return nil, errors.New("gelf: bad gelf_queue_policy: " + s)
+	}
+	size := defaultQueueSize
+	if s := httpclient.Option(route, "gelf_queue_size", "GELF_QUEUE_SIZE"); s != "" {
+		var err error
+		if size, err = strconv.Atoi(s); err != nil || size < workers {
+			return nil, errors.New("gelf: bad gelf_queue_size: " + s)
+		}
+	}
+	dir := httpclient.Option(route, "gelf_buffer_dir", "GELF_BUFFER_DIR")
+	for i := 0; i < workers; i++ {
+		workerRoute := *route
+		workerRoute.Options = make(map[string]string, len(route.Options)+2)
+		for k, v := range route.Options {
+			workerRoute.Options[k] = v
+		}
+		if httpclient.Option(route, "gelf_flush_interval", "GELF_FLUSH_INTERVAL") == "" {
+			workerRoute.Options["gelf_flush_interval"] = defaultWorkerFlushInterval
+		}
+		if dir != "" && i > 0 {
+			// the first worker keeps the directory of the synchronous
+			// writer, so what it buffered is sent
+			workerRoute.Options["gelf_buffer_dir"] = filepath.Join(dir, "worker-"+strconv.Itoa(i))
+		}
+		writer, err := newWriter(&workerRoute)
+		if err != nil {
+			w.Close()
+			return nil, err
+		}
+		worker := &asyncWorker{
+			queue:  make(chan *gelf.Message, size/workers),
+			writer: writer,
+			done:   make(chan struct{}),
+		}
+		go worker.run()
+		w.workers = append(w.workers, worker)
+	}
+	return w, nil
+}
+
+// run writes the messages of the queue until it is closed
+func (worker *asyncWorker) run() {
+	defer close(worker.done)
+	for m := range worker.queue {
+		if err := worker.writer.WriteMessage(m); err != nil {
+			log.Println("Graylog:", err)
+		}
+	}
+}
+
+// WriteMessage queues m for the next worker
+func (w *asyncWriter) WriteMessage(m *gelf.Message) error {
+	w.next = (w.next + 1) % len(w.workers)
+	return w.enqueue(w.workers[w.next], m)
+}
+
+// writeFrom queues m, a message of container, for the worker of container.
+// The writes of a worker are synchronous, so when its writer is a
+// multiWriter the worker balances the messages across the nodes.
+func (w *asyncWriter) writeFrom(container string, m *gelf.Message) error {
+	if container == "" {
+		return w.WriteMessage(m)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(container)) //nolint:errcheck
+	return w.enqueue(w.workers[h.Sum32()%uint32(len(w.workers))], m)
+}
+
+// enqueue adds m to the queue of worker, or drops it when the queue is full
+// and the policy is drop. Drops are logged when they start and stop.
+func (w *asyncWriter) enqueue(worker *asyncWorker, m *gelf.Message) error {
+	if w.block {
+		worker.queue <- m
+		return nil
+	}
+	select {
+	case worker.queue <- m:
+		if w.dropped > 0 {
+			log.Printf("gelf: dropped %d messages while the queue was full", w.dropped)
+			w.dropped = 0
+		}
+	default:
+		if w.dropped == 0 {
+			log.Println("gelf: queue is full, dropping messages")
+		}
+		w.dropped++
+		w.metrics.Dropped(1)
+	}
+	return nil
+}
+
+// Close writes the queued messages and closes the writers of the workers.
+// Their writers keep the messages they can't send, so it doesn't wait for a
+// Graylog that is down.
+func (w *asyncWriter) Close() error {
+	w.once.Do(func() {
+		for _, worker := range w.workers {
+			close(worker.queue)
+		}
+		for _, worker := range w.workers {
+			<-worker.done
+			if err := worker.writer.Close(); err != nil && w.closed == nil {
+				w.closed = err
+			}
+		}
+	})
+	return w.closed
+}
@@ -0,0 +1,120 @@
+package gelf
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// blockedWriter records the messages written once release is closed,
+// signalling started as each write starts
+type blockedWriter struct {
+	started chan struct{}
+	release chan struct{}
+	mu      sync.Mutex
+	shorts  []string
+}
+
+func (w *blockedWriter) WriteMessage(m *gelf.Message) error {
+	w.started <- struct{}{}
+	<-w.release
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.shorts = append(w.shorts, m.Short)
+	return nil
+}
+
+func (w *blockedWriter) Close() error {
+	return nil
+}
+
+func TestAsyncWriter(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var messages []<-chan string
+	for i := 0; i < 2; i++ {
+		messages = append(messages, receive(t, listener))
+	}
+	route := &router.Route{Adapter: "gelf+tcp", Address: listener.Addr().String(), Options: map[string]string{"gelf_workers": "2"}}
+	writer, err := newAsyncWriter(route, 2, func(route *router.Route) (messageWriter, error) {
+		if route.Options["gelf_flush_interval"] != defaultWorkerFlushInterval {
+			t.Errorf("expected the workers to batch their writes, got %v", route.Options)
+		}
+		return newTCPWriter(route, &countingTransport{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, short := range []string{"one", "two", "three", "four"} {
+		if err = writer.writeFrom("container", &gelf.Message{Version: "1.1", Host: "host", Short: short}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// the messages of a container are written in order by one worker
+	var got []string
+	for _, received := range messages {
+		for message := range received {
+			var m gelf.Message
+			if err := json.Unmarshal([]byte(message), &m); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, m.Short)
+		}
+	}
+	if len(got) != 4 || got[0] != "one" || got[3] != "four" {
+		t.Errorf("expected the 4 messages in order, got %q", got)
+	}
+}
+
+func TestAsyncWriterDrop(t *testing.T) {
+	blocked := &blockedWriter{started: make(chan struct{}, 5), release: make(chan struct{})}
+	route := &router.Route{ID: "async-drop", Options: map[string]string{"gelf_queue_size": "2", "gelf_queue_policy": "drop"}}
+	before := route.Metrics().Snapshot().Dropped
+	w, err := newAsyncWriter(route, 1, func(*router.Route) (messageWriter, error) { return blocked, nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the worker takes the first message, and waits on it with two queued
+	for _, short := range []string{"one", "two", "three", "four", "five"} {
+		if err = w.WriteMessage(&gelf.Message{Short: short}); err != nil {
+			t.Fatal(err)
+		}
+		if short == "one" {
+			<-blocked.started
+		}
+	}
+	close(blocked.release)
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(blocked.shorts) != 3 || blocked.shorts[0] != "one" || blocked.shorts[2] != "three" {
+		t.Errorf("expected the first 3 messages, got %q", blocked.shorts)
+	}
+	if dropped := route.Metrics().Snapshot().Dropped - before; dropped != 2 {
+		t.Errorf("expected 2 dropped messages, got %d", dropped)
+	}
+
+	for _, options := range []map[string]string{
+		{"gelf_workers": "-1"},
+		{"gelf_workers": "2", "gelf_queue_size": "1"},
+		{"gelf_workers": "2", "gelf_queue_policy": "spill"},
+	} {
+		if _, err := gelfWriter(&router.Route{Adapter: "gelf+tcp", Address: "127.0.0.1:1", Options: options}); err == nil {
+			t.Errorf("%v: expected an error", options)
+		}
+	}
+	if _, err := gelfWriter(&router.Route{Adapter: "gelf", Address: "127.0.0.1:12201", Options: map[string]string{"gelf_workers": "2"}}); err == nil {
+		t.Error("expected an error for gelf_workers with UDP")
+	}
+}
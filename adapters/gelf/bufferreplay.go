@@ -0,0 +1,182 @@
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// replayChunks is the number of writes per second of a throttled replay
+const replayChunks = 10
+
+// bufferReplayer is a writer that can send the messages it kept after they
+// were sent once more
+type bufferReplayer interface {
+	replayBuffer(since, until time.Time, throttle *replayThrottle) (int, error)
+}
+
+// replayThrottle spreads the messages of a replay to rate per second, across
+// all the writers of an adapter; a rate of 0 doesn't throttle
+type replayThrottle struct {
+	rate  int
+	start time.Time
+	sent  int
+}
+
+// chunk returns how many messages to write at once
+func (t *replayThrottle) chunk() int {
+	if t.rate < replayChunks {
+		return 1
+	}
+	return t.rate / replayChunks
+}
+
+// wait waits until n more messages may be written
+func (t *replayThrottle) wait(n int) {
+	t.sent += n
+	if t.rate <= 0 {
+		return
+	}
+	if delay := time.Until(t.start.Add(time.Duration(t.sent) * time.Second / time.Duration(t.rate))); delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// ReplayBuffer sends the messages sent between since and until, that the
+// writers kept for gelf_buffer_retention, once more, at most rate per
+// second, with their _replay field set. It implements router.BufferReplayer.
+func (a *GelfAdapter) ReplayBuffer(since, until time.Time, rate int) (int, error) {
+	r, ok := a.writer.(bufferReplayer)
+	if !ok {
+		return 0, router.ErrNoBuffer
+	}
+	return r.replayBuffer(since, until, &replayThrottle{rate: rate, start: time.Now()})
+}
+
+// replayBuffer replays the messages of each of the workers
+func (w *asyncWriter) replayBuffer(since, until time.Time, throttle *replayThrottle) (int, error) {
+	return replayAll(len(w.workers), func(i int) messageWriter { return w.workers[i].writer }, since, until, throttle)
+}
+
+// replayBuffer replays the messages each endpoint was sent to that endpoint
+func (w *multiWriter) replayBuffer(since, until time.Time, throttle *replayThrottle) (int, error) {
+	return replayAll(len(w.endpoints), func(i int) messageWriter { return w.endpoints[i].writer }, since, until, throttle)
+}
+
+// replayAll replays the messages of the n writers of writer
+func replayAll(n int, writer func(int) messageWriter, since, until time.Time, throttle *replayThrottle) (int, error) {
+	replayed := 0
+	err := router.ErrNoBuffer
+	for i := 0; i < n; i++ {
+		r, ok := writer(i).(bufferReplayer)
+		if !ok {
+			continue
+		}
+		count, replayErr := r.replayBuffer(since, until, throttle)
+		replayed += count
+		if replayErr == router.ErrNoBuffer {
+			continue
+		}
+		if err = replayErr; err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, err
+}
+
+// replayBuffer writes the messages sent between since and until once more,
+// in chunks of the throttle. Replayed messages aren't kept again.
+func (w *tcpWriter) replayBuffer(since, until time.Time, throttle *replayThrottle) (int, error) {
+	w.mu.Lock()
+	if w.spool == nil || w.spool.sent == nil {
+		w.mu.Unlock()
+		return 0, router.ErrNoBuffer
+	}
+	paths := w.spool.sentSince(since)
+	w.mu.Unlock()
+
+	var chunk bytes.Buffer
+	count, replayed := 0, 0
+	write := func() error {
+		throttle.wait(count)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.conn == nil {
+			return fmt.Errorf("gelf: %s is down", w.route.Address)
+		}
+		if _, err := w.conn.Write(chunk.Bytes()); err != nil {
+			w.conn.Close()
+			w.conn = nil
+			w.failed(time.Now(), err) //nolint:errcheck
+			return err
+		}
+		w.metrics.Sent(count, chunk.Len())
+		replayed += count
+		chunk.Reset()
+		count = 0
+		return nil
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			// dropped since, past the retention
+			continue
+		}
+		if err != nil {
+			return replayed, err
+		}
+		for len(data) > 0 {
+			end := bytes.IndexByte(data, 0)
+			if end < 0 {
+				break
+			}
+			message := data[:end]
+			data = data[end+1:]
+			if t, ok := sentTime(message); !ok || t.Before(since) || t.After(until) {
+				continue
+			}
+			chunk.Write(tagReplay(message))
+			chunk.WriteByte(0)
+			if count++; count >= throttle.chunk() {
+				if err := write(); err != nil {
+					return replayed, err
+				}
+			}
+		}
+	}
+	if count > 0 {
+		if err := write(); err != nil {
+			return replayed, err
+		}
+	}
+	log.Printf("gelf: replayed %d messages from the disk buffer to %s", replayed, w.route.Address)
+	return replayed, nil
+}
+
+// sentTime returns the timestamp of an encoded message
+func sentTime(message []byte) (time.Time, bool) {
+	var m struct {
+		Timestamp float64 `json:"timestamp"`
+	}
+	if err := json.Unmarshal(message, &m); err != nil {
+		return time.Time{}, false
+	}
+	sec := int64(m.Timestamp)
+	return time.Unix(sec, int64((m.Timestamp-float64(sec))*1e9)), true
+}
+
+// tagReplay returns the encoded message with its _replay field set
+func tagReplay(message []byte) []byte {
+	if bytes.Contains(message, []byte(`"_replay":`)) || len(message) < 2 || message[len(message)-1] != '}' {
+		return message
+	}
+	tagged := make([]byte, 0, len(message)+len(`,"_replay":true`))
+	tagged = append(tagged, message[:len(message)-1]...)
+	return append(tagged, `,"_replay":true}`...)
+}
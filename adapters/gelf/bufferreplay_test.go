@@ -0,0 +1,87 @@
+package gelf
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestReplayBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gelf-retention")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	transport := &flakyTransport{}
+	route := &router.Route{Adapter: "gelf+tcp", Address: "graylog:12201", Options: map[string]string{
+		"gelf_buffer_dir": dir, "gelf_buffer_retention": "1h",
+	}}
+	writer, err := newTCPWriter(route, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	for i, short := range []string{"one", "two", "three"} {
+		if err = writer.WriteMessage(&gelf.Message{Version: "1.1", Host: "host", Short: short, TimeUnix: float64(100 * (i + 1))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	adapter := &GelfAdapter{writer: writer}
+	n, err := adapter.ReplayBuffer(time.Unix(150, 0), time.Unix(300, 0), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 replayed messages, got %d", n)
+	}
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if len(transport.messages) != 5 {
+		t.Fatalf("expected the 3 messages and the 2 replayed, got %q", transport.messages)
+	}
+	for i, short := range []string{"two", "three"} {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(transport.messages[3+i]), &m); err != nil {
+			t.Fatal(err)
+		}
+		if m["short_message"] != short || m["_replay"] != true {
+			t.Errorf("expected %s replayed, got %v", short, m)
+		}
+	}
+}
+
+func TestReplayBufferWithoutRetention(t *testing.T) {
+	writer, err := newTCPWriter(&router.Route{Adapter: "gelf+tcp", Address: "graylog:12201", Options: map[string]string{}}, &flakyTransport{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if _, err = (&GelfAdapter{writer: writer}).ReplayBuffer(time.Time{}, time.Now(), 0); err != router.ErrNoBuffer {
+		t.Errorf("expected ErrNoBuffer, got %v", err)
+	}
+	if _, err = (&GelfAdapter{writer: &udpWriter{}}).ReplayBuffer(time.Time{}, time.Now(), 0); err != router.ErrNoBuffer {
+		t.Errorf("expected ErrNoBuffer from UDP, got %v", err)
+	}
+	if _, err = newTCPWriter(&router.Route{Options: map[string]string{"gelf_buffer_dir": os.TempDir(), "gelf_buffer_retention": "week"}}, &flakyTransport{}); err == nil {
+		t.Error("expected an error for a bad gelf_buffer_retention")
+	}
+}
+
+func TestReplayThrottle(t *testing.T) {
+	throttle := &replayThrottle{rate: 100, start: time.Now()}
+	if chunk := throttle.chunk(); chunk != 10 {
+		t.Errorf("expected chunks of 10, got %d", chunk)
+	}
+	for i := 0; i < 3; i++ {
+		throttle.wait(10)
+	}
+	if elapsed := time.Since(throttle.start); elapsed < 300*time.Millisecond {
+		t.Errorf("expected 30 messages to take 300ms at 100 per second, took %v", elapsed)
+	}
+}
@@ -0,0 +1,100 @@
+package gelf
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"strings"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// maxNumberDigits bounds the numbers coerced, as Elasticsearch stores
+// integers in 64 bits
+const maxNumberDigits = 18
+
+// typeCoercion sends the string fields that hold numbers or booleans, like
+// the labels replicas=3 or canary=true, as JSON numbers and booleans, so
+// Graylog can compare and aggregate them
+type typeCoercion struct {
+	// patterns match the names of the fields coerced, without their leading
+	// underscore; all fields are when there are none
+	patterns []string
+}
+
+// newTypeCoercion returns the coercion of the gelf_coerce_types option, true
+// or comma separated patterns of field names, or nil when it isn't set
+func newTypeCoercion(route *router.Route) (*typeCoercion, error) {
+	option := httpclient.Option(route, "gelf_coerce_types", "GELF_COERCE_TYPES")
+	switch option {
+	case "", "false":
+		return nil, nil
+	case "true":
+		return &typeCoercion{}, nil
+	}
+	patterns := splitList(option)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New("gelf: bad gelf_coerce_types: " + option)
+		}
+	}
+	return &typeCoercion{patterns: patterns}, nil
+}
+
+// fields coerces the values of fields
+func (c *typeCoercion) fields(fields map[string]interface{}) {
+	if c == nil {
+		return
+	}
+	for name, value := range fields {
+		if s, ok := value.(string); ok && c.matches(name) {
+			fields[name] = coerce(s)
+		}
+	}
+}
+
+func (c *typeCoercion) matches(name string) bool {
+	if len(c.patterns) == 0 {
+		return true
+	}
+	name = strings.TrimPrefix(name, "_")
+	for _, pattern := range c.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// coerce returns s as a boolean when it is true or false, as a number when
+// it is one written the way JSON writes it, or else as it is. Numbers with
+// leading zeros, like zip codes, and longer than maxNumberDigits, like IDs,
+// stay strings, as do other spellings like 1e3 or TRUE.
+func coerce(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	digits := strings.TrimPrefix(s, "-")
+	integer, fraction := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		integer, fraction = digits[:i], digits[i+1:]
+		if fraction == "" {
+			return s
+		}
+	}
+	if integer == "" || len(integer)+len(fraction) > maxNumberDigits || (integer[0] == '0' && len(integer) > 1) {
+		return s
+	}
+	for _, part := range []string{integer, fraction} {
+		for i := 0; i < len(part); i++ {
+			if part[i] < '0' || part[i] > '9' {
+				return s
+			}
+		}
+	}
+	return json.Number(s)
+}
@@ -0,0 +1,75 @@
+package gelf
+
+import (
+	"encoding/json"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestCoerce(t *testing.T) {
+	for _, tt := range []struct {
+		in  string
+		out interface{}
+	}{
+		{"3", json.Number("3")},
+		{"-12.5", json.Number("-12.5")},
+		{"0.25", json.Number("0.25")},
+		{"true", true},
+		{"false", false},
+		{"007", "007"},
+		{"1e3", "1e3"},
+		{"1.", "1."},
+		{".5", ".5"},
+		{"-", "-"},
+		{"TRUE", "TRUE"},
+		{"1234567890123456789", "1234567890123456789"},
+		{"1.2.3", "1.2.3"},
+		{"", ""},
+	} {
+		if out := coerce(tt.in); out != tt.out {
+			t.Errorf("%q: expected %#v, got %#v", tt.in, tt.out, out)
+		}
+	}
+}
+
+func TestTypeCoercion(t *testing.T) {
+	c, err := newTypeCoercion(&router.Route{Options: map[string]string{"gelf_coerce_types": "replicas,canary"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &docker.Container{ID: "123456", Name: "/web", Config: &docker.Config{
+		Labels: map[string]string{"gelf_replicas": "3", "gelf_canary": "true", "gelf_zone": "1"},
+	}}
+	cache := newFieldCache(map[string]interface{}{"_env": "prod"}, nil, nil, fieldLimit{}, c)
+	fields := cache.get(container)
+	for name, value := range map[string]interface{}{"_replicas": json.Number("3"), "_canary": true, "_zone": "1", "_container_id": "123456"} {
+		if fields.fields[name] != value {
+			t.Errorf("%s: expected %#v, got %#v", name, value, fields.fields[name])
+		}
+	}
+	msg := fields.message(&router.Message{Container: container, Fields: map[string]string{"replicas": "5"}}, "host")
+	if msg.Extra["_replicas"] != json.Number("5") {
+		t.Errorf("expected the message field to be coerced, got %#v", msg.Extra["_replicas"])
+	}
+
+	if _, err := newTypeCoercion(&router.Route{Options: map[string]string{"gelf_coerce_types": "[a"}}); err == nil {
+		t.Error("expected an error for a bad pattern")
+	}
+}
+
+func TestExtraNameDots(t *testing.T) {
+	for in, out := range map[string]string{
+		"app.version": "_app.version",
+		".hidden":     "__hidden",
+		"trailing.":   "_trailing_",
+		"a..b":        "_a._b",
+		"id":          "_id_",
+	} {
+		if name := extraName(in); name != out {
+			t.Errorf("%q: expected %q, got %q", in, out, name)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestEncodeMessage(t *testing.T) {
+	msg := &gelf.Message{
+		Version:  "1.1",
+		Host:     "host",
+		Short:    "quote \" backslash \\ tab \t bell \x07 bad \xff line \u2028 <html>",
+		TimeUnix: 1714557600.5,
+		Level:    gelf.LOG_WARNING,
+		Extra: map[string]interface{}{
+			"_created": time.Date(2024, 5, 1, 10, 0, 0, 5e8, time.UTC),
+			"_small":   1e-7,
+			"_big":     1e21,
+			"_count":   3,
+			"_number":  json.Number("2.50"),
+			"_ok":      true,
+			"_nil":     nil,
+			"_tags":    []string{"a"},
+		},
+	}
+	var buf bytes.Buffer
+	buf.WriteString("previous\x00")
+	if err := encodeMessage(&buf, msg); err != nil {
+		t.Fatal(err)
+	}
+	expected := `previous` + "\x00" + `{"version":"1.1","host":"host",` +
+		`"short_message":"quote \" backslash \\ tab \t bell \u0007 bad \ufffd line \u2028 <html>",` +
+		`"timestamp":1714557600.5,"level":4,"_big":1e+21,"_count":3,"_created":"2024-05-01T10:00:00.5Z",` +
+		`"_nil":null,"_number":2.50,"_ok":true,"_small":1e-7,"_tags":["a"]}`
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, buf.String())
+	}
+	var library bytes.Buffer
+	if err := msg.MarshalJSONBuf(&library); err != nil {
+		t.Fatal(err)
+	}
+	var got, want map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes()[len("previous\x00"):], &got); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(library.Bytes(), &want); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range want {
+		if s, ok := value.(string); ok && got[name] != s {
+			t.Errorf("%s: expected %q like go-gelf, got %q", name, s, got[name])
+		}
+	}
+
+	msg.Extra["_nan"] = math.NaN()
+	before := buf.Len()
+	if err := encodeMessage(&buf, msg); err == nil || buf.Len() != before {
+		t.Errorf("expected an error for NaN and the buffer left as it was, got %v", err)
+	}
+}
+
+func benchmarkMessage() *router.Message {
+	return &router.Message{
+		Container: &docker.Container{
+			ID:      "8dfafdbc3a40a8ad0ebc2c3d7cbbe0ab3d6e35b2c1e0f1a1e7d4b5c6a7f8e9d0",
+			Name:    "/shop_web.2.qbq8ecsxhnlkz6rx0dx2dvxk4",
+			Image:   "sha256:6b3b2c1a",
+			Created: time.Now(),
+			Config: &docker.Config{
+				Image: "registry.example.com/shop/web:1.2.3",
+				Cmd:   []string{"/app", "--port", "8080"},
+				Labels: map[string]string{
+					"com.docker.stack.namespace":    "shop",
+					"com.docker.swarm.service.name": "shop_web",
+					"com.docker.swarm.task.id":      "qbq8ecsxhnlkz6rx0dx2dvxk4",
+					"com.docker.swarm.task.name":    "shop_web.2.qbq8ecsxhnlkz6rx0dx2dvxk4",
+					"gelf_team":                     "core",
+				},
+			},
+		},
+		Source: "stdout",
+		Data:   `192.168.1.10 - - [01/May/2024:10:00:00 +0000] "GET /cart HTTP/1.1" 200 512 "-" "Mozilla/5.0"`,
+		Time:   time.Now(),
+		Fields: map[string]string{"stats_cpu_percent": "1.25", "stats_memory_bytes": "52428800"},
+	}
+}
+
+func BenchmarkNewMessage(b *testing.B) {
+	m := benchmarkMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		newMessage(m, "host", nil, nil)
+	}
+}
+
+// BenchmarkCachedMessage is the message and encoding of the adapter, with
+// the fields of the container from the cache
+func BenchmarkCachedMessage(b *testing.B) {
+	m := benchmarkMessage()
+	cache := newFieldCache(nil, nil, nil, fieldLimit{}, nil)
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := encodeMessage(&buf, cache.get(m.Container).message(m, "host")); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeMessage(b *testing.B) {
+	msg := newMessage(benchmarkMessage(), "host", nil, nil)
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := encodeMessage(&buf, msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMarshalJSONBuf is the encoding of go-gelf, as used for UDP
+func BenchmarkMarshalJSONBuf(b *testing.B) {
+	msg := newMessage(benchmarkMessage(), "host", nil, nil)
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if err := msg.MarshalJSONBuf(&buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package gelf
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	balanceFailover   = "failover"
+	balanceRoundRobin = "roundrobin"
+	// balanceRoundRobinAlt is the spelling of roundrobin with a dash
+	balanceRoundRobinAlt = "round-robin"
+	balanceHash          = "hash"
+	defaultHealthTimeout = 5 * time.Second
+	defaultHealthPeriod  = 10 * time.Second
+)
+
+// endpoint is one of the Graylog nodes of a multiWriter
+type endpoint struct {
+	address string
+	writer  messageWriter
+	up      bool
+	// downUntil is when an endpoint that failed a write is tried again when
+	// there is no health check to bring it back
+	downUntil time.Time
+}
+
+// multiWriter sends messages to one of several Graylog nodes, failing over
+// to the next one when a node fails a write or its health check. Each message
+// goes to a single node, so the chunks of a UDP message stay together.
+type multiWriter struct {
+	balance  string
+	health   func(address string) error
+	interval time.Duration
+	// sticky keeps failover on the node that took the last message once the
+	// first is back, as gelf_failback=false
+	sticky bool
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int
+	// active is the node sticky failover starts at
+	active int
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// newMultiWriter returns a writer for the addresses of the route, which may
+// list several separated by commas, and those in the gelf_endpoints option
+func newMultiWriter(route *router.Route, endpoints string) (*multiWriter, error) {
+	w := &multiWriter{
+		balance:  httpclient.Option(route, "gelf_balance", "GELF_BALANCE"),
+		interval: defaultHealthPeriod,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	switch w.balance {
+	case "":
+		w.balance = balanceFailover
+	case balanceRoundRobinAlt:
+		w.balance = balanceRoundRobin
+	}
+	if w.balance != balanceFailover && w.balance != balanceRoundRobin && w.balance != balanceHash {
+		return nil, errors.New("gelf: bad gelf_balance: " + w.balance)
+	}
+	if s := httpclient.Option(route, "gelf_health_interval", "GELF_HEALTH_INTERVAL"); s != "" {
+		var err error
+		if w.interval, err = time.ParseDuration(s); err != nil || w.interval <= 0 {
+			return nil, errors.New("gelf: bad gelf_health_interval: " + s)
+		}
+	}
+	if s := httpclient.Option(route, "gelf_failback", "GELF_FAILBACK"); s != "" {
+		failback, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, errors.New("gelf: bad gelf_failback: " + s)
+		}
+		w.sticky = !failback
+	}
+	var err error
+	if w.health, err = healthCheck(httpclient.Option(route, "gelf_health", "GELF_HEALTH")); err != nil {
+		return nil, err
+	}
+	addresses := strings.FieldsFunc(route.Address+","+endpoints, func(r rune) bool {
+		return r == '|' || r == ','
+	})
+	for _, address := range addresses {
+		endpointRoute := *route
+		endpointRoute.Address = strings.TrimSpace(address)
+		// a node that is down fails its writes, so they go to the next node
+		// rather than wait for it to reconnect
+		endpointRoute.Options = map[string]string{"gelf_reconnect_buffer": "0"}
+		for k, v := range route.Options {
+			if k != "gelf_reconnect_buffer" {
+				endpointRoute.Options[k] = v
+			}
+		}
+		writer, err := singleWriter(&endpointRoute)
+		if err != nil {
+			for _, e := range w.endpoints {
+				e.writer.Close()
+			}
+			return nil, err
+		}
+		w.endpoints = append(w.endpoints, &endpoint{address: endpointRoute.Address, writer: writer, up: true})
+	}
+	go w.checkEvery()
+	return w, nil
+}
+
+// healthCheck returns the health check configured with gelf_health: tcp
+// connects to the endpoint address, tcp:PORT to the endpoint host on PORT,
+// and lbstatus:PORT asks the Graylog API on PORT for its load balancer
+// status. Without one endpoints are only marked down by failed writes.
+func healthCheck(s string) (func(address string) error, error) {
+	kind, port := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		kind, port = s[:i], s[i+1:]
+	}
+	withPort := func(address string) string {
+		if port == "" {
+			return address
+		}
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		return net.JoinHostPort(host, port)
+	}
+	switch {
+	case s == "" || s == "none":
+		return nil, nil
+	case kind == "tcp":
+		return func(address string) error {
+			conn, err := net.DialTimeout("tcp", withPort(address), defaultHealthTimeout)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}, nil
+	case kind == "lbstatus" && port != "":
+		client := &http.Client{Timeout: defaultHealthTimeout}
+		return func(address string) error {
+			resp, err := client.Get("http://" + withPort(address) + "/api/system/lbstatus")
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return errors.New("load balancer status " + resp.Status)
+			}
+			return nil
+		}, nil
+	}
+	return nil, errors.New("gelf: bad gelf_health: " + s)
+}
+
+// pick returns the endpoints in the order to try them: the ones that are up
+// first, starting at the next one for round robin, at the one the container
+// hashes to or, for sticky failover, at the one that took the last message,
+// then the ones that are down as a last resort. A container whose
+// endpoint is down moves to the next one that is up, and back once it
+// recovers, so the containers of the other endpoints stay where they are.
+func (w *multiWriter) pick(container string) []*endpoint {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := time.Now()
+	start := 0
+	switch w.balance {
+	case balanceFailover:
+		if w.sticky {
+			start = w.active
+		}
+	case balanceRoundRobin:
+		start = w.next
+		w.next = (w.next + 1) % len(w.endpoints)
+	case balanceHash:
+		h := fnv.New32a()
+		h.Write([]byte(container)) //nolint:errcheck
+		start = int(h.Sum32() % uint32(len(w.endpoints)))
+	}
+	var up, down []*endpoint
+	for i := range w.endpoints {
+		e := w.endpoints[(start+i)%len(w.endpoints)]
+		if !e.up && w.health == nil && now.After(e.downUntil) {
+			e.up = true
+		}
+		if e.up {
+			up = append(up, e)
+		} else {
+			down = append(down, e)
+		}
+	}
+	return append(up, down...)
+}
+
+func (w *multiWriter) setUp(e *endpoint, up bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if e.up && !up {
+		log.Printf("gelf: endpoint %s is down: %v", e.address, err)
+		e.downUntil = time.Now().Add(w.interval)
+	} else if !e.up && up {
+		log.Printf("gelf: endpoint %s is up again", e.address)
+	}
+	e.up = up
+	if up && w.sticky {
+		for i := range w.endpoints {
+			if w.endpoints[i] == e {
+				w.active = i
+			}
+		}
+	}
+}
+
+// WriteMessage sends m to the first endpoint that takes it
+func (w *multiWriter) WriteMessage(m *gelf.Message) error {
+	return w.writeFrom("", m)
+}
+
+// writeFrom sends m from container to the first endpoint that takes it,
+// starting at the endpoint container hashes to when balancing by hash
+func (w *multiWriter) writeFrom(container string, m *gelf.Message) error {
+	var err error
+	for _, e := range w.pick(container) {
+		if err = e.writer.WriteMessage(m); err == nil {
+			w.setUp(e, true, nil)
+			return nil
+		}
+		w.setUp(e, false, err)
+	}
+	return err
+}
+
+func (w *multiWriter) checkEvery() {
+	defer close(w.done)
+	if w.health == nil {
+		return
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.mu.Lock()
+			endpoints := append([]*endpoint(nil), w.endpoints...)
+			w.mu.Unlock()
+			for _, e := range endpoints {
+				err := w.health(e.address)
+				w.setUp(e, err == nil, err)
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// Close stops the health checks and closes the writers of all endpoints
+func (w *multiWriter) Close() error {
+	select {
+	case <-w.quit:
+		return nil
+	default:
+	}
+	close(w.quit)
+	<-w.done
+	var err error
+	for _, e := range w.endpoints {
+		if closeErr := e.writer.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package gelf
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// fakeWriter counts the messages it takes, or fails while broken
+type fakeWriter struct {
+	broken   bool
+	messages int
+	last     *gelf.Message
+}
+
+func (w *fakeWriter) WriteMessage(m *gelf.Message) error {
+	if w.broken {
+		return errors.New("connection refused")
+	}
+	w.messages++
+	w.last = m
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	return nil
+}
+
+func newFakeMultiWriter(balance string, writers ...*fakeWriter) *multiWriter {
+	w := &multiWriter{balance: balance, interval: time.Hour, quit: make(chan struct{}), done: make(chan struct{})}
+	for i, writer := range writers {
+		w.endpoints = append(w.endpoints, &endpoint{address: string(rune('a' + i)), writer: writer, up: true})
+	}
+	close(w.done)
+	return w
+}
+
+func TestMultiWriterFailover(t *testing.T) {
+	first, second := &fakeWriter{}, &fakeWriter{}
+	w := newFakeMultiWriter(balanceFailover, first, second)
+	w.WriteMessage(&gelf.Message{}) //nolint:errcheck
+	first.broken = true
+	for i := 0; i < 3; i++ {
+		if err := w.WriteMessage(&gelf.Message{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if first.messages != 1 || second.messages != 3 {
+		t.Errorf("expected the second endpoint to take over, got %d and %d", first.messages, second.messages)
+	}
+	if w.endpoints[0].up {
+		t.Error("expected the failed endpoint to be down")
+	}
+
+	// without a health check the endpoint is tried again after the interval
+	first.broken = false
+	w.endpoints[0].downUntil = time.Now().Add(-time.Second)
+	w.WriteMessage(&gelf.Message{}) //nolint:errcheck
+	if first.messages != 2 {
+		t.Errorf("expected the first endpoint to be used again, got %d", first.messages)
+	}
+
+	second.broken = true
+	first.broken = true
+	if err := w.WriteMessage(&gelf.Message{}); err == nil {
+		t.Error("expected error when all endpoints fail")
+	}
+}
+
+func TestMultiWriterStickyFailover(t *testing.T) {
+	first, second := &fakeWriter{}, &fakeWriter{}
+	w := newFakeMultiWriter(balanceFailover, first, second)
+	w.sticky = true
+	first.broken = true
+	w.WriteMessage(&gelf.Message{}) //nolint:errcheck
+	first.broken = false
+	w.endpoints[0].downUntil = time.Now().Add(-time.Second)
+	w.WriteMessage(&gelf.Message{}) //nolint:errcheck
+	if first.messages != 0 || second.messages != 2 {
+		t.Errorf("expected the second endpoint to keep the messages, got %d and %d", first.messages, second.messages)
+	}
+}
+
+func TestMultiWriterAddresses(t *testing.T) {
+	var addresses []string
+	for i := 0; i < 2; i++ {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer server.Close()
+		addresses = append(addresses, strings.TrimPrefix(server.URL, "http://"))
+	}
+	writer, err := gelfWriter(&router.Route{
+		Adapter: "gelf+http",
+		Address: strings.Join(addresses, ","),
+		Options: map[string]string{"gelf_balance": "round-robin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	w, ok := writer.(*multiWriter)
+	if !ok || len(w.endpoints) != 2 || w.endpoints[1].address != addresses[1] || w.balance != balanceRoundRobin {
+		t.Fatalf("expected a round robin writer for %v, got %+v", addresses, writer)
+	}
+}
+
+func TestMultiWriterRoundRobin(t *testing.T) {
+	writers := []*fakeWriter{{}, {}, {}}
+	w := newFakeMultiWriter(balanceRoundRobin, writers...)
+	for i := 0; i < 6; i++ {
+		w.WriteMessage(&gelf.Message{}) //nolint:errcheck
+	}
+	for i, writer := range writers {
+		if writer.messages != 2 {
+			t.Errorf("expected endpoint %d to take 2 messages, got %d", i, writer.messages)
+		}
+	}
+}
+
+func TestMultiWriterHash(t *testing.T) {
+	writers := []*fakeWriter{{}, {}, {}}
+	w := newFakeMultiWriter(balanceHash, writers...)
+	adapter := &GelfAdapter{writer: w, route: &router.Route{ID: "hash"}}
+	endpointOf := func(id string) int {
+		before := make([]int, len(writers))
+		for i, writer := range writers {
+			before[i] = writer.messages
+		}
+		logstream := make(chan *router.Message, 1)
+		logstream <- &router.Message{Container: &docker.Container{ID: id}, Data: "hello"}
+		close(logstream)
+		adapter.Stream(logstream)
+		for i, writer := range writers {
+			if writer.messages != before[i] {
+				return i
+			}
+		}
+		return -1
+	}
+
+	ids := []string{"a1", "b2", "c3", "d4", "e5", "f6", "g7", "h8"}
+	sticky := make(map[string]int)
+	for _, id := range ids {
+		sticky[id] = endpointOf(id)
+		for i := 0; i < 3; i++ {
+			if got := endpointOf(id); got != sticky[id] {
+				t.Fatalf("expected container %s to stay on endpoint %d, got %d", id, sticky[id], got)
+			}
+		}
+	}
+
+	failed := sticky[ids[0]]
+	writers[failed].broken = true
+	for _, id := range ids {
+		got := endpointOf(id)
+		if sticky[id] == failed && got == failed {
+			t.Errorf("expected container %s to move off the failed endpoint", id)
+		}
+		if sticky[id] != failed && got != sticky[id] {
+			t.Errorf("expected container %s to stay on endpoint %d, got %d", id, sticky[id], got)
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	check, err := healthCheck("tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = check(address); err != nil {
+		t.Errorf("expected a listening endpoint to be healthy, got %v", err)
+	}
+	listener.Close()
+	if err = check(address); err == nil {
+		t.Error("expected a closed endpoint to be unhealthy")
+	}
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/system/lbstatus" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	if check, err = healthCheck("lbstatus:" + port); err != nil {
+		t.Fatal(err)
+	}
+	if err = check("127.0.0.1:12201"); err != nil {
+		t.Errorf("expected an alive node to be healthy, got %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err = check("127.0.0.1:12201"); err == nil {
+		t.Error("expected a dead node to be unhealthy")
+	}
+
+	for _, bad := range []string{"ping", "lbstatus"} {
+		if _, err = healthCheck(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package gelf
+
+import (
+	"errors"
+	"path"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// maxEnvContainers bounds the containers whose fields are kept
+const maxEnvContainers = 1024
+
+// envFields adds the environment variables of containers matching an
+// allowlist as extra fields, so versions and commits can be sent without
+// labelling the containers. Only allowed variables are sent, as the
+// environment often holds secrets. The fields of each container are built
+// once. It is only used from the Stream goroutine of its adapter.
+type envFields struct {
+	patterns   []string
+	static     map[string]interface{}
+	containers map[string]map[string]interface{}
+}
+
+// newEnvFields returns the fields for the gelf_env option, comma separated
+// patterns of the variable names, or nil when it isn't set
+func newEnvFields(route *router.Route, static map[string]interface{}) (*envFields, error) {
+	option := httpclient.Option(route, "gelf_env", "GELF_ENV")
+	patterns := splitList(option)
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New("gelf: bad gelf_env: " + option)
+		}
+	}
+	return &envFields{patterns: patterns, static: static, containers: make(map[string]map[string]interface{})}, nil
+}
+
+// fields returns the static fields of the route with the allowed variables
+// of container
+func (e *envFields) fields(container *docker.Container) map[string]interface{} {
+	if container == nil || container.Config == nil {
+		return e.static
+	}
+	if fields, ok := e.containers[container.ID]; ok {
+		return fields
+	}
+	if len(e.containers) >= maxEnvContainers {
+		e.containers = make(map[string]map[string]interface{})
+	}
+	fields := make(map[string]interface{}, len(e.static))
+	for name, value := range e.static {
+		fields[name] = value
+	}
+	for _, kv := range container.Config.Env {
+		name, value := kv, ""
+		if i := strings.IndexByte(kv, '='); i >= 0 {
+			name, value = kv[:i], kv[i+1:]
+		}
+		if e.allowed(name) {
+			fields[extraName(strings.ToLower(name))] = value
+		}
+	}
+	e.containers[container.ID] = fields
+	return fields
+}
+
+func (e *envFields) allowed(name string) bool {
+	for _, pattern := range e.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
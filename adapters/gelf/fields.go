@@ -0,0 +1,230 @@
+package gelf
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// maxFieldContainers bounds the containers whose fields are kept
+	maxFieldContainers = 1024
+	defaultMaxFields   = 100
+	// defaultMaxFieldBytes is the longest term Elasticsearch indexes, so
+	// Graylog can't store longer values anyway
+	defaultMaxFieldBytes = 32766
+)
+
+// fieldLimit guards the messages of a container against its metadata, like
+// the dozens of huge labels some orchestrators add, which would otherwise be
+// sent with every message
+type fieldLimit struct {
+	// fields is the most fields of a container; beyond it those of labels
+	// are dropped, in the order of their names
+	fields int
+	// bytes is what string values are cut to
+	bytes int
+}
+
+// newFieldLimit returns the limit of the gelf_max_fields and
+// gelf_max_field_bytes options of route
+func newFieldLimit(route *router.Route) (fieldLimit, error) {
+	l := fieldLimit{fields: defaultMaxFields, bytes: defaultMaxFieldBytes}
+	if s := httpclient.Option(route, "gelf_max_fields", "GELF_MAX_FIELDS"); s != "" {
+		var err error
+		if l.fields, err = strconv.Atoi(s); err != nil || l.fields < 0 {
+			return l, errors.New("gelf: bad gelf_max_fields: " + s)
+		}
+	}
+	if s := httpclient.Option(route, "gelf_max_field_bytes", "GELF_MAX_FIELD_BYTES"); s != "" {
+		var err error
+		if l.bytes, err = strconv.Atoi(s); err != nil || l.bytes < 0 {
+			return l, errors.New("gelf: bad gelf_max_field_bytes: " + s)
+		}
+	}
+	return l, nil
+}
+
+// containerFields are the extra fields of the messages of a container that
+// don't change during its life, like its name, image and labels, with their
+// encoding, so they aren't rebuilt and encoded for each message
+type containerFields struct {
+	container *docker.Container
+	fields    map[string]interface{}
+	// raw is the JSON object of fields, nil when there are none or they
+	// can't be encoded, in which case they are sent from the map
+	raw []byte
+	// coerce coerces the fields of the messages
+	coerce *typeCoercion
+}
+
+// newContainerFields returns the fields of container, with the static fields
+// and those of the labels, within limit. All but the fields of the container
+// itself are coerced. container is nil for messages without one.
+func newContainerFields(container *docker.Container, static map[string]interface{}, labels *labelFields, limit fieldLimit, coerce *typeCoercion) *containerFields {
+	c := &containerFields{container: container, fields: make(map[string]interface{}, len(static)+16), coerce: coerce}
+	for name, value := range static {
+		c.fields[name] = value
+	}
+	coerce.fields(c.fields)
+	if container != nil {
+		GelfMessage{Message: &router.Message{Container: container}}.addContainerFields(c.fields)
+		extra := make(map[string]interface{})
+		if container.Config != nil {
+			labels.add(container.Config.Labels, extra)
+		}
+		coerce.fields(extra)
+		if dropped, cut := limit.add(c.fields, extra); dropped > 0 || cut > 0 {
+			log.Printf("gelf: container %s: dropped %d label fields beyond gelf_max_fields, cut %d values to gelf_max_field_bytes",
+				strings.TrimPrefix(container.Name, "/"), dropped, cut)
+			if dropped > 0 {
+				c.fields["_fields_dropped"] = dropped
+			}
+		}
+	}
+	if len(c.fields) == 0 {
+		return c
+	}
+	names := make([]string, 0, len(c.fields))
+	for name := range c.fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	b := []byte{'{'}
+	for i, name := range names {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendString(b, name)
+		b = append(b, ':')
+		var err error
+		if b, err = appendValue(b, c.fields[name]); err != nil {
+			return c
+		}
+	}
+	c.raw = append(b, '}')
+	return c
+}
+
+// fieldCache keeps the containerFields of the containers of an adapter. It
+// is only used from the Stream goroutine of its adapter.
+type fieldCache struct {
+	static     map[string]interface{}
+	env        *envFields
+	labels     *labelFields
+	containers map[string]*containerFields
+	limit      fieldLimit
+	coerce     *typeCoercion
+	// none are the fields of messages without a container
+	none *containerFields
+}
+
+func newFieldCache(static map[string]interface{}, env *envFields, labels *labelFields, limit fieldLimit, coerce *typeCoercion) *fieldCache {
+	return &fieldCache{static: static, env: env, labels: labels, limit: limit, coerce: coerce, containers: make(map[string]*containerFields)}
+}
+
+// get returns the fields of container. They are built again when the pump
+// has inspected the container again, as after it was renamed.
+func (c *fieldCache) get(container *docker.Container) *containerFields {
+	if c == nil {
+		return nil
+	}
+	if container == nil {
+		if c.none == nil {
+			c.none = newContainerFields(nil, c.static, nil, c.limit, c.coerce)
+		}
+		return c.none
+	}
+	if fields, ok := c.containers[container.ID]; ok && fields.container == container {
+		return fields
+	}
+	if len(c.containers) >= maxFieldContainers {
+		c.containers = make(map[string]*containerFields)
+	}
+	static := c.static
+	if c.env != nil {
+		static = c.env.fields(container)
+	}
+	fields := newContainerFields(container, static, c.labels, c.limit, c.coerce)
+	c.containers[container.ID] = fields
+	return fields
+}
+
+// message returns the GELF message for m, like newMessage, with the encoded
+// fields of its container. Only the fields of the message are in Extra,
+// unless they replace those of the container.
+func (c *containerFields) message(m *router.Message, host string) *gelf.Message {
+	if c == nil {
+		return newMessage(m, host, nil, nil)
+	}
+	msg := baseMessage(m, host)
+	msg.Extra = make(map[string]interface{}, len(m.Fields)+1)
+	addMessageFields(m, msg.Extra)
+	c.coerce.fields(msg.Extra)
+	if c.raw == nil || c.replaced(msg.Extra) {
+		for name, value := range c.fields {
+			if _, ok := msg.Extra[name]; !ok {
+				msg.Extra[name] = value
+			}
+		}
+		return msg
+	}
+	msg.RawExtra = c.raw
+	return msg
+}
+
+// add adds the fields of labels to fields, which they may replace, up to the
+// most fields, and cuts the string values of all of them. It returns the
+// number of label fields dropped and of values cut.
+func (l fieldLimit) add(fields, labels map[string]interface{}) (int, int) {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dropped := 0
+	for _, name := range names {
+		if _, ok := fields[name]; !ok && l.fields > 0 && len(fields) >= l.fields {
+			dropped++
+			continue
+		}
+		fields[name] = labels[name]
+	}
+	cut := 0
+	if l.bytes > 0 {
+		for name, value := range fields {
+			if s, ok := value.(string); ok && len(s) > l.bytes {
+				fields[name] = router.TruncateBytes(s, l.bytes)
+				cut++
+			}
+		}
+	}
+	return dropped, cut
+}
+
+// replaced returns whether extra has any of the fields
+func (c *containerFields) replaced(extra map[string]interface{}) bool {
+	for name := range extra {
+		if _, ok := c.fields[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fixed returns the fields msg is sent with outside of its Extra, which
+// later steps mustn't add again
+func (c *containerFields) fixed(msg *gelf.Message) map[string]interface{} {
+	if c == nil || msg.RawExtra == nil {
+		return nil
+	}
+	return c.fields
+}
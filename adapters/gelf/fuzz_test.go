@@ -0,0 +1,71 @@
+//go:build go1.18
+// +build go1.18
+
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+var extraNameRe = regexp.MustCompile(`^_[\w.\-]+$`)
+
+var standardFields = map[string]bool{
+	"version": true, "host": true, "short_message": true, "full_message": true,
+	"timestamp": true, "level": true, "facility": true, "line": true, "file": true,
+}
+
+func FuzzNewMessage(f *testing.F) {
+	f.Add("hello", "/app", "team", "core")
+	f.Add("line one\nline \"two\"\x00\xff", "", "id", "x y")
+	f.Add(string(make([]byte, 64*1024)), "/", "", " ")
+	f.Fuzz(func(t *testing.T, data, name, label, field string) {
+		message := &router.Message{
+			Container: &docker.Container{
+				ID:     "abc",
+				Name:   name,
+				Config: &docker.Config{Labels: map[string]string{"gelf_" + label: data}},
+			},
+			Source: "stdout",
+			Data:   data,
+			Time:   time.Unix(0, 0),
+			Fields: map[string]string{field: data},
+		}
+		msg := newMessage(message, "host", nil, nil)
+		var buf, library bytes.Buffer
+		if err := encodeMessage(&buf, msg); err != nil {
+			t.Fatal(err)
+		}
+		var decoded, expected map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatalf("invalid JSON %q: %v", buf.Bytes(), err)
+		}
+		// the messages go-gelf writes, as over UDP, have to be the same
+		if err := msg.MarshalJSONBuf(&library); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(library.Bytes(), &expected); err != nil {
+			t.Fatalf("invalid JSON %q: %v", library.Bytes(), err)
+		}
+		if !reflect.DeepEqual(decoded, expected) {
+			t.Errorf("expected %s, got %s", library.Bytes(), buf.Bytes())
+		}
+		if utf8.ValidString(data) && decoded["short_message"] != data {
+			t.Errorf("expected short_message %q, got %q", data, decoded["short_message"])
+		}
+		for key := range decoded {
+			if !standardFields[key] && (!extraNameRe.MatchString(key) || key == "_id") {
+				t.Errorf("invalid additional field name %q", key)
+			}
+		}
+	})
+}
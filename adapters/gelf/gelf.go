@@ -2,121 +2,537 @@
 package gelf
 
 import (
+	"compress/flate"
 	"encoding/json"
 	"errors"
-	"io/ioutil"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Graylog2/go-gelf/gelf"
+	"github.com/gliderlabs/logspout/httpclient"
 	"github.com/gliderlabs/logspout/router"
-	"github.com/gliderlabs/logspout/cfg"
 )
 
-var hostname string
-
-func getHostname() string {
-	content, err := ioutil.ReadFile("/etc/host_hostname")
-	if err == nil && len(content) > 0 {
-		hostname = strings.TrimRight(string(content), "\r\n")
-	} else {
-		hostname = cfg.GetEnvDefault("SYSLOG_HOSTNAME", "{{.Container.Config.Hostname}}")
+// messageHost returns the host of the GELF message for m: the host logspout
+// runs on, or else the hostname of its container
+func messageHost(m *router.Message) string {
+	if host := router.Hostname(); host != "" {
+		return host
 	}
-	return hostname
+	if m.Container != nil && m.Container.Config != nil {
+		return m.Container.Config.Hostname
+	}
+	return ""
 }
 
 func init() {
-	hostname = getHostname()
 	router.AdapterFactories.Register(NewGelfAdapter, "gelf")
+	router.DescribeAdapter("gelf", router.AdapterInfo{
+		DefaultTransport: "udp",
+		Transports:       []string{"udp", "tcp", "tls", "http", "https"},
+		Options: append([]router.AdapterOption{
+			{Name: "gelf_endpoints", Env: "GELF_ENDPOINTS", Description: "more Graylog nodes, separated by | or ,"},
+			{Name: "gelf_balance", Env: "GELF_BALANCE", Description: "failover, roundrobin or hash across the nodes"},
+			{Name: "gelf_rate_limit", Env: "GELF_RATE_LIMIT", Description: "messages per second, minute or hour of each container, like 100/s, or the logspout.gelf_rate_limit label"},
+			{Name: "gelf_rate_limit_summary", Env: "GELF_RATE_LIMIT_SUMMARY", Description: "interval of the messages with the number of messages the rate limit suppressed"},
+			{Name: "gelf_host_source", Env: "GELF_HOST_SOURCE", Description: "host, container_hostname, container_name or a template for the host field"},
+			{Name: "gelf_facility", Env: "GELF_FACILITY", Description: "facility of the messages, or the logspout.gelf_facility label"},
+			{Name: "gelf_coerce_types", Env: "GELF_COERCE_TYPES", Description: "true or patterns of the fields whose numbers and booleans are sent as such"},
+			{Name: "gelf_failback", Env: "GELF_FAILBACK", Description: "false to stay on the node failed over to once the first is back"},
+			{Name: "gelf_health", Env: "GELF_HEALTH", Description: "none, tcp, tcp:PORT or lbstatus:PORT health check of the nodes"},
+			{Name: "gelf_health_interval", Env: "GELF_HEALTH_INTERVAL", Description: "interval of the health checks"},
+			{Name: "gelf_batch_size", Env: "GELF_BATCH_SIZE", Description: "messages per HTTP request"},
+			{Name: "gelf_flush_interval", Env: "GELF_FLUSH_INTERVAL", Description: "how long messages wait for a batch to fill"},
+			{Name: "gelf_workers", Env: "GELF_WORKERS", Description: "goroutines writing to TCP inputs, each over its own connection, 0 to write synchronously"},
+			{Name: "gelf_queue_size", Env: "GELF_QUEUE_SIZE", Description: "messages queued for the gelf_workers"},
+			{Name: "gelf_queue_policy", Env: "GELF_QUEUE_POLICY", Description: "block or drop messages when the queue of gelf_workers is full"},
+			{Name: "gelf_batch_bytes", Env: "GELF_BATCH_BYTES", Description: "bytes per TCP write"},
+			{Name: "gelf_compression_type", Env: "GELF_COMPRESSION_TYPE", Description: "gzip, zlib or none compression of UDP messages and HTTP requests"},
+			{Name: "gelf_compression_level", Env: "GELF_COMPRESSION_LEVEL", Description: "compression level of UDP messages and HTTP requests, -1 to 9"},
+			{Name: "gelf_http_retries", Env: "GELF_HTTP_RETRIES", Description: "times an HTTP request is retried after a 5xx, 429 or connection error"},
+			{Name: "gelf_tls_ca_cert", Env: "GELF_TLS_CA_CERT", Description: "file or Docker secret with the CA certificates of Graylog TLS inputs"},
+			{Name: "gelf_tls_client_cert", Env: "GELF_TLS_CLIENT_CERT", Description: "file or Docker secret with the client certificate"},
+			{Name: "gelf_tls_client_key", Env: "GELF_TLS_CLIENT_KEY", Description: "file or Docker secret with the key of the client certificate"},
+			{Name: "gelf_tls_server_name", Env: "GELF_TLS_SERVER_NAME", Description: "name to verify the certificate of Graylog with"},
+			{Name: "gelf_tls_skip_verify", Env: "GELF_TLS_SKIP_VERIFY", Description: "true to not verify the certificate of Graylog"},
+			{Name: "gelf_reconnect_buffer", Env: "GELF_RECONNECT_BUFFER", Description: "messages kept while reconnecting to a TCP input, 0 to drop them"},
+			{Name: "gelf_reconnect_max_backoff", Env: "GELF_RECONNECT_MAX_BACKOFF", Description: "longest delay between reconnects to a TCP input"},
+			{Name: "gelf_buffer_dir", Env: "GELF_BUFFER_DIR", Description: "directory to keep the messages beyond the reconnect buffer in"},
+			{Name: "gelf_buffer_max_bytes", Env: "GELF_BUFFER_MAX_BYTES", Description: "disk space the buffer of gelf_buffer_dir may use"},
+			{Name: "gelf_buffer_retention", Env: "GELF_BUFFER_RETENTION", Description: "how long the messages sent are kept in gelf_buffer_dir to be replayed"},
+			{Name: "gelf_tcp_nodelay", Env: "GELF_TCP_NODELAY", Description: "false to let TCP delay small writes"},
+			{Name: "graylog_token", Env: "GRAYLOG_TOKEN", Description: "token for Graylog HTTP inputs"},
+			{Name: "graylog_token_header", Env: "GRAYLOG_TOKEN_HEADER", Description: "header the token is sent in"},
+			{Name: "gelf_short_message_bytes", Env: "GELF_SHORT_MESSAGE_BYTES", Description: "bytes short_message is cut to, with the whole text in full_message"},
+			{Name: "truncate_ellipsis", Env: "TRUNCATE_ELLIPSIS", Description: "text ending cut messages"},
+			{Name: "gelf_max_message_bytes", Env: "GELF_MAX_MESSAGE_BYTES", Description: "largest message sent, as encoded JSON bytes"},
+			{Name: "gelf_oversize", Env: "GELF_OVERSIZE", Description: "truncate, full or split messages over gelf_max_message_bytes"},
+			{Name: "graylog_api", Env: "GRAYLOG_API", Description: "URL of the Graylog REST API to check the input with at startup"},
+			{Name: "graylog_api_token", Env: "GRAYLOG_API_TOKEN", Description: "access token for the Graylog REST API"},
+			{Name: "graylog_create_input", Env: "GRAYLOG_CREATE_INPUT", Description: "true to create a missing input"},
+			{Name: "graylog_index_set", Env: "GRAYLOG_INDEX_SET", Description: "title of an index set that has to exist"},
+			{Name: "gelf_static_fields", Env: "GELF_STATIC_FIELDS", Description: "extra fields for all messages, as a JSON object or name=value pairs"},
+			{Name: "gelf_env", Env: "GELF_ENV", Description: "comma separated patterns of the container environment variables to send as extra fields"},
+			{Name: "gelf_label_prefix", Env: "GELF_LABEL_PREFIX", Description: "prefix of the container labels sent as extra fields without it, gelf_ by default"},
+			{Name: "gelf_label_fields", Env: "GELF_LABEL_FIELDS", Description: "comma separated label=field pairs of container labels to send as extra fields"},
+			{Name: "gelf_all_labels", Env: "GELF_ALL_LABELS", Description: "true to send all container labels as _label_ fields"},
+			{Name: "gelf_max_fields", Env: "GELF_MAX_FIELDS", Description: "most extra fields of a container, beyond which those of labels are dropped, 0 for no limit"},
+			{Name: "gelf_max_field_bytes", Env: "GELF_MAX_FIELD_BYTES", Description: "bytes the values of the fields of containers are cut to, 0 for no limit"},
+			{Name: "gelf_level_stdout", Env: "GELF_LEVEL_STDOUT", Description: "level of stdout messages, as a name or a syslog severity"},
+			{Name: "gelf_level_stderr", Env: "GELF_LEVEL_STDERR", Description: "level of stderr messages, as a name or a syslog severity"},
+			{Name: "gelf_level_pattern", Env: "GELF_LEVEL_PATTERN", Description: "regular expression whose level or first group is the level of a message"},
+			{Name: "gelf_level_field", Env: "GELF_LEVEL_FIELD", Description: "key of JSON messages holding the level, with dots between nested keys"},
+			{Name: "gelf_time_layout", Env: "GELF_TIME_LAYOUT", Description: "strptime layout of the timestamps in messages to send instead of the read time"},
+			{Name: "gelf_time_zone", Env: "GELF_TIME_ZONE", Description: "time zone of timestamps without one, UTC by default"},
+			{Name: "gelf_json", Env: "GELF_JSON", Description: "true to promote the fields of JSON messages of all containers"},
+			{Name: "gelf_json_fields", Env: "GELF_JSON_FIELDS", Description: "comma separated patterns of the JSON fields to promote"},
+			{Name: "gelf_json_exclude", Env: "GELF_JSON_EXCLUDE", Description: "comma separated patterns of the JSON fields not to promote"},
+		}, httpclient.Options...),
+	})
+}
+
+// messageWriter sends GELF messages to Graylog
+type messageWriter interface {
+	WriteMessage(m *gelf.Message) error
+	Close() error
+}
+
+// containerWriter is a messageWriter that keeps the messages of a container
+// together, on a node or a worker
+type containerWriter interface {
+	writeFrom(container string, m *gelf.Message) error
 }
 
 // GelfAdapter is an adapter that streams UDP JSON to Graylog
 type GelfAdapter struct {
-	writer *gelf.Writer
+	writer messageWriter
 	route  *router.Route
+	// short cuts short_message, with the whole text sent as full_message
+	short router.Truncation
+	size  *sizeLimit
+	json  *jsonPromotion
+	// fields are the extra fields of the containers, with those of
+	// gelf_static_fields, gelf_env and the label rules
+	fields *fieldCache
+	levels *levelRules
+	times  *timeExtraction
+	source *messageSource
+	// limiter drops the messages of containers over their rate limit
+	limiter *rateLimiter
 }
 
 // NewGelfAdapter creates a GelfAdapter with UDP as the default transport.
 func NewGelfAdapter(route *router.Route) (router.LogAdapter, error) {
-	_, found := router.AdapterTransports.Lookup(route.AdapterTransport("udp"))
-	if !found {
-		return nil, errors.New("unable to find adapter: " + route.Adapter)
+	short, err := router.NewTruncation(route, "gelf_short_message_bytes", "GELF_SHORT_MESSAGE_BYTES")
+	if err != nil {
+		return nil, err
 	}
-
-	gelfWriter, err := gelf.NewWriter(route.Address)
+	size, err := newSizeLimit(route, short)
+	if err != nil {
+		return nil, err
+	}
+	promotion, err := newJSONPromotion(route)
+	if err != nil {
+		return nil, err
+	}
+	static, err := staticFields(httpclient.Option(route, "gelf_static_fields", "GELF_STATIC_FIELDS"))
+	if err != nil {
+		return nil, err
+	}
+	env, err := newEnvFields(route, static)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := newLabelFields(route)
+	if err != nil {
+		return nil, err
+	}
+	levels, err := newLevelRules(route)
+	if err != nil {
+		return nil, err
+	}
+	times, err := newTimeExtraction(route)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := newFieldLimit(route)
+	if err != nil {
+		return nil, err
+	}
+	coerce, err := newTypeCoercion(route)
+	if err != nil {
+		return nil, err
+	}
+	source, err := newMessageSource(route)
+	if err != nil {
+		return nil, err
+	}
+	limiter, err := newRateLimiter(route)
+	if err != nil {
+		return nil, err
+	}
+	if err = provisionInput(route); err != nil {
+		return nil, err
+	}
+	writer, err := gelfWriter(route)
 	if err != nil {
 		return nil, err
 	}
 
 	return &GelfAdapter{
-		route:  route,
-		writer: gelfWriter,
+		route:   route,
+		writer:  writer,
+		short:   short,
+		size:    size,
+		json:    promotion,
+		fields:  newFieldCache(static, env, labels, limit, coerce),
+		levels:  levels,
+		times:   times,
+		source:  source,
+		limiter: limiter,
 	}, nil
 }
 
+// gelfWriter returns the writer for the transport of route, with the workers
+// of gelf_workers
+func gelfWriter(route *router.Route) (messageWriter, error) {
+	workers, err := asyncWorkers(route)
+	if err != nil {
+		return nil, err
+	}
+	if workers > 0 {
+		return newAsyncWriter(route, workers, nodesWriter)
+	}
+	return nodesWriter(route)
+}
+
+// nodesWriter returns the writer for the Graylog nodes of route
+func nodesWriter(route *router.Route) (messageWriter, error) {
+	if endpoints := httpclient.Option(route, "gelf_endpoints", "GELF_ENDPOINTS"); endpoints != "" || strings.Contains(route.Address, ",") {
+		return newMultiWriter(route, endpoints)
+	}
+	return singleWriter(route)
+}
+
+// singleWriter returns the writer for a single Graylog node
+func singleWriter(route *router.Route) (messageWriter, error) {
+	switch transport := route.AdapterTransport("udp"); transport {
+	case "http", "https":
+		return newHTTPWriter(route)
+	default:
+		adapterTransport, found := router.AdapterTransports.Lookup(transport)
+		if !found {
+			return nil, errors.New("unable to find adapter: " + route.Adapter)
+		}
+		if transport == "tls" {
+			custom, err := newTLSTransport(route)
+			if err != nil {
+				return nil, err
+			}
+			if custom != nil {
+				adapterTransport = custom
+			}
+		}
+		if transport == "tcp" || transport == "tls" {
+			return newTCPWriter(route, adapterTransport)
+		}
+		return newUDPWriter(route)
+	}
+}
+
+// udpWriter is the go-gelf UDP writer, counting its writes in the metrics of
+// the route
+type udpWriter struct {
+	*gelf.Writer
+	metrics *router.Metrics
+}
+
+// newUDPWriter returns the go-gelf UDP writer, with the compression of the
+// gelf_compression_type and gelf_compression_level options
+func newUDPWriter(route *router.Route) (*udpWriter, error) {
+	compression, level, err := compressionOptions(route, gelf.CompressGzip)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := gelf.NewWriter(route.Address)
+	if err != nil {
+		return nil, err
+	}
+	writer.CompressionType = compression
+	writer.CompressionLevel = level
+	return &udpWriter{Writer: writer, metrics: route.Metrics()}, nil
+}
+
+// WriteMessage sends m in one or more datagrams. Their size isn't known, so
+// no bytes are counted.
+func (w *udpWriter) WriteMessage(m *gelf.Message) error {
+	if err := w.Writer.WriteMessage(m); err != nil {
+		w.metrics.Error()
+		w.metrics.Dropped(1)
+		return err
+	}
+	w.metrics.Sent(1, 0)
+	return nil
+}
+
+// compressionOptions returns the compression of the gelf_compression_type
+// and gelf_compression_level options, dfault when no type is set
+func compressionOptions(route *router.Route, dfault gelf.CompressType) (gelf.CompressType, int, error) {
+	compression := dfault
+	switch s := httpclient.Option(route, "gelf_compression_type", "GELF_COMPRESSION_TYPE"); s {
+	case "":
+	case "gzip":
+		compression = gelf.CompressGzip
+	case "zlib":
+		compression = gelf.CompressZlib
+	case "none":
+		compression = gelf.CompressNone
+	default:
+		return 0, 0, errors.New("gelf: bad gelf_compression_type: " + s)
+	}
+	level := flate.BestSpeed
+	if s := httpclient.Option(route, "gelf_compression_level", "GELF_COMPRESSION_LEVEL"); s != "" {
+		var err error
+		if level, err = strconv.Atoi(s); err != nil || level < flate.DefaultCompression || level > flate.BestCompression {
+			return 0, 0, errors.New("gelf: bad gelf_compression_level: " + s)
+		}
+	}
+	return compression, level, nil
+}
+
 // Stream implements the router.LogAdapter interface.
 func (a *GelfAdapter) Stream(logstream chan *router.Message) {
-	for message := range logstream {
-		m := &GelfMessage{message}
-		level := gelf.LOG_INFO
-		if m.Source == "stderr" {
-			level = gelf.LOG_ERR
+	tick, stop := a.limiter.tick()
+	defer stop()
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				a.summarize(time.Now())
+				return
+			}
+			if a.limiter.allow(message, time.Now()) {
+				a.send(message, a.gelfMessage(message))
+			}
+		case now := <-tick:
+			a.summarize(now)
 		}
-		extra, err := m.getExtraFields()
-		if err != nil {
-			log.Println("Graylog:", err)
-			continue
+	}
+}
+
+// gelfMessage returns the GELF message for message
+func (a *GelfAdapter) gelfMessage(message *router.Message) *gelf.Message {
+	fields := a.fields.get(message.Container)
+	msg := fields.message(message, a.source.hostOf(message))
+	a.source.setFacility(msg, message)
+	if a.levels != nil {
+		a.levels.apply(msg, message)
+	}
+	a.times.extract(msg, message)
+	if a.json != nil {
+		a.json.promote(msg, message, fields.fixed(msg))
+	}
+	// entries joined from multiple lines, like stack traces, are sent
+	// with their first line as the short message
+	if i := strings.IndexByte(msg.Short, '\n'); i >= 0 {
+		msg.Short, msg.Full = strings.TrimRight(msg.Short[:i], "\r"), msg.Short
+	}
+	if short := a.short.Truncate(msg.Short); short != msg.Short {
+		if msg.Full == "" {
+			msg.Full = msg.Short
+		}
+		msg.Short = short
+	}
+	return msg
+}
+
+// send writes msg, the GELF message for message, in parts when it is too
+// big
+func (a *GelfAdapter) send(message *router.Message, msg *gelf.Message) {
+	if parts := a.size.apply(msg); parts != nil {
+		for _, part := range parts {
+			a.write(message, part)
 		}
+		return
+	}
+	a.write(message, msg)
+}
 
-		msg := gelf.Message{
-			Version:  "1.1",
-			Host:     hostname,
-			Short:    m.Message.Data,
-			TimeUnix: float64(m.Message.Time.UnixNano()/int64(time.Millisecond)) / 1000.0,
-			Level:    level,
-			RawExtra: extra,
+// summarize sends the summaries of the containers whose messages the rate
+// limit suppressed, as warnings with the number in _suppressed
+func (a *GelfAdapter) summarize(now time.Time) {
+	for _, summary := range a.limiter.summaries(now) {
+		msg := a.gelfMessage(summary.message)
+		msg.Level = gelf.LOG_WARNING
+		if msg.Extra == nil {
+			msg.Extra = make(map[string]interface{}, 1)
 		}
-		// 	ContainerId:    m.Container.ID,
-		// 	ContainerImage: m.Container.Config.Image,
-		// 	ContainerName:  m.Container.Name,
-		// }
+		msg.Extra["_suppressed"] = summary.suppressed
+		a.send(summary.message, msg)
+	}
+}
 
-		// here be message write.
-		if err := a.writer.WriteMessage(&msg); err != nil {
-			log.Println("Graylog:", err)
-			continue
+// write sends msg, the GELF message for m
+func (a *GelfAdapter) write(m *router.Message, msg *gelf.Message) {
+	var err error
+	if w, ok := a.writer.(containerWriter); ok && m.Container != nil {
+		err = w.writeFrom(m.Container.ID, msg)
+	} else {
+		err = a.writer.WriteMessage(msg)
+	}
+	switch a.writer.(type) {
+	case *httpWriter, *tcpWriter, *asyncWriter:
+		// the batching writers report the state of their writes themselves
+	default:
+		if err != nil {
+			a.route.SetConnState(router.ConnFailed, err)
+		} else {
+			a.route.SetConnState(router.ConnConnected, nil)
 		}
 	}
+	if err != nil {
+		log.Println("Graylog:", err)
+	}
+}
+
+// Close closes the connection of the adapter
+func (a *GelfAdapter) Close() error {
+	return a.writer.Close()
+}
+
+// newMessage returns the GELF message for m, sent from host with the static
+// extra fields and those of the container labels. It only depends on its
+// arguments, so it can be tested on its own.
+func newMessage(m *router.Message, host string, static map[string]interface{}, labels *labelFields) *gelf.Message {
+	msg := baseMessage(m, host)
+	msg.Extra = GelfMessage{Message: m, static: static, labels: labels}.getExtraFields()
+	return msg
+}
+
+// baseMessage returns the GELF message for m without extra fields
+func baseMessage(m *router.Message, host string) *gelf.Message {
+	level := gelf.LOG_INFO
+	if m.Source == "stderr" {
+		level = gelf.LOG_ERR
+	}
+	return &gelf.Message{
+		Version:  "1.1",
+		Host:     host,
+		Short:    m.Data,
+		TimeUnix: float64(m.Time.UnixNano()/int64(time.Millisecond)) / 1000.0,
+		Level:    level,
+	}
 }
 
 type GelfMessage struct {
 	*router.Message
+	// static are extra fields for all messages, which those of the container
+	// and the message replace
+	static map[string]interface{}
+	labels *labelFields
+}
+
+// getExtraFields returns the extra fields of the message. They are only
+// encoded when the message is written, so later steps can change them.
+func (m GelfMessage) getExtraFields() map[string]interface{} {
+	extra := make(map[string]interface{}, len(m.static)+len(m.Fields)+8)
+	for name, value := range m.static {
+		extra[name] = value
+	}
+	if m.Container != nil {
+		m.addContainerFields(extra)
+		if config := m.Container.Config; config != nil {
+			m.labels.add(config.Labels, extra)
+		}
+	}
+	addMessageFields(m.Message, extra)
+	return extra
 }
 
-func (m GelfMessage) getExtraFields() (json.RawMessage, error) {
+// addMessageFields adds the fields of m itself, which replace those of its
+// container
+func addMessageFields(m *router.Message, extra map[string]interface{}) {
+	if m.Replay {
+		extra["_replay"] = true
+	}
+	for name, value := range m.Fields {
+		if name != "" {
+			extra[extraName(name)] = value
+		}
+	}
+}
 
-	extra := map[string]interface{}{
-		"_container_id":   m.Container.ID,
-		"_container_name": m.Container.Name[1:], // might be better to use strings.TrimLeft() to remove the first /
-		"_image_id":       m.Container.Image,
-		"_image_name":     m.Container.Config.Image,
-		"_command":        strings.Join(m.Container.Config.Cmd[:], " "),
-		"_created":        m.Container.Created,
+// staticFields parses the gelf_static_fields option: a JSON object, like
+// {"_env":"prod","_dc":"eu-west"}, or name=value pairs separated by commas.
+// Names get the _ prefix of additional fields when they don't have it.
+func staticFields(s string) (map[string]interface{}, error) {
+	if s = strings.TrimSpace(s); s == "" {
+		return nil, nil
 	}
-	for name, label := range m.Container.Config.Labels {
-		if len(name) > 5 && strings.ToLower(name[0:5]) == "gelf_" {
-			extra[name[4:]] = label
+	fields := make(map[string]interface{})
+	if strings.HasPrefix(s, "{") {
+		if err := json.Unmarshal([]byte(s), &fields); err != nil {
+			return nil, errors.New("gelf: bad gelf_static_fields: " + err.Error())
+		}
+	} else {
+		for _, pair := range strings.Split(s, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.New("gelf: bad gelf_static_fields: " + s)
+			}
+			fields[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
 		}
 	}
-	swarmnode := m.Container.Node
-	if swarmnode != nil {
-		extra["_swarm_node"] = swarmnode.Name
+	static := make(map[string]interface{}, len(fields))
+	for name, value := range fields {
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			return nil, errors.New("gelf: bad gelf_static_fields: " + name + " is not a string, number or boolean")
+		}
+		if name = strings.TrimPrefix(name, "_"); name == "" {
+			return nil, errors.New("gelf: bad gelf_static_fields: empty name")
+		}
+		static[extraName(name)] = value
 	}
+	return static, nil
+}
 
-	rawExtra, err := json.Marshal(extra)
-	if err != nil {
-		return nil, err
+// extraName returns the additional field name for name: GELF only allows
+// letters, digits, underscores, dashes and dots, with a name between dots,
+// and reserves _id
+func extraName(name string) string {
+	name = "_" + strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+	if strings.Contains(name, ".") {
+		// dots nest fields in Elasticsearch, which rejects empty names
+		// between them
+		b := []byte(name)
+		for i := 1; i < len(b); i++ {
+			if b[i] == '.' && (i == 1 || i == len(b)-1 || b[i-1] == '.') {
+				b[i] = '_'
+			}
+		}
+		name = string(b)
 	}
-	return rawExtra, nil
+	if name == "_id" {
+		return "_id_"
+	}
+	return name
+}
+
+// addContainerFields adds the attributes of the field providers of m, as
+// those of its container, without the fields of its labels
+func (m GelfMessage) addContainerFields(extra map[string]interface{}) {
+	m.ProvideAttributes(func(key string, value interface{}) {
+		if key != "" {
+			extra[extraName(key)] = value
+		}
+	})
 }
@@ -0,0 +1,416 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestShortMessageTruncation(t *testing.T) {
+	route := &router.Route{Options: map[string]string{"gelf_short_message_bytes": "8", "truncate_ellipsis": "~"}}
+	short, err := router.NewTruncation(route, "gelf_short_message_bytes", "GELF_SHORT_MESSAGE_BYTES")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &fakeWriter{}
+	adapter := &GelfAdapter{writer: writer, route: route, short: short}
+	for _, tt := range []struct{ data, short, full string }{
+		{"short", "short", ""},
+		{"größer als acht", "größe~", "größer als acht"},
+		{"Error\n\tat a", "Error", "Error\n\tat a"},
+		{"Exceptional\r\n\tat a", "Excepti~", "Exceptional\r\n\tat a"},
+	} {
+		stream := make(chan *router.Message, 1)
+		stream <- &router.Message{Data: tt.data, Time: time.Now()}
+		close(stream)
+		adapter.Stream(stream)
+		if writer.last.Short != tt.short || writer.last.Full != tt.full {
+			t.Errorf("expected %q and %q for %q, got %q and %q", tt.short, tt.full, tt.data, writer.last.Short, writer.last.Full)
+		}
+	}
+}
+
+func TestUDPCompression(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for _, tt := range []struct {
+		options map[string]string
+		open    func(io.Reader) (io.Reader, error)
+	}{
+		{map[string]string{}, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{map[string]string{"gelf_compression_type": "gzip", "gelf_compression_level": "9"},
+			func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{map[string]string{"gelf_compression_type": "zlib"}, func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) }},
+		{map[string]string{"gelf_compression_type": "none"}, func(r io.Reader) (io.Reader, error) { return r, nil }},
+	} {
+		writer, err := newUDPWriter(&router.Route{Address: conn.LocalAddr().String(), Options: tt.options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = writer.WriteMessage(&gelf.Message{Version: "1.1", Host: "host", Short: "hello", TimeUnix: 1})
+		writer.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := conn.ReadFrom(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := tt.open(bytes.NewReader(packet[:n]))
+		if err != nil {
+			t.Errorf("%v: %v", tt.options, err)
+			continue
+		}
+		var msg gelf.Message
+		if err = json.NewDecoder(r).Decode(&msg); err != nil || msg.Short != "hello" {
+			t.Errorf("%v: expected the message, got %+v, %v", tt.options, msg, err)
+		}
+	}
+}
+
+func TestUDPCompressionOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"gelf_compression_type": "lz4"},
+		{"gelf_compression_level": "10"},
+		{"gelf_compression_level": "fast"},
+	} {
+		if _, err := newUDPWriter(&router.Route{Address: "127.0.0.1:12201", Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
+
+// encodedExtra returns the extra fields of msg as Graylog gets them
+func encodedExtra(t *testing.T, msg *gelf.Message) map[string]interface{} {
+	var buf bytes.Buffer
+	if err := encodeMessage(&buf, msg); err != nil {
+		t.Fatal(err)
+	}
+	decoded := make(map[string]interface{})
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.Bytes(), err)
+	}
+	extra := make(map[string]interface{})
+	for name, value := range decoded {
+		if strings.HasPrefix(name, "_") {
+			extra[name] = value
+		}
+	}
+	return extra
+}
+
+func TestStaticFields(t *testing.T) {
+	for _, s := range []string{`{"_env":"prod","dc":"eu-west","_replicas":3}`, "env=prod, _dc=eu-west"} {
+		static, err := staticFields(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		container := &docker.Container{ID: "abc", Config: &docker.Config{Labels: map[string]string{"gelf_dc": "us-east"}}}
+		extra := encodedExtra(t, newMessage(&router.Message{Container: container, Data: "hello", Time: time.Now()}, "host", static, nil))
+		if extra["_env"] != "prod" {
+			t.Errorf("%s: expected _env=prod, got %v", s, extra["_env"])
+		}
+		if extra["_dc"] != "us-east" {
+			t.Errorf("%s: expected the container label to replace _dc, got %v", s, extra["_dc"])
+		}
+	}
+	for _, s := range []string{`{"_env":`, `{"_tags":["a"]}`, "env", "=prod"} {
+		if _, err := staticFields(s); err == nil {
+			t.Errorf("expected an error for %s", s)
+		}
+	}
+}
+
+func TestLabelFields(t *testing.T) {
+	labels := map[string]string{
+		"gelf_service":       "api",
+		"com.example.team":   "core",
+		"com.example.tier":   "web",
+		"com.example.owner":  "ops",
+		"COM.EXAMPLE.REGION": "eu",
+	}
+	for _, tt := range []struct {
+		options map[string]string
+		fields  map[string]interface{}
+	}{
+		{map[string]string{}, map[string]interface{}{"_service": "api"}},
+		{map[string]string{"gelf_label_prefix": "com.example."}, map[string]interface{}{
+			"_team": "core", "_tier": "web", "_owner": "ops", "_REGION": "eu",
+		}},
+		{map[string]string{"gelf_label_prefix": "com.example.", "gelf_label_fields": "com.example.owner=_team, gelf_service=app"}, map[string]interface{}{
+			"_team": "ops", "_tier": "web", "_owner": "ops", "_REGION": "eu", "_app": "api",
+		}},
+		{map[string]string{"gelf_all_labels": "true"}, map[string]interface{}{
+			"_service": "api", "_label_gelf_service": "api", "_label_com.example.team": "core",
+			"_label_com.example.tier": "web", "_label_com.example.owner": "ops", "_label_COM.EXAMPLE.REGION": "eu",
+		}},
+	} {
+		rules, err := newLabelFields(&router.Route{Options: tt.options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		extra := make(map[string]interface{})
+		rules.add(labels, extra)
+		if len(extra) != len(tt.fields) {
+			t.Errorf("%v: expected %v, got %v", tt.options, tt.fields, extra)
+		}
+		for name, value := range tt.fields {
+			if extra[name] != value {
+				t.Errorf("%v: expected %s=%v, got %v", tt.options, name, value, extra[name])
+			}
+		}
+	}
+	for _, options := range []map[string]string{
+		{"gelf_all_labels": "yes"},
+		{"gelf_label_fields": "team"},
+		{"gelf_label_fields": "com.example.team="},
+	} {
+		if _, err := newLabelFields(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
+
+func TestSwarmFields(t *testing.T) {
+	for _, tt := range []struct {
+		labels map[string]string
+		fields map[string]interface{}
+	}{
+		{map[string]string{
+			"com.docker.stack.namespace":    "shop",
+			"com.docker.swarm.service.name": "shop_web",
+			"com.docker.swarm.task.id":      "qbq8ecsxhnlkz6rx0dx2dvxk4",
+			"com.docker.swarm.task.name":    "shop_web.2.qbq8ecsxhnlkz6rx0dx2dvxk4",
+		}, map[string]interface{}{
+			"_swarm_service": "shop_web", "_swarm_stack": "shop", "_task_id": "qbq8ecsxhnlkz6rx0dx2dvxk4", "_task_slot": float64(2),
+		}},
+		{map[string]string{
+			"com.docker.swarm.service.name": "agent",
+			"com.docker.swarm.task.id":      "x3kd7q0a1l9m2n4b5v6c7z8p9",
+			"com.docker.swarm.task.name":    "agent.n0deid5lb7ryq6tj1pgfzyw5f.x3kd7q0a1l9m2n4b5v6c7z8p9",
+		}, map[string]interface{}{
+			"_swarm_service": "agent", "_task_id": "x3kd7q0a1l9m2n4b5v6c7z8p9",
+		}},
+		{map[string]string{"com.docker.compose.service": "web"}, map[string]interface{}{}},
+	} {
+		container := &docker.Container{ID: "abc", Config: &docker.Config{Labels: tt.labels}}
+		extra := encodedExtra(t, newMessage(&router.Message{Container: container, Data: "hello", Time: time.Now()}, "host", nil, nil))
+		for _, name := range []string{"_swarm_service", "_swarm_stack", "_task_id", "_task_slot"} {
+			if extra[name] != tt.fields[name] {
+				t.Errorf("%v: expected %s=%v, got %v", tt.labels, name, tt.fields[name], extra[name])
+			}
+		}
+	}
+}
+
+func TestEnvFields(t *testing.T) {
+	env, err := newEnvFields(&router.Route{Options: map[string]string{"gelf_env": "SERVICE_VERSION,GIT_*"}}, map[string]interface{}{"_env": "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &docker.Container{ID: "abc", Config: &docker.Config{Env: []string{
+		"SERVICE_VERSION=1.2.3", "GIT_SHA=0a1b2c", "DB_PASSWORD=secret", "GIT_DIRTY",
+	}}}
+	fields := env.fields(container)
+	for name, value := range map[string]interface{}{"_env": "prod", "_service_version": "1.2.3", "_git_sha": "0a1b2c", "_git_dirty": ""} {
+		if fields[name] != value {
+			t.Errorf("expected %s=%q, got %v", name, value, fields[name])
+		}
+	}
+	if len(fields) != 4 {
+		t.Errorf("expected the allowed variables only, got %v", fields)
+	}
+	container.Config.Env = nil
+	if cached := env.fields(container); cached["_git_sha"] != "0a1b2c" {
+		t.Errorf("expected the fields of the container to be kept, got %v", cached)
+	}
+	if _, err := newEnvFields(&router.Route{Options: map[string]string{"gelf_env": "[GIT"}}, nil); err == nil {
+		t.Error("expected an error for a bad pattern")
+	}
+}
+
+func TestLevelRules(t *testing.T) {
+	for _, tt := range []struct {
+		options map[string]string
+		source  string
+		data    string
+		level   int32
+	}{
+		{map[string]string{"gelf_level_stderr": "warning"}, "stderr", "deprecated call", gelf.LOG_WARNING},
+		{map[string]string{"gelf_level_stdout": "7"}, "stdout", "tick", gelf.LOG_DEBUG},
+		{map[string]string{"gelf_level_stdout": "7"}, "stderr", "failed", gelf.LOG_ERR},
+		{map[string]string{"gelf_level_pattern": `^\[?(DEBUG|INFO|WARN|ERROR)`}, "stderr", "INFO starting", gelf.LOG_INFO},
+		{map[string]string{"gelf_level_pattern": `^\[?(DEBUG|INFO|WARN|ERROR)`}, "stdout", "[WARN] disk full", gelf.LOG_WARNING},
+		{map[string]string{"gelf_level_pattern": `^\[?(DEBUG|INFO|WARN|ERROR)`}, "stderr", "panic: nil map", gelf.LOG_ERR},
+		{map[string]string{"gelf_level_pattern": `(ts=\S+) level=(?P<level>\w+)`}, "stdout", "ts=1 level=debug msg=hi", gelf.LOG_DEBUG},
+		{map[string]string{"gelf_level_field": "log.level"}, "stdout", `{"log":{"level":"error"},"msg":"x"}`, gelf.LOG_ERR},
+		{map[string]string{"gelf_level_field": "severity"}, "stdout", `{"severity":50}`, gelf.LOG_ERR},
+		{map[string]string{"gelf_level_field": "severity"}, "stderr", `not json`, gelf.LOG_ERR},
+	} {
+		rules, err := newLevelRules(&router.Route{Options: tt.options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &gelf.Message{}
+		rules.apply(msg, &router.Message{Source: tt.source, Data: tt.data})
+		if msg.Level != tt.level {
+			t.Errorf("%v %s %q: expected level %d, got %d", tt.options, tt.source, tt.data, tt.level, msg.Level)
+		}
+	}
+	for _, options := range []map[string]string{
+		{"gelf_level_stdout": "verbose"},
+		{"gelf_level_stderr": "8"},
+		{"gelf_level_pattern": "WARN|ERROR"},
+		{"gelf_level_pattern": "(WARN"},
+	} {
+		if _, err := newLevelRules(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
+
+func TestFieldCache(t *testing.T) {
+	cache := newFieldCache(map[string]interface{}{"_env": "prod"}, nil, nil, fieldLimit{}, nil)
+	container := &docker.Container{ID: "abc", Name: "/app", Config: &docker.Config{Image: "app:1", Labels: map[string]string{"gelf_team": "core"}}}
+	for _, m := range []*router.Message{
+		{Container: container, Data: "hello", Time: time.Now()},
+		{Container: container, Data: "stats", Time: time.Now(), Fields: map[string]string{"stats_cpu": "1.5"}},
+		{Container: container, Data: "renamed", Time: time.Now(), Fields: map[string]string{"container_name": "other"}},
+		{Data: "no container", Time: time.Now(), Replay: true},
+	} {
+		expected := encodedExtra(t, newMessage(m, "host", cache.static, nil))
+		if got := encodedExtra(t, cache.get(m.Container).message(m, "host")); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%q: expected %v, got %v", m.Data, expected, got)
+		}
+	}
+	if fields := cache.get(container); fields != cache.get(container) {
+		t.Error("expected the fields of the container to be kept")
+	}
+	renamed := *container
+	renamed.Name = "/web"
+	if extra := encodedExtra(t, cache.get(&renamed).message(&router.Message{Container: &renamed, Time: time.Now()}, "host")); extra["_container_name"] != "web" {
+		t.Errorf("expected the fields of the inspected container again, got %v", extra["_container_name"])
+	}
+
+	promotion, err := newJSONPromotion(&router.Route{Options: map[string]string{"gelf_json": "true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &router.Message{Container: container, Data: `{"msg":"x","team":"other","user":"joe"}`, Time: time.Now()}
+	fields := cache.get(container)
+	msg := fields.message(m, "host")
+	promotion.promote(msg, m, fields.fixed(msg))
+	if extra := encodedExtra(t, msg); extra["_team"] != "core" || extra["_user"] != "joe" {
+		t.Errorf("expected the JSON fields not to replace those of the container, got %v", extra)
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	text := strings.Repeat("line \"one\" ü\n", 40)
+	for _, policy := range []string{"truncate", "full", "split"} {
+		limit, err := newSizeLimit(&router.Route{Options: map[string]string{
+			"gelf_max_message_bytes": "200", "gelf_oversize": policy, "truncate_ellipsis": "~",
+		}}, router.Truncation{Ellipsis: "~"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if limit.apply(&gelf.Message{Version: "1.1", Host: "host", Short: "fits", TimeUnix: 1}) != nil {
+			t.Errorf("%s: expected a small message to be sent as it is", policy)
+		}
+		msg := &gelf.Message{Version: "1.1", Host: "host", Short: "line \"one\" ü", Full: text, TimeUnix: 1, Extra: map[string]interface{}{"_app": "web"}}
+		messages := limit.apply(msg)
+		var sent strings.Builder
+		for _, m := range messages {
+			if size := limit.size(m); size > 200 {
+				t.Errorf("%s: expected at most 200 bytes, got %d", policy, size)
+			}
+			if m.Extra["_app"] != "web" {
+				t.Errorf("%s: expected the fields to be kept, got %v", policy, m.Extra)
+			}
+			sent.WriteString(m.Short)
+		}
+		switch policy {
+		case "truncate":
+			if len(messages) != 1 || messages[0].Full != "" || !strings.HasSuffix(messages[0].Short, "~") || messages[0].Extra["_truncated"] != true {
+				t.Errorf("%s: expected a cut short_message, got %+v", policy, messages[0])
+			}
+		case "full":
+			if len(messages) != 1 || messages[0].Short != "line \"one\" ü" || !strings.HasPrefix(text, strings.TrimSuffix(messages[0].Full, "~")) {
+				t.Errorf("%s: expected the text in full_message, got %+v", policy, messages[0])
+			}
+		case "split":
+			if sent.String() != text || len(messages) < 2 || messages[1].Extra["_part"] != 2 || messages[1].Extra["_parts"] != len(messages) {
+				t.Errorf("%s: expected the text in numbered parts, got %d messages", policy, len(messages))
+			}
+		}
+	}
+	for _, s := range []string{"plain", "quote \" tab \t bell \x07 bad \xff line \u2028 ü"} {
+		if n := encodedLen(s); n != len(appendString(nil, s))-2 {
+			t.Errorf("%q: expected %d encoded bytes, got %d", s, len(appendString(nil, s))-2, n)
+		}
+	}
+	if limit, err := newSizeLimit(&router.Route{}, router.Truncation{}); limit != nil || err != nil {
+		t.Errorf("expected no limit by default, got %v, %v", limit, err)
+	}
+	for _, options := range []map[string]string{
+		{"gelf_max_message_bytes": "big"},
+		{"gelf_max_message_bytes": "1000", "gelf_oversize": "drop"},
+	} {
+		if _, err := newSizeLimit(&router.Route{Options: options}, router.Truncation{}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
+
+func TestFieldLimit(t *testing.T) {
+	labels := map[string]string{"gelf_huge": strings.Repeat("x", 100)}
+	for i := 0; i < 20; i++ {
+		labels["gelf_l"+strconv.Itoa(i+10)] = "v"
+	}
+	container := &docker.Container{ID: "abc", Name: "/app", Config: &docker.Config{Image: "app:1", Labels: labels}}
+	limit, err := newFieldLimit(&router.Route{Options: map[string]string{"gelf_max_fields": "12", "gelf_max_field_bytes": "10"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := newFieldCache(map[string]interface{}{"_env": "prod"}, nil, nil, limit, nil)
+	extra := encodedExtra(t, cache.get(container).message(&router.Message{Container: container, Time: time.Now()}, "host"))
+	// the 6 fields of the container and _env are kept, and the first 5 of
+	// the labels by name
+	for _, name := range []string{"_env", "_container_id", "_image_name", "_huge", "_l10", "_l13"} {
+		if _, ok := extra[name]; !ok {
+			t.Errorf("expected %s, got %v", name, extra)
+		}
+	}
+	if _, ok := extra["_l14"]; ok || extra["_fields_dropped"] != float64(16) {
+		t.Errorf("expected the last 16 label fields to be dropped, got %v", extra)
+	}
+	if extra["_huge"] != "xxxxxxxxxx" {
+		t.Errorf("expected the value to be cut to 10 bytes, got %v", extra["_huge"])
+	}
+	if limit, _ := newFieldLimit(&router.Route{}); limit.fields != 100 || limit.bytes != 32766 {
+		t.Errorf("expected the default limits, got %+v", limit)
+	}
+	for _, options := range []map[string]string{{"gelf_max_fields": "-1"}, {"gelf_max_field_bytes": "big"}} {
+		if _, err := newFieldLimit(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package gelf
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"text/template"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// hostSourceHost is the host logspout runs on, or the hostname of the
+	// container when it isn't known
+	hostSourceHost              = "host"
+	hostSourceContainerHostname = "container_hostname"
+	hostSourceContainerName     = "container_name"
+	// facilityLabel sets the facility of the messages of a container
+	facilityLabel = "logspout.gelf_facility"
+)
+
+// messageSource sets the host and facility fields of the messages of an
+// adapter, from the gelf_host_source and gelf_facility options
+type messageSource struct {
+	host string
+	// tmpl is the template of the host when the source is one
+	tmpl     *template.Template
+	facility string
+	buf      bytes.Buffer
+}
+
+// newMessageSource returns the source of the options of route
+func newMessageSource(route *router.Route) (*messageSource, error) {
+	s := &messageSource{
+		host:     httpclient.Option(route, "gelf_host_source", "GELF_HOST_SOURCE"),
+		facility: httpclient.Option(route, "gelf_facility", "GELF_FACILITY"),
+	}
+	switch {
+	case s.host == "":
+		s.host = hostSourceHost
+	case s.host == hostSourceHost || s.host == hostSourceContainerHostname || s.host == hostSourceContainerName:
+	case strings.Contains(s.host, "{{"):
+		tmpl, err := template.New("host").Option("missingkey=zero").Parse(s.host)
+		if err != nil {
+			return nil, errors.New("gelf: bad gelf_host_source: " + err.Error())
+		}
+		s.tmpl = tmpl
+	default:
+		return nil, errors.New("gelf: bad gelf_host_source: " + s.host)
+	}
+	return s, nil
+}
+
+// hostOf returns the host field of the message for m, falling back to the
+// host logspout runs on when the source is empty for m
+func (s *messageSource) hostOf(m *router.Message) string {
+	if s == nil {
+		return messageHost(m)
+	}
+	var host string
+	switch {
+	case s.tmpl != nil:
+		s.buf.Reset()
+		if err := s.tmpl.Execute(&s.buf, m); err == nil {
+			host = strings.TrimSpace(s.buf.String())
+		}
+	case m.Container == nil || s.host == hostSourceHost:
+	case s.host == hostSourceContainerName:
+		host = strings.TrimPrefix(m.Container.Name, "/")
+	case s.host == hostSourceContainerHostname && m.Container.Config != nil:
+		host = m.Container.Config.Hostname
+	}
+	if host == "" {
+		return messageHost(m)
+	}
+	return host
+}
+
+// setFacility sets the facility of msg, from the label of the container of m
+// or the option
+func (s *messageSource) setFacility(msg *gelf.Message, m *router.Message) {
+	if s == nil {
+		return
+	}
+	msg.Facility = s.facility
+	if m.Container != nil && m.Container.Config != nil {
+		if facility := m.Container.Config.Labels[facilityLabel]; facility != "" {
+			msg.Facility = facility
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package gelf
+
+import (
+	"testing"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestMessageSource(t *testing.T) {
+	container := &docker.Container{
+		ID:   "8dfafdbc3a40",
+		Name: "/web",
+		Config: &docker.Config{
+			Hostname: "8dfafdbc3a40",
+			Labels:   map[string]string{"com.docker.compose.service": "frontend"},
+		},
+	}
+	m := &router.Message{Container: container, Data: "hello"}
+	for _, tt := range []struct {
+		source, host string
+	}{
+		{"container_hostname", "8dfafdbc3a40"},
+		{"container_name", "web"},
+		{`{{index .Container.Config.Labels "com.docker.compose.service"}}.example.com`, "frontend.example.com"},
+	} {
+		s, err := newMessageSource(&router.Route{Options: map[string]string{"gelf_host_source": tt.source}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host := s.hostOf(m); host != tt.host {
+			t.Errorf("%s: expected %q, got %q", tt.source, tt.host, host)
+		}
+	}
+	if _, err := newMessageSource(&router.Route{Options: map[string]string{"gelf_host_source": "node"}}); err == nil {
+		t.Error("expected an error for an unknown source")
+	}
+
+	s, err := newMessageSource(&router.Route{Options: map[string]string{"gelf_facility": "docker"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &gelf.Message{}
+	s.setFacility(msg, m)
+	if msg.Facility != "docker" {
+		t.Errorf("expected the facility of the option, got %q", msg.Facility)
+	}
+	container.Config.Labels[facilityLabel] = "frontend"
+	s.setFacility(msg, m)
+	if msg.Facility != "frontend" {
+		t.Errorf("expected the facility of the label, got %q", msg.Facility)
+	}
+}
@@ -0,0 +1,302 @@
+package gelf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultHTTPPath          = "/gelf"
+	defaultHTTPBatchSize     = 100
+	defaultHTTPFlushInterval = time.Second
+	defaultTokenHeader       = "Authorization"
+	defaultHTTPRetries       = 2
+	maxRetryAfter            = 30 * time.Second
+)
+
+// retryBackoff is the delay before the first retry of a request, doubled
+// for each next one
+var retryBackoff = 500 * time.Millisecond
+
+// httpWriter posts GELF messages to a Graylog HTTP input, or to the REST
+// ingestion endpoint of a hosted Graylog. Messages are sent in bulk, as
+// newline delimited GELF, once the batch size of messages is pending or the
+// flush interval passed.
+type httpWriter struct {
+	route       *router.Route
+	url         string
+	client      *http.Client
+	tokenHeader string
+	token       string
+	batching    *httpclient.Batching
+	// encoding is the Content-Encoding of the requests, empty when they
+	// aren't compressed
+	encoding string
+	level    int
+	retries  int
+	metrics  *router.Metrics
+
+	mu    sync.Mutex
+	batch bytes.Buffer
+	count int
+
+	sendMu sync.Mutex
+	quit   chan struct{}
+	done   chan struct{}
+}
+
+func newHTTPWriter(route *router.Route) (*httpWriter, error) {
+	path := defaultHTTPPath
+	if route.Path != "" {
+		path = route.Path
+	}
+	u := &url.URL{
+		Scheme: route.AdapterTransport("http"),
+		User:   route.User,
+		Host:   route.Address,
+		Path:   path,
+	}
+	client, err := httpclient.New(route)
+	if err != nil {
+		return nil, err
+	}
+	w := &httpWriter{
+		route:       route,
+		url:         u.String(),
+		client:      client,
+		tokenHeader: httpclient.Option(route, "graylog_token_header", "GRAYLOG_TOKEN_HEADER"),
+		token:       httpclient.Option(route, "graylog_token", "GRAYLOG_TOKEN"),
+		metrics:     route.Metrics(),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if w.tokenHeader == "" {
+		w.tokenHeader = defaultTokenHeader
+	}
+	batchSize := defaultHTTPBatchSize
+	if s := httpclient.Option(route, "gelf_batch_size", "GELF_BATCH_SIZE"); s != "" {
+		if batchSize, err = strconv.Atoi(s); err != nil || batchSize < 1 {
+			return nil, fmt.Errorf("gelf: invalid gelf_batch_size: %s", s)
+		}
+	}
+	if w.batching, err = httpclient.NewBatching(route, batchSize); err != nil {
+		return nil, err
+	}
+	compression, level, err := compressionOptions(route, gelf.CompressNone)
+	if err != nil {
+		return nil, err
+	}
+	switch compression {
+	case gelf.CompressGzip:
+		w.encoding = "gzip"
+	case gelf.CompressZlib:
+		w.encoding = "deflate"
+	}
+	w.level = level
+	w.retries = defaultHTTPRetries
+	if s := httpclient.Option(route, "gelf_http_retries", "GELF_HTTP_RETRIES"); s != "" {
+		if w.retries, err = strconv.Atoi(s); err != nil || w.retries < 0 {
+			return nil, fmt.Errorf("gelf: invalid gelf_http_retries: %s", s)
+		}
+	}
+	interval := defaultHTTPFlushInterval
+	if s := httpclient.Option(route, "gelf_flush_interval", "GELF_FLUSH_INTERVAL"); s != "" {
+		if interval, err = time.ParseDuration(s); err != nil || interval <= 0 {
+			return nil, fmt.Errorf("gelf: invalid gelf_flush_interval: %s", s)
+		}
+	}
+	log.Printf("gelf: using Graylog url: %s\n", u.Redacted())
+	go w.flushEvery(interval)
+	return w, nil
+}
+
+// WriteMessage adds m to the pending batch, sending the batch when it's full
+func (w *httpWriter) WriteMessage(m *gelf.Message) error {
+	w.mu.Lock()
+	if w.count > 0 {
+		w.batch.WriteByte('\n')
+	}
+	if err := encodeMessage(&w.batch, m); err != nil {
+		w.mu.Unlock()
+		w.metrics.Dropped(1)
+		return err
+	}
+	w.count++
+	full := w.count >= w.batching.Limit()
+	w.mu.Unlock()
+	if full {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *httpWriter) flushEvery(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.flush(); err != nil {
+				log.Println("Graylog:", err)
+			}
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// flush sends the pending batch. A batch that fails is dropped.
+func (w *httpWriter) flush() error {
+	err := w.send()
+	if err != nil {
+		w.route.SetConnState(router.ConnFailed, err)
+	}
+	return err
+}
+
+func (w *httpWriter) send() error {
+	w.sendMu.Lock()
+	defer w.sendMu.Unlock()
+	w.mu.Lock()
+	if w.count == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	body := make([]byte, w.batch.Len())
+	copy(body, w.batch.Bytes())
+	count := w.count
+	full := count >= w.batching.Limit()
+	w.batch.Reset()
+	w.count = 0
+	w.mu.Unlock()
+
+	body, err := w.compress(body)
+	if err != nil {
+		w.metrics.Dropped(count)
+		return err
+	}
+	start := time.Now()
+	err = w.postWithRetries(body)
+	w.batching.Observe(len(body), full, time.Since(start), err)
+	if err != nil {
+		w.metrics.Error()
+		w.metrics.Dropped(count)
+		return fmt.Errorf("dropped %d messages: %v", count, err)
+	}
+	w.metrics.Sent(count, len(body))
+	w.route.SetConnState(router.ConnConnected, nil)
+	return nil
+}
+
+// compress returns body with the Content-Encoding of the writer
+func (w *httpWriter) compress(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	var err error
+	switch w.encoding {
+	case "gzip":
+		zw, err = gzip.NewWriterLevel(&buf, w.level)
+	case "deflate":
+		zw, err = zlib.NewWriterLevel(&buf, w.level)
+	default:
+		return body, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err = zw.Write(body); err != nil {
+		return nil, err
+	}
+	if err = zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// postWithRetries posts body, retrying after a server error, a 429 or a
+// failed connection, with a delay doubling each time. A Retry-After of the
+// server is waited for instead, up to maxRetryAfter.
+func (w *httpWriter) postWithRetries(body []byte) error {
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if w.encoding != "" {
+			req.Header.Set("Content-Encoding", w.encoding)
+		}
+		if w.token != "" {
+			req.Header.Set(w.tokenHeader, w.token)
+		}
+		retry, wait, err := w.post(req)
+		if err == nil || !retry || attempt == w.retries {
+			return err
+		}
+		if wait == 0 {
+			wait = backoff
+		}
+		backoff *= 2
+		select {
+		case <-time.After(wait):
+		case <-w.quit:
+			// a closing writer makes one last attempt only
+			return err
+		}
+	}
+}
+
+// post sends req, and returns whether a failure is worth retrying and how
+// long the server asked to wait before that
+func (w *httpWriter) post(req *http.Request) (bool, time.Duration, error) {
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) //nolint:errcheck
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, 0, nil
+	}
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return false, 0, err
+	}
+	var wait time.Duration
+	if seconds, errConv := strconv.Atoi(resp.Header.Get("Retry-After")); errConv == nil && seconds > 0 {
+		wait = time.Duration(seconds) * time.Second
+		if wait > maxRetryAfter {
+			wait = maxRetryAfter
+		}
+	}
+	return true, wait, err
+}
+
+// Close sends the pending batch and stops the periodic flush
+func (w *httpWriter) Close() error {
+	select {
+	case <-w.quit:
+		return nil
+	default:
+	}
+	close(w.quit)
+	<-w.done
+	return w.flush()
+}
@@ -0,0 +1,186 @@
+package gelf
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestHTTPWriterBatchesWithToken(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gelf" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if token := r.Header.Get("X-Graylog-Token"); token != "secret" {
+			t.Errorf("unexpected token %q", token)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	route := &router.Route{
+		Adapter: "gelf+http",
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Options: map[string]string{
+			"graylog_token":        "secret",
+			"graylog_token_header": "X-Graylog-Token",
+			"gelf_batch_size":      "2",
+			"gelf_flush_interval":  "1h",
+		},
+	}
+	writer, err := gelfWriter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, short := range []string{"one", "two", "three"} {
+		if err = writer.WriteMessage(&gelf.Message{Version: "1.1", Host: "host", Short: short}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(bodies))
+	}
+	if lines := strings.Split(bodies[0], "\n"); len(lines) != 2 || !strings.Contains(lines[1], `"short_message":"two"`) {
+		t.Errorf("expected the first request to hold two messages, got %q", bodies[0])
+	}
+	if !strings.Contains(bodies[1], `"short_message":"three"`) {
+		t.Errorf("expected the remaining message to be sent on close, got %q", bodies[1])
+	}
+}
+
+func TestHTTPWriterRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	route := &router.Route{
+		Adapter: "gelf+http",
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Options: map[string]string{"gelf_batch_size": "1"},
+	}
+	writer, err := gelfWriter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if err = writer.WriteMessage(&gelf.Message{Version: "1.1", Short: "one"}); err == nil {
+		t.Error("expected an error for a rejected request")
+	}
+}
+
+func TestHTTPWriterCompressesAndRetries(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+	var mu sync.Mutex
+	var requests int
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("expected a gzip request, got %q", r.Header.Get("Content-Encoding"))
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		body, _ := ioutil.ReadAll(zr)
+		bodies = append(bodies, string(body))
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	route := &router.Route{
+		Adapter: "gelf+http",
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Options: map[string]string{"gelf_batch_size": "1", "gelf_compression_type": "gzip"},
+	}
+	writer, err := gelfWriter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	if err = writer.WriteMessage(&gelf.Message{Version: "1.1", Host: "host", Short: "one"}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 {
+		t.Fatalf("expected the request to be retried once, got %d requests", requests)
+	}
+	for _, body := range bodies {
+		if !strings.Contains(body, `"short_message":"one"`) {
+			t.Errorf("expected the message in each attempt, got %q", body)
+		}
+	}
+}
+
+func TestHTTPWriterRetries(t *testing.T) {
+	defer func(backoff time.Duration) { retryBackoff = backoff }(retryBackoff)
+	retryBackoff = time.Millisecond
+	for _, tt := range []struct {
+		status   int
+		options  map[string]string
+		requests int
+	}{
+		{http.StatusBadRequest, map[string]string{}, 1},
+		{http.StatusTooManyRequests, map[string]string{}, 3},
+		{http.StatusInternalServerError, map[string]string{"gelf_http_retries": "0"}, 1},
+		{http.StatusBadGateway, map[string]string{"gelf_http_retries": "4"}, 5},
+	} {
+		var mu sync.Mutex
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			requests++
+			mu.Unlock()
+			w.WriteHeader(tt.status)
+		}))
+		tt.options["gelf_batch_size"] = "1"
+		writer, err := gelfWriter(&router.Route{Adapter: "gelf+http", Address: strings.TrimPrefix(server.URL, "http://"), Options: tt.options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = writer.WriteMessage(&gelf.Message{Version: "1.1", Short: "one"}); err == nil {
+			t.Errorf("%d: expected an error", tt.status)
+		}
+		writer.Close()
+		server.Close()
+		mu.Lock()
+		if requests != tt.requests {
+			t.Errorf("%d %v: expected %d requests, got %d", tt.status, tt.options, tt.requests, requests)
+		}
+		mu.Unlock()
+	}
+	if _, err := newHTTPWriter(&router.Route{Adapter: "gelf+http", Address: "graylog", Options: map[string]string{"gelf_http_retries": "-1"}}); err == nil {
+		t.Error("expected an error for negative retries")
+	}
+}
@@ -0,0 +1,259 @@
+package gelf
+
+import (
+	"encoding/json"
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// jsonLabel opts a container in or out of JSON parsing
+	jsonLabel = "logspout.gelf_json"
+	// maxJSONFields bounds the extra fields promoted from one message
+	maxJSONFields = 100
+)
+
+var (
+	jsonMessageKeys = []string{"msg", "message"}
+	jsonLevelKeys   = []string{"level", "severity", "lvl"}
+	jsonTimeKeys    = []string{"timestamp", "@timestamp", "time", "ts"}
+)
+
+// jsonLevels maps level names to syslog severities
+var jsonLevels = map[string]int32{
+	"trace":     gelf.LOG_DEBUG,
+	"debug":     gelf.LOG_DEBUG,
+	"info":      gelf.LOG_INFO,
+	"notice":    gelf.LOG_NOTICE,
+	"warn":      gelf.LOG_WARNING,
+	"warning":   gelf.LOG_WARNING,
+	"err":       gelf.LOG_ERR,
+	"error":     gelf.LOG_ERR,
+	"crit":      gelf.LOG_CRIT,
+	"critical":  gelf.LOG_CRIT,
+	"fatal":     gelf.LOG_CRIT,
+	"panic":     gelf.LOG_CRIT,
+	"alert":     gelf.LOG_ALERT,
+	"emerg":     gelf.LOG_EMERG,
+	"emergency": gelf.LOG_EMERG,
+}
+
+// jsonPromotion parses messages that are JSON objects, and promotes their
+// message, level and time to the GELF fields and the other keys to extra
+// fields
+type jsonPromotion struct {
+	// all parses the messages of all containers, instead of those with the
+	// label only
+	all     bool
+	include []string
+	exclude []string
+}
+
+// newJSONPromotion returns the promotion for the gelf_json options of route
+func newJSONPromotion(route *router.Route) (*jsonPromotion, error) {
+	p := &jsonPromotion{
+		include: splitList(httpclient.Option(route, "gelf_json_fields", "GELF_JSON_FIELDS")),
+		exclude: splitList(httpclient.Option(route, "gelf_json_exclude", "GELF_JSON_EXCLUDE")),
+	}
+	switch s := httpclient.Option(route, "gelf_json", "GELF_JSON"); s {
+	case "true":
+		p.all = true
+	case "", "false":
+	default:
+		return nil, errors.New("gelf: bad gelf_json: " + s)
+	}
+	for _, pattern := range append(p.include, p.exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New("gelf: bad field pattern: " + pattern)
+		}
+	}
+	return p, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// enabled returns whether the messages of the container of m are parsed
+func (p *jsonPromotion) enabled(m *router.Message) bool {
+	if m.Container != nil && m.Container.Config != nil {
+		if s, ok := m.Container.Config.Labels[jsonLabel]; ok {
+			return strings.EqualFold(s, "true")
+		}
+	}
+	return p.all
+}
+
+// keep returns whether the field with the flattened name is promoted
+func (p *jsonPromotion) keep(name string) bool {
+	for _, pattern := range p.exclude {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(p.include) == 0 {
+		return true
+	}
+	for _, pattern := range p.include {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// promote updates msg with the JSON object in the data of m. Messages that
+// aren't a JSON object are left as they are.
+func (p *jsonPromotion) promote(msg *gelf.Message, m *router.Message, fixed map[string]interface{}) {
+	data := strings.TrimSpace(m.Data)
+	if !p.enabled(m) || !strings.HasPrefix(data, "{") {
+		return
+	}
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return
+	}
+	if msg.Extra == nil {
+		msg.Extra = make(map[string]interface{})
+	}
+	extra := msg.Extra
+	if s, ok := takeString(object, jsonMessageKeys); ok {
+		msg.Short = s
+	}
+	for _, key := range jsonLevelKeys {
+		if level, ok := jsonLevel(object[key]); ok {
+			msg.Level = level
+			delete(object, key)
+			break
+		}
+	}
+	for _, key := range jsonTimeKeys {
+		if t, ok := jsonTime(object[key]); ok {
+			msg.TimeUnix = float64(t.UnixNano()/int64(time.Millisecond)) / 1000.0
+			delete(object, key)
+			break
+		}
+	}
+	fields := make(map[string]interface{})
+	flatten("", object, fields)
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	added := 0
+	for _, name := range names {
+		if added == maxJSONFields {
+			break
+		}
+		key := extraName(name)
+		// the fields of logspout win over those of the message
+		if _, ok := extra[key]; ok || name == "" || !p.keep(name) {
+			continue
+		}
+		if _, ok := fixed[key]; ok {
+			continue
+		}
+		extra[key] = fields[name]
+		added++
+	}
+}
+
+// takeString removes and returns the first of keys in object with a string
+// value
+func takeString(object map[string]interface{}, keys []string) (string, bool) {
+	for _, key := range keys {
+		if s, ok := object[key].(string); ok {
+			delete(object, key)
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// jsonLevel returns the syslog severity of a level name, a syslog severity or
+// a Bunyan or Pino level number
+func jsonLevel(value interface{}) (int32, bool) {
+	switch v := value.(type) {
+	case string:
+		level, ok := jsonLevels[strings.ToLower(v)]
+		return level, ok
+	case json.Number:
+		n, err := v.Int64()
+		switch {
+		case err != nil || n < 0:
+			return 0, false
+		case n <= int64(gelf.LOG_DEBUG):
+			return int32(n), true
+		case n <= 20:
+			return gelf.LOG_DEBUG, true
+		case n <= 30:
+			return gelf.LOG_INFO, true
+		case n <= 40:
+			return gelf.LOG_WARNING, true
+		case n <= 50:
+			return gelf.LOG_ERR, true
+		default:
+			return gelf.LOG_CRIT, true
+		}
+	}
+	return 0, false
+}
+
+// jsonTime returns the time of an RFC 3339 string, or of a number of seconds
+// or milliseconds since the epoch
+func jsonTime(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil || f <= 0 {
+			return time.Time{}, false
+		}
+		if f > 1e12 {
+			f /= 1000
+		}
+		return time.Unix(0, int64(f*float64(time.Second))), true
+	}
+	return time.Time{}, false
+}
+
+// flatten adds the values of object to fields, with the keys of nested
+// objects joined by _. Arrays are added as JSON.
+func flatten(prefix string, object map[string]interface{}, fields map[string]interface{}) {
+	for key, value := range object {
+		name := key
+		if prefix != "" {
+			name = prefix + "_" + key
+		}
+		switch v := value.(type) {
+		case nil:
+		case map[string]interface{}:
+			flatten(name, v, fields)
+		case []interface{}:
+			if buf, err := json.Marshal(v); err == nil {
+				fields[name] = string(buf)
+			}
+		default:
+			fields[name] = v
+		}
+	}
+}
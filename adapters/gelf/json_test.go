@@ -0,0 +1,124 @@
+package gelf
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func promoted(t *testing.T, options map[string]string, m *router.Message) (*gelf.Message, map[string]interface{}) {
+	promotion, err := newJSONPromotion(&router.Route{Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := newMessage(m, "host", nil, nil)
+	promotion.promote(msg, m, nil)
+	return msg, encodedExtra(t, msg)
+}
+
+func TestJSONPromotion(t *testing.T) {
+	container := &docker.Container{ID: "abc", Name: "/app", Config: &docker.Config{}}
+	msg, extra := promoted(t, map[string]string{"gelf_json": "true"}, &router.Message{
+		Container: container,
+		Source:    "stdout",
+		Data:      `{"msg":"request done","level":"warn","time":"2024-05-01T10:00:00.5Z","http":{"status":500,"path":"/"},"tags":["a","b"],"container_id":"other","empty":null}`,
+		Time:      time.Now(),
+	})
+	if msg.Short != "request done" {
+		t.Errorf("expected the msg field as short message, got %q", msg.Short)
+	}
+	if msg.Level != gelf.LOG_WARNING {
+		t.Errorf("expected level %d, got %d", gelf.LOG_WARNING, msg.Level)
+	}
+	if expected := float64(time.Date(2024, 5, 1, 10, 0, 0, 5e8, time.UTC).Unix()) + 0.5; msg.TimeUnix != expected {
+		t.Errorf("expected time %v, got %v", expected, msg.TimeUnix)
+	}
+	for key, value := range map[string]interface{}{
+		"_http_status":  float64(500),
+		"_http_path":    "/",
+		"_tags":         `["a","b"]`,
+		"_container_id": "abc",
+	} {
+		if extra[key] != value {
+			t.Errorf("expected %s=%v, got %v", key, value, extra[key])
+		}
+	}
+	for _, key := range []string{"_msg", "_level", "_time", "_empty"} {
+		if _, ok := extra[key]; ok {
+			t.Errorf("expected no %s field", key)
+		}
+	}
+}
+
+func TestJSONPromotionFields(t *testing.T) {
+	m := &router.Message{Data: `{"message":"hi","a":1,"b":{"c":2,"d":3},"secret":"x"}`, Time: time.Now()}
+	_, extra := promoted(t, map[string]string{"gelf_json": "true", "gelf_json_fields": "a,b_*", "gelf_json_exclude": "b_d"}, m)
+	if len(extra) != 2 || extra["_a"] == nil || extra["_b_c"] == nil {
+		t.Errorf("expected _a and _b_c only, got %v", extra)
+	}
+}
+
+func TestJSONPromotionLabel(t *testing.T) {
+	labelled := &docker.Container{Config: &docker.Config{Labels: map[string]string{jsonLabel: "true"}}}
+	optedOut := &docker.Container{Config: &docker.Config{Labels: map[string]string{jsonLabel: "false"}}}
+	for _, tt := range []struct {
+		options   map[string]string
+		container *docker.Container
+		short     string
+	}{
+		{map[string]string{}, &docker.Container{Config: &docker.Config{}}, `{"msg":"hi"}`},
+		{map[string]string{}, labelled, "hi"},
+		{map[string]string{"gelf_json": "true"}, optedOut, `{"msg":"hi"}`},
+		{map[string]string{"gelf_json": "true"}, labelled, "hi"},
+	} {
+		msg, _ := promoted(t, tt.options, &router.Message{Container: tt.container, Data: `{"msg":"hi"}`, Time: time.Now()})
+		if msg.Short != tt.short {
+			t.Errorf("%v %v: expected %q, got %q", tt.options, tt.container.Config.Labels, tt.short, msg.Short)
+		}
+	}
+}
+
+func TestJSONPromotionPlainText(t *testing.T) {
+	for _, data := range []string{"plain text", `{"truncated":`, `["array"]`} {
+		msg, _ := promoted(t, map[string]string{"gelf_json": "true"}, &router.Message{Data: data, Source: "stderr", Time: time.Now()})
+		if msg.Short != data || msg.Level != gelf.LOG_ERR {
+			t.Errorf("expected %q to be left as is, got %+v", data, msg)
+		}
+	}
+}
+
+func TestJSONLevel(t *testing.T) {
+	for _, tt := range []struct {
+		value interface{}
+		level int32
+		ok    bool
+	}{
+		{"ERROR", gelf.LOG_ERR, true},
+		{"fatal", gelf.LOG_CRIT, true},
+		{json.Number("3"), gelf.LOG_ERR, true},
+		{json.Number("30"), gelf.LOG_INFO, true},
+		{json.Number("50"), gelf.LOG_ERR, true},
+		{"verbose", 0, false},
+		{true, 0, false},
+	} {
+		if level, ok := jsonLevel(tt.value); level != tt.level || ok != tt.ok {
+			t.Errorf("%v: expected %d, %v, got %d, %v", tt.value, tt.level, tt.ok, level, ok)
+		}
+	}
+}
+
+func TestJSONPromotionOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"gelf_json": "yes"},
+		{"gelf_json_fields": "[a"},
+	} {
+		if _, err := newJSONPromotion(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package gelf
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultLabelPrefix = "gelf_"
+	allLabelsPrefix    = "label_"
+)
+
+// labelFields are the rules turning container labels into extra fields. The
+// nil rules send the labels starting with gelf_, without the prefix.
+type labelFields struct {
+	// prefix starts the labels sent without it, matched in any case
+	prefix string
+	// fields maps labels to the extra fields they are sent as
+	fields map[string]string
+	// all sends every label as _label_<name>
+	all bool
+}
+
+// newLabelFields returns the rules for the gelf_label_prefix,
+// gelf_label_fields and gelf_all_labels options of route, or nil when none
+// are set
+func newLabelFields(route *router.Route) (*labelFields, error) {
+	prefix := httpclient.Option(route, "gelf_label_prefix", "GELF_LABEL_PREFIX")
+	mapping := httpclient.Option(route, "gelf_label_fields", "GELF_LABEL_FIELDS")
+	all := httpclient.Option(route, "gelf_all_labels", "GELF_ALL_LABELS")
+	if prefix == "" && mapping == "" && all == "" {
+		return nil, nil
+	}
+	l := &labelFields{prefix: defaultLabelPrefix}
+	if prefix != "" {
+		l.prefix = prefix
+	}
+	switch all {
+	case "true":
+		l.all = true
+	case "", "false":
+	default:
+		return nil, errors.New("gelf: bad gelf_all_labels: " + all)
+	}
+	if mapping != "" {
+		l.fields = make(map[string]string)
+		for _, pair := range splitList(mapping) {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, errors.New("gelf: bad gelf_label_fields: " + mapping)
+			}
+			label, field := strings.TrimSpace(kv[0]), strings.TrimPrefix(strings.TrimSpace(kv[1]), "_")
+			if label == "" || field == "" {
+				return nil, errors.New("gelf: bad gelf_label_fields: " + mapping)
+			}
+			l.fields[label] = extraName(field)
+		}
+	}
+	return l, nil
+}
+
+// add adds the extra fields of labels to extra: all labels first, then those
+// with the prefix, then the mapped ones, so the more specific rules win
+func (l *labelFields) add(labels map[string]string, extra map[string]interface{}) {
+	prefix := defaultLabelPrefix
+	if l != nil {
+		prefix = l.prefix
+		if l.all {
+			for name, value := range labels {
+				extra[extraName(allLabelsPrefix+name)] = value
+			}
+		}
+	}
+	for name, value := range labels {
+		if len(name) > len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+			extra[extraName(name[len(prefix):])] = value
+		}
+	}
+	if l != nil {
+		for label, field := range l.fields {
+			if value, ok := labels[label]; ok {
+				extra[field] = value
+			}
+		}
+	}
+}
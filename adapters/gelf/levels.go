@@ -0,0 +1,134 @@
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// levelRules set the GELF level of messages: by their source, from the text
+// matched by a regular expression, or from a key of JSON messages. The nil
+// rules leave the info level of stdout and the error level of stderr.
+type levelRules struct {
+	stdout int32
+	stderr int32
+	// pattern matches the level in the text, as its level group or else its
+	// first group
+	pattern *regexp.Regexp
+	group   int
+	// field is the key of JSON messages holding the level, with the keys of
+	// nested objects separated by dots
+	field []string
+}
+
+// newLevelRules returns the rules for the gelf_level options of route, or nil
+// when none are set
+func newLevelRules(route *router.Route) (*levelRules, error) {
+	stdout := httpclient.Option(route, "gelf_level_stdout", "GELF_LEVEL_STDOUT")
+	stderr := httpclient.Option(route, "gelf_level_stderr", "GELF_LEVEL_STDERR")
+	pattern := httpclient.Option(route, "gelf_level_pattern", "GELF_LEVEL_PATTERN")
+	field := httpclient.Option(route, "gelf_level_field", "GELF_LEVEL_FIELD")
+	if stdout == "" && stderr == "" && pattern == "" && field == "" {
+		return nil, nil
+	}
+	l := &levelRules{stdout: gelf.LOG_INFO, stderr: gelf.LOG_ERR}
+	for _, option := range []struct {
+		name, value string
+		level       *int32
+	}{
+		{"gelf_level_stdout", stdout, &l.stdout},
+		{"gelf_level_stderr", stderr, &l.stderr},
+	} {
+		if option.value == "" {
+			continue
+		}
+		level, ok := optionLevel(option.value)
+		if !ok {
+			return nil, errors.New("gelf: bad " + option.name + ": " + option.value)
+		}
+		*option.level = level
+	}
+	if pattern != "" {
+		var err error
+		if l.pattern, err = regexp.Compile(pattern); err != nil {
+			return nil, errors.New("gelf: bad gelf_level_pattern: " + err.Error())
+		}
+		if l.pattern.NumSubexp() == 0 {
+			return nil, errors.New("gelf: bad gelf_level_pattern: no group for the level: " + pattern)
+		}
+		l.group = 1
+		for i, name := range l.pattern.SubexpNames() {
+			if name == "level" {
+				l.group = i
+			}
+		}
+	}
+	if field != "" {
+		l.field = strings.Split(field, ".")
+	}
+	return l, nil
+}
+
+// optionLevel returns the syslog severity of a level name or of a severity
+// from 0 to 7, as given in options or matched in texts
+func optionLevel(s string) (int32, bool) {
+	if level, ok := jsonLevel(s); ok {
+		return level, true
+	}
+	if len(s) == 1 && s[0] >= '0' && s[0] <= '7' {
+		return int32(s[0] - '0'), true
+	}
+	return 0, false
+}
+
+// apply sets the level of msg for m: that of its source, replaced by the one
+// found in its text when there is one
+func (l *levelRules) apply(msg *gelf.Message, m *router.Message) {
+	if m.Source == "stderr" {
+		msg.Level = l.stderr
+	} else {
+		msg.Level = l.stdout
+	}
+	if l.pattern != nil {
+		if match := l.pattern.FindStringSubmatch(m.Data); match != nil {
+			if level, ok := optionLevel(match[l.group]); ok {
+				msg.Level = level
+				return
+			}
+		}
+	}
+	if l.field != nil {
+		if level, ok := l.fieldLevel(m.Data); ok {
+			msg.Level = level
+		}
+	}
+}
+
+// fieldLevel returns the level in the field of data when it is a JSON object
+func (l *levelRules) fieldLevel(data string) (int32, bool) {
+	data = strings.TrimSpace(data)
+	if !strings.HasPrefix(data, "{") {
+		return 0, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(data)))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return 0, false
+	}
+	for _, key := range l.field {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return 0, false
+		}
+		value = object[key]
+	}
+	return jsonLevel(value)
+}
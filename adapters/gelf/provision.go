@@ -0,0 +1,179 @@
+package gelf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const graylogAPITimeout = 10 * time.Second
+
+// inputTypes are the Graylog input types of the transports
+var inputTypes = map[string]string{
+	"udp":   "org.graylog2.inputs.gelf.udp.GELFUDPInput",
+	"tcp":   "org.graylog2.inputs.gelf.tcp.GELFTCPInput",
+	"tls":   "org.graylog2.inputs.gelf.tcp.GELFTCPInput",
+	"http":  "org.graylog2.inputs.gelf.http.GELFHttpInput",
+	"https": "org.graylog2.inputs.gelf.http.GELFHttpInput",
+}
+
+type graylogInput struct {
+	ID         string                 `json:"id"`
+	Title      string                 `json:"title"`
+	Type       string                 `json:"type"`
+	Attributes map[string]interface{} `json:"attributes"`
+}
+
+type graylogIndexSet struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// graylogAPI checks the Graylog a route sends to through its REST API, so a
+// missing input fails the route instead of dropping its messages silently
+type graylogAPI struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// provisionInput verifies, when the graylog_api option is set, that Graylog
+// has the input the route sends to, and the index set of graylog_index_set.
+// With graylog_create_input=true a missing input is created.
+func provisionInput(route *router.Route) error {
+	u := httpclient.Option(route, "graylog_api", "GRAYLOG_API")
+	if u == "" {
+		return nil
+	}
+	create := false
+	if s := httpclient.Option(route, "graylog_create_input", "GRAYLOG_CREATE_INPUT"); s != "" {
+		var err error
+		if create, err = strconv.ParseBool(s); err != nil {
+			return errors.New("gelf: bad graylog_create_input: " + s)
+		}
+	}
+	api := &graylogAPI{
+		url:    strings.TrimSuffix(u, "/"),
+		token:  httpclient.Option(route, "graylog_api_token", "GRAYLOG_API_TOKEN"),
+		client: &http.Client{Timeout: graylogAPITimeout},
+	}
+	if title := httpclient.Option(route, "graylog_index_set", "GRAYLOG_INDEX_SET"); title != "" {
+		if err := api.checkIndexSet(title); err != nil {
+			return err
+		}
+	}
+	return api.checkInput(route.AdapterTransport("udp"), route.Address, create)
+}
+
+func (api *graylogAPI) do(method, path string, body, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, api.url+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	// Graylog asks for this header on requests changing its state
+	req.Header.Set("X-Requested-By", "logspout")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if api.token != "" {
+		req.SetBasicAuth(api.token, "token")
+	}
+	resp, err := api.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("gelf: Graylog API: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		io.Copy(ioutil.Discard, resp.Body) //nolint:errcheck
+		return fmt.Errorf("gelf: Graylog API: %s %s: %s", method, path, resp.Status)
+	}
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (api *graylogAPI) checkIndexSet(title string) error {
+	var sets struct {
+		IndexSets []graylogIndexSet `json:"index_sets"`
+	}
+	if err := api.do("GET", "/system/indices/index_sets", nil, &sets); err != nil {
+		return err
+	}
+	for _, set := range sets.IndexSets {
+		if set.Title == title {
+			return nil
+		}
+	}
+	return fmt.Errorf("gelf: Graylog at %s has no index set %q", api.url, title)
+}
+
+// checkInput looks for an input of the type of transport on the port of
+// address, on any port when address has none
+func (api *graylogAPI) checkInput(transport, address string, create bool) error {
+	inputType, ok := inputTypes[transport]
+	if !ok {
+		return nil
+	}
+	port := ""
+	if _, p, err := net.SplitHostPort(address); err == nil {
+		port = p
+	}
+	var inputs struct {
+		Inputs []graylogInput `json:"inputs"`
+	}
+	if err := api.do("GET", "/system/inputs", nil, &inputs); err != nil {
+		return err
+	}
+	for _, input := range inputs.Inputs {
+		if input.Type == inputType && (port == "" || fmt.Sprint(input.Attributes["port"]) == port) {
+			return nil
+		}
+	}
+	missing := fmt.Sprintf("gelf: Graylog at %s has no %s input on port %s", api.url, inputType, port)
+	switch {
+	case !create:
+		return errors.New(missing + ", create it or set graylog_create_input=true")
+	case port == "" || transport == "tls":
+		return errors.New(missing + ", create it in Graylog")
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return errors.New("gelf: bad port: " + port)
+	}
+	input := map[string]interface{}{
+		"title":  "GELF " + strings.ToUpper(transport) + " " + port + " (logspout)",
+		"type":   inputType,
+		"global": true,
+		"configuration": map[string]interface{}{
+			"bind_address": "0.0.0.0",
+			"port":         portNumber,
+		},
+	}
+	if err := api.do("POST", "/system/inputs", input, nil); err != nil {
+		return err
+	}
+	log.Printf("gelf: created %s input on port %s in Graylog at %s", inputType, port, api.url)
+	return nil
+}
@@ -0,0 +1,103 @@
+package gelf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// fakeGraylog serves the inputs and index sets of the Graylog REST API
+type fakeGraylog struct {
+	mu      sync.Mutex
+	inputs  []graylogInput
+	created []map[string]interface{}
+}
+
+func (g *fakeGraylog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if user, pass, _ := r.BasicAuth(); user != "secret" || pass != "token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/api/system/inputs":
+		json.NewEncoder(w).Encode(map[string]interface{}{"inputs": g.inputs, "total": len(g.inputs)})
+	case r.Method == "POST" && r.URL.Path == "/api/system/inputs":
+		if r.Header.Get("X-Requested-By") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var input map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&input)
+		g.created = append(g.created, input)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "GET" && r.URL.Path == "/api/system/indices/index_sets":
+		json.NewEncoder(w).Encode(map[string]interface{}{"index_sets": []graylogIndexSet{{ID: "1", Title: "Default index set"}}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestProvisionInput(t *testing.T) {
+	graylog := &fakeGraylog{inputs: []graylogInput{
+		{ID: "a", Type: inputTypes["udp"], Attributes: map[string]interface{}{"port": 12201}},
+	}}
+	server := httptest.NewServer(graylog)
+	defer server.Close()
+	options := func(extra ...string) map[string]string {
+		o := map[string]string{"graylog_api": server.URL + "/api", "graylog_api_token": "secret"}
+		for i := 0; i < len(extra); i += 2 {
+			o[extra[i]] = extra[i+1]
+		}
+		return o
+	}
+	for _, tt := range []struct {
+		adapter, address string
+		options          map[string]string
+		err              string
+	}{
+		{"gelf", "graylog:12201", options(), ""},
+		{"gelf", "graylog:12201", options("graylog_index_set", "Default index set"), ""},
+		{"gelf", "graylog:12201", options("graylog_index_set", "Audit"), "no index set"},
+		{"gelf", "graylog:12202", options(), "create it or set graylog_create_input=true"},
+		{"gelf+tcp", "graylog:12201", options(), "GELFTCPInput"},
+		{"gelf+tls", "graylog:12201", options("graylog_create_input", "true"), "create it in Graylog"},
+		{"gelf", "graylog:12201", map[string]string{"graylog_api": server.URL + "/api"}, "401"},
+	} {
+		err := provisionInput(&router.Route{Adapter: tt.adapter, Address: tt.address, Options: tt.options})
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s %s %v: expected error %q, got %v", tt.adapter, tt.address, tt.options, tt.err, err)
+		}
+	}
+	if len(graylog.created) != 0 {
+		t.Fatalf("expected no inputs to be created, got %v", graylog.created)
+	}
+	route := &router.Route{Adapter: "gelf+tcp", Address: "graylog:12201", Options: options("graylog_create_input", "true")}
+	if err := provisionInput(route); err != nil {
+		t.Fatal(err)
+	}
+	if len(graylog.created) != 1 || graylog.created[0]["type"] != inputTypes["tcp"] || graylog.created[0]["global"] != true {
+		t.Fatalf("expected a global GELF TCP input, got %v", graylog.created)
+	}
+	if port := graylog.created[0]["configuration"].(map[string]interface{})["port"]; port != float64(12201) {
+		t.Errorf("expected port 12201, got %v", port)
+	}
+}
+
+func TestProvisionInputDisabled(t *testing.T) {
+	if err := provisionInput(&router.Route{Adapter: "gelf", Address: "graylog:12201"}); err != nil {
+		t.Fatal(err)
+	}
+	route := &router.Route{Adapter: "gelf", Address: "graylog:12201", Options: map[string]string{
+		"graylog_api": "http://127.0.0.1:1/api", "graylog_create_input": "maybe",
+	}}
+	if err := provisionInput(route); err == nil {
+		t.Error("expected an error for a bad graylog_create_input")
+	}
+}
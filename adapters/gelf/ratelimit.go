@@ -0,0 +1,183 @@
+package gelf
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// rateLimitLabel sets the rate limit of a container, or none for none
+	rateLimitLabel          = "logspout.gelf_rate_limit"
+	defaultRateLimitSummary = time.Minute
+	rateLimitUnlimited      = "none"
+)
+
+// rateLimit is a number of messages per period, like 100/s
+type rateLimit struct {
+	spec string
+	// rate is the number of messages per second, and burst the most sent
+	// at once
+	rate  float64
+	burst float64
+}
+
+// parseRateLimit parses a limit like 100/s, 6000/m or 50000/h, returning nil
+// for none
+func parseRateLimit(s string) (*rateLimit, bool) {
+	if s == rateLimitUnlimited {
+		return nil, true
+	}
+	parts := strings.SplitN(s, "/", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || n < 1 {
+		return nil, false
+	}
+	var per time.Duration
+	switch parts[1] {
+	case "s", "sec", "second":
+		per = time.Second
+	case "m", "min", "minute":
+		per = time.Minute
+	case "h", "hour":
+		per = time.Hour
+	default:
+		return nil, false
+	}
+	return &rateLimit{spec: s, rate: float64(n) / per.Seconds(), burst: float64(n)}, true
+}
+
+// tokenBucket is the rate limit of a container, with the messages it
+// suppressed since the last summary
+type tokenBucket struct {
+	limit      *rateLimit
+	tokens     float64
+	last       time.Time
+	suppressed int
+	container  *docker.Container
+}
+
+// rateLimiter drops the messages of the containers sending more than their
+// rate limit, the gelf_rate_limit option or the logspout.gelf_rate_limit
+// label of the container, with a token bucket per container. Every
+// gelf_rate_limit_summary it sends a message for each container that was
+// limited, with the number of messages suppressed.
+type rateLimiter struct {
+	dfault  *rateLimit
+	summary time.Duration
+	buckets map[string]*tokenBucket
+}
+
+// newRateLimiter returns the limiter of the gelf_rate_limit options of
+// route. Without the option only the containers with the label are limited.
+func newRateLimiter(route *router.Route) (*rateLimiter, error) {
+	var dfault *rateLimit
+	if option := httpclient.Option(route, "gelf_rate_limit", "GELF_RATE_LIMIT"); option != "" {
+		var ok bool
+		if dfault, ok = parseRateLimit(option); !ok {
+			return nil, errors.New("gelf: bad gelf_rate_limit: " + option)
+		}
+	}
+	l := &rateLimiter{dfault: dfault, summary: defaultRateLimitSummary, buckets: make(map[string]*tokenBucket)}
+	if s := httpclient.Option(route, "gelf_rate_limit_summary", "GELF_RATE_LIMIT_SUMMARY"); s != "" {
+		var err error
+		if l.summary, err = time.ParseDuration(s); err != nil || l.summary <= 0 {
+			return nil, errors.New("gelf: bad gelf_rate_limit_summary: " + s)
+		}
+	}
+	return l, nil
+}
+
+// allow returns whether m is within the rate limit of its container at now,
+// counting it as suppressed when it isn't
+func (l *rateLimiter) allow(m *router.Message, now time.Time) bool {
+	if l == nil || m.Container == nil {
+		return true
+	}
+	b := l.buckets[m.Container.ID]
+	if b == nil {
+		b = &tokenBucket{limit: l.dfault, container: m.Container, last: now}
+		if m.Container.Config != nil {
+			if s, ok := m.Container.Config.Labels[rateLimitLabel]; ok {
+				if limit, ok := parseRateLimit(s); ok {
+					b.limit = limit
+				} else {
+					log.Printf("gelf: bad %s label of %s: %s", rateLimitLabel, m.Container.Name, s)
+				}
+			}
+		}
+		if b.limit != nil {
+			b.tokens = b.limit.burst
+		}
+		l.buckets[m.Container.ID] = b
+	}
+	if b.limit == nil {
+		return true
+	}
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.rate
+	if b.tokens > b.limit.burst {
+		b.tokens = b.limit.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		b.suppressed++
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimitSummary is the message of a container that suppressed messages
+type rateLimitSummary struct {
+	message    *router.Message
+	suppressed int
+}
+
+// summaries returns the summaries of the containers that suppressed messages
+// since the last call, and forgets the containers that sent none for a
+// while, so buckets don't pile up
+func (l *rateLimiter) summaries(now time.Time) []rateLimitSummary {
+	if l == nil {
+		return nil
+	}
+	var summaries []rateLimitSummary
+	for id, b := range l.buckets {
+		if b.suppressed > 0 {
+			summaries = append(summaries, rateLimitSummary{
+				message: &router.Message{
+					Container: b.container,
+					Source:    "stderr",
+					Data:      fmt.Sprintf("%d messages suppressed by the rate limit of %s", b.suppressed, b.limit.spec),
+					Time:      now,
+					Fields:    map[string]string{"rate_limit": b.limit.spec},
+				},
+				suppressed: b.suppressed,
+			})
+			b.suppressed = 0
+		} else if now.Sub(b.last) > 2*l.summary {
+			delete(l.buckets, id)
+		}
+	}
+	return summaries
+}
+
+// tick returns the channel of the summary interval, nil without a limiter,
+// and the function to stop it
+func (l *rateLimiter) tick() (<-chan time.Time, func()) {
+	if l == nil {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(l.summary)
+	return ticker.C, ticker.Stop
+}
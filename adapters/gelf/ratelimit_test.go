@@ -0,0 +1,96 @@
+package gelf
+
+import (
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l, err := newRateLimiter(&router.Route{Options: map[string]string{"gelf_rate_limit": "2/s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chatty := &docker.Container{ID: "chatty", Name: "/chatty", Config: &docker.Config{}}
+	quiet := &docker.Container{ID: "quiet", Name: "/quiet", Config: &docker.Config{Labels: map[string]string{rateLimitLabel: "none"}}}
+	slow := &docker.Container{ID: "slow", Name: "/slow", Config: &docker.Config{Labels: map[string]string{rateLimitLabel: "1/m"}}}
+	now := time.Unix(1000, 0)
+	allowed := func(c *docker.Container, n int, at time.Time) int {
+		count := 0
+		for i := 0; i < n; i++ {
+			if l.allow(&router.Message{Container: c}, at) {
+				count++
+			}
+		}
+		return count
+	}
+	if n := allowed(chatty, 5, now); n != 2 {
+		t.Errorf("expected the burst of 2, got %d", n)
+	}
+	if n := allowed(chatty, 5, now.Add(500*time.Millisecond)); n != 1 {
+		t.Errorf("expected 1 message after half a second, got %d", n)
+	}
+	if n := allowed(quiet, 5, now); n != 5 {
+		t.Errorf("expected no limit with the none label, got %d", n)
+	}
+	if n := allowed(slow, 3, now); n != 1 {
+		t.Errorf("expected the limit of the label, got %d", n)
+	}
+
+	summaries := l.summaries(now.Add(time.Second))
+	if len(summaries) != 2 {
+		t.Fatalf("expected summaries of the 2 limited containers, got %d", len(summaries))
+	}
+	for _, s := range summaries {
+		switch s.message.Container {
+		case chatty:
+			if s.suppressed != 7 || s.message.Data != "7 messages suppressed by the rate limit of 2/s" {
+				t.Errorf("unexpected summary %d %q", s.suppressed, s.message.Data)
+			}
+		case slow:
+			if s.suppressed != 2 {
+				t.Errorf("expected 2 suppressed messages of slow, got %d", s.suppressed)
+			}
+		default:
+			t.Errorf("unexpected summary of %s", s.message.Container.Name)
+		}
+	}
+	if summaries = l.summaries(now.Add(2 * time.Second)); len(summaries) != 0 {
+		t.Errorf("expected no summaries without suppressed messages, got %d", len(summaries))
+	}
+	if l.summaries(now.Add(time.Hour)); len(l.buckets) != 0 {
+		t.Errorf("expected the idle buckets to be dropped, got %d", len(l.buckets))
+	}
+
+	for _, option := range []string{"100", "0/s", "10/day", "many/s"} {
+		if _, err := newRateLimiter(&router.Route{Options: map[string]string{"gelf_rate_limit": option}}); err == nil {
+			t.Errorf("%s: expected an error", option)
+		}
+	}
+}
+
+func TestRateLimitSummary(t *testing.T) {
+	limiter, err := newRateLimiter(&router.Route{Options: map[string]string{"gelf_rate_limit": "1/h"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &fakeWriter{}
+	adapter := &GelfAdapter{writer: writer, route: &router.Route{}, limiter: limiter}
+	container := &docker.Container{ID: "chatty", Name: "/chatty", Config: &docker.Config{}}
+	stream := make(chan *router.Message, 3)
+	for i := 0; i < 3; i++ {
+		stream <- &router.Message{Container: container, Data: "hello", Time: time.Now()}
+	}
+	close(stream)
+	adapter.Stream(stream)
+	// the first message and the summary sent when the stream ends
+	if writer.messages != 2 {
+		t.Fatalf("expected 2 messages, got %d", writer.messages)
+	}
+	if writer.last.Extra["_suppressed"] != 2 || writer.last.Level != 4 || writer.last.Extra["_rate_limit"] != "1/h" {
+		t.Errorf("unexpected summary %+v", writer.last)
+	}
+}
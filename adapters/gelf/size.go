@@ -0,0 +1,203 @@
+package gelf
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	oversizeTruncate = "truncate"
+	oversizeFull     = "full"
+	oversizeSplit    = "split"
+	// overviewBytes is what short_message is cut to when the text of an
+	// oversized message is moved to full_message
+	overviewBytes = 256
+	// maxParts bounds the messages an oversized message is split into
+	maxParts = 1000
+)
+
+// sizeLimit keeps the encoded messages of an adapter below the limit of the
+// Graylog input, which drops bigger ones without a trace. It is only used
+// from the Stream goroutine of its adapter.
+type sizeLimit struct {
+	max      int
+	policy   string
+	ellipsis string
+	// short cuts the short_message of the text moved to full_message
+	short router.Truncation
+	buf   bytes.Buffer
+}
+
+// newSizeLimit returns the limit of the gelf_max_message_bytes and
+// gelf_oversize options of route, or nil when there is none
+func newSizeLimit(route *router.Route, short router.Truncation) (*sizeLimit, error) {
+	s := httpclient.Option(route, "gelf_max_message_bytes", "GELF_MAX_MESSAGE_BYTES")
+	if s == "" {
+		return nil, nil
+	}
+	max, err := strconv.Atoi(s)
+	if err != nil || max < 0 {
+		return nil, errors.New("gelf: bad gelf_max_message_bytes: " + s)
+	}
+	if max == 0 {
+		return nil, nil
+	}
+	l := &sizeLimit{max: max, policy: oversizeTruncate, ellipsis: short.Ellipsis, short: short}
+	switch policy := httpclient.Option(route, "gelf_oversize", "GELF_OVERSIZE"); policy {
+	case "":
+	case oversizeTruncate, oversizeFull, oversizeSplit:
+		l.policy = policy
+	default:
+		return nil, errors.New("gelf: bad gelf_oversize: " + policy)
+	}
+	if l.short.MaxBytes == 0 {
+		l.short.MaxBytes = overviewBytes
+	}
+	return l, nil
+}
+
+// apply fits msg in the limit, returning nil when it already does and the
+// messages to send instead when it doesn't
+func (l *sizeLimit) apply(msg *gelf.Message) []*gelf.Message {
+	if l == nil || l.size(msg) <= l.max {
+		return nil
+	}
+	text := msg.Short
+	if msg.Full != "" {
+		text = msg.Full
+	}
+	if msg.Extra == nil {
+		msg.Extra = make(map[string]interface{})
+	}
+	switch l.policy {
+	case oversizeFull:
+		short := msg.Short
+		if i := strings.IndexByte(short, '\n'); i >= 0 {
+			short = strings.TrimRight(short[:i], "\r")
+		}
+		msg.Short = l.short.Truncate(short)
+		msg.Extra["_truncated"] = true
+		// full_message is left out when empty, so it is measured with a byte
+		msg.Full = "x"
+		msg.Full = l.cut(text, l.max-l.size(msg)+1)
+	case oversizeSplit:
+		return l.split(msg, text)
+	default:
+		msg.Full = ""
+		msg.Short = ""
+		msg.Extra["_truncated"] = true
+		msg.Short = l.cut(text, l.max-l.size(msg))
+	}
+	return []*gelf.Message{msg}
+}
+
+// split returns the parts of text that fit in the limit as copies of msg,
+// numbered in _part and _parts
+func (l *sizeLimit) split(msg *gelf.Message, text string) []*gelf.Message {
+	msg.Short, msg.Full = "", ""
+	// the room of a part is measured with the longest numbers it can get
+	msg.Extra["_part"], msg.Extra["_parts"] = maxParts, maxParts
+	room := l.max - l.size(msg)
+	var parts []string
+	for text != "" && len(parts) < maxParts {
+		n := fitBytes(text, room)
+		if n == 0 {
+			// not even a character fits, so send the rest over the limit
+			n = len(text)
+		}
+		parts = append(parts, text[:n])
+		text = text[n:]
+	}
+	if text != "" {
+		parts[len(parts)-1] += text
+	}
+	messages := make([]*gelf.Message, len(parts))
+	for i, part := range parts {
+		m := *msg
+		m.Short = part
+		m.Extra = make(map[string]interface{}, len(msg.Extra))
+		for name, value := range msg.Extra {
+			m.Extra[name] = value
+		}
+		m.Extra["_part"], m.Extra["_parts"] = i+1, len(parts)
+		messages[i] = &m
+	}
+	return messages
+}
+
+// cut returns text cut to room encoded bytes, ending in the ellipsis when it
+// was cut
+func (l *sizeLimit) cut(text string, room int) string {
+	if n := fitBytes(text, room); n == len(text) {
+		return text
+	}
+	ellipsis := l.ellipsis
+	if encodedLen(ellipsis) > room {
+		ellipsis = ""
+	}
+	return text[:fitBytes(text, room-encodedLen(ellipsis))] + ellipsis
+}
+
+// size returns the encoded size of msg
+func (l *sizeLimit) size(msg *gelf.Message) int {
+	l.buf.Reset()
+	if err := encodeMessage(&l.buf, msg); err != nil {
+		// messages that can't be encoded are left for the writer to report
+		return 0
+	}
+	return l.buf.Len()
+}
+
+// fitBytes returns the length of the longest prefix of s that doesn't split
+// a character and takes at most room bytes in a JSON string, as written by
+// appendString
+func fitBytes(s string, room int) int {
+	n := 0
+	for i := 0; i < len(s); {
+		size, encoded := encodedRune(s[i:])
+		if n += encoded; n > room {
+			return i
+		}
+		i += size
+	}
+	return len(s)
+}
+
+// encodedLen returns the bytes s takes in a JSON string, without its quotes
+func encodedLen(s string) int {
+	n := 0
+	for i := 0; i < len(s); {
+		size, encoded := encodedRune(s[i:])
+		n += encoded
+		i += size
+	}
+	return n
+}
+
+// encodedRune returns the size of the first character of s and the bytes it
+// takes in a JSON string
+func encodedRune(s string) (int, int) {
+	c := s[0]
+	if c < utf8.RuneSelf {
+		switch {
+		case c == '"' || c == '\\' || c == '\n' || c == '\r' || c == '\t':
+			return 1, 2
+		case c < 0x20:
+			return 1, 6
+		}
+		return 1, 1
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if (r == utf8.RuneError && size == 1) || r == '\u2028' || r == '\u2029' {
+		return size, 6
+	}
+	return size, size
+}
@@ -0,0 +1,262 @@
+package gelf
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultSpoolMaxBytes = 100 << 20
+	// spoolSegmentBytes is the size segments are closed at
+	spoolSegmentBytes = 1 << 20
+	// spoolReplayBytes bounds what is replayed per flush, so new messages
+	// aren't held up for long
+	spoolReplayBytes = 8 << 20
+	spoolSuffix      = ".seg"
+	// sentDir is the directory of the messages kept after they were sent
+	sentDir = "sent"
+)
+
+// diskSpool keeps the messages a TCP writer couldn't send in memory on disk,
+// as null delimited GELF like on the wire, in numbered segment files that are
+// replayed oldest first and removed once they were sent. Segments are kept
+// across restarts. Beyond maxBytes the oldest segments are dropped. It is
+// used with the lock of its writer held.
+type diskSpool struct {
+	dir      string
+	maxBytes int64
+	// segments are the numbers of the segments, oldest first, and size
+	// their total size
+	segments []uint64
+	size     int64
+	current  *os.File
+	// currentSize is the size of the last segment, which current appends to
+	currentSize int64
+	metrics     *router.Metrics
+	// sent keeps the messages sent for gelf_buffer_retention, so they can be
+	// replayed, in the sent directory
+	sent *diskSpool
+	// retention is how long the segments of sent are kept
+	retention time.Duration
+}
+
+// newDiskSpool returns the spool of the gelf_buffer_dir and
+// gelf_buffer_max_bytes options of route, or nil when there is none. Each
+// node the route sends to gets its own directory in it. Dropped messages are
+// counted in metrics.
+func newDiskSpool(route *router.Route, metrics *router.Metrics) (*diskSpool, error) {
+	dir := httpclient.Option(route, "gelf_buffer_dir", "GELF_BUFFER_DIR")
+	if dir == "" {
+		return nil, nil
+	}
+	s := &diskSpool{maxBytes: defaultSpoolMaxBytes, metrics: metrics}
+	if v := httpclient.Option(route, "gelf_buffer_max_bytes", "GELF_BUFFER_MAX_BYTES"); v != "" {
+		var err error
+		if s.maxBytes, err = strconv.ParseInt(v, 10, 64); err != nil || s.maxBytes < spoolSegmentBytes {
+			return nil, fmt.Errorf("gelf: invalid gelf_buffer_max_bytes: %s", v)
+		}
+	}
+	s.dir = filepath.Join(cfg.DataPath(dir), strings.Map(func(r rune) rune {
+		if r == ':' || r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, route.Address))
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	if len(s.segments) > 0 {
+		log.Printf("gelf: replaying %d bytes buffered on disk for %s", s.size, route.Address)
+	}
+	if v := httpclient.Option(route, "gelf_buffer_retention", "GELF_BUFFER_RETENTION"); v != "" {
+		retention, err := time.ParseDuration(v)
+		if err != nil || retention <= 0 {
+			return nil, fmt.Errorf("gelf: invalid gelf_buffer_retention: %s", v)
+		}
+		s.sent = &diskSpool{dir: filepath.Join(s.dir, sentDir), maxBytes: s.maxBytes, retention: retention}
+		if err := os.MkdirAll(s.sent.dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := s.sent.load(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// load reads the segments left by an earlier run. The last one is cut after
+// its last whole message, in case the process died while writing it.
+func (s *diskSpool) load() error {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		n, err := strconv.ParseUint(strings.TrimSuffix(file.Name(), spoolSuffix), 10, 64)
+		if err != nil || !strings.HasSuffix(file.Name(), spoolSuffix) || !file.Mode().IsRegular() {
+			continue
+		}
+		s.segments = append(s.segments, n)
+		s.size += file.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+	if len(s.segments) == 0 {
+		return nil
+	}
+	last := s.path(s.segments[len(s.segments)-1])
+	data, err := ioutil.ReadFile(last)
+	if err != nil {
+		return err
+	}
+	if whole := bytes.LastIndexByte(data, 0) + 1; whole < len(data) {
+		s.size -= int64(len(data) - whole)
+		return os.Truncate(last, int64(whole))
+	}
+	return nil
+}
+
+func (s *diskSpool) path(n uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", n, spoolSuffix))
+}
+
+// empty returns whether there is nothing to replay
+func (s *diskSpool) empty() bool {
+	return s == nil || len(s.segments) == 0
+}
+
+// write appends messages, null delimited, to the last segment, starting a
+// new one when it is full and dropping the oldest beyond the maximum size
+func (s *diskSpool) write(messages []byte) error {
+	if s.current == nil || s.currentSize >= spoolSegmentBytes {
+		if err := s.next(); err != nil {
+			return err
+		}
+	}
+	n, err := s.current.Write(messages)
+	s.currentSize += int64(n)
+	s.size += int64(n)
+	for s.size > s.maxBytes && len(s.segments) > 1 {
+		if s.retention > 0 {
+			// messages that were sent aren't lost
+			if err := s.remove(); err != nil {
+				return err
+			}
+			continue
+		}
+		log.Printf("gelf: disk buffer %s is full, dropping its oldest messages", s.dir)
+		if data, err := ioutil.ReadFile(s.path(s.segments[0])); err == nil {
+			s.metrics.Dropped(bytes.Count(data, []byte{0}))
+		}
+		if err := s.remove(); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// next closes the last segment and starts a new one
+func (s *diskSpool) next() error {
+	s.close()
+	var n uint64
+	if len(s.segments) > 0 {
+		n = s.segments[len(s.segments)-1] + 1
+	}
+	file, err := os.OpenFile(s.path(n), os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	s.segments = append(s.segments, n)
+	s.current, s.currentSize = file, 0
+	return nil
+}
+
+// oldest returns the messages of the oldest segment, to be removed once they
+// were sent
+func (s *diskSpool) oldest() ([]byte, error) {
+	if len(s.segments) == 1 {
+		// the segment being written is sent as it is, and a new one started
+		// for later messages
+		s.close()
+	}
+	return ioutil.ReadFile(s.path(s.segments[0]))
+}
+
+// remove removes the oldest segment. It is left out of the spool even when
+// it can't be removed, so its messages aren't sent again and again.
+func (s *diskSpool) remove() error {
+	path := s.path(s.segments[0])
+	if len(s.segments) == 1 {
+		s.close()
+	}
+	s.segments = s.segments[1:]
+	info, err := os.Stat(path)
+	if err == nil {
+		s.size -= info.Size()
+		err = os.Remove(path)
+	}
+	if len(s.segments) == 0 {
+		s.size = 0
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// wrote keeps messages that were sent in the sent spool, dropping the
+// segments older than the retention
+func (s *diskSpool) wrote(messages []byte) {
+	if s == nil || s.sent == nil {
+		return
+	}
+	if err := s.sent.write(messages); err != nil {
+		log.Println("gelf:", err)
+	}
+	expired := time.Now().Add(-s.sent.retention)
+	for len(s.sent.segments) > 1 {
+		info, err := os.Stat(s.sent.path(s.sent.segments[0]))
+		if err == nil && !info.ModTime().Before(expired) {
+			return
+		}
+		if err = s.sent.remove(); err != nil {
+			log.Println("gelf:", err)
+			return
+		}
+	}
+}
+
+// sentSince returns the paths of the segments of the sent spool that were
+// written to since t, oldest first, which hold the messages sent since
+func (s *diskSpool) sentSince(t time.Time) []string {
+	var paths []string
+	for _, n := range s.sent.segments {
+		if info, err := os.Stat(s.sent.path(n)); err == nil && !info.ModTime().Before(t) {
+			paths = append(paths, s.sent.path(n))
+		}
+	}
+	return paths
+}
+
+func (s *diskSpool) close() {
+	if s.current != nil {
+		if err := s.current.Close(); err != nil {
+			log.Println("gelf:", err)
+		}
+		s.current = nil
+	}
+}
@@ -0,0 +1,318 @@
+package gelf
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultTCPBatchBytes    = 64 * 1024
+	defaultReconnectBuffer  = 1000
+	defaultReconnectBackoff = 30 * time.Second
+	minReconnectBackoff     = time.Second
+	reconnectCheckInterval  = 250 * time.Millisecond
+)
+
+// tcpWriter sends GELF messages to a Graylog TCP input, null byte delimited
+// and uncompressed. Messages are written one by one, or coalesced into one
+// write per flush interval or batchBytes when gelf_flush_interval is set.
+// When the connection fails, like when Graylog restarts, the messages are
+// kept, up to gelf_reconnect_buffer, while the writer reconnects with a
+// backoff from 1s up to gelf_reconnect_max_backoff, with jitter; beyond that
+// the oldest are dropped, or moved to the disk buffer of gelf_buffer_dir,
+// which is sent first once the connection is back.
+type tcpWriter struct {
+	route      *router.Route
+	transport  router.AdapterTransport
+	nodelay    bool
+	batchBytes int
+	interval   time.Duration
+	buffer     int
+	maxBackoff time.Duration
+
+	mu      sync.Mutex
+	conn    net.Conn
+	pending bytes.Buffer
+	// sizes are the sizes of the pending messages, oldest first
+	sizes []int
+	// backoff is the delay before the next reconnect after retry, while
+	// the connection is down
+	backoff  time.Duration
+	retry    time.Time
+	attempts int
+	dropped  int
+	spool    *diskSpool
+	metrics  *router.Metrics
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newTCPWriter(route *router.Route, transport router.AdapterTransport) (*tcpWriter, error) {
+	w := &tcpWriter{
+		route:      route,
+		transport:  transport,
+		nodelay:    true,
+		batchBytes: defaultTCPBatchBytes,
+		buffer:     defaultReconnectBuffer,
+		maxBackoff: defaultReconnectBackoff,
+		metrics:    route.Metrics(),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	var err error
+	if s := httpclient.Option(route, "gelf_batch_bytes", "GELF_BATCH_BYTES"); s != "" {
+		if w.batchBytes, err = strconv.Atoi(s); err != nil || w.batchBytes < 1 {
+			return nil, fmt.Errorf("gelf: invalid gelf_batch_bytes: %s", s)
+		}
+	}
+	if s := httpclient.Option(route, "gelf_flush_interval", "GELF_FLUSH_INTERVAL"); s != "" {
+		if w.interval, err = time.ParseDuration(s); err != nil || w.interval <= 0 {
+			return nil, fmt.Errorf("gelf: invalid gelf_flush_interval: %s", s)
+		}
+	}
+	if s := httpclient.Option(route, "gelf_tcp_nodelay", "GELF_TCP_NODELAY"); s != "" {
+		if w.nodelay, err = strconv.ParseBool(s); err != nil {
+			return nil, fmt.Errorf("gelf: invalid gelf_tcp_nodelay: %s", s)
+		}
+	}
+	if s := httpclient.Option(route, "gelf_reconnect_buffer", "GELF_RECONNECT_BUFFER"); s != "" {
+		if w.buffer, err = strconv.Atoi(s); err != nil || w.buffer < 0 {
+			return nil, fmt.Errorf("gelf: invalid gelf_reconnect_buffer: %s", s)
+		}
+	}
+	if s := httpclient.Option(route, "gelf_reconnect_max_backoff", "GELF_RECONNECT_MAX_BACKOFF"); s != "" {
+		if w.maxBackoff, err = time.ParseDuration(s); err != nil || w.maxBackoff < minReconnectBackoff {
+			return nil, fmt.Errorf("gelf: invalid gelf_reconnect_max_backoff: %s", s)
+		}
+	}
+	if w.spool, err = newDiskSpool(route, w.metrics); err != nil {
+		return nil, err
+	}
+	if w.spool != nil && w.buffer == 0 {
+		return nil, fmt.Errorf("gelf: gelf_buffer_dir needs a gelf_reconnect_buffer")
+	}
+	if err = w.dial(); err != nil {
+		return nil, err
+	}
+	go w.flushEvery()
+	return w, nil
+}
+
+// dial connects to Graylog; w.mu is held or w isn't shared yet
+func (w *tcpWriter) dial() error {
+	conn, err := w.transport.Dial(w.route.Address, w.route.Options)
+	if err != nil {
+		return err
+	}
+	if tcp, ok := router.NetConn(conn).(*net.TCPConn); ok {
+		tcp.SetNoDelay(w.nodelay) //nolint:errcheck
+	}
+	w.conn = conn
+	return nil
+}
+
+// WriteMessage adds m to the pending messages, writing them when there is no
+// flush interval, batchBytes are pending or memory is short
+func (w *tcpWriter) WriteMessage(m *gelf.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	before := w.pending.Len()
+	if err := encodeMessage(&w.pending, m); err != nil {
+		w.pending.Truncate(before)
+		w.metrics.Dropped(1)
+		return err
+	}
+	w.pending.WriteByte(0)
+	w.sizes = append(w.sizes, w.pending.Len()-before)
+	if dropped := len(w.sizes) - router.BufferSize(w.buffer); dropped > 0 && w.buffer > 0 && w.conn == nil {
+		// the buffer is full while reconnecting: drop the oldest, or move
+		// them to disk. It is smaller under memory pressure.
+		n := 0
+		for _, size := range w.sizes[:dropped] {
+			n += size
+		}
+		if w.spool == nil {
+			w.dropped += dropped
+			w.metrics.Dropped(dropped)
+		} else if err := w.spool.write(w.pending.Bytes()[:n]); err != nil {
+			log.Println("gelf:", err)
+			w.dropped += dropped
+			w.metrics.Dropped(dropped)
+		}
+		w.pending.Next(n)
+		w.sizes = w.sizes[dropped:]
+	}
+	if w.interval > 0 && w.pending.Len() < w.batchBytes && !router.MemoryPressure() {
+		return nil
+	}
+	return w.flushLocked(time.Now())
+}
+
+// flushEvery writes the pending messages every flush interval, and retries
+// the messages kept while reconnecting when no new messages arrive
+func (w *tcpWriter) flushEvery() {
+	defer close(w.done)
+	interval := w.interval
+	if interval == 0 {
+		interval = reconnectCheckInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			w.mu.Lock()
+			if w.interval > 0 || w.conn == nil || !w.spool.empty() {
+				w.flushLocked(now) //nolint:errcheck
+			}
+			w.mu.Unlock()
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// flushLocked writes the pending messages in one write, reconnecting when the
+// connection failed before and the backoff passed. Messages that fail are
+// kept for the next attempt.
+func (w *tcpWriter) flushLocked(now time.Time) error {
+	if len(w.sizes) == 0 && w.spool.empty() {
+		return nil
+	}
+	if w.conn == nil {
+		if now.Before(w.retry) {
+			return nil
+		}
+		w.attempts++
+		if err := w.dial(); err != nil {
+			return w.failed(now, err)
+		}
+		log.Printf("gelf: reconnected to %s after %d attempts, dropped %d messages", w.route.Address, w.attempts, w.dropped)
+		w.metrics.Reconnect()
+		w.backoff, w.attempts, w.dropped = 0, 0, 0
+	}
+	if err := w.replayLocked(); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return w.failed(now, err)
+	}
+	if !w.spool.empty() || len(w.sizes) == 0 {
+		// the pending messages wait for the older ones on disk
+		w.route.SetConnState(router.ConnConnected, nil)
+		return nil
+	}
+	if _, err := w.conn.Write(w.pending.Bytes()); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return w.failed(now, err)
+	}
+	w.metrics.Sent(len(w.sizes), w.pending.Len())
+	w.spool.wrote(w.pending.Bytes())
+	w.pending.Reset()
+	w.sizes = w.sizes[:0]
+	w.route.SetConnState(router.ConnConnected, nil)
+	return nil
+}
+
+// replayLocked sends the messages of the disk buffer, oldest first, up to
+// spoolReplayBytes. A segment that fails is sent again after the reconnect,
+// so its messages can arrive twice.
+func (w *tcpWriter) replayLocked() error {
+	for sent := 0; !w.spool.empty() && sent < spoolReplayBytes; {
+		data, err := w.spool.oldest()
+		if err == nil {
+			if _, err = w.conn.Write(data); err != nil {
+				return err
+			}
+			w.metrics.Sent(bytes.Count(data, []byte{0}), len(data))
+			w.spool.wrote(data)
+			sent += len(data)
+		} else {
+			log.Println("gelf: dropping unreadable disk buffer segment:", err)
+		}
+		if err = w.spool.remove(); err != nil {
+			log.Println("gelf:", err)
+		}
+	}
+	return nil
+}
+
+// failed schedules the next reconnect after the connection failed with err.
+// Without a reconnect buffer the pending messages are dropped, and the next
+// write reconnects.
+func (w *tcpWriter) failed(now time.Time, err error) error {
+	w.metrics.Error()
+	if w.buffer == 0 {
+		w.metrics.Dropped(len(w.sizes))
+		err = fmt.Errorf("dropped %d messages: %v", len(w.sizes), err)
+		w.pending.Reset()
+		w.sizes = w.sizes[:0]
+		w.route.SetConnState(router.ConnFailed, err)
+		return err
+	}
+	if w.backoff == 0 {
+		w.backoff = minReconnectBackoff
+	} else if w.backoff *= 2; w.backoff > w.maxBackoff {
+		w.backoff = w.maxBackoff
+	}
+	// jitter keeps the nodes of a fleet from reconnecting all at once
+	delay := w.backoff/2 + time.Duration(rand.Int63n(int64(w.backoff/2)+1))
+	w.retry = now.Add(delay)
+	log.Printf("gelf: connection to %s failed, reconnecting in %v: %v", w.route.Address, delay.Round(time.Millisecond), err)
+	w.route.SetConnState(router.ConnBackoff, err)
+	return nil
+}
+
+// Close writes the pending messages and closes the connection
+func (w *tcpWriter) Close() error {
+	select {
+	case <-w.quit:
+		return nil
+	default:
+	}
+	close(w.quit)
+	<-w.done
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// a last attempt, regardless of the backoff
+	w.retry = time.Time{}
+	err := w.flushLocked(time.Now())
+	if w.spool != nil {
+		// the messages that weren't sent are kept for the next run
+		if len(w.sizes) > 0 {
+			if err = w.spool.write(w.pending.Bytes()); err == nil {
+				w.pending.Reset()
+				w.sizes = w.sizes[:0]
+			}
+		}
+		w.spool.close()
+		if w.spool.sent != nil {
+			w.spool.sent.close()
+		}
+	}
+	if len(w.sizes) > 0 {
+		w.metrics.Dropped(len(w.sizes))
+		if err == nil {
+			err = fmt.Errorf("dropped %d messages: %s is down", len(w.sizes), w.route.Address)
+		}
+	}
+	if w.conn != nil {
+		if closeErr := w.conn.Close(); err == nil {
+			err = closeErr
+		}
+		w.conn = nil
+	}
+	return err
+}
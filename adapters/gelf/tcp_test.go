@@ -0,0 +1,327 @@
+package gelf
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// countingTransport dials TCP and counts the writes on its connections
+type countingTransport struct {
+	mu     sync.Mutex
+	writes int
+}
+
+type countingConn struct {
+	net.Conn
+	transport *countingTransport
+}
+
+func (t *countingTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, transport: t}, nil
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.transport.mu.Lock()
+	c.transport.writes++
+	c.transport.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+// receive returns the null byte delimited messages received by listener
+func receive(t *testing.T, listener net.Listener) <-chan string {
+	messages := make(chan string, 100)
+	go func() {
+		defer close(messages)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			message, err := r.ReadString(0)
+			if err != nil {
+				return
+			}
+			messages <- message[:len(message)-1]
+		}
+	}()
+	return messages
+}
+
+func TestTCPWriter(t *testing.T) {
+	for _, test := range []struct {
+		options map[string]string
+		writes  int
+	}{
+		{map[string]string{}, 3},
+		{map[string]string{"gelf_flush_interval": "1h", "gelf_tcp_nodelay": "false"}, 1},
+		{map[string]string{"gelf_flush_interval": "1h", "gelf_batch_bytes": "1"}, 3},
+	} {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		messages := receive(t, listener)
+		transport := &countingTransport{}
+		route := &router.Route{Adapter: "gelf+tcp", Address: listener.Addr().String(), Options: test.options}
+		writer, err := newTCPWriter(route, transport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, short := range []string{"one", "two", "three"} {
+			if err = writer.WriteMessage(&gelf.Message{Version: "1.1", Host: "host", Short: short}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for message := range messages {
+			got = append(got, message)
+		}
+		listener.Close()
+		if len(got) != 3 || got[0] != `{"version":"1.1","host":"host","short_message":"one","timestamp":0}` {
+			t.Errorf("%v: expected the 3 messages, got %q", test.options, got)
+		}
+		if transport.writes != test.writes {
+			t.Errorf("%v: expected %d writes, got %d", test.options, test.writes, transport.writes)
+		}
+	}
+
+	if _, err := newTCPWriter(&router.Route{Options: map[string]string{"gelf_batch_bytes": "none"}}, &countingTransport{}); err == nil {
+		t.Error("expected error for a bad gelf_batch_bytes")
+	}
+}
+
+// flakyTransport collects the messages written to its connections, and fails
+// to dial and write while it is down
+type flakyTransport struct {
+	mu       sync.Mutex
+	down     bool
+	messages []string
+}
+
+type flakyConn struct {
+	net.Conn
+	transport *flakyTransport
+}
+
+func (t *flakyTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.down {
+		return nil, errors.New("connection refused")
+	}
+	return &flakyConn{transport: t}, nil
+}
+
+func (t *flakyTransport) setDown(down bool) {
+	t.mu.Lock()
+	t.down = down
+	t.mu.Unlock()
+}
+
+func (c *flakyConn) Write(b []byte) (int, error) {
+	c.transport.mu.Lock()
+	defer c.transport.mu.Unlock()
+	if c.transport.down {
+		return 0, errors.New("broken pipe")
+	}
+	for _, message := range strings.Split(strings.TrimSuffix(string(b), "\x00"), "\x00") {
+		c.transport.messages = append(c.transport.messages, message)
+	}
+	return len(b), nil
+}
+
+func (c *flakyConn) Close() error {
+	return nil
+}
+
+func TestTCPWriterReconnect(t *testing.T) {
+	transport := &flakyTransport{}
+	route := &router.Route{ID: "reconnect", Adapter: "gelf+tcp", Address: "graylog:12201", Options: map[string]string{"gelf_reconnect_buffer": "2"}}
+	writer, err := newTCPWriter(route, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	write := func(short string) {
+		if err := writer.WriteMessage(&gelf.Message{Version: "1.1", Host: "host", Short: short}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("one")
+	transport.setDown(true)
+	write("two")
+	writer.mu.Lock()
+	if writer.conn != nil || writer.backoff != time.Second ||
+		writer.retry.Before(time.Now().Add(400*time.Millisecond)) || writer.retry.After(time.Now().Add(time.Second)) {
+		t.Errorf("expected a reconnect in 0.5s to 1s, got %v", time.Until(writer.retry))
+	}
+	writer.mu.Unlock()
+	write("three")
+	write("four")
+
+	// the reconnect fails and backs off further
+	writer.mu.Lock()
+	writer.flushLocked(time.Now().Add(time.Second)) //nolint:errcheck
+	if writer.backoff != 2*time.Second || writer.dropped != 1 {
+		t.Errorf("expected a backoff of 2s and a dropped message, got %v and %d", writer.backoff, writer.dropped)
+	}
+	writer.mu.Unlock()
+
+	transport.setDown(false)
+	writer.mu.Lock()
+	writer.flushLocked(time.Now().Add(time.Minute)) //nolint:errcheck
+	writer.mu.Unlock()
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	var got []string
+	for _, message := range transport.messages {
+		var m gelf.Message
+		if err := json.Unmarshal([]byte(message), &m); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, m.Short)
+	}
+	if strings.Join(got, ",") != "one,three,four" {
+		t.Errorf("expected the first and the last two messages, got %q", got)
+	}
+	if m := route.Metrics().Snapshot(); m.Sent != 3 || m.Dropped != 1 || m.Reconnects != 1 || m.Errors != 2 || m.Bytes == 0 {
+		t.Errorf("expected 3 sent, 1 dropped, 1 reconnect and 2 errors, got %+v", m)
+	}
+}
+
+func TestTCPWriterCloseDuringBackoff(t *testing.T) {
+	transport := &flakyTransport{}
+	route := &router.Route{ID: "closebackoff", Adapter: "gelf+tcp", Address: "graylog:12201", Options: map[string]string{"gelf_reconnect_buffer": "10"}}
+	writer, err := newTCPWriter(route, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.setDown(true)
+	if err = writer.WriteMessage(&gelf.Message{Version: "1.1", Host: "host", Short: "pending"}); err != nil {
+		t.Fatal(err)
+	}
+	writer.mu.Lock()
+	backingOff := writer.conn == nil && writer.retry.After(time.Now())
+	writer.mu.Unlock()
+	if !backingOff {
+		t.Fatal("expected the writer to back off")
+	}
+	transport.setDown(false)
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if len(transport.messages) != 1 || !strings.Contains(transport.messages[0], `"pending"`) {
+		t.Errorf("expected the pending message to be sent on close, got %q", transport.messages)
+	}
+}
+
+func TestTCPWriterNoReconnectBuffer(t *testing.T) {
+	transport := &flakyTransport{}
+	route := &router.Route{Adapter: "gelf+tcp", Address: "graylog:12201", Options: map[string]string{"gelf_reconnect_buffer": "0"}}
+	writer, err := newTCPWriter(route, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	transport.setDown(true)
+	if err = writer.WriteMessage(&gelf.Message{Short: "lost"}); err == nil {
+		t.Error("expected the message to be dropped without a reconnect buffer")
+	}
+	transport.setDown(false)
+	if err = writer.WriteMessage(&gelf.Message{Short: "sent"}); err != nil {
+		t.Errorf("expected the next write to reconnect, got %v", err)
+	}
+}
+
+func TestTCPWriterDiskBuffer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gelf-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	transport := &flakyTransport{}
+	route := &router.Route{Adapter: "gelf+tcp", Address: "graylog:12201", Options: map[string]string{
+		"gelf_reconnect_buffer": "2", "gelf_buffer_dir": dir,
+	}}
+	writer, err := newTCPWriter(route, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(writer *tcpWriter, short string) {
+		if err := writer.WriteMessage(&gelf.Message{Version: "1.1", Host: "host", Short: short}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(writer, "one")
+	transport.setDown(true)
+	for _, short := range []string{"two", "three", "four", "five"} {
+		write(writer, short)
+	}
+	// the messages kept in memory are moved to disk too on close
+	if err = writer.Close(); err != nil {
+		t.Errorf("expected the messages to be kept on disk, got %v", err)
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "graylog_12201", "*.seg"))
+	if len(segments) == 0 {
+		t.Fatal("expected segments on disk")
+	}
+	// a message cut off by a crash is dropped
+	file, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"version":"1.1","sho`)
+	file.Close()
+
+	transport.setDown(false)
+	writer, err = newTCPWriter(route, transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(writer, "six")
+	writer.Close()
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	var got []string
+	for _, message := range transport.messages {
+		var m gelf.Message
+		if err := json.Unmarshal([]byte(message), &m); err != nil {
+			t.Fatalf("invalid message %q: %v", message, err)
+		}
+		got = append(got, m.Short)
+	}
+	if strings.Join(got, ",") != "one,two,three,four,five,six" {
+		t.Errorf("expected all messages in order, got %q", got)
+	}
+	if segments, _ = filepath.Glob(filepath.Join(dir, "graylog_12201", "*.seg")); len(segments) != 0 {
+		t.Errorf("expected the sent segments to be removed, got %v", segments)
+	}
+	if _, err := newTCPWriter(&router.Route{Options: map[string]string{"gelf_buffer_dir": dir, "gelf_buffer_max_bytes": "1k"}}, transport); err == nil {
+		t.Error("expected error for a bad gelf_buffer_max_bytes")
+	}
+}
@@ -0,0 +1,185 @@
+package gelf
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	// timeLayoutLabel sets the layout of the timestamps of a container
+	timeLayoutLabel = "logspout.gelf_time_layout"
+	// maxTimeLayouts bounds the layouts of container labels kept compiled
+	maxTimeLayouts = 64
+)
+
+// strptimeDirectives are the Go layouts and the patterns of the strptime
+// directives of time layouts
+var strptimeDirectives = map[byte][2]string{
+	'Y': {"2006", `\d{4}`},
+	'y': {"06", `\d{2}`},
+	'm': {"01", `\d{2}`},
+	'd': {"02", `\d{2}`},
+	'e': {"_2", `[ \d]\d`},
+	'b': {"Jan", `[A-Z][a-z]{2}`},
+	'B': {"January", `[A-Z][a-z]+`},
+	'a': {"Mon", `[A-Z][a-z]{2}`},
+	'A': {"Monday", `[A-Z][a-z]+`},
+	'H': {"15", `\d{2}`},
+	'I': {"03", `\d{2}`},
+	'p': {"PM", `[AP]M`},
+	'M': {"04", `\d{2}`},
+	'S': {"05", `\d{2}`},
+	'f': {"999999999", `\d{1,9}`},
+	'z': {"Z0700", `(?:Z|[+-]\d{4})`},
+	'Z': {"MST", `[A-Z]{3,4}`},
+	'F': {"2006-01-02", `\d{4}-\d{2}-\d{2}`},
+	'T': {"15:04:05", `\d{2}:\d{2}:\d{2}`},
+	'%': {"%", `%`},
+}
+
+// timeLayout finds and parses the timestamps of a strptime layout in texts
+type timeLayout struct {
+	layout  string
+	pattern *regexp.Regexp
+	// year is whether the layout has a year, or it is that of the read time
+	year bool
+}
+
+// newTimeLayout compiles the strptime layout s, like %Y-%m-%dT%H:%M:%S.%f%z
+func newTimeLayout(s string) (*timeLayout, error) {
+	var layout, pattern strings.Builder
+	l := &timeLayout{}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			layout.WriteByte(s[i])
+			pattern.WriteString(regexp.QuoteMeta(s[i : i+1]))
+			continue
+		}
+		if i++; i == len(s) {
+			return nil, errors.New("gelf: bad gelf_time_layout: " + s)
+		}
+		directive, ok := strptimeDirectives[s[i]]
+		if !ok {
+			return nil, errors.New("gelf: bad gelf_time_layout: unknown directive %" + s[i:i+1] + " in " + s)
+		}
+		switch s[i] {
+		case 'Y', 'y', 'F':
+			l.year = true
+		case 'f':
+			// Go only parses fractions of seconds after their separator
+			if i < 2 || (s[i-2] != '.' && s[i-2] != ',') {
+				return nil, errors.New("gelf: bad gelf_time_layout: %f has to follow . or , in " + s)
+			}
+		}
+		layout.WriteString(directive[0])
+		pattern.WriteString(directive[1])
+	}
+	var err error
+	if l.pattern, err = regexp.Compile(pattern.String()); err != nil {
+		return nil, errors.New("gelf: bad gelf_time_layout: " + s)
+	}
+	l.layout = layout.String()
+	return l, nil
+}
+
+// parse returns the first timestamp in data, in location when the layout has
+// no zone. Timestamps without a year get that of read, or the one before
+// when that would put them more than a day after it.
+func (l *timeLayout) parse(data string, location *time.Location, read time.Time) (time.Time, bool) {
+	match := l.pattern.FindString(data)
+	if match == "" {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(l.layout, match, location)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if !l.year {
+		t = t.AddDate(read.Year(), 0, 0)
+		if t.After(read.AddDate(0, 0, 1)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+	}
+	return t, true
+}
+
+// timeExtraction sets the timestamp of messages to the one in their text,
+// with the layout of the route or of the label of their container
+type timeExtraction struct {
+	route    *timeLayout
+	location *time.Location
+	// labels are the compiled layouts of container labels. It is only used
+	// from the Stream goroutine of its adapter.
+	labels map[string]*timeLayout
+}
+
+// newTimeExtraction returns the extraction for the gelf_time_layout and
+// gelf_time_zone options of route
+func newTimeExtraction(route *router.Route) (*timeExtraction, error) {
+	e := &timeExtraction{location: time.UTC, labels: make(map[string]*timeLayout)}
+	if s := httpclient.Option(route, "gelf_time_zone", "GELF_TIME_ZONE"); s != "" {
+		var err error
+		if e.location, err = time.LoadLocation(s); err != nil {
+			return nil, errors.New("gelf: bad gelf_time_zone: " + s)
+		}
+	}
+	if s := httpclient.Option(route, "gelf_time_layout", "GELF_TIME_LAYOUT"); s != "" {
+		var err error
+		if e.route, err = newTimeLayout(s); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// layout returns the layout for the messages of m, or nil when they keep
+// their read time
+func (e *timeExtraction) layout(m *router.Message) *timeLayout {
+	if m.Container == nil || m.Container.Config == nil {
+		return e.route
+	}
+	s, ok := m.Container.Config.Labels[timeLayoutLabel]
+	if !ok {
+		return e.route
+	}
+	if s == "" {
+		// an empty label opts the container out
+		return nil
+	}
+	if l, ok := e.labels[s]; ok {
+		return l
+	}
+	if len(e.labels) >= maxTimeLayouts {
+		e.labels = make(map[string]*timeLayout)
+	}
+	l, err := newTimeLayout(s)
+	if err != nil {
+		log.Println("gelf: container", strings.TrimPrefix(m.Container.Name, "/")+":", err)
+	}
+	// bad layouts are kept as nil, so they aren't compiled for each message
+	e.labels[s] = l
+	return l
+}
+
+// extract sets the timestamp of msg to the one in the text of m, leaving the
+// read time when there is none
+func (e *timeExtraction) extract(msg *gelf.Message, m *router.Message) {
+	if e == nil {
+		return
+	}
+	l := e.layout(m)
+	if l == nil {
+		return
+	}
+	if t, ok := l.parse(m.Data, e.location, m.Time); ok {
+		msg.TimeUnix = float64(t.UnixNano()/int64(time.Millisecond)) / 1000.0
+	}
+}
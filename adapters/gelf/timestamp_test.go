@@ -0,0 +1,75 @@
+package gelf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Graylog2/go-gelf/gelf"
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestTimeLayout(t *testing.T) {
+	read := time.Date(2024, 1, 1, 0, 30, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		layout, data string
+		expected     time.Time
+	}{
+		{"%Y-%m-%d %H:%M:%S", "2023-12-31 23:59:58 INFO done", time.Date(2023, 12, 31, 23, 59, 58, 0, time.UTC)},
+		{"%Y-%m-%dT%H:%M:%S.%f%z", "level=info ts=2023-12-31T22:00:00.25+0100 msg=hi", time.Date(2023, 12, 31, 21, 0, 0, 25e7, time.UTC)},
+		{"%FT%TZ%%", "[2023-06-01T10:00:00Z%] x", time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)},
+		{"%d/%b/%Y:%H:%M:%S %z", `10.0.0.1 - - [31/Dec/2023:23:00:00 +0000] "GET / HTTP/1.1"`, time.Date(2023, 12, 31, 23, 0, 0, 0, time.UTC)},
+		// a syslog timestamp from the end of the year before
+		{"%b %e %H:%M:%S", "Dec 31 23:59:00 host sshd[1]: accepted", time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC)},
+		{"%b %e %H:%M:%S", "Jan  1 00:20:00 host cron[2]: run", time.Date(2024, 1, 1, 0, 20, 0, 0, time.UTC)},
+		{"%H:%M:%S,%f", "00:10:00,123 WARN x", time.Date(2024, 1, 1, 0, 10, 0, 123e6, time.UTC)},
+	} {
+		l, err := newTimeLayout(tt.layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, ok := l.parse(tt.data, time.UTC, read)
+		if !ok || !got.Equal(tt.expected) {
+			t.Errorf("%s %q: expected %v, got %v, %v", tt.layout, tt.data, tt.expected, got, ok)
+		}
+	}
+	for _, layout := range []string{"%Y-%m-%d %Q", "%Y-%m-%d %", "%S%f"} {
+		if _, err := newTimeLayout(layout); err == nil {
+			t.Errorf("expected error for %s", layout)
+		}
+	}
+}
+
+func TestTimeExtraction(t *testing.T) {
+	e, err := newTimeExtraction(&router.Route{Options: map[string]string{
+		"gelf_time_layout": "%Y-%m-%d %H:%M:%S",
+		"gelf_time_zone":   "Europe/Amsterdam",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		labels map[string]string
+		data   string
+		time   time.Time
+	}{
+		{nil, "2024-05-01 10:00:00 started", time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)},
+		{nil, "no timestamp", read},
+		{nil, "2024-13-01 10:00:00 bad month", read},
+		{map[string]string{timeLayoutLabel: "%H:%M:%S %Y/%m/%d"}, "10:00:00 2024/04/30 x", time.Date(2024, 4, 30, 8, 0, 0, 0, time.UTC)},
+		{map[string]string{timeLayoutLabel: ""}, "2024-05-01 10:00:00 opted out", read},
+		{map[string]string{timeLayoutLabel: "%Q"}, "2024-05-01 10:00:00 bad label", read},
+	} {
+		m := &router.Message{Container: &docker.Container{Name: "/app", Config: &docker.Config{Labels: tt.labels}}, Data: tt.data, Time: read}
+		msg := &gelf.Message{TimeUnix: float64(read.Unix())}
+		e.extract(msg, m)
+		if msg.TimeUnix != float64(tt.time.Unix()) {
+			t.Errorf("%v %q: expected %v, got %v", tt.labels, tt.data, tt.time, time.Unix(int64(msg.TimeUnix), 0).UTC())
+		}
+	}
+	if _, err := newTimeExtraction(&router.Route{Options: map[string]string{"gelf_time_zone": "Mars/Olympus"}}); err == nil {
+		t.Error("expected error for an unknown time zone")
+	}
+}
@@ -0,0 +1,88 @@
+package gelf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// secretsDir is where Docker mounts the secrets of a service
+var secretsDir = "/run/secrets"
+
+// tlsTransport dials Graylog TLS inputs with the TLS settings of a route,
+// instead of those of the tls transport shared by all routes
+type tlsTransport struct {
+	config *tls.Config
+}
+
+func (t *tlsTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	conn, err := tls.Dial("tcp", addr, t.config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// secretFile returns the path of a file setting: a path, or the name of a
+// Docker secret
+func secretFile(s string) string {
+	if strings.ContainsRune(s, '/') {
+		return s
+	}
+	return filepath.Join(secretsDir, s)
+}
+
+// newTLSTransport returns the transport for the gelf_tls_* options of route,
+// or nil when it sets none of them
+func newTLSTransport(route *router.Route) (router.AdapterTransport, error) {
+	caCert := httpclient.Option(route, "gelf_tls_ca_cert", "GELF_TLS_CA_CERT")
+	clientCert := httpclient.Option(route, "gelf_tls_client_cert", "GELF_TLS_CLIENT_CERT")
+	clientKey := httpclient.Option(route, "gelf_tls_client_key", "GELF_TLS_CLIENT_KEY")
+	serverName := httpclient.Option(route, "gelf_tls_server_name", "GELF_TLS_SERVER_NAME")
+	skipVerify := httpclient.Option(route, "gelf_tls_skip_verify", "GELF_TLS_SKIP_VERIFY")
+	if caCert == "" && clientCert == "" && clientKey == "" && serverName == "" && skipVerify == "" {
+		return nil, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: serverName}
+	if skipVerify != "" {
+		skip, err := strconv.ParseBool(skipVerify)
+		if err != nil {
+			return nil, errors.New("gelf: bad gelf_tls_skip_verify: " + skipVerify)
+		}
+		if skip {
+			log.Println("gelf: not verifying the certificate of", route.Address)
+		}
+		config.InsecureSkipVerify = skip //nolint:gosec
+	}
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(secretFile(caCert))
+		if err != nil {
+			return nil, errors.New("gelf: " + err.Error())
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("gelf: no certificates in " + caCert)
+		}
+	}
+	if clientCert != "" || clientKey != "" {
+		if clientCert == "" || clientKey == "" {
+			return nil, errors.New("gelf: gelf_tls_client_cert and gelf_tls_client_key go together")
+		}
+		cert, err := tls.LoadX509KeyPair(secretFile(clientCert), secretFile(clientKey))
+		if err != nil {
+			return nil, errors.New("gelf: " + err.Error())
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return &tlsTransport{config: cfg.RestrictTLS(config)}, nil
+}
@@ -0,0 +1,145 @@
+package gelf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// writeCert writes a certificate and key signed by parent, or self-signed, to
+// name.pem and name-key.pem in dir
+func writeCert(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(filepath.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestTLSTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gelf-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { secretsDir = dir }(secretsDir)
+	secretsDir = dir
+
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey := writeCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "ca"}, NotAfter: notAfter,
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}, nil, nil)
+	writeCert(t, dir, "graylog", &x509.Certificate{
+		SerialNumber: big.NewInt(2), DNSNames: []string{"graylog.internal"}, NotAfter: notAfter,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writeCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "logspout"}, NotAfter: notAfter,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	serverCert, err := tls.LoadX509KeyPair(filepath.Join(dir, "graylog.pem"), filepath.Join(dir, "graylog-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	clients := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			client := ""
+			if tlsConn.Handshake() == nil && len(tlsConn.ConnectionState().PeerCertificates) > 0 {
+				client = tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+			}
+			conn.Close()
+			clients <- client
+		}
+	}()
+
+	for _, tt := range []struct {
+		options map[string]string
+		ok      bool
+		client  string
+	}{
+		{map[string]string{"gelf_tls_ca_cert": "ca.pem", "gelf_tls_server_name": "graylog.internal",
+			"gelf_tls_client_cert": "client.pem", "gelf_tls_client_key": filepath.Join(dir, "client-key.pem")}, true, "logspout"},
+		{map[string]string{"gelf_tls_ca_cert": "ca.pem"}, false, ""},
+		{map[string]string{"gelf_tls_ca_cert": "ca.pem", "gelf_tls_server_name": "other.internal"}, false, ""},
+		{map[string]string{"gelf_tls_skip_verify": "true"}, true, ""},
+	} {
+		route := &router.Route{Address: listener.Addr().String(), Options: tt.options}
+		transport, err := newTLSTransport(route)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := transport.Dial(route.Address, route.Options)
+		if conn != nil {
+			conn.Close()
+		}
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: expected success %v, got %v", tt.options, tt.ok, err)
+		}
+		if client := <-clients; tt.ok && client != tt.client {
+			t.Errorf("%v: expected client certificate %q, got %q", tt.options, tt.client, client)
+		}
+	}
+}
+
+func TestTLSTransportOptions(t *testing.T) {
+	if transport, err := newTLSTransport(&router.Route{Options: map[string]string{}}); transport != nil || err != nil {
+		t.Errorf("expected the shared tls transport without options, got %v, %v", transport, err)
+	}
+	for _, options := range []map[string]string{
+		{"gelf_tls_skip_verify": "maybe"},
+		{"gelf_tls_ca_cert": "/no/such/ca.pem"},
+		{"gelf_tls_client_cert": "client.pem"},
+	} {
+		if _, err := newTLSTransport(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
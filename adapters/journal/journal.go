@@ -0,0 +1,242 @@
+// Package journal provides an adapter that uploads messages to
+// systemd-journal-remote in the journal export format, so they show up in the
+// central journal with their container metadata as journal fields.
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	contentType          = "application/vnd.fdo.journal"
+	defaultPath          = "/upload"
+	defaultBatchSize     = 100
+	defaultFlushInterval = time.Second
+	maxFieldName         = 64
+)
+
+var hostname string
+
+func init() {
+	hostname, _ = os.Hostname()
+	router.AdapterFactories.Register(NewJournalAdapter, "journal")
+	router.DescribeAdapter("journal", router.AdapterInfo{
+		DefaultTransport: "https",
+		Transports:       []string{"http", "https"},
+		Options: append([]router.AdapterOption{
+			{Name: "journal_batch_size", Env: "JOURNAL_BATCH_SIZE", Description: "entries per upload"},
+			{Name: "journal_flush_interval", Env: "JOURNAL_FLUSH_INTERVAL", Description: "how long entries wait for a batch to fill"},
+			{Name: "journal_labels", Env: "JOURNAL_LABELS", Description: "container labels added as journal fields"},
+		}, httpclient.Options...),
+	})
+}
+
+// Adapter uploads messages to systemd-journal-remote
+type Adapter struct {
+	route         *router.Route
+	url           string
+	client        *http.Client
+	labels        []string
+	batching      *httpclient.Batching
+	flushInterval time.Duration
+}
+
+// NewJournalAdapter returns an Adapter with HTTPS as the default transport
+func NewJournalAdapter(route *router.Route) (router.LogAdapter, error) {
+	scheme := route.AdapterTransport("https")
+	if scheme != "http" && scheme != "https" {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	path := defaultPath
+	if route.Path != "" {
+		path = route.Path
+	}
+	u := &url.URL{Scheme: scheme, User: route.User, Host: route.Address, Path: path}
+	client, err := httpclient.New(route)
+	if err != nil {
+		return nil, err
+	}
+	a := &Adapter{
+		route:         route,
+		url:           u.String(),
+		client:        client,
+		flushInterval: defaultFlushInterval,
+	}
+	for _, label := range strings.Split(httpclient.Option(route, "journal_labels", "JOURNAL_LABELS"), ",") {
+		if label = strings.TrimSpace(label); label != "" {
+			a.labels = append(a.labels, label)
+		}
+	}
+	batchSize := defaultBatchSize
+	if s := httpclient.Option(route, "journal_batch_size", "JOURNAL_BATCH_SIZE"); s != "" {
+		if batchSize, err = strconv.Atoi(s); err != nil || batchSize < 1 {
+			return nil, errors.New("journal: bad journal_batch_size: " + s)
+		}
+	}
+	if a.batching, err = httpclient.NewBatching(route, batchSize); err != nil {
+		return nil, err
+	}
+	if s := httpclient.Option(route, "journal_flush_interval", "JOURNAL_FLUSH_INTERVAL"); s != "" {
+		if a.flushInterval, err = time.ParseDuration(s); err != nil || a.flushInterval <= 0 {
+			return nil, errors.New("journal: bad journal_flush_interval: " + s)
+		}
+	}
+	log.Printf("journal: using url: %s\n", u.Redacted())
+	return a, nil
+}
+
+// Stream implements the router.LogAdapter interface. Entries are uploaded in
+// batches of the batch size, or after the flush interval.
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	var batch bytes.Buffer
+	count := 0
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		full := count >= a.batching.Limit()
+		start := time.Now()
+		err := a.upload(batch.Bytes())
+		a.batching.Observe(batch.Len(), full, time.Since(start), err)
+		if err != nil {
+			a.route.SetConnState(router.ConnFailed, err)
+			log.Printf("journal: dropped %d messages: %v", count, err)
+		} else {
+			a.route.SetConnState(router.ConnConnected, nil)
+		}
+		batch.Reset()
+		count = 0
+	}
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				flush()
+				return
+			}
+			a.encode(&batch, message)
+			count++
+			if count >= a.batching.Limit() {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (a *Adapter) upload(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) //nolint:errcheck
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// encode appends message as an entry in the journal export format
+func (a *Adapter) encode(buf *bytes.Buffer, m *router.Message) {
+	writeField(buf, "__REALTIME_TIMESTAMP", strconv.FormatInt(m.Time.UnixNano()/int64(time.Microsecond), 10))
+	writeField(buf, "_HOSTNAME", hostname)
+	writeField(buf, "MESSAGE", m.Data)
+	priority := "6"
+	if m.Source == "stderr" {
+		priority = "3"
+	}
+	writeField(buf, "PRIORITY", priority)
+	if m.Container != nil {
+		name := strings.TrimPrefix(m.Container.Name, "/")
+		writeField(buf, "SYSLOG_IDENTIFIER", name)
+		writeField(buf, "CONTAINER_NAME", name)
+		writeField(buf, "CONTAINER_ID", normalID(m.Container.ID))
+		writeField(buf, "CONTAINER_ID_FULL", m.Container.ID)
+		if m.Container.Config != nil {
+			writeField(buf, "IMAGE_NAME", m.Container.Config.Image)
+			for _, label := range a.labels {
+				if value, ok := m.Container.Config.Labels[label]; ok {
+					writeField(buf, fieldName(label), value)
+				}
+			}
+		}
+	}
+	if m.Replay {
+		writeField(buf, "LOGSPOUT_REPLAY", "1")
+	}
+	for name, value := range m.Fields {
+		writeField(buf, fieldName(name), value)
+	}
+	buf.WriteByte('\n')
+}
+
+// writeField writes a field in the export format. Values holding a newline
+// use the binary form: the name, a newline, the little endian length and the
+// value.
+func writeField(buf *bytes.Buffer, name, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteString(name)
+	buf.WriteByte('\n')
+	binary.Write(buf, binary.LittleEndian, uint64(len(value))) //nolint:errcheck
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// fieldName turns name into a valid journal field name: upper case letters,
+// digits and underscores, not starting with a digit or underscore and at most
+// 64 characters
+func fieldName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	field := strings.TrimLeft(b.String(), "_0123456789")
+	if field == "" {
+		field = "LABEL"
+	}
+	if len(field) > maxFieldName {
+		field = field[:maxFieldName]
+	}
+	return field
+}
+
+func normalID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
@@ -0,0 +1,146 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// parseExport decodes entries in the journal export format
+func parseExport(t *testing.T, data []byte) []map[string]string {
+	var entries []map[string]string
+	entry := make(map[string]string)
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			entries = append(entries, entry)
+			entry = make(map[string]string)
+		case strings.Contains(line, "="):
+			parts := strings.SplitN(line, "=", 2)
+			entry[parts[0]] = parts[1]
+		default:
+			var size uint64
+			if err = binary.Read(reader, binary.LittleEndian, &size); err != nil {
+				t.Fatal(err)
+			}
+			value := make([]byte, size+1)
+			if _, err = io.ReadFull(reader, value); err != nil {
+				t.Fatal(err)
+			}
+			entry[line] = string(value[:size])
+		}
+	}
+}
+
+func TestJournalUpload(t *testing.T) {
+	var entries []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/upload" || r.Header.Get("Content-Type") != contentType {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		entries = append(entries, parseExport(t, body)...)
+	}))
+	defer server.Close()
+
+	route := &router.Route{
+		Adapter: "journal+http",
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Options: map[string]string{"journal_labels": "com.example.team"},
+	}
+	adapter, err := NewJournalAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &docker.Container{
+		ID:     "8dfafdbc3a40aaaaaaaa",
+		Name:   "/app",
+		Config: &docker.Config{Image: "app:1", Labels: map[string]string{"com.example.team": "core", "other": "x"}},
+	}
+	logstream := make(chan *router.Message, 2)
+	logstream <- &router.Message{Container: container, Source: "stderr", Data: "failed", Time: time.Unix(10, 0)}
+	logstream <- &router.Message{Container: container, Source: "stdout", Data: "line one\nline two", Time: time.Unix(11, 0)}
+	close(logstream)
+	adapter.Stream(logstream)
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	first := entries[0]
+	for field, value := range map[string]string{
+		"MESSAGE":              "failed",
+		"PRIORITY":             "3",
+		"CONTAINER_NAME":       "app",
+		"CONTAINER_ID":         "8dfafdbc3a40",
+		"IMAGE_NAME":           "app:1",
+		"COM_EXAMPLE_TEAM":     "core",
+		"__REALTIME_TIMESTAMP": "10000000",
+	} {
+		if first[field] != value {
+			t.Errorf("expected %s=%s, got %q", field, value, first[field])
+		}
+	}
+	if _, ok := first["OTHER"]; ok {
+		t.Error("expected labels not listed in journal_labels to be left out")
+	}
+	if entries[1]["MESSAGE"] != "line one\nline two" {
+		t.Errorf("expected the multiline message to survive, got %q", entries[1]["MESSAGE"])
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"com.docker.compose.service": "COM_DOCKER_COMPOSE_SERVICE",
+		"_private":                   "PRIVATE",
+		"1st-label":                  "ST_LABEL",
+		"?":                          "LABEL",
+	} {
+		if got := fieldName(name); got != expected {
+			t.Errorf("expected %s for %s, got %s", expected, name, got)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	a := &Adapter{labels: []string{"com.example.team"}}
+	m := &router.Message{
+		Container: &docker.Container{
+			ID:   "8dfafdbc3a40a8ad0ebc2c3d7cbbe0ab3d6e35b2c1e0f1a1e7d4b5c6a7f8e9d0",
+			Name: "/web",
+			Config: &docker.Config{
+				Image:  "registry.example.com/shop/web:1.2.3",
+				Labels: map[string]string{"com.docker.compose.service": "web", "com.example.team": "core"},
+			},
+		},
+		Source: "stdout",
+		Data:   `192.168.1.10 - - [01/May/2024:10:00:00 +0000] "GET /cart HTTP/1.1" 200 512 "-" "Mozilla/5.0"`,
+		Time:   time.Now(),
+		Fields: map[string]string{"stats_cpu_percent": "1.25"},
+	}
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		a.encode(&buf, m)
+	}
+}
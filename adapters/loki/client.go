@@ -0,0 +1,197 @@
+package loki
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	lokiclient "github.com/livepeer/loki-client/client"
+	"github.com/livepeer/loki-client/logproto"
+	"github.com/livepeer/loki-client/model"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	contentType      = "application/x-protobuf"
+	maxErrMsgLen     = 1024
+	defaultBatchWait = time.Second
+	defaultBatchSize = 100 * 1024
+)
+
+var defaultBackoff = lokiclient.BackoffConfig{
+	MinBackoff: 100 * time.Millisecond,
+	MaxBackoff: 10 * time.Second,
+	MaxRetries: 10,
+}
+
+// client pushes batches of log entries to Loki. It follows the batching of
+// the loki-client package, which always sends through http.DefaultClient,
+// but uses a per route http.Client so requests can carry authentication.
+type client struct {
+	route      *router.Route
+	url        string
+	httpClient *http.Client
+	batchWait  time.Duration
+	batching   *httpclient.Batching
+	backoff    lokiclient.BackoffConfig
+	quit       chan struct{}
+	entries    chan entry
+	wg         sync.WaitGroup
+	stopOnce   sync.Once
+}
+
+type entry struct {
+	labels model.LabelSet
+	logproto.Entry
+}
+
+func newClient(route *router.Route, url string, httpClient *http.Client, batching *httpclient.Batching) *client {
+	c := &client{
+		route:      route,
+		url:        url,
+		httpClient: httpClient,
+		batchWait:  defaultBatchWait,
+		batching:   batching,
+		backoff:    defaultBackoff,
+		quit:       make(chan struct{}),
+		entries:    make(chan entry),
+	}
+	c.wg.Add(1)
+	go c.run()
+	return c
+}
+
+// Handle adds a new line to the next batch
+func (c *client) Handle(ls model.LabelSet, t time.Time, s string) {
+	select {
+	case c.entries <- entry{ls, logproto.Entry{Timestamp: t, Line: s}}:
+	case <-c.quit:
+	}
+}
+
+// Stop flushes the pending batch and stops the client
+func (c *client) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.quit)
+		c.wg.Wait()
+	})
+}
+
+func (c *client) run() {
+	defer c.wg.Done()
+	batch := map[string]*logproto.Stream{}
+	batchSize := 0
+	maxWait := time.NewTimer(c.batchWait)
+	defer maxWait.Stop()
+
+	flush := func(full bool) {
+		if len(batch) > 0 {
+			c.sendBatch(batch, full)
+		}
+		batch = map[string]*logproto.Stream{}
+		batchSize = 0
+	}
+
+	for {
+		select {
+		case <-c.quit:
+			flush(false)
+			return
+		case e := <-c.entries:
+			if batchSize+len(e.Line) > c.batching.Limit() {
+				flush(true)
+			}
+			batchSize += len(e.Line)
+			fp := e.labels.String()
+			stream, ok := batch[fp]
+			if !ok {
+				stream = &logproto.Stream{Labels: fp}
+				batch[fp] = stream
+			}
+			stream.Entries = append(stream.Entries, e.Entry)
+		case <-maxWait.C:
+			flush(false)
+			maxWait.Reset(c.batchWait)
+		}
+	}
+}
+
+// sendBatch sends batch, retrying server and connection errors. full is
+// whether the batch was sent because it reached the batch size.
+func (c *client) sendBatch(batch map[string]*logproto.Stream, full bool) {
+	buf, err := encodeBatch(batch)
+	if err != nil {
+		logger("loki: error encoding batch:", err)
+		return
+	}
+
+	ctx := context.Background()
+	backoff := lokiclient.NewBackoff(ctx, c.backoff)
+	var status int
+	for backoff.Ongoing() {
+		start := time.Now()
+		status, err = c.send(ctx, buf)
+		c.batching.Observe(len(buf), full, time.Since(start), err)
+		if err == nil {
+			c.route.SetConnState(router.ConnConnected, nil)
+			return
+		}
+		// only retry 5xx and connection-level errors
+		if status > 0 && status/100 != 5 {
+			break
+		}
+		logger("loki: error sending batch, will retry:", err)
+		c.route.SetConnState(router.ConnBackoff, err)
+		backoff.Wait()
+	}
+	c.route.SetConnState(router.ConnFailed, err)
+	logger("loki: final error sending batch:", err)
+}
+
+func encodeBatch(batch map[string]*logproto.Stream) ([]byte, error) {
+	req := logproto.PushRequest{
+		Streams: make([]*logproto.Stream, 0, len(batch)),
+	}
+	for _, stream := range batch {
+		req.Streams = append(req.Streams, stream)
+	}
+	buf, err := proto.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, buf), nil
+}
+
+func (c *client) send(ctx context.Context, buf []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(buf))
+	if err != nil {
+		return -1, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxErrMsgLen))
+		line := ""
+		if scanner.Scan() {
+			line = scanner.Text()
+		}
+		err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, line)
+	}
+	return resp.StatusCode, err
+}
@@ -0,0 +1,144 @@
+package loki
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/livepeer/loki-client/model"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+var (
+	validLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	invalidLabel   = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+)
+
+// labelRules rewrite the labels of the streams of a route, to keep the number
+// of streams in Loki bounded: container labels to add, the labels to keep,
+// labels whose values are hashed or put in buckets, and static labels
+type labelRules struct {
+	// container maps Docker labels to the Loki labels they are added as
+	container map[string]string
+	keep      map[string]bool
+	// hash maps labels to a number of buckets, or 0 to hash the value
+	hash   map[string]int
+	static model.LabelSet
+}
+
+// lokiLabelName turns a Docker label such as com.example.team into a valid
+// Loki label name
+func lokiLabelName(s string) string {
+	name := invalidLabel.ReplaceAllString(s, "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// splitPairs splits a list like a=b,c into its keys and values, with the
+// values empty when not given
+func splitPairs(s, sep string) [][2]string {
+	var pairs [][2]string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, sep, 2)
+		if len(kv) == 1 {
+			kv = append(kv, "")
+		}
+		pairs = append(pairs, [2]string{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])})
+	}
+	return pairs
+}
+
+func newLabelRules(route *router.Route) (*labelRules, error) {
+	r := &labelRules{}
+	if s := httpclient.Option(route, "loki_container_labels", "LOKI_CONTAINER_LABELS"); s != "" {
+		r.container = make(map[string]string)
+		for _, pair := range splitPairs(s, "=") {
+			name := pair[1]
+			if name == "" {
+				name = lokiLabelName(pair[0])
+			}
+			if !validLabelName.MatchString(name) {
+				return nil, fmt.Errorf("loki: invalid loki_container_labels: %s", s)
+			}
+			r.container[pair[0]] = name
+		}
+	}
+	if s := httpclient.Option(route, "loki_labels", "LOKI_LABELS"); s != "" {
+		r.keep = make(map[string]bool)
+		for _, pair := range splitPairs(s, "=") {
+			r.keep[pair[0]] = true
+		}
+	}
+	if s := httpclient.Option(route, "loki_hash_labels", "LOKI_HASH_LABELS"); s != "" {
+		r.hash = make(map[string]int)
+		for _, pair := range splitPairs(s, ":") {
+			buckets := 0
+			if pair[1] != "" {
+				var err error
+				if buckets, err = strconv.Atoi(pair[1]); err != nil || buckets < 1 {
+					return nil, fmt.Errorf("loki: invalid loki_hash_labels: %s", s)
+				}
+			}
+			r.hash[pair[0]] = buckets
+		}
+	}
+	if s := httpclient.Option(route, "loki_static_labels", "LOKI_STATIC_LABELS"); s != "" {
+		r.static = make(model.LabelSet)
+		for _, pair := range splitPairs(s, "=") {
+			if !validLabelName.MatchString(pair[0]) {
+				return nil, fmt.Errorf("loki: invalid loki_static_labels: %s", s)
+			}
+			r.static[pair[0]] = pair[1]
+		}
+	}
+	if r.container == nil && r.keep == nil && r.hash == nil && r.static == nil {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// apply rewrites the labels of the stream of m
+func (r *labelRules) apply(labels model.LabelSet, m *router.Message) {
+	if m.Container != nil && m.Container.Config != nil {
+		for docker, name := range r.container {
+			if v, ok := m.Container.Config.Labels[docker]; ok {
+				labels[name] = v
+			}
+		}
+	}
+	if r.keep != nil {
+		for name := range labels {
+			if !r.keep[name] {
+				delete(labels, name)
+			}
+		}
+	}
+	for name, buckets := range r.hash {
+		if v, ok := labels[name]; ok {
+			labels[name] = hashLabel(v, buckets)
+		}
+	}
+	for name, v := range r.static {
+		labels[name] = v
+	}
+}
+
+// hashLabel returns the bucket of v out of buckets, or a short hash of v
+// when buckets is 0
+func hashLabel(v string, buckets int) string {
+	h := fnv.New32a()
+	h.Write([]byte(v)) //nolint:errcheck
+	if buckets == 0 {
+		return fmt.Sprintf("%08x", h.Sum32())
+	}
+	return strconv.Itoa(int(h.Sum32() % uint32(buckets)))
+}
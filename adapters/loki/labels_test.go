@@ -0,0 +1,78 @@
+package loki
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/livepeer/loki-client/model"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestLabelRules(t *testing.T) {
+	rules, err := newLabelRules(&router.Route{Options: map[string]string{
+		"loki_container_labels": "com.example.team,com.example.tenant=tenant",
+		"loki_labels":           "container_name,com_example_team,tenant",
+		"loki_hash_labels":      "container_name:4,tenant",
+		"loki_static_labels":    "env=prod",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &docker.Container{Config: &docker.Config{Labels: map[string]string{
+		"com.example.team":   "payments",
+		"com.example.tenant": "acme",
+		"com.example.other":  "x",
+	}}}
+	labels := model.LabelSet{"container_name": "app", "container_id": "abc", "nodename": "node"}
+	rules.apply(labels, &router.Message{Container: container})
+	expected := model.LabelSet{
+		"container_name":   hashLabel("app", 4),
+		"com_example_team": "payments",
+		"tenant":           hashLabel("acme", 0),
+		"env":              "prod",
+	}
+	if len(labels) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, labels)
+	}
+	for name, v := range expected {
+		if labels[name] != v {
+			t.Errorf("expected %s=%q, got %q", name, v, labels[name])
+		}
+	}
+	if len(labels["tenant"]) != 8 {
+		t.Errorf("expected an 8 digit hash, got %q", labels["tenant"])
+	}
+}
+
+func TestHashLabelBuckets(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, v := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		bucket := hashLabel(v, 3)
+		if bucket != hashLabel(v, 3) {
+			t.Fatalf("expected the bucket of %s to be stable", v)
+		}
+		seen[bucket] = true
+	}
+	for bucket := range seen {
+		if bucket != "0" && bucket != "1" && bucket != "2" {
+			t.Errorf("unexpected bucket %q", bucket)
+		}
+	}
+}
+
+func TestLabelRulesOptions(t *testing.T) {
+	if rules, err := newLabelRules(&router.Route{}); rules != nil || err != nil {
+		t.Errorf("expected no rules, got %v, %v", rules, err)
+	}
+	for _, options := range []map[string]string{
+		{"loki_hash_labels": "tenant:0"},
+		{"loki_hash_labels": "tenant:x"},
+		{"loki_static_labels": "bad-name=x"},
+		{"loki_container_labels": "team=bad.name"},
+	} {
+		if _, err := newLabelRules(&router.Route{Options: options}); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
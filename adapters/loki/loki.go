@@ -7,36 +7,55 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gliderlabs/logspout/cfg"
-	"github.com/gliderlabs/logspout/router"
-	lokiclient "github.com/livepeer/loki-client/client"
 	"github.com/livepeer/loki-client/model"
+
+	"github.com/gliderlabs/logspout/httpclient"
+	"github.com/gliderlabs/logspout/router"
 )
 
-var hostname string
+const (
+	defaultStreamLabel = "stream"
+	defaultMaxStreams  = 100
+)
 
-func getHostname() string {
-	content, err := os.ReadFile("/etc/host_hostname")
-	if err == nil && len(content) > 0 {
-		hostname = strings.TrimRight(string(content), "\r\n")
-	} else {
-		hostname = cfg.GetEnvDefault("SYSLOG_HOSTNAME", "{{.Container.Config.Hostname}}")
+// nodename returns the host logspout runs on, or else the hostname of the
+// container of m
+func nodename(m *router.Message) string {
+	if host := router.Hostname(); host != "" {
+		return host
 	}
-	return hostname
+	return m.Container.Config.Hostname
 }
 
 func init() {
-	hostname = getHostname()
 	router.AdapterFactories.Register(NewLokiAdapter, "loki")
+	router.DescribeAdapter("loki", router.AdapterInfo{
+		DefaultTransport: "http",
+		Transports:       []string{"http", "https"},
+		Options: append([]router.AdapterOption{
+			{Name: "loki_stream", Env: "LOKI_STREAM", Description: "template resolving to the stream of a message"},
+			{Name: "loki_stream_label", Env: "LOKI_STREAM_LABEL", Description: "label holding the resolved stream"},
+			{Name: "loki_max_streams", Env: "LOKI_MAX_STREAMS", Description: "maximum number of distinct streams, 0 for no limit"},
+			{Name: "loki_labels", Env: "LOKI_LABELS", Description: "comma separated labels to keep, all by default"},
+			{Name: "loki_container_labels", Env: "LOKI_CONTAINER_LABELS", Description: "comma separated container labels to add, as label or label=name"},
+			{Name: "loki_static_labels", Env: "LOKI_STATIC_LABELS", Description: "comma separated name=value labels to add to every stream"},
+			{Name: "loki_hash_labels", Env: "LOKI_HASH_LABELS", Description: "comma separated labels to replace by a hash of their value, or by one of N buckets as label:N"},
+		}, httpclient.Options...),
+	})
 }
 
 // LokiAdapter is an adapter that streams logs to Loki.
 type LokiAdapter struct {
-	route  *router.Route
-	client *lokiclient.Client
+	route       *router.Route
+	client      *client
+	sig         chan os.Signal
+	stream      *router.TargetTemplate
+	streamLabel string
+	labels      *labelRules
 }
 
 func logger(v ...interface{}) {
@@ -45,7 +64,6 @@ func logger(v ...interface{}) {
 
 // NewLokiAdapter creates a LokiAdapter.
 func NewLokiAdapter(route *router.Route) (router.LogAdapter, error) {
-	baseLabels := model.LabelSet{}
 	path := "/api/prom/push"
 	if route.Path != "" {
 		path = route.Path
@@ -57,18 +75,43 @@ func NewLokiAdapter(route *router.Route) (router.LogAdapter, error) {
 		Path:   path,
 	}
 	log.Printf("Using Loki url: %s\n", urlObject.Redacted())
-	client, err := lokiclient.NewWithDefaults(urlObject.String(), baseLabels, logger)
+	httpClient, err := httpclient.New(route)
+	if err != nil {
+		return nil, err
+	}
+	adapter := &LokiAdapter{
+		route:       route,
+		streamLabel: httpclient.Option(route, "loki_stream_label", "LOKI_STREAM_LABEL"),
+	}
+	if adapter.streamLabel == "" {
+		adapter.streamLabel = defaultStreamLabel
+	}
+	if adapter.labels, err = newLabelRules(route); err != nil {
+		return nil, err
+	}
+	if text := httpclient.Option(route, "loki_stream", "LOKI_STREAM"); text != "" {
+		maxStreams := defaultMaxStreams
+		if s := httpclient.Option(route, "loki_max_streams", "LOKI_MAX_STREAMS"); s != "" {
+			if maxStreams, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("loki: invalid loki_max_streams: %s", s)
+			}
+		}
+		if adapter.stream, err = router.NewTargetTemplate(text, "default", maxStreams); err != nil {
+			return nil, err
+		}
+	}
+	batching, err := httpclient.NewBatching(route, defaultBatchSize)
 	if err != nil {
 		return nil, err
 	}
-	c := make(chan os.Signal)
-	signal.Notify(c, os.Interrupt)
-	go waitExit(client, c)
-
-	return &LokiAdapter{
-		route:  route,
-		client: client,
-	}, nil
+	c := newClient(route, urlObject.String(), httpClient, batching)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go waitExit(c, sig)
+
+	adapter.client = c
+	adapter.sig = sig
+	return adapter, nil
 }
 
 // Stream implements the router.LogAdapter interface.
@@ -77,7 +120,7 @@ func (a *LokiAdapter) Stream(logstream chan *router.Message) {
 
 	for m := range logstream {
 		labels := model.LabelSet{
-			"nodename":       hostname,
+			"nodename":       nodename(m),
 			"container_id":   m.Container.ID,
 			"container_name": m.Container.Name[1:],
 			"image_id":       m.Container.Image,
@@ -85,17 +128,41 @@ func (a *LokiAdapter) Stream(logstream chan *router.Message) {
 			"command":        strings.Join(m.Container.Config.Cmd[:], " "),
 			"created":        fmt.Sprintf("%s", m.Container.Created),
 		}
+		if a.labels != nil {
+			a.labels.apply(labels, m)
+		}
+		if m.Replay {
+			labels["replay"] = "true"
+		}
+		if a.stream != nil {
+			labels[a.streamLabel] = a.stream.Target(m)
+		}
 
+		// replayed lines keep the time they were originally logged at
+		timestamp := time.Now()
+		if m.Replay {
+			timestamp = m.Time
+		}
 		line := strings.TrimSpace(m.Data)
 		if len(line) > 0 {
-			a.client.Handle(labels, time.Now(), line)
+			a.client.Handle(labels, timestamp, line)
 		}
 	}
 }
 
-func waitExit(client *lokiclient.Client, c chan os.Signal) {
-	<-c
-	client.Stop()
+// Close flushes pending entries and stops the client of the adapter
+func (a *LokiAdapter) Close() error {
+	signal.Stop(a.sig)
+	a.client.Stop()
+	return nil
+}
+
+func waitExit(c *client, sig chan os.Signal) {
+	select {
+	case <-sig:
+		c.Stop()
+	case <-c.quit:
+	}
 }
 
 func scheme(adapter string) string {
@@ -0,0 +1,287 @@
+// Package lumberjack provides an adapter speaking version 2 of the Lumberjack
+// protocol, as used by Beats, so logs can be shipped to the beats input of
+// Logstash. Messages are sent in windows that the receiver acknowledges.
+package lumberjack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	protocolVersion      = '2'
+	frameWindow          = 'W'
+	frameJSON            = 'J'
+	frameCompressed      = 'C'
+	frameAck             = 'A'
+	defaultWindow        = 256
+	defaultFlushInterval = time.Second
+	defaultTimeout       = 30 * time.Second
+	defaultRetries       = 5
+)
+
+var hostname string
+
+func init() {
+	hostname, _ = os.Hostname()
+	router.AdapterFactories.Register(NewLumberjackAdapter, "lumberjack")
+	router.DescribeAdapter("lumberjack", router.AdapterInfo{
+		DefaultTransport: "tcp",
+		Options: []router.AdapterOption{
+			{Name: "lumberjack_window", Env: "LUMBERJACK_WINDOW", Description: "events sent before waiting for an ACK"},
+			{Name: "lumberjack_flush_interval", Env: "LUMBERJACK_FLUSH_INTERVAL", Description: "how long events wait for a window to fill"},
+			{Name: "lumberjack_timeout", Env: "LUMBERJACK_TIMEOUT", Description: "how long to wait for an ACK"},
+			{Name: "lumberjack_compression", Env: "LUMBERJACK_COMPRESSION", Description: "zlib compression level, 0 for none"},
+		},
+	})
+}
+
+// Adapter sends messages to a Lumberjack v2 receiver
+type Adapter struct {
+	route         *router.Route
+	transport     router.AdapterTransport
+	conn          net.Conn
+	window        int
+	flushInterval time.Duration
+	timeout       time.Duration
+	compression   int
+	retries       int
+}
+
+// NewLumberjackAdapter returns an Adapter with TCP as the default transport.
+// Use the tls transport for encrypted connections.
+func NewLumberjackAdapter(route *router.Route) (router.LogAdapter, error) {
+	transport, found := router.AdapterTransports.Lookup(route.AdapterTransport("tcp"))
+	if !found {
+		return nil, errors.New("bad transport: " + route.Adapter)
+	}
+	a := &Adapter{
+		route:         route,
+		transport:     transport,
+		window:        defaultWindow,
+		flushInterval: defaultFlushInterval,
+		timeout:       defaultTimeout,
+		retries:       defaultRetries,
+	}
+	var err error
+	if s := option(route, "lumberjack_window", "LUMBERJACK_WINDOW"); s != "" {
+		if a.window, err = strconv.Atoi(s); err != nil || a.window < 1 {
+			return nil, errors.New("lumberjack: bad lumberjack_window: " + s)
+		}
+	}
+	if s := option(route, "lumberjack_flush_interval", "LUMBERJACK_FLUSH_INTERVAL"); s != "" {
+		if a.flushInterval, err = time.ParseDuration(s); err != nil || a.flushInterval <= 0 {
+			return nil, errors.New("lumberjack: bad lumberjack_flush_interval: " + s)
+		}
+	}
+	if s := option(route, "lumberjack_timeout", "LUMBERJACK_TIMEOUT"); s != "" {
+		if a.timeout, err = time.ParseDuration(s); err != nil || a.timeout <= 0 {
+			return nil, errors.New("lumberjack: bad lumberjack_timeout: " + s)
+		}
+	}
+	if s := option(route, "lumberjack_compression", "LUMBERJACK_COMPRESSION"); s != "" {
+		if a.compression, err = strconv.Atoi(s); err != nil || a.compression < 0 || a.compression > 9 {
+			return nil, errors.New("lumberjack: bad lumberjack_compression: " + s)
+		}
+	}
+	if a.conn, err = transport.Dial(route.Address, route.Options); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func option(route *router.Route, key, env string) string {
+	if v := route.Options[key]; v != "" {
+		return v
+	}
+	return cfg.GetEnvDefault(env, "")
+}
+
+type event struct {
+	Timestamp string            `json:"@timestamp"`
+	Message   string            `json:"message"`
+	Stream    string            `json:"stream"`
+	Host      string            `json:"host"`
+	Docker    dockerInfo        `json:"docker"`
+	Replay    bool              `json:"replay,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+type dockerInfo struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Image    string            `json:"image"`
+	Hostname string            `json:"hostname"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+func encode(m *router.Message) ([]byte, error) {
+	e := event{
+		Timestamp: m.Time.UTC().Format(time.RFC3339Nano),
+		Message:   m.Data,
+		Stream:    m.Source,
+		Host:      hostname,
+		Replay:    m.Replay,
+		Fields:    m.Fields,
+	}
+	if m.Container != nil {
+		e.Docker.ID = m.Container.ID
+		e.Docker.Name = strings.TrimPrefix(m.Container.Name, "/")
+		if m.Container.Config != nil {
+			e.Docker.Image = m.Container.Config.Image
+			e.Docker.Hostname = m.Container.Config.Hostname
+			e.Docker.Labels = m.Container.Config.Labels
+		}
+	}
+	return json.Marshal(e)
+}
+
+// Stream implements the router.LogAdapter interface. Messages are collected
+// until a window is full or the flush interval passed.
+func (a *Adapter) Stream(logstream chan *router.Message) {
+	ticker := time.NewTicker(a.flushInterval)
+	defer ticker.Stop()
+	var batch [][]byte
+	for {
+		select {
+		case message, ok := <-logstream:
+			if !ok {
+				a.send(batch)
+				return
+			}
+			data, err := encode(message)
+			if err != nil {
+				log.Println("lumberjack:", err)
+				continue
+			}
+			batch = append(batch, data)
+			if len(batch) >= a.window {
+				a.send(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			a.send(batch)
+			batch = nil
+		}
+	}
+}
+
+// send writes batch as one window and waits for its acknowledgement. When
+// that fails it reconnects and sends the messages that weren't acknowledged
+// again.
+func (a *Adapter) send(batch [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+	var err error
+	for attempt := 0; attempt <= a.retries; attempt++ {
+		if attempt > 0 {
+			a.route.SetConnState(router.ConnBackoff, err)
+			time.Sleep((1 << uint(attempt)) * 100 * time.Millisecond)
+		}
+		if a.conn == nil {
+			if a.conn, err = a.transport.Dial(a.route.Address, a.route.Options); err != nil {
+				continue
+			}
+		}
+		var acked int
+		if acked, err = a.sendWindow(batch); err == nil {
+			a.route.SetConnState(router.ConnConnected, nil)
+			return
+		}
+		batch = batch[acked:]
+		a.conn.Close()
+		a.conn = nil
+	}
+	a.route.SetConnState(router.ConnFailed, err)
+	log.Printf("lumberjack: dropped %d messages: %v", len(batch), err)
+}
+
+// sendWindow returns the number of messages acknowledged by the receiver
+func (a *Adapter) sendWindow(batch [][]byte) (int, error) {
+	a.conn.SetDeadline(time.Now().Add(a.timeout)) //nolint:errcheck
+	// sequence numbers start at 1 in every window
+	var frames bytes.Buffer
+	for i, data := range batch {
+		writeFrame(&frames, frameJSON, uint32(i+1), uint32(len(data)))
+		frames.Write(data)
+	}
+	var buf bytes.Buffer
+	writeFrame(&buf, frameWindow, uint32(len(batch)))
+	if a.compression > 0 {
+		var compressed bytes.Buffer
+		zw, err := zlib.NewWriterLevel(&compressed, a.compression)
+		if err != nil {
+			return 0, err
+		}
+		zw.Write(frames.Bytes()) //nolint:errcheck
+		if err = zw.Close(); err != nil {
+			return 0, err
+		}
+		writeFrame(&buf, frameCompressed, uint32(compressed.Len()))
+		buf.Write(compressed.Bytes())
+	} else {
+		buf.Write(frames.Bytes())
+	}
+	if _, err := a.conn.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return a.awaitAck(uint32(len(batch)))
+}
+
+// awaitAck reads acknowledgements until the last sequence number of the
+// window has been acknowledged. Receivers acknowledge partially while they
+// are busy, which extends the deadline.
+func (a *Adapter) awaitAck(last uint32) (int, error) {
+	ack := make([]byte, 6)
+	var acked uint32
+	for {
+		if _, err := io.ReadFull(a.conn, ack); err != nil {
+			return int(acked), err
+		}
+		if ack[0] != protocolVersion || ack[1] != frameAck {
+			return int(acked), fmt.Errorf("lumberjack: unexpected frame %q", ack[:2])
+		}
+		seq := binary.BigEndian.Uint32(ack[2:])
+		if seq > last {
+			return int(acked), fmt.Errorf("lumberjack: acknowledgement %d outside window of %d", seq, last)
+		}
+		if seq > acked {
+			acked = seq
+		}
+		if acked == last {
+			return int(acked), nil
+		}
+		a.conn.SetDeadline(time.Now().Add(a.timeout)) //nolint:errcheck
+	}
+}
+
+func writeFrame(buf *bytes.Buffer, frameType byte, fields ...uint32) {
+	buf.WriteByte(protocolVersion)
+	buf.WriteByte(frameType)
+	for _, field := range fields {
+		binary.Write(buf, binary.BigEndian, field) //nolint:errcheck
+	}
+}
+
+// Close closes the connection of the adapter
+func (a *Adapter) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	return a.conn.Close()
+}
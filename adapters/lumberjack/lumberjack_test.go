@@ -0,0 +1,193 @@
+package lumberjack
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/tcp"
+)
+
+// beatsServer decodes Lumberjack v2 windows and acknowledges them. When
+// dropFirst is set the first connection only processes and acknowledges half
+// of its first window before it is dropped.
+type beatsServer struct {
+	listener  net.Listener
+	dropFirst bool
+
+	mu       sync.Mutex
+	messages []string
+	conns    int
+}
+
+func newBeatsServer(t *testing.T, dropFirst bool) *beatsServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &beatsServer{listener: listener, dropFirst: dropFirst}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *beatsServer) serve(conn net.Conn) {
+	defer conn.Close()
+	s.mu.Lock()
+	s.conns++
+	drop := s.dropFirst && s.conns == 1
+	s.mu.Unlock()
+	for {
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		if header[1] != frameWindow {
+			return
+		}
+		size := binary.BigEndian.Uint32(header[2:])
+		var reader io.Reader = conn
+		peek := make([]byte, 2)
+		if _, err := io.ReadFull(conn, peek); err != nil {
+			return
+		}
+		if peek[1] == frameCompressed {
+			var length uint32
+			binary.Read(conn, binary.BigEndian, &length) //nolint:errcheck
+			compressed := make([]byte, length)
+			io.ReadFull(conn, compressed) //nolint:errcheck
+			zr, err := zlib.NewReader(bytes.NewReader(compressed))
+			if err != nil {
+				return
+			}
+			data, _ := ioutil.ReadAll(zr)
+			reader = bytes.NewReader(data[2:])
+		}
+		for i := uint32(0); i < size; i++ {
+			if i > 0 {
+				io.ReadFull(reader, peek) //nolint:errcheck
+			}
+			var seq, length uint32
+			binary.Read(reader, binary.BigEndian, &seq)    //nolint:errcheck
+			binary.Read(reader, binary.BigEndian, &length) //nolint:errcheck
+			payload := make([]byte, length)
+			if _, err := io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			if drop && seq > size/2 {
+				// lost in the crash
+				continue
+			}
+			var e event
+			json.Unmarshal(payload, &e) //nolint:errcheck
+			s.mu.Lock()
+			s.messages = append(s.messages, e.Message)
+			s.mu.Unlock()
+		}
+		if drop {
+			ack := []byte{protocolVersion, frameAck, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(ack[2:], size/2)
+			conn.Write(ack) //nolint:errcheck
+			return
+		}
+		ack := []byte{protocolVersion, frameAck, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(ack[2:], size)
+		conn.Write(ack) //nolint:errcheck
+	}
+}
+
+func (s *beatsServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func streamMessages(t *testing.T, options map[string]string, server *beatsServer, messages []string) {
+	route := &router.Route{Adapter: "lumberjack", Address: server.listener.Addr().String(), Options: options}
+	adapter, err := NewLumberjackAdapter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adapter.(*Adapter).Close()
+	logstream := make(chan *router.Message)
+	done := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(done)
+	}()
+	container := &docker.Container{ID: "abc", Name: "/app", Config: &docker.Config{Image: "app:1"}}
+	for _, data := range messages {
+		logstream <- &router.Message{Container: container, Data: data, Source: "stdout", Time: time.Now()}
+	}
+	close(logstream)
+	<-done
+}
+
+func TestLumberjackWindows(t *testing.T) {
+	for _, compression := range []string{"0", "6"} {
+		server := newBeatsServer(t, false)
+		streamMessages(t, map[string]string{"lumberjack_window": "3", "lumberjack_compression": compression},
+			server, []string{"a", "b", "c", "d", "e"})
+		server.listener.Close()
+		if got := server.received(); len(got) != 5 || got[0] != "a" || got[4] != "e" {
+			t.Errorf("compression %s: expected all messages in order, got %v", compression, got)
+		}
+	}
+}
+
+func TestLumberjackResendsUnacknowledged(t *testing.T) {
+	server := newBeatsServer(t, true)
+	defer server.listener.Close()
+	streamMessages(t, map[string]string{"lumberjack_window": "4"}, server, []string{"a", "b", "c", "d"})
+	got := server.received()
+	expected := []string{"a", "b", "c", "d"}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	m := &router.Message{
+		Container: &docker.Container{
+			ID:   "8dfafdbc3a40a8ad0ebc2c3d7cbbe0ab3d6e35b2c1e0f1a1e7d4b5c6a7f8e9d0",
+			Name: "/web",
+			Config: &docker.Config{
+				Image:    "registry.example.com/shop/web:1.2.3",
+				Hostname: "8dfafdbc3a40",
+				Labels:   map[string]string{"com.docker.compose.service": "web", "com.example.team": "core"},
+			},
+		},
+		Source: "stdout",
+		Data:   `192.168.1.10 - - [01/May/2024:10:00:00 +0000] "GET /cart HTTP/1.1" 200 512 "-" "Mozilla/5.0"`,
+		Time:   time.Now(),
+		Fields: map[string]string{"stats_cpu_percent": "1.25"},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := encode(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package multiline
 
 import (
 	"errors"
+	"io"
+	"log"
 	"os"
 	"regexp"
 	"strconv"
@@ -20,6 +22,11 @@ const (
 	matchNonFirst     = "nonfirst"
 	matchNonLast      = "nonlast"
 	defaultFlushAfter = 500 * time.Millisecond
+	// labelPrefix starts the container labels setting up multiline logging
+	// for a container
+	labelPrefix = "logspout.multiline"
+	// maxContainerRules bounds the containers whose rules are kept
+	maxContainerRules = 1024
 )
 
 func init() {
@@ -39,6 +46,50 @@ type Adapter struct {
 	checkInterval   time.Duration
 	buffers         map[string]*router.Message
 	nextCheck       <-chan time.Time
+	// maxLines sends an entry once it has this many lines, 0 for no limit
+	maxLines int
+	// rules are the rules of each container, lines the number of lines of
+	// each buffered entry
+	rules map[string]*lineRules
+	lines map[string]int
+}
+
+// lineRules tell which lines of a container start or end an entry
+type lineRules struct {
+	pattern        *regexp.Regexp
+	matchFirstLine bool
+	negateMatch    bool
+	flushAfter     time.Duration
+	maxLines       int
+}
+
+// parseMatch returns whether the pattern matches the first or last line of
+// an entry and whether the match is negated, for a MULTILINE_MATCH value
+func parseMatch(matchType string) (matchFirstLine, negateMatch bool, err error) {
+	switch strings.ToLower(matchType) {
+	case matchFirst:
+		return true, false, nil
+	case matchLast:
+		return false, false, nil
+	case matchNonFirst:
+		return true, true, nil
+	case matchNonLast:
+		return false, true, nil
+	default:
+		return false, false, errors.New("must be one of first|last|nonfirst|nonlast")
+	}
+}
+
+// parseFlushAfter parses a number of milliseconds, or a duration like 2s
+func parseFlushAfter(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if timeoutMS, errConv := strconv.Atoi(s); errConv == nil {
+		d, err = time.Duration(timeoutMS)*time.Millisecond, nil
+	}
+	if err != nil || d <= 0 {
+		return 0, errors.New("must be a number of milliseconds or a duration")
+	}
+	return d, nil
 }
 
 // NewMultilineAdapter returns a configured multiline.Adapter
@@ -70,23 +121,8 @@ func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) {
 	if matchType == "" {
 		matchType = matchNonFirst
 	}
-	matchType = strings.ToLower(matchType)
-	matchFirstLine := false
-	negateMatch := false
-	switch matchType {
-	case matchFirst:
-		matchFirstLine = true
-		negateMatch = false
-	case matchLast:
-		matchFirstLine = false
-		negateMatch = false
-	case matchNonFirst:
-		matchFirstLine = true
-		negateMatch = true
-	case matchNonLast:
-		matchFirstLine = false
-		negateMatch = true
-	default:
+	matchFirstLine, negateMatch, err := parseMatch(matchType)
+	if err != nil {
 		return nil, errors.New("multiline: invalid value for MULTILINE_MATCH (must be one of first|last|nonfirst|nonlast): " + matchType)
 	}
 
@@ -100,6 +136,13 @@ func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) {
 		flushAfter = time.Duration(timeoutMS) * time.Millisecond
 	}
 
+	maxLines := 0
+	if s := os.Getenv("MULTILINE_MAX_LINES"); s != "" {
+		if maxLines, err = strconv.Atoi(s); err != nil || maxLines < 0 {
+			return nil, errors.New("multiline: invalid value for MULTILINE_MAX_LINES (must be a number): " + s)
+		}
+	}
+
 	parts := strings.SplitN(route.Adapter, "+", 2)
 	if len(parts) != 2 { //nolint:gomnd
 		return nil, errors.New("multiline: adapter must have a sub-adapter, eg: multiline+raw+tcp")
@@ -132,9 +175,18 @@ func NewMultilineAdapter(route *router.Route) (a router.LogAdapter, err error) {
 		checkInterval:   checkInterval,
 		buffers:         make(map[string]*router.Message),
 		nextCheck:       time.After(checkInterval),
+		maxLines:        maxLines,
 	}, nil
 }
 
+// Close closes the sub-adapter when it holds resources
+func (a *Adapter) Close() error {
+	if closer, ok := a.subAdapter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 // Stream sends log data to the next adapter
 func (a *Adapter) Stream(logstream chan *router.Message) { //nolint:gocyclo,gocognit
 	wg := sync.WaitGroup{}
@@ -159,43 +211,51 @@ func (a *Adapter) Stream(logstream chan *router.Message) { //nolint:gocyclo,goco
 				return
 			}
 
-			if !multilineContainer(message.Container, a.enableByDefault) {
+			rules := a.containerRules(message.Container)
+			if rules == nil {
 				a.out <- message
 				continue
 			}
 
 			cID := message.Container.ID
 			old, oldExists := a.buffers[cID]
-			if a.isFirstLine(message) { //nolint:nestif
+			if rules.isFirstLine(message) { //nolint:nestif
 				if oldExists {
 					a.out <- old
 				}
 
-				a.buffers[cID] = message
+				a.hold(cID, message, 1)
 			} else {
-				isLastLine := a.isLastLine(message)
+				isLastLine := rules.isLastLine(message)
+				lines := a.lines[cID] + 1
 
 				if oldExists {
 					old.Data += a.separator + message.Data
 					message = old
 				}
 
-				if isLastLine {
+				if isLastLine || rules.maxLines > 0 && lines >= rules.maxLines {
 					a.out <- message
 					if oldExists {
-						delete(a.buffers, cID)
+						a.release(cID)
 					}
+				} else if oldExists {
+					a.lines[cID] = lines
 				} else {
-					a.buffers[cID] = message
+					a.hold(cID, message, lines)
 				}
 			}
 		case <-a.nextCheck:
 			now := time.Now()
 
 			for key, message := range a.buffers {
-				if message.Time.Add(a.flushAfter).After(now) {
+				flushAfter := a.flushAfter
+				if rules, ok := a.rules[key]; ok && rules != nil {
+					flushAfter = rules.flushAfter
+				}
+				if !message.Time.Add(flushAfter).After(now) {
 					a.out <- message
-					delete(a.buffers, key)
+					a.release(key)
 				}
 			}
 
@@ -204,33 +264,129 @@ func (a *Adapter) Stream(logstream chan *router.Message) { //nolint:gocyclo,goco
 	}
 }
 
-func (a *Adapter) isFirstLine(message *router.Message) bool {
-	if !a.matchFirstLine {
+// hold buffers a copy of message as the entry of container, so the message
+// other routes get is left as it is
+func (a *Adapter) hold(container string, message *router.Message, lines int) {
+	if a.lines == nil {
+		a.lines = make(map[string]int)
+	}
+	copied := *message
+	a.buffers[container] = &copied
+	a.lines[container] = lines
+}
+
+func (a *Adapter) release(container string) {
+	delete(a.buffers, container)
+	delete(a.lines, container)
+}
+
+// containerRules returns the rules for the lines of container, or nil when
+// multiline logging is off for it. The rules are set by the labels of the
+// container, with the settings of the adapter as the defaults.
+func (a *Adapter) containerRules(container *docker.Container) *lineRules {
+	if rules, ok := a.rules[container.ID]; ok {
+		return rules
+	}
+	if a.rules == nil || len(a.rules) >= maxContainerRules {
+		a.rules = make(map[string]*lineRules)
+	}
+	var rules *lineRules
+	if multilineContainer(container, a.enableByDefault) {
+		var err error
+		if rules, err = a.labelRules(container.Config.Labels); err != nil {
+			log.Printf("multiline: container %s: %v", container.ID, err)
+		}
+		if rules.flushAfter/2 < a.checkInterval { //nolint:gomnd
+			a.checkInterval = rules.flushAfter / 2 //nolint:gomnd
+		}
+	}
+	a.rules[container.ID] = rules
+	return rules
+}
+
+// labelRules returns the rules of the logspout.multiline.* labels, and the
+// error of the first bad label. Bad labels keep the defaults.
+func (a *Adapter) labelRules(labels map[string]string) (*lineRules, error) {
+	rules := &lineRules{
+		pattern:        a.pattern,
+		matchFirstLine: a.matchFirstLine,
+		negateMatch:    a.negateMatch,
+		flushAfter:     a.flushAfter,
+		maxLines:       a.maxLines,
+	}
+	var first error
+	bad := func(name, value, reason string) {
+		if first == nil {
+			first = errors.New("invalid value for " + labelPrefix + "." + name + " (" + reason + "): " + value)
+		}
+	}
+	if s, ok := labels[labelPrefix+".pattern"]; ok {
+		if re, err := regexp.Compile(s); err != nil {
+			bad("pattern", s, "must be regexp")
+		} else {
+			rules.pattern = re
+		}
+	}
+	if s, ok := labels[labelPrefix+".match"]; ok {
+		if matchFirstLine, negateMatch, err := parseMatch(s); err != nil {
+			bad("match", s, err.Error())
+		} else {
+			rules.matchFirstLine, rules.negateMatch = matchFirstLine, negateMatch
+		}
+	}
+	if s, ok := labels[labelPrefix+".flush_after"]; ok {
+		if d, err := parseFlushAfter(s); err != nil {
+			bad("flush_after", s, err.Error())
+		} else {
+			rules.flushAfter = d
+		}
+	}
+	if s, ok := labels[labelPrefix+".max_lines"]; ok {
+		if n, err := strconv.Atoi(s); err != nil || n < 0 {
+			bad("max_lines", s, "must be a number")
+		} else {
+			rules.maxLines = n
+		}
+	}
+	return rules, first
+}
+
+func (r *lineRules) isFirstLine(message *router.Message) bool {
+	if !r.matchFirstLine {
 		return false
 	}
 
-	match := a.pattern.MatchString(message.Data)
-	if a.negateMatch {
+	match := r.pattern.MatchString(message.Data)
+	if r.negateMatch {
 		return !match
 	}
 
 	return match
 }
 
-func (a *Adapter) isLastLine(message *router.Message) bool {
-	if a.matchFirstLine {
+func (r *lineRules) isLastLine(message *router.Message) bool {
+	if r.matchFirstLine {
 		return false
 	}
 
-	match := a.pattern.MatchString(message.Data)
-	if a.negateMatch {
+	match := r.pattern.MatchString(message.Data)
+	if r.negateMatch {
 		return !match
 	}
 
 	return match
 }
 
+// multilineContainer returns whether multiline logging is on for container,
+// with the logspout.multiline label or the LOGSPOUT_MULTILINE environment
+// variable of the container, def otherwise
 func multilineContainer(container *docker.Container, def bool) bool {
+	switch strings.ToLower(container.Config.Labels[labelPrefix]) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
 	for _, kv := range container.Config.Env {
 		kvp := strings.SplitN(kv, "=", 2)
 		if len(kvp) == 2 && kvp[0] == "LOGSPOUT_MULTILINE" {
@@ -230,3 +230,89 @@ func TestContainerEnv(t *testing.T) {
 func replaceNewLines(str string) string {
 	return strings.Replace(str, "\n", "\\n", -1)
 }
+
+func TestContainerLabels(t *testing.T) {
+	a := &Adapter{
+		enableByDefault: false,
+		pattern:         regexp.MustCompile(`^\s`),
+		matchFirstLine:  true,
+		negateMatch:     true,
+		flushAfter:      time.Second,
+		checkInterval:   500 * time.Millisecond,
+	}
+	container := &docker.Container{ID: "a", Config: &docker.Config{Labels: map[string]string{
+		"logspout.multiline":             "true",
+		"logspout.multiline.pattern":     `^\d{4}-`,
+		"logspout.multiline.match":       "first",
+		"logspout.multiline.flush_after": "200ms",
+		"logspout.multiline.max_lines":   "3",
+	}}}
+	rules := a.containerRules(container)
+	if rules == nil {
+		t.Fatal("expected the label to turn multiline logging on")
+	}
+	if rules.pattern.String() != `^\d{4}-` || !rules.matchFirstLine || rules.negateMatch ||
+		rules.flushAfter != 200*time.Millisecond || rules.maxLines != 3 {
+		t.Errorf("expected the rules of the labels, got %+v", rules)
+	}
+	if a.checkInterval != 100*time.Millisecond {
+		t.Errorf("expected the check interval to follow the shortest flush, got %v", a.checkInterval)
+	}
+	if a.containerRules(&docker.Container{ID: "b", Config: &docker.Config{}}) != nil {
+		t.Error("expected multiline logging to be off without the label")
+	}
+
+	bad := &docker.Container{ID: "c", Config: &docker.Config{Labels: map[string]string{
+		"logspout.multiline":             "true",
+		"logspout.multiline.pattern":     `(`,
+		"logspout.multiline.flush_after": "0",
+		"logspout.multiline.max_lines":   "many",
+	}}}
+	rules = a.containerRules(bad)
+	if rules == nil || rules.pattern != a.pattern || rules.flushAfter != a.flushAfter || rules.maxLines != 0 {
+		t.Errorf("expected bad labels to keep the defaults, got %+v", rules)
+	}
+}
+
+func TestMaxLinesAndFlush(t *testing.T) {
+	in := make(chan *router.Message)
+	da := &dummyAdapter{make([]*router.Message, 0), &sync.WaitGroup{}}
+	da.Add(1)
+	ma := &Adapter{
+		out:             make(chan *router.Message),
+		subAdapter:      da,
+		enableByDefault: true,
+		pattern:         regexp.MustCompile(`^\s`),
+		matchFirstLine:  true,
+		negateMatch:     true,
+		flushAfter:      50 * time.Millisecond,
+		checkInterval:   10 * time.Millisecond,
+		buffers:         make(map[string]*router.Message),
+		nextCheck:       time.After(10 * time.Millisecond),
+		separator:       "\n",
+	}
+	go ma.Stream(in)
+	container := &docker.Container{ID: "test", Config: &docker.Config{Labels: map[string]string{
+		"logspout.multiline.max_lines": "3",
+	}}}
+	send := func(data string) {
+		in <- &router.Message{Container: container, Data: data, Source: "stdout", Time: time.Now()}
+	}
+	for _, line := range []string{"a", " 1", " 2", " 3", "b", " 1"} {
+		send(line)
+	}
+	time.Sleep(200 * time.Millisecond)
+	send("c")
+	close(in)
+	da.Wait()
+
+	expected := []string{"a\n 1\n 2", " 3", "b\n 1", "c"}
+	if len(da.messages) != len(expected) {
+		t.Fatalf("expected %d messages, got %d", len(expected), len(da.messages))
+	}
+	for i, m := range da.messages {
+		if m.Data != expected[i] {
+			t.Errorf("Expected: '%v', Got: '%v'", replaceNewLines(expected[i]), replaceNewLines(m.Data))
+		}
+	}
+}
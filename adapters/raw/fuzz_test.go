@@ -0,0 +1,38 @@
+//go:build go1.18
+// +build go1.18
+
+package raw
+
+import (
+	"encoding/json"
+	"testing"
+	"text/template"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func FuzzRenderJSON(f *testing.F) {
+	f.Add("hello", "team")
+	f.Add("\"quoted\"\n\x00\xff", "</script>")
+	tmpl := template.Must(template.New("raw").Funcs(funcs).Parse(`{{ toJSON . }}`))
+	data := template.Must(template.New("raw").Funcs(funcs).Parse("{{.Data}}\n"))
+	f.Fuzz(func(t *testing.T, text, field string) {
+		message := &router.Message{
+			Container: &docker.Container{ID: "abc", Name: "/app"},
+			Data:      text,
+			Fields:    map[string]string{field: text},
+		}
+		buf, err := render(tmpl, message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !json.Valid(buf) {
+			t.Errorf("invalid JSON %q", buf)
+		}
+		if buf, err = render(data, message); err != nil || string(buf) != text+"\n" {
+			t.Errorf("expected the data as is, got %q %v", buf, err)
+		}
+	})
+}
@@ -14,6 +14,12 @@ import (
 
 func init() {
 	router.AdapterFactories.Register(NewRawAdapter, "raw")
+	router.DescribeAdapter("raw", router.AdapterInfo{
+		DefaultTransport: "udp",
+		Options: []router.AdapterOption{
+			{Name: "tag", Description: "tag template of the Docker logging drivers for {{tag .}} in RAW_FORMAT (default {{.ID}})"},
+		},
+	})
 }
 
 var funcs = template.FuncMap{
@@ -41,39 +47,77 @@ func NewRawAdapter(route *router.Route) (router.LogAdapter, error) {
 	if os.Getenv("RAW_FORMAT") != "" {
 		tmplStr = os.Getenv("RAW_FORMAT")
 	}
-	tmpl, err := template.New("raw").Funcs(funcs).Parse(tmplStr)
+	tag, err := router.RouteTag(route)
+	if err == nil && tag == nil {
+		tag, err = router.NewTag(router.DefaultTag)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tmpl, err := template.New("raw").Funcs(funcs).Funcs(template.FuncMap{"tag": tag.Tag}).Parse(tmplStr)
 	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	schema, err := router.NewOutputSchema(route)
+	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 	return &Adapter{
-		route: route,
-		conn:  conn,
-		tmpl:  tmpl,
+		route:  route,
+		conn:   conn,
+		tmpl:   tmpl,
+		schema: schema,
 	}, nil
 }
 
 // Adapter is a simple adapter that streams log output to a connection without any templating
 type Adapter struct {
-	conn  net.Conn
-	route *router.Route
-	tmpl  *template.Template
+	conn   net.Conn
+	route  *router.Route
+	tmpl   *template.Template
+	schema *router.OutputSchema
 }
 
 // Stream sends log data to a connection
 func (a *Adapter) Stream(logstream chan *router.Message) {
 	for message := range logstream {
-		buf := new(bytes.Buffer)
-		err := a.tmpl.Execute(buf, message)
+		buf, err := render(a.tmpl, message)
 		if err != nil {
 			log.Println("raw:", err)
 			return
 		}
-		_, err = a.conn.Write(buf.Bytes())
+		if a.schema != nil && !a.schema.Valid(message, buf) {
+			continue
+		}
+		_, err = a.conn.Write(buf)
 		if err != nil {
 			log.Println("raw:", err)
-			if _, ok := a.conn.(*net.UDPConn); !ok {
+			a.route.SetConnState(router.ConnFailed, err)
+			if _, ok := router.NetConn(a.conn).(*net.UDPConn); !ok {
 				return
 			}
+			continue
 		}
+		a.route.SetConnState(router.ConnConnected, nil)
+	}
+}
+
+// render returns message as formatted by tmpl
+func render(tmpl *template.Template, message *router.Message) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, message); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Close closes the connection of the adapter
+func (a *Adapter) Close() error {
+	if a.schema != nil {
+		a.schema.Close()
 	}
+	return a.conn.Close()
 }
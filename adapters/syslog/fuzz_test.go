@@ -0,0 +1,56 @@
+//go:build go1.18
+// +build go1.18
+
+package syslog
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func FuzzRender(f *testing.F) {
+	f.Add("hello", "/app")
+	f.Add("line one\nline two", "")
+	f.Add("\x00\xff[\"]", "/a b\nc")
+	tmpl, err := getFieldTemplates(&router.Route{Options: map[string]string{}})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Fuzz(func(t *testing.T, data, name string) {
+		m := &Message{&router.Message{
+			Container: &docker.Container{Name: name, Config: &docker.Config{Hostname: name}},
+			Source:    "stderr",
+			Data:      data,
+			Time:      time.Unix(0, 0),
+		}}
+		for _, format := range []Format{Rfc5424Format, Rfc3164Format} {
+			buf, err := m.Render(format, tmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := string(buf)
+			if !strings.HasSuffix(out, "\n") {
+				t.Errorf("%s: expected a trailing newline, got %q", format, out)
+			}
+			if format != Rfc5424Format {
+				continue
+			}
+			// PRI VERSION, TIMESTAMP, HOSTNAME, APP-NAME, PROCID, MSGID,
+			// STRUCTURED-DATA and MSG
+			parts := strings.SplitN(out, " ", 8)
+			if len(parts) != 8 {
+				t.Fatalf("expected 8 fields, got %q", out)
+			}
+			for i, part := range parts[:7] {
+				if part == "" || strings.ContainsAny(part, "\n\x00") {
+					t.Errorf("bad header field %d %q in %q", i, part, out)
+				}
+			}
+		}
+	})
+}
@@ -5,7 +5,6 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"log/syslog"
 	"net"
@@ -49,6 +48,16 @@ type TCPFraming string
 func init() {
 	hostname, _ = os.Hostname()
 	router.AdapterFactories.Register(NewSyslogAdapter, "syslog")
+	router.DescribeAdapter("syslog", router.AdapterInfo{
+		DefaultTransport: "udp",
+		Options: []router.AdapterOption{
+			{Name: "tag", Description: "tag template of the Docker logging drivers, like {{.Name}}/{{.ID}}, instead of SYSLOG_TAG"},
+			{Name: "append_tag", Description: "text appended to the tag"},
+			{Name: "structured_data", Env: "SYSLOG_STRUCTURED_DATA", Description: "structured data of the messages"},
+			{Name: "syslog_msg_bytes", Env: "SYSLOG_MSG_BYTES", Description: "bytes the MSG part is cut to"},
+			{Name: "truncate_ellipsis", Env: "TRUNCATE_ELLIPSIS", Description: "text ending cut messages"},
+		},
+	})
 }
 
 func debug(v ...interface{}) {
@@ -68,14 +77,13 @@ func getFormat() (Format, error) {
 	}
 }
 
+// getHostname returns the template of the hostname field: the host logspout
+// runs on, or else SYSLOG_HOSTNAME
 func getHostname() string {
-	content, err := ioutil.ReadFile("/etc/host_hostname")
-	if err == nil && len(content) > 0 {
-		hostname = strings.TrimRight(string(content), "\r\n")
-	} else {
-		hostname = cfg.GetEnvDefault("SYSLOG_HOSTNAME", "{{.Container.Config.Hostname}}")
+	if host := router.Hostname(); host != "" {
+		return host
 	}
-	return hostname
+	return cfg.GetEnvDefault("SYSLOG_HOSTNAME", "{{.Container.Config.Hostname}}")
 }
 
 func getFieldTemplates(route *router.Route) (*FieldTemplates, error) {
@@ -102,7 +110,17 @@ func getFieldTemplates(route *router.Route) (*FieldTemplates, error) {
 	debug("setting hostname to:", s)
 
 	s = cfg.GetEnvDefault("SYSLOG_TAG", "{{.ContainerName}}"+route.Options["append_tag"])
-	if tmpl.tag, err = template.New("tag").Parse(s); err != nil {
+	tag, err := router.RouteTag(route)
+	if err != nil {
+		return nil, err
+	}
+	if tag != nil {
+		// the tag option is rendered like the tag of the Docker syslog driver
+		s = "{{dockerTag .Message}}" + route.Options["append_tag"]
+	}
+	if tmpl.tag, err = template.New("tag").Funcs(template.FuncMap{"dockerTag": func(m *router.Message) string {
+		return tag.Tag(m)
+	}}).Parse(s); err != nil {
 		return nil, err
 	}
 	debug("setting tag to:", s)
@@ -133,6 +151,10 @@ func getFieldTemplates(route *router.Route) (*FieldTemplates, error) {
 	}
 	debug("setting data to:", s)
 
+	if tmpl.msg, err = router.NewTruncation(route, "syslog_msg_bytes", "SYSLOG_MSG_BYTES"); err != nil {
+		return nil, err
+	}
+
 	return &tmpl, nil
 }
 
@@ -157,7 +179,7 @@ func getRetryCount() uint {
 }
 
 func isTCPConnection(conn net.Conn) bool {
-	switch conn.(type) {
+	switch router.NetConn(conn).(type) {
 	case *net.TCPConn:
 		return true
 	case *tls.Conn:
@@ -224,6 +246,8 @@ type FieldTemplates struct {
 	pid            *template.Template
 	structuredData *template.Template
 	data           *template.Template
+	// msg cuts the rendered data, the MSG part of the message
+	msg router.Truncation
 }
 
 // Adapter streams log output to a connection in the Syslog format
@@ -254,16 +278,26 @@ func (a *Adapter) Stream(logstream chan *router.Message) {
 
 		if _, err = a.conn.Write(buf); err != nil {
 			log.Println("syslog:", err)
-			if a.connIsTCP {
-				if err = a.retry(buf, err); err != nil {
-					log.Panicf("syslog retry err: %+v", err)
-					return
-				}
+			if !a.connIsTCP {
+				a.route.SetConnState(router.ConnFailed, err)
+				continue
+			}
+			a.route.SetConnState(router.ConnBackoff, err)
+			if err = a.retry(buf, err); err != nil {
+				a.route.SetConnState(router.ConnFailed, err)
+				log.Panicf("syslog retry err: %+v", err)
+				return
 			}
 		}
+		a.route.SetConnState(router.ConnConnected, nil)
 	}
 }
 
+// Close closes the connection of the adapter
+func (a *Adapter) Close() error {
+	return a.conn.Close()
+}
+
 func (a *Adapter) retry(buf []byte, err error) error {
 	if opError, ok := err.(*net.OpError); ok {
 		if (opError.Temporary() && !errors.Is(opError, syscall.ECONNRESET)) || opError.Timeout() {
@@ -379,6 +413,7 @@ func (m *Message) Render(format Format, tmpl *FieldTemplates) ([]byte, error) {
 	if err := tmpl.data.Execute(data, m); err != nil {
 		return nil, err
 	}
+	msg := tmpl.msg.Truncate(data.String())
 
 	buf := new(bytes.Buffer)
 	switch format {
@@ -389,20 +424,36 @@ func (m *Message) Render(format Format, tmpl *FieldTemplates) ([]byte, error) {
 		// - the TAG field must not exceed 48 characters
 		// - the PROCID field must not exceed 128 characters
 		fmt.Fprintf(buf, "<%s>1 %s %.255s %.48s %.128s - %s %s\n",
-			priority, timestamp, hostname, tag, pid, structuredData, data,
+			priority, timestamp, headerField(hostname.String()), headerField(tag.String()),
+			headerField(pid.String()), structuredData, msg,
 		)
 	case Rfc3164Format:
 		// notes from RFC:
 		// - the entire message must be <= 1024 bytes
 		// - the TAG field must not exceed 32 characters
 		fmt.Fprintf(buf, "<%s>%s %s %.32s[%s]: %s\n",
-			priority, timestamp, hostname, tag, pid, data,
+			priority, timestamp, headerField(hostname.String()), headerField(tag.String()), pid, msg,
 		)
 	}
 
 	return buf.Bytes(), nil
 }
 
+// headerField makes s safe to use as a header field: the header fields are
+// separated by spaces and may only hold printable ASCII, and an empty field is
+// written as the nil value "-"
+func headerField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.Map(func(r rune) rune {
+		if r < '!' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
 // Priority returns a syslog.Priority based on the message source
 func (m *Message) Priority() syslog.Priority {
 	switch m.Message.Source {
@@ -427,7 +478,11 @@ func (m *Message) Timestamp() string {
 
 // ContainerName returns the message's container name
 func (m *Message) ContainerName() string {
-	return m.Message.Container.Name[1:]
+	name := m.Message.Container.Name
+	if name == "" {
+		return ""
+	}
+	return name[1:]
 }
 
 // ContainerNameSplitN returns the message's container name sliced at most "n" times using "sep"
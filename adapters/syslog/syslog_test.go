@@ -288,3 +288,36 @@ func check(t *testing.T, in string, out string) {
 		t.Errorf("expected: %s\ngot: %s\n", in, out)
 	}
 }
+
+func TestSyslogMsgTruncation(t *testing.T) {
+	route := &router.Route{Options: map[string]string{"syslog_msg_bytes": "10"}}
+	tmpl, err := getFieldTemplates(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &Message{&router.Message{Container: container, Data: "ünïcödé message", Time: time.Now(), Source: "stdout"}}
+	b, err := msg.Render(Rfc5424Format, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(b), " - ünïc...\n") {
+		t.Errorf("expected the MSG part cut to 10 bytes, got %q", b)
+	}
+}
+
+func TestSyslogDockerTag(t *testing.T) {
+	route := &router.Route{Options: map[string]string{"tag": "{{.Name}}/{{.ID}}"}}
+	tmpl, err := getFieldTemplates(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := &docker.Container{ID: "8dfafdbc3a40c1a2b3", Name: "/web", Config: &docker.Config{}}
+	msg := &Message{&router.Message{Container: web, Data: "hello", Time: time.Now(), Source: "stdout"}}
+	b, err := msg.Render(Rfc5424Format, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := " web/8dfafdbc3a40 "; !strings.Contains(string(b), expected) {
+		t.Errorf("expected the tag %q in %q", expected, b)
+	}
+}
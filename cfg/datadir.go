@@ -0,0 +1,38 @@
+package cfg
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const defaultRoutesPath = "/mnt/routes"
+
+// DataDir returns LOGSPOUT_DATA_DIR, the directory logspout keeps its state
+// in, or "" when it isn't set. Everything logspout writes can be kept there,
+// so it runs with a read-only root filesystem and a single writable volume.
+func DataDir() string {
+	return os.Getenv("LOGSPOUT_DATA_DIR")
+}
+
+// DataPath returns the path of a state file or directory: name itself when
+// it is absolute or there is no data directory, otherwise name in the data
+// directory
+func DataPath(name string) string {
+	dir := DataDir()
+	if name == "" || dir == "" || filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// RoutesPath returns the directory the routes are persisted in: ROUTESPATH,
+// the routes directory of the data directory, or /mnt/routes
+func RoutesPath() string {
+	if path := os.Getenv("ROUTESPATH"); path != "" {
+		return DataPath(path)
+	}
+	if DataDir() != "" {
+		return DataPath("routes")
+	}
+	return defaultRoutesPath
+}
@@ -0,0 +1,30 @@
+package cfg
+
+import (
+	"os"
+	"testing"
+)
+
+func TestDataPath(t *testing.T) {
+	defer os.Setenv("LOGSPOUT_DATA_DIR", os.Getenv("LOGSPOUT_DATA_DIR"))
+	defer os.Setenv("ROUTESPATH", os.Getenv("ROUTESPATH"))
+	for _, test := range []struct {
+		dataDir, routesPath string
+		name, path, routes  string
+	}{
+		{"", "", "ledger.json", "ledger.json", "/mnt/routes"},
+		{"", "/etc/routes", "/var/ledger.json", "/var/ledger.json", "/etc/routes"},
+		{"/data", "", "ledger.json", "/data/ledger.json", "/data/routes"},
+		{"/data", "custom", "/var/ledger.json", "/var/ledger.json", "/data/custom"},
+		{"/data", "/etc/routes", "", "", "/etc/routes"},
+	} {
+		os.Setenv("LOGSPOUT_DATA_DIR", test.dataDir)
+		os.Setenv("ROUTESPATH", test.routesPath)
+		if path := DataPath(test.name); path != test.path {
+			t.Errorf("%q: expected %q for %q, got %q", test.dataDir, test.path, test.name, path)
+		}
+		if routes := RoutesPath(); routes != test.routes {
+			t.Errorf("%q, %q: expected routes in %q, got %q", test.dataDir, test.routesPath, test.routes, routes)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package cfg
+
+import "crypto/tls"
+
+var (
+	// fipsCipherSuites are the TLS 1.2 cipher suites approved by
+	// NIST SP 800-52r2 that Go implements
+	fipsCipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	}
+	fipsCurvePreferences = []tls.CurveID{tls.CurveP384, tls.CurveP256}
+)
+
+// FIPS returns whether FIPS mode is enabled with FIPS=true
+func FIPS() bool {
+	return GetEnvDefault("FIPS", "") == "true"
+}
+
+// RestrictTLS restricts config to FIPS approved TLS versions, cipher suites
+// and curves in FIPS mode. TLS 1.3 is left out because Go doesn't allow
+// restricting its cipher suites. It returns config.
+func RestrictTLS(config *tls.Config) *tls.Config {
+	if !FIPS() {
+		return config
+	}
+	config.MinVersion = tls.VersionTLS12
+	config.MaxVersion = tls.VersionTLS12
+	config.CipherSuites = fipsCipherSuites
+	config.CurvePreferences = fipsCurvePreferences
+	config.InsecureSkipVerify = false
+	return config
+}
@@ -0,0 +1,26 @@
+package cfg
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+)
+
+func TestRestrictTLS(t *testing.T) {
+	config := RestrictTLS(&tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+	if config.CipherSuites != nil || !config.InsecureSkipVerify {
+		t.Error("expected the config to be left alone without FIPS mode")
+	}
+
+	os.Setenv("FIPS", "true")
+	defer os.Unsetenv("FIPS")
+	config = RestrictTLS(&tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+	if config.MinVersion != tls.VersionTLS12 || config.MaxVersion != tls.VersionTLS12 || config.InsecureSkipVerify {
+		t.Errorf("expected TLS 1.2 with verification, got %+v", config)
+	}
+	for _, suite := range config.CipherSuites {
+		if suite == tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305 {
+			t.Error("expected ChaCha20 not to be allowed")
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package cfg
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	defaultHTTPPort = "80"
+	unixPrefix      = "unix:"
+)
+
+// HTTPPort returns the port of the HTTP server: PORT, HTTP_PORT, or 80
+func HTTPPort() string {
+	if port := GetEnvDefault("PORT", os.Getenv("HTTP_PORT")); port != "" {
+		return port
+	}
+	return defaultHTTPPort
+}
+
+// APIAddress returns HTTP_API_ADDRESS, the address of a separate listener
+// for the HTTP API, and the path of its unix socket when it is given as
+// unix:PATH. A relative path is taken in the data directory.
+func APIAddress() (address, socket string) {
+	address = os.Getenv("HTTP_API_ADDRESS")
+	if strings.HasPrefix(address, unixPrefix) {
+		socket = DataPath(strings.TrimPrefix(strings.TrimPrefix(address, unixPrefix), "//"))
+	}
+	return address, socket
+}
@@ -0,0 +1,44 @@
+package cfg
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHTTPPort(t *testing.T) {
+	defer os.Setenv("PORT", os.Getenv("PORT"))
+	defer os.Setenv("HTTP_PORT", os.Getenv("HTTP_PORT"))
+	os.Setenv("PORT", "")
+	os.Setenv("HTTP_PORT", "")
+	if port := HTTPPort(); port != defaultHTTPPort {
+		t.Errorf("expected port %s, got %s", defaultHTTPPort, port)
+	}
+	os.Setenv("HTTP_PORT", "8080")
+	if port := HTTPPort(); port != "8080" {
+		t.Errorf("expected HTTP_PORT, got %s", port)
+	}
+	os.Setenv("PORT", "9000")
+	if port := HTTPPort(); port != "9000" {
+		t.Errorf("expected PORT to take precedence, got %s", port)
+	}
+}
+
+func TestAPIAddress(t *testing.T) {
+	defer os.Setenv("HTTP_API_ADDRESS", os.Getenv("HTTP_API_ADDRESS"))
+	defer os.Setenv("LOGSPOUT_DATA_DIR", os.Getenv("LOGSPOUT_DATA_DIR"))
+	os.Setenv("LOGSPOUT_DATA_DIR", "/data")
+	for _, test := range []struct {
+		address, socket string
+	}{
+		{"", ""},
+		{"127.0.0.1:8001", ""},
+		{"unix:/run/logspout/api.sock", "/run/logspout/api.sock"},
+		{"unix:///run/logspout/api.sock", "/run/logspout/api.sock"},
+		{"unix:api.sock", "/data/api.sock"},
+	} {
+		os.Setenv("HTTP_API_ADDRESS", test.address)
+		if address, socket := APIAddress(); address != test.address || socket != test.socket {
+			t.Errorf("%q: expected socket %q, got %q, %q", test.address, test.socket, address, socket)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// SetMaxProcs sets GOMAXPROCS to the CPU quota of the container, rounded down
+// to at least one, so the Go scheduler doesn't run more threads than the
+// container may use and get throttled. GOMAXPROCS in the environment takes
+// precedence, as does a CPU affinity with fewer CPUs. It returns the
+// resulting GOMAXPROCS.
+func SetMaxProcs() int {
+	if os.Getenv("GOMAXPROCS") != "" {
+		return runtime.GOMAXPROCS(0)
+	}
+	if procs := maxProcs(cgroupRoot, runtime.NumCPU()); procs > 0 {
+		runtime.GOMAXPROCS(procs)
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// maxProcs returns the GOMAXPROCS for the CPU quota of the cgroup mounted at
+// root and cpus, or 0 without a quota
+func maxProcs(root string, cpus int) int {
+	quota := cpuQuota(root)
+	if quota <= 0 {
+		return 0
+	}
+	procs := int(quota)
+	if procs < 1 {
+		procs = 1
+	}
+	if procs > cpus {
+		procs = cpus
+	}
+	return procs
+}
+
+// cpuQuota returns the CPU quota in CPUs of the cgroup mounted at root, from
+// cpu.max for cgroup v2 or the CFS quota and period for cgroup v1, or 0
+// without a quota
+func cpuQuota(root string) float64 {
+	if max, err := ioutil.ReadFile(filepath.Join(root, "cpu.max")); err == nil {
+		fields := strings.Fields(string(max))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0
+		}
+		return ratio(fields[0], fields[1])
+	}
+	for _, dir := range []string{"cpu", "cpu,cpuacct"} {
+		quota, err := ioutil.ReadFile(filepath.Join(root, dir, "cpu.cfs_quota_us"))
+		if err != nil {
+			continue
+		}
+		period, err := ioutil.ReadFile(filepath.Join(root, dir, "cpu.cfs_period_us"))
+		if err != nil {
+			continue
+		}
+		return ratio(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+	}
+	return 0
+}
+
+func ratio(quota, period string) float64 {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return q / p
+}
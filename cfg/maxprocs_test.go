@@ -0,0 +1,47 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeCgroup(t *testing.T, files map[string]string) string {
+	root, err := ioutil.TempDir("", "cgroup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		filename := filepath.Join(root, name)
+		if err = os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestMaxProcs(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		files    map[string]string
+		expected int
+	}{
+		{"v2 quota", map[string]string{"cpu.max": "250000 100000\n"}, 2},
+		{"v2 fraction", map[string]string{"cpu.max": "50000 100000\n"}, 1},
+		{"v2 no quota", map[string]string{"cpu.max": "max 100000\n"}, 0},
+		{"v2 above cpus", map[string]string{"cpu.max": "1600000 100000\n"}, 8},
+		{"v1 quota", map[string]string{"cpu/cpu.cfs_quota_us": "300000\n", "cpu/cpu.cfs_period_us": "100000\n"}, 3},
+		{"v1 no quota", map[string]string{"cpu,cpuacct/cpu.cfs_quota_us": "-1\n", "cpu,cpuacct/cpu.cfs_period_us": "100000\n"}, 0},
+		{"no cgroup", nil, 0},
+	} {
+		root := writeCgroup(t, test.files)
+		if got := maxProcs(root, 8); got != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, got)
+		}
+		os.RemoveAll(root)
+	}
+}
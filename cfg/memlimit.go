@@ -0,0 +1,67 @@
+package cfg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// unlimitedMemory is the smallest memory limit cgroup v1 reports for no
+// limit, a page-rounded maximum int64
+const unlimitedMemory = 1 << 62
+
+// MemoryLimit returns the memory logspout may use in bytes: GOMEMLIMIT, in
+// the format of Go 1.19 and later, or else the memory limit of the container,
+// or 0 without either. The Go 1.13 runtime of the image ignores GOMEMLIMIT,
+// so only the buffers of logspout act on it.
+func MemoryLimit() int64 {
+	return memoryLimit(os.Getenv("GOMEMLIMIT"), cgroupRoot)
+}
+
+func memoryLimit(gomemlimit, root string) int64 {
+	if limit := parseMemLimit(gomemlimit); limit > 0 {
+		return limit
+	}
+	return cgroupMemory(root)
+}
+
+// parseMemLimit parses a GOMEMLIMIT, a number of bytes with an optional B,
+// KiB, MiB, GiB or TiB suffix, returning 0 for off or a bad value
+func parseMemLimit(s string) int64 {
+	s = strings.TrimSpace(s)
+	unit := int64(1)
+	for i, suffix := range []string{"TiB", "GiB", "MiB", "KiB", "B"} {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSuffix(s, suffix)
+			if suffix != "B" {
+				unit = 1 << (10 * uint(4-i))
+			}
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n * unit
+}
+
+// cgroupMemory returns the memory limit of the cgroup mounted at root, from
+// memory.max for cgroup v2 or memory.limit_in_bytes for cgroup v1, or 0
+// without a limit
+func cgroupMemory(root string) int64 {
+	for _, name := range []string{"memory.max", filepath.Join("memory", "memory.limit_in_bytes")} {
+		content, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 64)
+		if err != nil || limit <= 0 || limit >= unlimitedMemory {
+			return 0
+		}
+		return limit
+	}
+	return 0
+}
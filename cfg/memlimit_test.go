@@ -0,0 +1,31 @@
+package cfg
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		gomemlimit string
+		files      map[string]string
+		expected   int64
+	}{
+		{"GOMEMLIMIT bytes", "104857600", nil, 100 << 20},
+		{"GOMEMLIMIT MiB", "200MiB", map[string]string{"memory.max": "536870912\n"}, 200 << 20},
+		{"GOMEMLIMIT GiB", "1GiB", nil, 1 << 30},
+		{"GOMEMLIMIT off", "off", map[string]string{"memory.max": "536870912\n"}, 512 << 20},
+		{"v2 limit", "", map[string]string{"memory.max": "268435456\n"}, 256 << 20},
+		{"v2 no limit", "", map[string]string{"memory.max": "max\n"}, 0},
+		{"v1 limit", "", map[string]string{"memory/memory.limit_in_bytes": "134217728\n"}, 128 << 20},
+		{"v1 no limit", "", map[string]string{"memory/memory.limit_in_bytes": "9223372036854771712\n"}, 0},
+		{"no cgroup", "", nil, 0},
+	} {
+		root := writeCgroup(t, test.files)
+		if got := memoryLimit(test.gomemlimit, root); got != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, got)
+		}
+		os.RemoveAll(root)
+	}
+}
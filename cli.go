@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const cliTimeout = 10 * time.Second
+
+const cliUsage = `usage: logspout COMMAND [ARGS]
+
+Commands talking to the HTTP API of a running logspout:
+  routes list         list the routes
+  routes add URI      add a route, given like in ROUTE_URIS
+  routes rm ID        remove a route
+  tail [-sources S] CONTAINER
+                      stream the logs of a container, by name or as id:ID
+  stats               show the connection state, and the ledger if enabled
+  help                show this help
+
+The API is at LOGSPOUT_URL, by default HTTP_API_ADDRESS when it is set, or
+http://localhost on PORT.
+`
+
+// cliCommands are the subcommands of logspout, run instead of the daemon
+var cliCommands = map[string]func(api *apiClient, args []string, out io.Writer) error{
+	"routes": routesCommand,
+	"tail":   tailCommand,
+	"stats":  statsCommand,
+	"help":   helpCommand,
+}
+
+// apiClient calls the HTTP API of a running logspout
+type apiClient struct {
+	url    string
+	client *http.Client
+}
+
+// runCommand runs a subcommand with its arguments and returns the exit
+// status, or false when args name no subcommand
+func runCommand(args []string, out io.Writer) (int, bool) {
+	command, ok := cliCommands[args[0]]
+	if !ok {
+		return 0, false
+	}
+	api := defaultAPIClient()
+	if err := command(api, args[1:], out); err != nil {
+		fmt.Fprintln(os.Stderr, "logspout:", err)
+		return 1, true
+	}
+	return 0, true
+}
+
+// defaultAPIClient returns the client for LOGSPOUT_URL, or else for the API
+// listener of HTTP_API_ADDRESS or the port of the HTTP server on localhost
+func defaultAPIClient() *apiClient {
+	if u := os.Getenv("LOGSPOUT_URL"); u != "" {
+		return &apiClient{url: strings.TrimSuffix(u, "/"), client: http.DefaultClient}
+	}
+	address, socket := cfg.APIAddress()
+	switch {
+	case socket != "":
+		// the host of the URL is only sent as the Host header
+		return &apiClient{url: "http://logspout", client: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", socket)
+			},
+		}}}
+	case address != "":
+		if strings.HasPrefix(address, ":") {
+			address = "localhost" + address
+		}
+		return &apiClient{url: "http://" + address, client: http.DefaultClient}
+	default:
+		return &apiClient{url: "http://localhost:" + cfg.HTTPPort(), client: http.DefaultClient}
+	}
+}
+
+// do sends a request to the API and returns the response, or an error for a
+// response status other than 2xx
+func (api *apiClient) do(method, path string, body io.Reader, timeout time.Duration) (*http.Response, error) {
+	req, err := http.NewRequest(method, api.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := *api.client
+	client.Timeout = timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		err = fmt.Errorf("%s %s: %s", method, path, resp.Status)
+		if s := strings.TrimSpace(string(msg)); s != "" {
+			err = fmt.Errorf("%v: %s", err, s)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// get decodes the JSON response to a GET request into obj
+func (api *apiClient) get(path string, obj interface{}) error {
+	resp, err := api.do("GET", path, nil, cliTimeout)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(obj)
+}
+
+func helpCommand(api *apiClient, args []string, out io.Writer) error {
+	_, err := fmt.Fprint(out, cliUsage)
+	return err
+}
+
+func routesCommand(api *apiClient, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("routes: expected list, add or rm")
+	}
+	switch command := args[0]; {
+	case command == "list" && len(args) == 1:
+		var routes []*router.Route
+		if err := api.get("/routes", &routes); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 8, 1, ' ', 0)
+		fmt.Fprintln(w, "ID\tADAPTER\tADDRESS\tCONTAINERS\tSOURCES\tOPTIONS\tPAUSED")
+		for _, route := range routes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%v\t%v\n",
+				route,
+				route.Adapter,
+				route.Address,
+				route.FilterID+route.FilterName+strings.Join(route.FilterLabels, ","),
+				strings.Join(route.FilterSources, ","),
+				route.Options,
+				route.Paused)
+		}
+		return w.Flush()
+	case command == "add" && len(args) == 2:
+		route, err := router.ParseRouteURI(args[1])
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(route)
+		if err != nil {
+			return err
+		}
+		resp, err := api.do("POST", "/routes", bytes.NewReader(body), cliTimeout)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err = json.NewDecoder(resp.Body).Decode(route); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, route.ID)
+		return nil
+	case command == "rm" && len(args) == 2:
+		resp, err := api.do("DELETE", "/routes/"+url.PathEscape(args[1]), nil, cliTimeout)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	default:
+		return errors.New("routes: expected list, add URI or rm ID")
+	}
+}
+
+func tailCommand(api *apiClient, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("logspout tail", flag.ContinueOnError)
+	sources := flags.String("sources", "", "only stream the stdout or stderr lines")
+	flags.SetOutput(ioutil.Discard)
+	if err := flags.Parse(args); err != nil {
+		return errors.New("tail: " + err.Error())
+	}
+	if flags.NArg() != 1 {
+		return errors.New("tail: expected a container")
+	}
+	predicate := flags.Arg(0)
+	if !strings.Contains(predicate, ":") {
+		predicate = "name:" + predicate
+	}
+	query := url.Values{"colors": {"off"}}
+	if *sources != "" {
+		query.Set("sources", *sources)
+	}
+	resp, err := api.do("GET", "/logs/"+url.PathEscape(predicate)+"?"+query.Encode(), nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+func statsCommand(api *apiClient, args []string, out io.Writer) error {
+	if len(args) != 0 {
+		return errors.New("stats: expected no arguments")
+	}
+	var health struct {
+		Connections []router.ConnState `json:"connections"`
+	}
+	if err := api.get("/health?format=json", &health); err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(out, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "ROUTE\tADAPTER\tADDRESS\tSTATE\tSINCE\tLAST ERROR")
+	for _, s := range health.Connections {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			s.Route, s.Adapter, s.Address, s.State, s.Since.Format(time.RFC3339), s.LastError)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	// the ledger is only served when LEDGER_PATH is set
+	var ledger []router.LedgerEntry
+	if err := api.get("/ledger", &ledger); err != nil || len(ledger) == 0 {
+		return nil
+	}
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 8, 1, ' ', 0)
+	fmt.Fprintln(w, "MONTH\tROUTE\tLABEL\tBYTES\tMESSAGES")
+	for _, e := range ledger {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", e.Month, e.Route, e.Label, e.Bytes, e.Messages)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunCommand(t *testing.T) {
+	var requests []string
+	var added map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.Method+" "+req.URL.RequestURI())
+		switch {
+		case req.Method == "GET" && req.URL.Path == "/routes":
+			w.Write([]byte(`[{"id":"abc","adapter":"syslog","address":"logs:514","filter_name":"web"}]`))
+		case req.Method == "POST" && req.URL.Path == "/routes":
+			body, _ := ioutil.ReadAll(req.Body)
+			json.Unmarshal(body, &added)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":"def","adapter":"syslog","address":"logs:514"}`))
+		case req.Method == "DELETE" && req.URL.Path == "/routes/abc":
+		case req.URL.Path == "/logs/name:web":
+			w.Write([]byte("hello\n"))
+		case req.URL.Path == "/health":
+			w.Write([]byte(`{"connections":[{"route":"abc","adapter":"syslog","address":"logs:514",` +
+				`"state":"reconnecting","since":"2020-01-01T00:00:00Z","last_error":"refused"}]}`))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+	os.Setenv("LOGSPOUT_URL", server.URL)
+	defer os.Unsetenv("LOGSPOUT_URL")
+
+	run := func(args ...string) (int, string) {
+		var out bytes.Buffer
+		status, ok := runCommand(args, &out)
+		if !ok {
+			t.Fatalf("expected %v to be a command", args)
+		}
+		return status, out.String()
+	}
+
+	if status, out := run("routes", "list"); status != 0 || !strings.Contains(out, "abc") || !strings.Contains(out, "web") {
+		t.Errorf("routes list: status %d, output %q", status, out)
+	}
+	if status, out := run("routes", "add", "syslog://logs:514?filter.name=web&structured_data=x"); status != 0 || out != "def\n" {
+		t.Errorf("routes add: status %d, output %q", status, out)
+	}
+	if added["adapter"] != "syslog" || added["address"] != "logs:514" || added["filter_name"] != "web" {
+		t.Errorf("routes add: unexpected route %v", added)
+	}
+	if status, _ := run("routes", "rm", "abc"); status != 0 {
+		t.Errorf("routes rm: status %d", status)
+	}
+	if status, _ := run("routes", "rm", "missing"); status != 1 {
+		t.Errorf("routes rm of a missing route: status %d", status)
+	}
+	if status, out := run("tail", "-sources", "stderr", "web"); status != 0 || out != "hello\n" {
+		t.Errorf("tail: status %d, output %q", status, out)
+	}
+	if status, out := run("stats"); status != 0 || !strings.Contains(out, "reconnecting") || !strings.Contains(out, "refused") {
+		t.Errorf("stats: status %d, output %q", status, out)
+	}
+	if len(requests) != 7 {
+		t.Errorf("unexpected requests %v", requests)
+	}
+	if _, ok := runCommand([]string{"syslog://logs:514"}, ioutil.Discard); ok {
+		t.Error("expected a route URI not to be a command")
+	}
+}
@@ -0,0 +1,212 @@
+// Package controller lets logspout register with a central controller, which
+// hands out the routes of each instance and collects their state, so a fleet
+// of logspouts is configured in one place.
+package controller
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultInterval = 30 * time.Second
+	requestTimeout  = 10 * time.Second
+	// maxRoutesBody bounds the routes document of the controller
+	maxRoutesBody = 4 << 20
+)
+
+func init() {
+	if u := cfg.GetEnvDefault("CONTROLLER_URL", ""); u != "" {
+		router.Jobs.Register(&Controller{url: strings.TrimSuffix(u, "/"), routes: router.Routes}, "controller")
+	}
+}
+
+// routeStore is the part of the RouteManager the controller manages
+type routeStore interface {
+	GetAll() ([]*router.Route, error)
+	Replace(routes []*router.Route) error
+}
+
+// Status is what an instance reports to the controller
+type Status struct {
+	Instance    string             `json:"instance"`
+	Time        time.Time          `json:"time"`
+	Routes      []string           `json:"routes"`
+	Connections []router.ConnState `json:"connections"`
+	// ConfigError is why the last routes of the controller weren't applied
+	ConfigError string `json:"config_error,omitempty"`
+}
+
+// Controller is the job that reports the status of the instance to the
+// controller at CONTROLLER_URL and applies the routes it hands out, every
+// CONTROLLER_INTERVAL. It is enabled with CONTROLLER_URL.
+type Controller struct {
+	url      string
+	token    string
+	instance string
+	interval time.Duration
+	client   *http.Client
+	routes   routeStore
+
+	// etag and applied are of the last routes document applied
+	etag        string
+	applied     []byte
+	configError string
+}
+
+// Name returns the name of the controller job
+func (c *Controller) Name() string {
+	return "controller"
+}
+
+// Setup reads the settings of the controller
+func (c *Controller) Setup() error {
+	if _, err := url.Parse(c.url); err != nil {
+		return errors.New("bad CONTROLLER_URL: " + c.url)
+	}
+	c.token = cfg.GetEnvDefault("CONTROLLER_TOKEN", "")
+	c.instance = cfg.GetEnvDefault("CONTROLLER_INSTANCE", "")
+	if c.instance == "" {
+		c.instance, _ = os.Hostname()
+	}
+	c.interval = defaultInterval
+	if s := cfg.GetEnvDefault("CONTROLLER_INTERVAL", ""); s != "" {
+		interval, err := time.ParseDuration(s)
+		if err != nil || interval < time.Second {
+			return errors.New("bad CONTROLLER_INTERVAL: " + s)
+		}
+		c.interval = interval
+	}
+	if c.client == nil {
+		c.client = &http.Client{Timeout: requestTimeout}
+		if cfg.FIPS() {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = cfg.RestrictTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+			c.client.Transport = transport
+		}
+	}
+	return nil
+}
+
+// Run reports to the controller and pulls the routes every interval. A
+// controller that can't be reached leaves the routes as they are.
+func (c *Controller) Run() error {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.sync()
+		<-ticker.C
+	}
+}
+
+func (c *Controller) sync() {
+	if err := c.report(); err != nil {
+		log.Println("controller: report:", err)
+	}
+	if err := c.pull(); err != nil {
+		log.Println("controller: routes:", err)
+	}
+}
+
+func (c *Controller) path(suffix string) string {
+	return c.url + "/instances/" + url.PathEscape(c.instance) + suffix
+}
+
+func (c *Controller) do(req *http.Request) (*http.Response, error) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return c.client.Do(req)
+}
+
+// report registers the instance with the controller, with its state
+func (c *Controller) report() error {
+	status := Status{
+		Instance:    c.instance,
+		Time:        time.Now().UTC(),
+		Routes:      []string{},
+		Connections: router.ConnStates(),
+		ConfigError: c.configError,
+	}
+	routes, _ := c.routes.GetAll()
+	for _, route := range routes {
+		status.Routes = append(status.Routes, route.ID)
+	}
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", c.path(""), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) //nolint:errcheck
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s", req.URL, resp.Status)
+	}
+	return nil
+}
+
+// pull replaces the routes by those of the controller when they changed.
+// 204 and 404 mean the controller has no routes for the instance, which
+// keeps the routes it has.
+func (c *Controller) pull() error {
+	req, err := http.NewRequest("GET", c.path("/routes"), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRoutesBody))
+	if err != nil {
+		return err
+	}
+	if c.applied != nil && bytes.Equal(body, c.applied) {
+		return nil
+	}
+	var routes []*router.Route
+	if err = json.Unmarshal(body, &routes); err == nil {
+		err = c.routes.Replace(routes)
+	}
+	if err != nil {
+		c.configError = err.Error()
+		return err
+	}
+	log.Println("controller: applied", len(routes), "routes")
+	c.etag, c.applied, c.configError = resp.Header.Get("ETag"), body, ""
+	return nil
+}
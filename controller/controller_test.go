@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+type fakeRoutes struct {
+	routes   []*router.Route
+	replaced int
+	err      error
+}
+
+func (f *fakeRoutes) GetAll() ([]*router.Route, error) {
+	return f.routes, nil
+}
+
+func (f *fakeRoutes) Replace(routes []*router.Route) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.routes = routes
+	f.replaced++
+	return nil
+}
+
+func TestControllerSync(t *testing.T) {
+	var status Status
+	document := `[{"id":"syslog","adapter":"syslog","address":"logs:514"}]`
+	etag := `"1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case req.Method == "PUT" && req.URL.Path == "/instances/host-1":
+			json.NewDecoder(req.Body).Decode(&status)
+		case req.Method == "GET" && req.URL.Path == "/instances/host-1/routes":
+			if req.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Write([]byte(document))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	routes := &fakeRoutes{routes: []*router.Route{{ID: "local"}}}
+	c := &Controller{url: server.URL, routes: routes}
+	for k, v := range map[string]string{"CONTROLLER_TOKEN": "secret", "CONTROLLER_INSTANCE": "host-1"} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+	if err := c.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	c.sync()
+	if status.Instance != "host-1" || len(status.Routes) != 1 || status.Routes[0] != "local" {
+		t.Errorf("expected the status of the instance, got %+v", status)
+	}
+	if routes.replaced != 1 || routes.routes[0].ID != "syslog" || routes.routes[0].Address != "logs:514" {
+		t.Fatalf("expected the routes of the controller, got %d replacements of %+v", routes.replaced, routes.routes)
+	}
+	c.sync()
+	if routes.replaced != 1 {
+		t.Error("expected unchanged routes to be left as they are")
+	}
+
+	document, etag = `[{"adapter":"unknown"}]`, `"2"`
+	routes.err = errors.New("unknown adapter")
+	c.sync()
+	c.sync()
+	if status.ConfigError != "unknown adapter" || routes.routes[0].ID != "syslog" {
+		t.Errorf("expected a bad document to be reported and not applied, got %+v", status)
+	}
+	routes.err = nil
+	c.sync()
+	if routes.replaced != 2 || c.configError != "" {
+		t.Errorf("expected the routes to be applied once valid, got %d replacements", routes.replaced)
+	}
+}
+
+func TestControllerSetup(t *testing.T) {
+	os.Setenv("CONTROLLER_INTERVAL", "10ms")
+	defer os.Unsetenv("CONTROLLER_INTERVAL")
+	c := &Controller{url: "http://controller", routes: &fakeRoutes{}}
+	if err := c.Setup(); err == nil {
+		t.Error("expected an interval below 1s to be rejected")
+	}
+}
+
+func TestControllerSetupFIPS(t *testing.T) {
+	os.Setenv("FIPS", "true")
+	defer os.Unsetenv("FIPS")
+	c := &Controller{url: "https://controller", routes: &fakeRoutes{}}
+	if err := c.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	transport, ok := c.client.Transport.(*http.Transport)
+	if !ok || transport.TLSClientConfig == nil || transport.TLSClientConfig.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("expected the client restricted to FIPS settings, got %+v", c.client.Transport)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package main
+
+// Built with GOEXPERIMENT=boringcrypto, TLS is limited to FIPS approved
+// settings by the BoringCrypto module, whether or not FIPS=true is set.
+import _ "crypto/tls/fipsonly"
@@ -12,8 +12,8 @@ require (
 	github.com/docker/docker v20.10.3+incompatible // indirect
 	github.com/docker/engine-api v0.3.2-0.20160708123604-98348ad6f9c8 // indirect
 	github.com/fsouza/go-dockerclient v1.7.0
-	github.com/gogo/protobuf v1.3.2 // indirect
-	github.com/golang/snappy v0.0.4 // indirect
+	github.com/gogo/protobuf v1.3.2
+	github.com/golang/snappy v0.0.4
 	github.com/gorilla/context v0.0.0-20160525203319-aed02d124ae4 // indirect
 	github.com/gorilla/mux v1.8.0
 	github.com/hashicorp/go-cleanhttp v0.0.0-20160407174126-ad28ea4487f0 // indirect
@@ -22,8 +22,11 @@ require (
 	github.com/looplab/logspout-logstash v0.0.0-20171130125839-68a4e47e757d
 	github.com/moby/term v0.0.0-20201216013528-df9cb8a40635 // indirect
 	github.com/opencontainers/runc v1.0.0-rc1.0.20160706165155-9d7831e41d3e // indirect
+	github.com/xeipuuv/gojsonschema v1.2.0
+	github.com/yuin/gopher-lua v0.0.0-20200603152657-dc2b0ca8b37e
 	go.opencensus.io v0.22.6 // indirect
 	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
 	golang.org/x/sync v0.0.0-20201207232520-09787c993a3a // indirect
 	golang.org/x/sys v0.0.0-20210124154548-22da62e12c0c // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
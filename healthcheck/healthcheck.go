@@ -1,7 +1,10 @@
 package healthcheck
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -12,11 +15,30 @@ func init() {
 	router.HTTPHandlers.Register(HealthCheck, "health")
 }
 
-// HealthCheck returns a http.Handler for the health check
+// HealthCheck returns a http.Handler for the health check. The adapters that
+// aren't connected are listed after the first line, so the response stays
+// usable for probes.
 func HealthCheck() http.Handler {
 	r := mux.NewRouter()
 	r.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+		states := router.ConnStates()
+		if req.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{"connections": states}) //nolint:errcheck
+			return
+		}
 		w.Write([]byte("Healthy!\n"))
+		for _, s := range states {
+			if s.State == router.ConnConnected {
+				continue
+			}
+			fmt.Fprintf(w, "route %s: %s %s %s since %s", s.Route, s.Adapter, s.Address, s.State,
+				s.Since.Format(time.RFC3339))
+			if s.LastError != "" {
+				fmt.Fprintf(w, ": %s", s.LastError)
+			}
+			fmt.Fprintln(w)
+		}
 	})
 	return r
 }
@@ -0,0 +1,97 @@
+package httpclient
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultBatchMaxFactor = 16
+	defaultBatchTargetRTT = time.Second
+	// batchRateDrop is the drop in byte rate at which larger batches stop
+	// paying off, so the batch size stops growing
+	batchRateDrop = 0.9
+)
+
+// Batching sizes the batches of an adapter, in the units the adapter counts
+// them in. With batch_adaptive=true it adapts the size to the backend, in the
+// manner of TCP congestion control: batches that fill up and come back within
+// the target round trip time grow the size by the configured size, up to
+// batch_adaptive_max times it, while the byte rate keeps up; errors and slow
+// round trips halve it. A backlog, like after an outage, is then sent in few
+// large requests, while batches that don't fill up are sent on the flush
+// interval as before.
+type Batching struct {
+	min, max int
+	target   time.Duration
+
+	mu    sync.Mutex
+	limit int
+	rate  float64
+}
+
+// NewBatching returns the batching of an adapter configured with batches of
+// size
+func NewBatching(route *router.Route, size int) (*Batching, error) {
+	b := &Batching{min: size, max: size, limit: size, target: defaultBatchTargetRTT}
+	if s := Option(route, "batch_adaptive", "BATCH_ADAPTIVE"); s != "true" {
+		if s != "" && s != "false" {
+			return nil, errors.New("bad batch_adaptive: " + s)
+		}
+		return b, nil
+	}
+	factor := defaultBatchMaxFactor
+	if s := Option(route, "batch_adaptive_max", "BATCH_ADAPTIVE_MAX"); s != "" {
+		var err error
+		if factor, err = strconv.Atoi(s); err != nil || factor < 1 {
+			return nil, errors.New("bad batch_adaptive_max: " + s)
+		}
+	}
+	b.max = size * factor
+	if s := Option(route, "batch_target_rtt", "BATCH_TARGET_RTT"); s != "" {
+		var err error
+		if b.target, err = time.ParseDuration(s); err != nil || b.target <= 0 {
+			return nil, errors.New("bad batch_target_rtt: " + s)
+		}
+	}
+	return b, nil
+}
+
+// Limit returns the size at which a batch is sent, smaller under memory
+// pressure so batches don't pile up
+func (b *Batching) Limit() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return router.BufferSize(b.limit)
+}
+
+// Observe adapts the size to a batch of n bytes that took rtt to send, and
+// whether it was sent because it reached the limit
+func (b *Batching) Observe(n int, full bool, rtt time.Duration, err error) {
+	if b.min == b.max {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case err != nil || rtt > b.target:
+		b.limit /= 2
+		if b.limit < b.min {
+			b.limit = b.min
+		}
+		b.rate = 0
+	case full && rtt > 0:
+		rate := float64(n) / rtt.Seconds()
+		if rate >= b.rate*batchRateDrop {
+			b.limit += b.min
+			if b.limit > b.max {
+				b.limit = b.max
+			}
+		}
+		b.rate = rate
+	}
+}
@@ -0,0 +1,66 @@
+package httpclient
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func TestBatchingFixed(t *testing.T) {
+	b, err := NewBatching(&router.Route{}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Observe(1000, true, time.Millisecond, nil)
+	if limit := b.Limit(); limit != 100 {
+		t.Errorf("expected the configured size without batch_adaptive, got %d", limit)
+	}
+}
+
+func TestBatchingAdaptive(t *testing.T) {
+	b, err := NewBatching(&router.Route{Options: map[string]string{
+		"batch_adaptive": "true", "batch_adaptive_max": "4", "batch_target_rtt": "100ms",
+	}}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Observe(1000, false, time.Millisecond, nil)
+	if limit := b.Limit(); limit != 100 {
+		t.Errorf("expected batches that don't fill up to keep the size, got %d", limit)
+	}
+	for i, expected := range []int{200, 300, 400, 400} {
+		b.Observe(1000*(i+1), true, 10*time.Millisecond, nil)
+		if limit := b.Limit(); limit != expected {
+			t.Errorf("expected full batches to grow the size to %d, got %d", expected, limit)
+		}
+	}
+	b.Observe(4000, true, time.Second, nil)
+	if limit := b.Limit(); limit != 200 {
+		t.Errorf("expected a slow round trip to halve the size, got %d", limit)
+	}
+	b.Observe(2000, true, 10*time.Millisecond, errors.New("503"))
+	b.Observe(2000, true, 10*time.Millisecond, errors.New("503"))
+	if limit := b.Limit(); limit != 100 {
+		t.Errorf("expected errors to shrink the size to the configured one, got %d", limit)
+	}
+
+	b.Observe(1000, true, 10*time.Millisecond, nil)
+	b.Observe(1000, true, 20*time.Millisecond, nil)
+	if limit := b.Limit(); limit != 200 {
+		t.Errorf("expected a dropping byte rate to stop the growth, got %d", limit)
+	}
+}
+
+func TestBatchingOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"batch_adaptive": "yes"},
+		{"batch_adaptive": "true", "batch_adaptive_max": "0"},
+		{"batch_adaptive": "true", "batch_target_rtt": "fast"},
+	} {
+		if _, err := NewBatching(&router.Route{Options: options}, 100); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Package httpclient builds the HTTP clients used by HTTP based adapters.
+// Authentication is layered on top of the default transport based on route
+// options, with environment variables as fallback.
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const defaultTimeout = 10 * time.Second
+
+// New returns a http.Client configured for the given route
+func New(route *router.Route) (*http.Client, error) {
+	timeout := defaultTimeout
+	if s := Option(route, "http_timeout", "HTTP_CLIENT_TIMEOUT"); s != "" {
+		var err error
+		if timeout, err = time.ParseDuration(s); err != nil {
+			return nil, err
+		}
+	}
+	base, err := baseTransport(route)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := Transport(route, router.ChaosRoundTripper(base))
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}, nil
+}
+
+// Transport wraps base with the authentication configured for the route
+func Transport(route *router.Route, base http.RoundTripper) (http.RoundTripper, error) {
+	source, err := newOAuth2TokenSource(route, base)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := newSigV4Transport(route, base)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case source != nil && signer != nil:
+		return nil, errors.New("httpclient: oauth2 and sigv4 authentication can't be combined")
+	case source != nil:
+		return &oauth2Transport{source: source, base: base}, nil
+	case signer != nil:
+		return signer, nil
+	}
+	return base, nil
+}
+
+// Option returns the route option with the given key, falling back to the
+// environment variable env when the route does not set it
+func Option(route *router.Route, key, env string) string {
+	if route != nil {
+		if v := route.Options[key]; v != "" {
+			return v
+		}
+	}
+	if env == "" {
+		return ""
+	}
+	return cfg.GetEnvDefault(env, "")
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if part != "" {
+			list = append(list, part)
+		}
+	}
+	return list
+}
+
+// Options are the route options of the HTTP clients and batching of this
+// package, for the adapters to list in router.DescribeAdapter
+var Options = []router.AdapterOption{
+	{Name: "batch_adaptive", Env: "BATCH_ADAPTIVE", Description: "true to adapt the batch size to the round trips of the backend"},
+	{Name: "batch_adaptive_max", Env: "BATCH_ADAPTIVE_MAX", Description: "largest batch size, as a multiple of the configured one"},
+	{Name: "batch_target_rtt", Env: "BATCH_TARGET_RTT", Description: "round trip time above which batches shrink"},
+	{Name: "http_timeout", Env: "HTTP_CLIENT_TIMEOUT", Description: "timeout of HTTP requests"},
+	{Name: "oauth2_token_url", Env: "OAUTH2_TOKEN_URL", Description: "token URL for OAuth2 client credentials"},
+	{Name: "oauth2_client_id", Env: "OAUTH2_CLIENT_ID", Description: "OAuth2 client ID"},
+	{Name: "oauth2_client_secret", Env: "OAUTH2_CLIENT_SECRET", Description: "OAuth2 client secret"},
+	{Name: "oauth2_scopes", Env: "OAUTH2_SCOPES", Description: "comma separated OAuth2 scopes"},
+	{Name: "oauth2_audience", Env: "OAUTH2_AUDIENCE", Description: "OAuth2 audience"},
+	{Name: "oauth2_auth_style", Env: "OAUTH2_AUTH_STYLE", Description: "params to send the client credentials in the form instead of basic auth"},
+	{Name: "oauth2_expiry_skew", Env: "OAUTH2_EXPIRY_SKEW", Description: "how long before expiry tokens are refreshed"},
+	{Name: "sigv4_region", Env: "AWS_SIGV4_REGION", Description: "AWS region to sign requests for"},
+	{Name: "sigv4_service", Env: "AWS_SIGV4_SERVICE", Description: "AWS service to sign requests for"},
+	{Name: "tls_ca_cert", Env: "HTTP_TLS_CA_CERT", Description: "CA certificate file to verify the server with"},
+	{Name: "tls_client_cert", Env: "HTTP_TLS_CLIENT_CERT", Description: "client certificate file"},
+	{Name: "tls_client_key", Env: "HTTP_TLS_CLIENT_KEY", Description: "client key file"},
+}
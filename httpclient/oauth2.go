@@ -0,0 +1,193 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultExpirySkew   = 30 * time.Second
+	maxTokenResponseLen = 1 << 20
+	authStyleParams     = "params"
+)
+
+// tokenSource fetches and caches access tokens using the OAuth2
+// client-credentials grant (RFC 6749 section 4.4)
+type tokenSource struct {
+	mu           sync.Mutex
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scopes       []string
+	audience     string
+	authInParams bool
+	skew         time.Duration
+	client       *http.Client
+	now          func() time.Time
+
+	token  string
+	expiry time.Time
+}
+
+type tokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	TokenType   string      `json:"token_type"`
+	ExpiresIn   json.Number `json:"expires_in"`
+	Error       string      `json:"error"`
+	Description string      `json:"error_description"`
+}
+
+func newOAuth2TokenSource(route *router.Route, base http.RoundTripper) (*tokenSource, error) {
+	tokenURL := Option(route, "oauth2_token_url", "OAUTH2_TOKEN_URL")
+	if tokenURL == "" {
+		return nil, nil
+	}
+	ts := &tokenSource{
+		tokenURL:     tokenURL,
+		clientID:     Option(route, "oauth2_client_id", "OAUTH2_CLIENT_ID"),
+		clientSecret: Option(route, "oauth2_client_secret", "OAUTH2_CLIENT_SECRET"),
+		scopes:       splitList(Option(route, "oauth2_scopes", "OAUTH2_SCOPES")),
+		audience:     Option(route, "oauth2_audience", "OAUTH2_AUDIENCE"),
+		authInParams: Option(route, "oauth2_auth_style", "OAUTH2_AUTH_STYLE") == authStyleParams,
+		skew:         defaultExpirySkew,
+		client:       &http.Client{Transport: base, Timeout: defaultTimeout},
+		now:          time.Now,
+	}
+	if ts.clientID == "" {
+		return nil, errors.New("oauth2: client id is required when a token url is configured")
+	}
+	if s := Option(route, "oauth2_expiry_skew", "OAUTH2_EXPIRY_SKEW"); s != "" {
+		skew, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("oauth2: invalid expiry skew %q: %v", s, err)
+		}
+		ts.skew = skew
+	}
+	return ts, nil
+}
+
+// Token returns a valid access token, refreshing it when it is about to expire.
+// A token is considered expired skew before its advertised expiry so that
+// clock differences with the identity provider don't cause rejected requests.
+func (ts *tokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != "" && (ts.expiry.IsZero() || ts.now().Add(ts.skew).Before(ts.expiry)) {
+		return ts.token, nil
+	}
+	return ts.refresh()
+}
+
+// Invalidate drops the cached token so that the next call to Token fetches a new one
+func (ts *tokenSource) Invalidate(token string) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token == token {
+		ts.token = ""
+	}
+}
+
+func (ts *tokenSource) refresh() (string, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(ts.scopes) > 0 {
+		form.Set("scope", strings.Join(ts.scopes, " "))
+	}
+	if ts.audience != "" {
+		form.Set("audience", ts.audience)
+	}
+	if ts.authInParams {
+		form.Set("client_id", ts.clientID)
+		form.Set("client_secret", ts.clientSecret)
+	}
+	req, err := http.NewRequest(http.MethodPost, ts.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !ts.authInParams {
+		req.SetBasicAuth(url.QueryEscape(ts.clientID), url.QueryEscape(ts.clientSecret))
+	}
+
+	requested := ts.now()
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oauth2: token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTokenResponseLen))
+	if err != nil {
+		return "", fmt.Errorf("oauth2: reading token response: %v", err)
+	}
+
+	var tr tokenResponse
+	if err = json.Unmarshal(body, &tr); err != nil && resp.StatusCode/100 == 2 {
+		return "", fmt.Errorf("oauth2: invalid token response: %v", err)
+	}
+	if resp.StatusCode/100 != 2 || tr.AccessToken == "" {
+		if tr.Error != "" {
+			return "", fmt.Errorf("oauth2: token endpoint returned %s: %s %s", resp.Status, tr.Error, tr.Description)
+		}
+		return "", fmt.Errorf("oauth2: token endpoint returned %s without an access token", resp.Status)
+	}
+
+	ts.token = tr.AccessToken
+	ts.expiry = time.Time{}
+	// expires_in is relative, so measure it from when the request was sent
+	// rather than trusting absolute timestamps from the provider
+	if secs, err := tr.ExpiresIn.Int64(); err == nil && secs > 0 {
+		ts.expiry = requested.Add(time.Duration(secs) * time.Second)
+	}
+	return ts.token, nil
+}
+
+// oauth2Transport adds a bearer token to every request
+type oauth2Transport struct {
+	source *tokenSource
+	base   http.RoundTripper
+}
+
+func (t *oauth2Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(withBearer(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// the token may have been revoked before its expiry, retry once with a
+	// fresh one when the request body can be replayed
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	t.source.Invalidate(token)
+	if token, err = t.source.Token(); err != nil {
+		return resp, nil
+	}
+	retry := withBearer(req, token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
+
+func withBearer(req *http.Request, token string) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
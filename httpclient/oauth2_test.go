@@ -0,0 +1,122 @@
+package httpclient
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+func newTokenServer(t *testing.T, expiresIn int, issued *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := req.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if grant := req.PostForm.Get("grant_type"); grant != "client_credentials" {
+			t.Errorf("expected client_credentials grant, got %q", grant)
+		}
+		if user, pass, ok := req.BasicAuth(); !ok || user != "id" || pass != "secret" {
+			t.Errorf("expected basic auth id:secret, got %q:%q", user, pass)
+		}
+		n := atomic.AddInt32(issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":%d}`, n, expiresIn)
+	}))
+}
+
+func TestOAuth2TokenRefreshHonorsSkew(t *testing.T) {
+	var issued int32
+	srv := newTokenServer(t, 60, &issued)
+	defer srv.Close()
+
+	route := &router.Route{Options: map[string]string{
+		"oauth2_token_url":     srv.URL,
+		"oauth2_client_id":     "id",
+		"oauth2_client_secret": "secret",
+		"oauth2_expiry_skew":   "10s",
+	}}
+	ts, err := newOAuth2TokenSource(route, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ts.now = func() time.Time { return now }
+
+	token, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "token-1" {
+		t.Errorf("expected token-1, got %s", token)
+	}
+
+	now = now.Add(45 * time.Second)
+	if token, _ = ts.Token(); token != "token-1" {
+		t.Errorf("expected cached token-1, got %s", token)
+	}
+
+	// within the skew window of the expiry the token must be refreshed
+	now = now.Add(10 * time.Second)
+	if token, _ = ts.Token(); token != "token-2" {
+		t.Errorf("expected refreshed token-2, got %s", token)
+	}
+}
+
+func TestOAuth2TransportRetriesUnauthorized(t *testing.T) {
+	var issued int32
+	tokenSrv := newTokenServer(t, 3600, &issued)
+	defer tokenSrv.Close()
+
+	var calls int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if req.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer backend.Close()
+
+	client, err := New(&router.Route{Options: map[string]string{
+		"oauth2_token_url":     tokenSrv.URL,
+		"oauth2_client_id":     "id",
+		"oauth2_client_secret": "secret",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Post(backend.URL, "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("expected 204 after token refresh, got %d", resp.StatusCode)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 backend calls, got %d", calls)
+	}
+}
+
+func TestOAuth2RequiresClientID(t *testing.T) {
+	_, err := New(&router.Route{Options: map[string]string{"oauth2_token_url": "http://localhost"}})
+	if err == nil {
+		t.Error("expected error without client id")
+	}
+}
+
+func TestNewWithoutAuth(t *testing.T) {
+	client, err := New(&router.Route{Options: map[string]string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Transport != http.DefaultTransport {
+		t.Error("expected default transport without auth options")
+	}
+}
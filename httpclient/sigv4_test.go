@@ -0,0 +1,90 @@
+package httpclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// credentials and expected signature from the get-vanilla case of the
+// AWS Signature Version 4 test suite
+var testCredentials = awsCredentials{
+	accessKeyID:     "AKIDEXAMPLE",
+	secretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+}
+
+func TestSigV4GetVanilla(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now, _ := time.Parse(sigv4TimeFormat, "20150830T123600Z")
+	signRequest(req, sha256Hex(nil), testCredentials, "us-east-1", "service", now)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, auth)
+	}
+	if date := req.Header.Get("X-Amz-Date"); date != "20150830T123600Z" {
+		t.Errorf("expected X-Amz-Date 20150830T123600Z, got %s", date)
+	}
+}
+
+func TestSigV4CanonicalQuery(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/?b=2&a=z a&a=b", nil)
+	if q := canonicalQuery(req); q != "a=b&a=z%20a&b=2" {
+		t.Errorf("unexpected canonical query: %s", q)
+	}
+}
+
+func TestSigV4TransportSignsBody(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", testCredentials.accessKeyID)
+	os.Setenv("AWS_SECRET_ACCESS_KEY", testCredentials.secretAccessKey)
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	var auth, body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		auth = req.Header.Get("Authorization")
+		b, _ := ioutil.ReadAll(req.Body)
+		body = string(b)
+	}))
+	defer srv.Close()
+
+	client, err := New(&router.Route{Options: map[string]string{"sigv4_region": "eu-west-1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Post(srv.URL+"/_bulk", "application/json", strings.NewReader(`{"index":{}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !strings.Contains(auth, "/eu-west-1/es/aws4_request") {
+		t.Errorf("expected request signed for eu-west-1/es, got %q", auth)
+	}
+	if body != `{"index":{}}` {
+		t.Errorf("expected body to be forwarded, got %q", body)
+	}
+}
+
+func TestSigV4AndOAuth2Exclusive(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", testCredentials.accessKeyID)
+	os.Setenv("AWS_SECRET_ACCESS_KEY", testCredentials.secretAccessKey)
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	_, err := New(&router.Route{Options: map[string]string{
+		"sigv4_region":     "eu-west-1",
+		"oauth2_token_url": "http://localhost",
+		"oauth2_client_id": "id",
+	}})
+	if err == nil {
+		t.Error("expected error when combining sigv4 and oauth2")
+	}
+}
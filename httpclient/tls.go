@@ -0,0 +1,44 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+)
+
+// baseTransport returns http.DefaultTransport, or a copy of it with the TLS
+// settings of the route when it has any or in FIPS mode
+func baseTransport(route *router.Route) (http.RoundTripper, error) {
+	caCert := Option(route, "tls_ca_cert", "HTTP_TLS_CA_CERT")
+	clientCert := Option(route, "tls_client_cert", "HTTP_TLS_CLIENT_CERT")
+	clientKey := Option(route, "tls_client_key", "HTTP_TLS_CLIENT_KEY")
+	if caCert == "" && clientCert == "" && clientKey == "" && !cfg.FIPS() {
+		return http.DefaultTransport, nil
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("httpclient: no certificates in " + caCert)
+		}
+	}
+	if clientCert != "" || clientKey != "" {
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg.RestrictTLS(config)
+	return transport, nil
+}
@@ -0,0 +1,4 @@
+// Package integration holds end-to-end tests of the adapters against real
+// backends started with docker compose. The tests are behind the integration
+// build tag; run them with make test-integration.
+package integration
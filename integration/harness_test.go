@@ -0,0 +1,145 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	_ "github.com/gliderlabs/logspout/adapters/gelf"
+	_ "github.com/gliderlabs/logspout/adapters/loki"
+	_ "github.com/gliderlabs/logspout/adapters/syslog"
+	"github.com/gliderlabs/logspout/cfg"
+	"github.com/gliderlabs/logspout/router"
+	_ "github.com/gliderlabs/logspout/transports/tcp"
+	_ "github.com/gliderlabs/logspout/transports/udp"
+)
+
+const (
+	composeProject  = "logspout-integration"
+	deliveryTimeout = time.Minute
+)
+
+var container = &docker.Container{
+	ID:   "1e6a7e57c0n7",
+	Name: "/integration",
+	Config: &docker.Config{
+		Image:    "integration:1",
+		Hostname: "integration",
+		Cmd:      []string{"integration"},
+	},
+}
+
+// compose runs docker compose on the backends of the tests and returns its
+// output
+func compose(t *testing.T, args ...string) string {
+	t.Helper()
+	args = append([]string{"compose", "-f", "docker-compose.yml", "-p",
+		cfg.GetEnvDefault("INTEGRATION_PROJECT", composeProject)}, args...)
+	out, err := exec.Command("docker", args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("docker %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// token returns a string unique to the test run, to find its messages among
+// the ones of earlier runs
+func token(t *testing.T) string {
+	return fmt.Sprintf("%s-%d", strings.ToLower(t.Name()), time.Now().UnixNano())
+}
+
+// pipe streams messages to the adapter of a route, the way the router does
+type pipe struct {
+	adapter   router.LogAdapter
+	logstream chan *router.Message
+	done      chan struct{}
+}
+
+// newPipe creates the adapter for uri and starts streaming to it
+func newPipe(t *testing.T, uri string) *pipe {
+	t.Helper()
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := &router.Route{
+		ID:      strings.ToLower(t.Name()),
+		Adapter: u.Scheme,
+		Address: u.Host,
+		Path:    u.Path,
+		Options: make(map[string]string),
+	}
+	for key := range u.Query() {
+		route.Options[key] = u.Query().Get(key)
+	}
+	factory, ok := router.AdapterFactories.Lookup(route.AdapterType())
+	if !ok {
+		t.Fatalf("no adapter %s", route.AdapterType())
+	}
+	adapter, err := factory(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &pipe{adapter: adapter, logstream: make(chan *router.Message), done: make(chan struct{})}
+	go func() {
+		adapter.Stream(p.logstream)
+		close(p.done)
+	}()
+	return p
+}
+
+func (p *pipe) send(lines ...string) {
+	for _, line := range lines {
+		p.logstream <- &router.Message{Container: container, Source: "stdout", Data: line, Time: time.Now()}
+	}
+}
+
+// close ends the stream and waits for the adapter to flush
+func (p *pipe) close() {
+	close(p.logstream)
+	<-p.done
+	if closer, ok := p.adapter.(io.Closer); ok {
+		closer.Close() //nolint:errcheck
+	}
+}
+
+// numbered returns count lines holding token and their number
+func numbered(token string, count int) []string {
+	lines := make([]string, count)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s %03d", token, i)
+	}
+	return lines
+}
+
+// eventually calls received until it returns at least len(expected) lines,
+// then checks they are expected, in order
+func eventually(t *testing.T, expected []string, received func() ([]string, error)) {
+	t.Helper()
+	deadline := time.Now().Add(deliveryTimeout)
+	var got []string
+	var err error
+	for time.Now().Before(deadline) {
+		if got, err = received(); err == nil && len(got) >= len(expected) {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if len(got) < len(expected) {
+		t.Fatalf("expected %d messages, got %d (last error: %v)", len(expected), len(got), err)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected message %d to be %q, got %q", i, expected[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,70 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+func TestLokiDelivery(t *testing.T) {
+	address := cfg.GetEnvDefault("INTEGRATION_LOKI", "localhost:3100")
+	start := time.Now().Add(-time.Minute)
+	tok := token(t)
+	lines := numbered(tok, 50)
+
+	p := newPipe(t, "loki://"+address+"/loki/api/v1/push")
+	p.send(lines...)
+	p.close()
+
+	eventually(t, lines, func() ([]string, error) {
+		return queryLoki(address, tok, start)
+	})
+}
+
+// queryLoki returns the lines of the integration container holding token, in
+// the order Loki received them
+func queryLoki(address, token string, start time.Time) ([]string, error) {
+	query := url.Values{
+		"query":     {fmt.Sprintf(`{container_name="integration"} |= %q`, token)},
+		"start":     {strconv.FormatInt(start.UnixNano(), 10)},
+		"direction": {"forward"},
+		"limit":     {"1000"},
+	}
+	resp, err := http.Get("http://" + address + "/loki/api/v1/query_range?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("loki query: %s", resp.Status)
+	}
+	var result struct {
+		Data struct {
+			Result []struct {
+				Values [][2]string `json:"values"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	var lines []string
+	for _, stream := range result.Data.Result {
+		for _, value := range stream.Values {
+			if strings.Contains(value[1], token) {
+				lines = append(lines, value[1])
+			}
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,67 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+func syslogAddress() string {
+	return cfg.GetEnvDefault("INTEGRATION_SYSLOG", "localhost:5514")
+}
+
+// syslogLines returns the messages holding token that syslog-ng wrote, in the
+// order it received them
+func syslogLines(t *testing.T, token string) func() ([]string, error) {
+	return func() ([]string, error) {
+		var lines []string
+		for _, line := range strings.Split(compose(t, "exec", "-T", "syslog", "cat", "/var/log/integration.log"), "\n") {
+			if i := strings.Index(line, token); i >= 0 {
+				lines = append(lines, line[i:])
+			}
+		}
+		return lines, nil
+	}
+}
+
+func TestSyslogDelivery(t *testing.T) {
+	for _, transport := range []string{"tcp", "udp"} {
+		t.Run(transport, func(t *testing.T) {
+			tok := token(t)
+			lines := numbered(tok, 50)
+			p := newPipe(t, "syslog+"+transport+"://"+syslogAddress())
+			p.send(lines...)
+			p.close()
+			eventually(t, lines, syslogLines(t, tok))
+		})
+	}
+}
+
+func TestSyslogReconnect(t *testing.T) {
+	tok := token(t)
+	before := numbered(tok+"-before", 10)
+	p := newPipe(t, "syslog+tcp://"+syslogAddress())
+	defer p.close()
+	p.send(before...)
+	eventually(t, before, syslogLines(t, tok+"-before"))
+
+	compose(t, "restart", "syslog")
+
+	// the first writes after the restart can go to the closed connection
+	// before the adapter notices; keep sending until messages arrive again
+	after := tok + "-after"
+	deadline := time.Now().Add(deliveryTimeout)
+	for i := 0; time.Now().Before(deadline); i++ {
+		p.send(numbered(after, i+1)[i])
+		if got, _ := syslogLines(t, after)(); len(got) > 0 {
+			return
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	t.Fatal("expected messages to arrive after syslog restarted")
+}
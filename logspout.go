@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -16,11 +17,25 @@ var Version string
 
 func main() {
 	if len(os.Args) == 2 && os.Args[1] == "--version" {
-		fmt.Printf("%s\n", Version)
+		fmt.Printf("%s\n", versionString())
 		os.Exit(0)
 	}
+	if len(os.Args) == 2 && os.Args[1] == "--schema" {
+		schema, _ := json.MarshalIndent(router.RoutesSchema(), "", "  ")
+		fmt.Printf("%s\n", schema)
+		os.Exit(0)
+	}
+	if len(os.Args) > 1 {
+		if status, ok := runCommand(os.Args[1:], os.Stdout); ok {
+			os.Exit(status)
+		}
+	}
 
-	log.Printf("# logspout %s by gliderlabs\n", Version)
+	log.Printf("# logspout %s by gliderlabs\n", versionString())
+	if err := router.DisableModules(); err != nil {
+		log.Printf("!! %v\n", err)
+		os.Exit(1)
+	}
 	log.Printf("# adapters: %s\n", strings.Join(router.AdapterFactories.Names(), " "))
 	log.Printf("# options : ")
 	if d := cfg.GetEnvDefault("DEBUG", ""); d != "" {
@@ -29,7 +44,14 @@ func main() {
 	if b := cfg.GetEnvDefault("BACKLOG", ""); b != "" {
 		log.Printf("backlog:%s\n", b)
 	}
-	log.Printf("persist:%s\n", cfg.GetEnvDefault("ROUTESPATH", "/mnt/routes"))
+	if dir := cfg.DataDir(); dir != "" {
+		log.Printf("data:%s\n", dir)
+	}
+	log.Printf("persist:%s\n", cfg.RoutesPath())
+	log.Printf("maxprocs:%d\n", cfg.SetMaxProcs())
+	if cfg.FIPS() {
+		log.Printf("fips:true\n")
+	}
 
 	var jobs []string
 	for _, job := range router.Jobs.All() {
@@ -48,9 +70,10 @@ func main() {
 		log.Println("# routes  :")
 		w := new(tabwriter.Writer)
 		w.Init(os.Stdout, 0, 8, 0, '\t', 0)
-		fmt.Fprintln(w, "#   ADAPTER\tADDRESS\tCONTAINERS\tSOURCES\tOPTIONS") //nolint:errcheck
+		fmt.Fprintln(w, "#   ROUTE\tADAPTER\tADDRESS\tCONTAINERS\tSOURCES\tOPTIONS") //nolint:errcheck
 		for _, route := range routes {
-			fmt.Fprintf(w, "#   %s\t%s\t%s\t%s\t%s\n",
+			fmt.Fprintf(w, "#   %s\t%s\t%s\t%s\t%s\t%s\n",
+				route,
 				route.Adapter,
 				route.Address,
 				route.FilterID+route.FilterName+strings.Join(route.FilterLabels, ","),
@@ -0,0 +1,217 @@
+package router
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// RecordAccessor is a key of a message in the record accessor syntax of
+// Fluent Bit, like $log or $kubernetes['labels']['app'], so the rules of
+// Fluent Bit pipelines can be ported with the keys they use. Messages are
+// seen as the records the Fluent Bit Docker and Kubernetes inputs make:
+//
+//	$log                        the text of the message
+//	$stream                     stdout or stderr
+//	$time                       the time of the message, in RFC 3339
+//	$container_id               the ID of the container
+//	$container_name             the name of the container
+//	$labels['name']             a label of the container
+//	$kubernetes['pod_name']     pod_name, namespace_name, pod_id,
+//	                            container_name, container_image and docker_id
+//	                            from the labels of the container
+//	$kubernetes['labels']['x']  a label of the container
+//
+// Any other key is a field of the message, with the keys of nested objects
+// joined by dots, or by underscores when no field has the dotted name, so
+// $http['status'] is the field http.status or http_status.
+type RecordAccessor struct {
+	text string
+	keys []string
+}
+
+// kubernetesLabels are the labels kubelet gives the containers of pods, by
+// the key of the Fluent Bit Kubernetes metadata
+var kubernetesLabels = map[string]string{
+	"pod_name":       "io.kubernetes.pod.name",
+	"namespace_name": "io.kubernetes.pod.namespace",
+	"pod_id":         "io.kubernetes.pod.uid",
+	"container_name": "io.kubernetes.container.name",
+}
+
+// ParseRecordAccessor parses s, like $kubernetes['labels']['app']
+func ParseRecordAccessor(s string) (*RecordAccessor, error) {
+	a, rest, err := parseRecordAccessor(s)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, errors.New("bad record accessor: " + s)
+	}
+	return a, nil
+}
+
+// parseRecordAccessor parses the record accessor s starts with, returning
+// the rest of s
+func parseRecordAccessor(s string) (*RecordAccessor, string, error) {
+	bad := errors.New("bad record accessor: " + s)
+	if !strings.HasPrefix(s, "$") {
+		return nil, "", bad
+	}
+	i := 1
+	for i < len(s) && (s[i] == '_' || s[i] == '-' || s[i] == '.' || s[i] == '/' ||
+		(s[i] >= 'a' && s[i] <= 'z') || (s[i] >= 'A' && s[i] <= 'Z') || (s[i] >= '0' && s[i] <= '9')) {
+		i++
+	}
+	if i == 1 {
+		return nil, "", bad
+	}
+	a := &RecordAccessor{keys: []string{s[1:i]}}
+	for i < len(s) && s[i] == '[' {
+		i++
+		if i == len(s) {
+			return nil, "", bad
+		}
+		var key string
+		if quote := s[i]; quote == '\'' || quote == '"' {
+			end := strings.IndexByte(s[i+1:], quote)
+			if end < 0 || i+end+2 >= len(s) || s[i+end+2] != ']' {
+				return nil, "", bad
+			}
+			key = s[i+1 : i+1+end]
+			i += end + 3
+		} else {
+			// array indexes, which messages don't have, are taken as keys
+			end := strings.IndexByte(s[i:], ']')
+			if end <= 0 {
+				return nil, "", bad
+			}
+			key = s[i : i+end]
+			i += end + 1
+		}
+		a.keys = append(a.keys, key)
+	}
+	a.text = s[:i]
+	return a, s[i:], nil
+}
+
+// String returns the accessor as it was parsed
+func (a *RecordAccessor) String() string {
+	return a.text
+}
+
+// Get returns the value of the key in message, and whether it has one
+func (a *RecordAccessor) Get(message *Message) (string, bool) {
+	var labels map[string]string
+	if message.Container != nil && message.Container.Config != nil {
+		labels = message.Container.Config.Labels
+	}
+	key, sub := a.keys[0], a.keys[1:]
+	switch {
+	case key == "log" && len(sub) == 0:
+		return message.Data, true
+	case key == "stream" && len(sub) == 0:
+		return message.Source, true
+	case key == "time" && len(sub) == 0:
+		return message.Time.Format(time.RFC3339Nano), true
+	case key == "container_id" && len(sub) == 0 && message.Container != nil:
+		return message.Container.ID, true
+	case key == "container_name" && len(sub) == 0 && message.Container != nil:
+		return strings.TrimPrefix(message.Container.Name, "/"), true
+	case key == "labels" && len(sub) == 1:
+		value, ok := labels[sub[0]]
+		return value, ok
+	case key == "kubernetes" && len(sub) == 2 && sub[0] == "labels":
+		value, ok := labels[sub[1]]
+		return value, ok
+	case key == "kubernetes" && len(sub) == 1:
+		switch sub[0] {
+		case "docker_id":
+			if message.Container != nil {
+				return message.Container.ID, true
+			}
+		case "container_image":
+			if message.Container != nil && message.Container.Config != nil {
+				return message.Container.Config.Image, true
+			}
+		default:
+			if label, ok := kubernetesLabels[sub[0]]; ok {
+				value, ok := labels[label]
+				return value, ok
+			}
+		}
+		return "", false
+	}
+	if value, ok := message.Fields[strings.Join(a.keys, ".")]; ok || len(sub) == 0 {
+		return value, ok
+	}
+	value, ok := message.Fields[strings.Join(a.keys, "_")]
+	return value, ok
+}
+
+// recordMatch is a record accessor with the pattern its value has to match
+type recordMatch struct {
+	accessor *RecordAccessor
+	value    pattern
+}
+
+// recordMatches are record matches that all have to match
+type recordMatches []recordMatch
+
+// parseRecordMatches parses comma separated accessor:pattern pairs like
+// $kubernetes['labels']['app']:web*
+func parseRecordMatches(s string) (recordMatches, error) {
+	var matches recordMatches
+	for rest := s; rest != ""; {
+		accessor, after, err := parseRecordAccessor(strings.TrimLeft(rest, " "))
+		if err != nil || !strings.HasPrefix(after, ":") {
+			return nil, errors.New("expected accessor:pattern pairs: " + s)
+		}
+		// patterns end at the comma before the next accessor, so they can
+		// have commas too
+		value := after[1:]
+		rest = ""
+		for i := strings.IndexByte(value, ','); i >= 0; i = nextComma(value, i) {
+			if strings.HasPrefix(strings.TrimLeft(value[i+1:], " "), "$") {
+				value, rest = value[:i], value[i+1:]
+				break
+			}
+		}
+		matches = append(matches, recordMatch{accessor: accessor, value: newPattern(value)})
+	}
+	return matches, nil
+}
+
+// match returns whether message matches all of the matches. Messages without
+// the key of an accessor don't match.
+func (matches recordMatches) match(message *Message) bool {
+	for _, m := range matches {
+		if value, ok := m.accessor.Get(message); !ok || !m.value.match(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// newRecordFilterStage returns the stage for the filter.record option, which
+// drops the messages that don't match all of its accessor:pattern pairs
+func newRecordFilterStage(s string) (stage, error) {
+	matches, err := parseRecordMatches(s)
+	if err != nil {
+		return nil, errors.New("bad filter.record: " + s)
+	}
+	return stageFunc(func(message *Message) *Message {
+		if !matches.match(message) {
+			return nil
+		}
+		return message
+	}), nil
+}
+
+// nextComma returns the index of the comma in s after the one at i, or -1
+func nextComma(s string, i int) int {
+	if j := strings.IndexByte(s[i+1:], ','); j >= 0 {
+		return i + 1 + j
+	}
+	return -1
+}
@@ -0,0 +1,95 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestRecordAccessor(t *testing.T) {
+	m := &Message{
+		Container: &docker.Container{
+			ID:   "8dfafdbc3a40",
+			Name: "/web",
+			Config: &docker.Config{
+				Image: "nginx:1.25",
+				Labels: map[string]string{
+					"app":                          "frontend",
+					"io.kubernetes.pod.namespace":  "shop",
+					"io.kubernetes.container.name": "nginx",
+				},
+			},
+		},
+		Source: "stderr",
+		Data:   "GET /",
+		Time:   time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Fields: map[string]string{"level": "warn", "http.status": "404", "user_id": "7"},
+	}
+	for _, tt := range []struct {
+		accessor, value string
+		ok              bool
+	}{
+		{"$log", "GET /", true},
+		{"$stream", "stderr", true},
+		{"$time", "2024-01-02T03:04:05Z", true},
+		{"$container_name", "web", true},
+		{"$labels['app']", "frontend", true},
+		{`$kubernetes["labels"]["app"]`, "frontend", true},
+		{"$kubernetes['namespace_name']", "shop", true},
+		{"$kubernetes['container_name']", "nginx", true},
+		{"$kubernetes['container_image']", "nginx:1.25", true},
+		{"$kubernetes['pod_name']", "", false},
+		{"$kubernetes['host']", "", false},
+		{"$level", "warn", true},
+		{"$http['status']", "404", true},
+		{"$user['id']", "7", true},
+		{"$missing", "", false},
+	} {
+		a, err := ParseRecordAccessor(tt.accessor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value, ok := a.Get(m); value != tt.value || ok != tt.ok {
+			t.Errorf("%s: expected %q, %v, got %q, %v", tt.accessor, tt.value, tt.ok, value, ok)
+		}
+		if a.String() != tt.accessor {
+			t.Errorf("expected %q, got %q", tt.accessor, a.String())
+		}
+	}
+	for _, s := range []string{"", "log", "$", "$log[", "$log['a'", "$log['a']x", "$log[]"} {
+		if _, err := ParseRecordAccessor(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestRecordFilterStage(t *testing.T) {
+	m := &Message{
+		Container: &docker.Container{Config: &docker.Config{Labels: map[string]string{"app": "frontend"}}},
+		Data:      "GET health, 200",
+	}
+	for _, tt := range []struct {
+		filter string
+		match  bool
+	}{
+		{"$labels['app']:front*", true},
+		{"$labels['app']:front*,$log:GET *", true},
+		{"$labels['app']:front*, $log:*, 200", true},
+		{"$labels['app']:back*,$log:GET *", false},
+		{"$labels['tier']:*", false},
+	} {
+		s, err := newRecordFilterStage(tt.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match := s.process(m) != nil; match != tt.match {
+			t.Errorf("%s: expected %v, got %v", tt.filter, tt.match, match)
+		}
+	}
+	for _, s := range []string{"$log", "log:x", "$log:x,$"} {
+		if _, err := newRecordFilterStage(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package router
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Attribute is a structured field of a message, with a value that is a
+// string, a number, a boolean or a time
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// FieldProvider adds the attributes it knows of the container of message, as
+// its image, the pod it runs in or the cloud zone of its host, with add.
+// Adapters may keep the attributes of a container for all of its messages, so
+// data of a message itself belongs in its Fields, as the parse stage adds it.
+// Providers must not modify message, and are called from the goroutines of
+// adapters, concurrently.
+type FieldProvider func(message *Message, add func(key string, value interface{}))
+
+type namedFieldProvider struct {
+	name     string
+	provider FieldProvider
+}
+
+var fieldProviders = struct {
+	sync.RWMutex
+	providers []namedFieldProvider
+}{providers: []namedFieldProvider{
+	{"container", containerAttributes},
+	{"swarm", swarmAttributes},
+}}
+
+// RegisterFieldProvider adds a provider after those registered before it, or
+// replaces the one of name in its place. The attributes of later providers
+// replace those of earlier ones with the same key.
+func RegisterFieldProvider(name string, provider FieldProvider) {
+	fieldProviders.Lock()
+	defer fieldProviders.Unlock()
+	// the providers are copied, as ProvideAttributes reads them unlocked
+	providers := make([]namedFieldProvider, 0, len(fieldProviders.providers)+1)
+	replaced := false
+	for _, p := range fieldProviders.providers {
+		if p.name == name {
+			p.provider, replaced = provider, true
+		}
+		providers = append(providers, p)
+	}
+	if !replaced {
+		providers = append(providers, namedFieldProvider{name, provider})
+	}
+	fieldProviders.providers = providers
+}
+
+// UnregisterFieldProvider removes the provider of name
+func UnregisterFieldProvider(name string) {
+	fieldProviders.Lock()
+	defer fieldProviders.Unlock()
+	for i, p := range fieldProviders.providers {
+		if p.name == name {
+			fieldProviders.providers = append(fieldProviders.providers[:i:i], fieldProviders.providers[i+1:]...)
+			return
+		}
+	}
+}
+
+// ProvideAttributes adds the attributes of the field providers of m with
+// add, in the order the providers were registered in, without its Fields.
+// Adapters that add fields of their own between those of the providers and
+// those of the message, as the labels of containers, use it instead of
+// Attributes.
+func (m *Message) ProvideAttributes(add func(key string, value interface{})) {
+	fieldProviders.RLock()
+	providers := fieldProviders.providers
+	fieldProviders.RUnlock()
+	for _, p := range providers {
+		p.provider(m, add)
+	}
+}
+
+// Attributes returns the attributes of m: those of the field providers, then
+// its Fields, which replace those of the providers with the same key. An
+// attribute keeps the place of the first one of its key.
+func (m *Message) Attributes() []Attribute {
+	var attributes []Attribute
+	index := make(map[string]int)
+	add := func(key string, value interface{}) {
+		if i, ok := index[key]; ok {
+			attributes[i].Value = value
+			return
+		}
+		index[key] = len(attributes)
+		attributes = append(attributes, Attribute{key, value})
+	}
+	m.ProvideAttributes(add)
+	for key, value := range m.Fields {
+		add(key, value)
+	}
+	return attributes
+}
+
+// containerAttributes provides the id, name, image, command and creation time
+// of the container of message, and the Swarm node it runs on
+func containerAttributes(message *Message, add func(key string, value interface{})) {
+	container := message.Container
+	if container == nil {
+		return
+	}
+	add("container_id", container.ID)
+	add("container_name", strings.TrimPrefix(container.Name, "/"))
+	add("image_id", container.Image)
+	add("created", container.Created)
+	if config := container.Config; config != nil {
+		add("image_name", config.Image)
+		add("command", strings.Join(config.Cmd, " "))
+	}
+	if container.Node != nil {
+		add("swarm_node", container.Node.Name)
+	}
+}
+
+// swarmAttributes provides the service, stack and task of the containers of
+// Swarm services, from the labels Docker gives them
+func swarmAttributes(message *Message, add func(key string, value interface{})) {
+	if message.Container == nil || message.Container.Config == nil {
+		return
+	}
+	labels := message.Container.Config.Labels
+	service := labels["com.docker.swarm.service.name"]
+	if service == "" {
+		return
+	}
+	add("swarm_service", service)
+	if stack := labels["com.docker.stack.namespace"]; stack != "" {
+		add("swarm_stack", stack)
+	}
+	if id := labels["com.docker.swarm.task.id"]; id != "" {
+		add("task_id", id)
+	}
+	// tasks of replicated services are named service.slot.id, those of
+	// global services service.node.id
+	name := strings.TrimPrefix(labels["com.docker.swarm.task.name"], service+".")
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if slot, err := strconv.Atoi(name[:i]); err == nil {
+			add("task_slot", slot)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestMessageAttributes(t *testing.T) {
+	RegisterFieldProvider("zone", func(message *Message, add func(string, interface{})) {
+		add("zone", "eu-west-1a")
+		add("image_name", "mirror/nginx")
+	})
+	defer UnregisterFieldProvider("zone")
+	created := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	m := &Message{
+		Container: &docker.Container{ID: "abc", Name: "/web", Image: "sha256:1", Created: created, Config: &docker.Config{
+			Image: "nginx",
+			Cmd:   []string{"nginx", "-g", "daemon off;"},
+			Labels: map[string]string{
+				"com.docker.swarm.service.name": "shop_web",
+				"com.docker.stack.namespace":    "shop",
+				"com.docker.swarm.task.name":    "shop_web.2.qbq8ecsxhnlkz6rx0dx2dvxk4",
+			},
+		}},
+		Fields: map[string]string{"zone": "local"},
+	}
+	expected := []Attribute{
+		{"container_id", "abc"},
+		{"container_name", "web"},
+		{"image_id", "sha256:1"},
+		{"created", created},
+		{"image_name", "mirror/nginx"},
+		{"command", "nginx -g daemon off;"},
+		{"swarm_service", "shop_web"},
+		{"swarm_stack", "shop"},
+		{"task_slot", 2},
+		{"zone", "local"},
+	}
+	got := m.Attributes()
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i, attribute := range expected {
+		if got[i] != attribute {
+			t.Errorf("expected %v, got %v", attribute, got[i])
+		}
+	}
+
+	UnregisterFieldProvider("zone")
+	if got := (&Message{Fields: map[string]string{"level": "info"}}).Attributes(); len(got) != 1 || got[0] != (Attribute{"level", "info"}) {
+		t.Errorf("expected only the fields of a message without a container, got %v", got)
+	}
+}
@@ -0,0 +1,32 @@
+package router
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"unicode/utf8"
+)
+
+const defaultBinaryField = "data_base64"
+
+// newBinaryStage returns a stage that moves payloads that aren't valid UTF-8
+// into field, base64 encoded, so adapters encoding Data as text don't corrupt
+// them. Data is replaced with a short note of the size.
+func newBinaryStage(mode, field string) (stage, error) {
+	if mode != "base64" {
+		return nil, errors.New("bad binary: " + mode)
+	}
+	if field == "" {
+		field = defaultBinaryField
+	}
+	return stageFunc(func(message *Message) *Message {
+		if utf8.ValidString(message.Data) {
+			return message
+		}
+		binary := message.WithFields(map[string]string{
+			field: base64.StdEncoding.EncodeToString([]byte(message.Data)),
+		})
+		binary.Data = "binary payload of " + strconv.Itoa(len(message.Data)) + " bytes"
+		return binary
+	}), nil
+}
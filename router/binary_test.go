@@ -0,0 +1,30 @@
+package router
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestBinaryStage(t *testing.T) {
+	s, err := newBinaryStage("base64", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := &Message{Data: "héllo"}
+	if got := s.process(text); got != text {
+		t.Error("expected valid UTF-8 to pass unchanged")
+	}
+	payload := "\x08\x96\x01\xff\xfe"
+	original := &Message{Data: payload}
+	got := s.process(original)
+	decoded, err := base64.StdEncoding.DecodeString(got.Fields[defaultBinaryField])
+	if err != nil || string(decoded) != payload {
+		t.Errorf("expected the payload base64 encoded, got %q", got.Fields[defaultBinaryField])
+	}
+	if got.Data != "binary payload of 5 bytes" || original.Data != payload {
+		t.Errorf("expected a copy with a size note, got %q", got.Data)
+	}
+	if _, err = newBinaryStage("hex", ""); err == nil {
+		t.Error("expected error for an unknown binary mode")
+	}
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+)
+
+const defaultCanaryPercent = 10
+
+// canaryAdapter sends a percentage of the containers of a route to an
+// alternate address. Containers are assigned by hashing their ID, so all logs
+// of a container end up at the same destination.
+type canaryAdapter struct {
+	primary LogAdapter
+	canary  LogAdapter
+	percent uint32
+}
+
+func newCanaryAdapter(route *Route, factory AdapterFactory) (*canaryAdapter, error) {
+	percent := defaultCanaryPercent
+	if s := route.Options["canary_percent"]; s != "" {
+		var err error
+		if percent, err = strconv.Atoi(s); err != nil || percent < 0 || percent > 100 {
+			return nil, errors.New("bad canary_percent: " + s)
+		}
+	}
+	primary, err := newAddressAdapter(route, factory)
+	if err != nil {
+		return nil, err
+	}
+	canaryRoute := route.withAddress(route.Options["canary_address"])
+	delete(canaryRoute.Options, "canary_address")
+	delete(canaryRoute.Options, "canary_percent")
+	canary, err := newAddressAdapter(canaryRoute, factory)
+	if err != nil {
+		closeAdapter(primary)
+		return nil, err
+	}
+	return &canaryAdapter{
+		primary: primary,
+		canary:  canary,
+		percent: uint32(percent),
+	}, nil
+}
+
+// Stream implements the router.LogAdapter interface
+func (a *canaryAdapter) Stream(logstream chan *Message) {
+	primary := make(chan *Message)
+	canary := make(chan *Message)
+	defer close(primary)
+	defer close(canary)
+	go a.primary.Stream(primary)
+	go a.canary.Stream(canary)
+	for message := range logstream {
+		if a.isCanary(message.Container.ID) {
+			canary <- message
+		} else {
+			primary <- message
+		}
+	}
+}
+
+// Close closes the primary and the canary adapter
+func (a *canaryAdapter) Close() error {
+	closeAdapter(a.primary)
+	closeAdapter(a.canary)
+	return nil
+}
+
+func (a *canaryAdapter) isCanary(containerID string) bool {
+	h := fnv.New32a()
+	h.Write([]byte(containerID))
+	return h.Sum32()%100 < a.percent
+}
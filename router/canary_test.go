@@ -0,0 +1,75 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestCanaryAdapterSplitsPerContainer(t *testing.T) {
+	recorder := &addressRecorder{messages: make(map[string][]string)}
+	route := &Route{
+		Address: "primary:514",
+		Options: map[string]string{"canary_address": "canary:514", "canary_percent": "30"},
+	}
+	adapter, err := newCanaryAdapter(route, recorder.factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logstream := make(chan *Message)
+	done := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(done)
+	}()
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("container%d", i)
+		for j := 0; j < 2; j++ {
+			logstream <- &Message{Container: &docker.Container{ID: id}, Data: id}
+		}
+	}
+	close(logstream)
+	<-done
+	recorder.wg.Wait()
+
+	canary := recorder.messages["canary:514"]
+	if n := len(canary) / 2; n < 250 || n > 350 {
+		t.Errorf("expected about 30%% of the containers to go to the canary, got %d of 1000", n)
+	}
+	seen := make(map[string]bool)
+	for _, id := range canary {
+		seen[id] = true
+	}
+	for _, id := range recorder.messages["primary:514"] {
+		if seen[id] {
+			t.Fatalf("container %s was sent to both destinations", id)
+		}
+	}
+}
+
+func TestCanaryBadPercent(t *testing.T) {
+	route := &Route{Options: map[string]string{"canary_address": "canary:514", "canary_percent": "150"}}
+	if _, err := newCanaryAdapter(route, newDummyAdapter); err == nil {
+		t.Error("expected error for canary_percent above 100")
+	}
+}
+
+func TestCanaryClosesPrimaryOnError(t *testing.T) {
+	closed := 0
+	factory := func(route *Route) (LogAdapter, error) {
+		if route.Address == "canary:514" {
+			return nil, errors.New("dial failed")
+		}
+		return &closingAdapter{closed: &closed}, nil
+	}
+	route := &Route{Address: "primary:514", Options: map[string]string{"canary_address": "canary:514"}}
+	if _, err := newCanaryAdapter(route, factory); err == nil {
+		t.Fatal("expected error from the canary factory")
+	}
+	if closed != 1 {
+		t.Errorf("expected the primary adapter to be closed, got %d", closed)
+	}
+}
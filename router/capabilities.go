@@ -0,0 +1,143 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// AdapterOption describes a route option, for tooling listing the valid
+// configuration of routes
+type AdapterOption struct {
+	Name        string `json:"name"`
+	Env         string `json:"env,omitempty"`
+	Description string `json:"description"`
+}
+
+// AdapterInfo describes the transports and route options of an adapter. An
+// adapter without Transports dials its address with any registered transport.
+type AdapterInfo struct {
+	DefaultTransport string          `json:"default_transport,omitempty"`
+	Transports       []string        `json:"transports,omitempty"`
+	Options          []AdapterOption `json:"options,omitempty"`
+}
+
+type adapterCapabilities struct {
+	Name string `json:"name"`
+	AdapterInfo
+}
+
+var adapterInfos = struct {
+	sync.Mutex
+	infos map[string]AdapterInfo
+}{infos: make(map[string]AdapterInfo)}
+
+// routeOptions are the options of all routes, handled by the router
+var routeOptions = []AdapterOption{
+	{Name: "filter.id", Description: "only route the container with this ID prefix"},
+	{Name: "filter.name", Description: "only route containers with a name matching this pattern"},
+	{Name: "filter.labels", Description: "only route containers with labels matching these key:pattern pairs"},
+	{Name: "filter.sources", Description: "only route these sources, stdout or stderr"},
+	{Name: "filter.record", Description: "only route messages whose Fluent Bit record accessors, like $kubernetes['labels']['app'], match these accessor:pattern pairs"},
+	{Name: "filter.match", Description: "only route messages matching this regular expression"},
+	{Name: "filter.exclude", Description: "don't route messages matching this regular expression"},
+	{Name: "filter.min_level", Description: "only route messages at or above this level, like warning or error"},
+	{Name: "filter.networks", Description: "only route containers on a network matching one of these patterns"},
+	{Name: "filter.ips", Description: "only route containers with an address in one of these networks"},
+	{Name: "filter.compose.project", Description: "only route containers of a Docker Compose project matching this pattern"},
+	{Name: "filter.compose.service", Description: "only route containers of a Docker Compose service matching this pattern"},
+	{Name: "pause_policy", Description: "drop or buffer messages while the route is paused"},
+	{Name: "pause_buffer", Description: "messages buffered while the route is paused"},
+	{Name: "pause_catchup_ratio", Description: "buffered messages sent per new message after a resume, instead of all of them first"},
+	{Name: "canary_address", Description: "address sent a share of the messages, to try a new backend"},
+	{Name: "canary_percent", Description: "percentage of the containers sent to canary_address"},
+	{Name: "time_offset", Env: "TIME_OFFSET", Description: "duration added to timestamps, or ntp or ntp:SERVER for the offset of the host clock to an NTP server"},
+	{Name: "time_offset_interval", Env: "TIME_OFFSET_INTERVAL", Description: "interval of the NTP measurements"},
+	{Name: "order", Description: "container to pass on the messages of each container in the order of their timestamps"},
+	{Name: "order_holdback", Description: "how long messages are held back to be put in order"},
+	{Name: "order_buffer", Description: "messages held back to be put in order"},
+	{Name: "binary", Description: "base64 to keep payloads that aren't valid UTF-8"},
+	{Name: "binary_field", Description: "field for binary payloads"},
+	{Name: "strip_prefix", Description: "bytes, cri or regular expression of a line prefix to strip before parsing"},
+	{Name: "strip_prefix_field", Description: "field for the stripped prefix"},
+	{Name: "crash_loop", Description: "runs of a restarting container with the same logs before the next ones are condensed"},
+	{Name: "crash_loop_window", Description: "how often condensed runs are summarized, and how long a run stays up to end the loop"},
+	{Name: "parse", Env: "PARSE", Description: "parse profiles to apply, true for all"},
+	{Name: "container_fields", Description: "networks, mounts and security to add fields with the networks, mount points and security context of the container"},
+	{Name: "container_mounts", Description: "patterns of the mount points added, all by default"},
+	{Name: "instance_fields", Env: "INSTANCE_FIELDS", Description: "version, instance and node of logspout to add to messages, true for all"},
+	{Name: "stats_interval", Description: "add container stats sampled at this interval"},
+	{Name: "stats_events", Description: "send container stats events at this interval instead of logs"},
+	{Name: "exec", Description: "command to pipe messages through as NDJSON, one of EXEC_COMMANDS"},
+	{Name: "exec_timeout", Description: "how long the command may take to answer a message"},
+	{Name: "exec_failure", Description: "pass or drop messages while the command fails"},
+	{Name: "lua", Description: "Lua script whose process function filters and transforms messages"},
+	{Name: "transforms", Description: "transforms messages pass through in order: rename, tag, rewrite, drop, redact, or one registered by a module"},
+	{Name: "transform.rename", Description: "old:new field names the rename transform renames"},
+	{Name: "transform.tags", Description: "key:value fields the tag transform adds"},
+	{Name: "transform.rewrite", Description: "regular expression the rewrite transform replaces in the message text"},
+	{Name: "transform.rewrite_replacement", Description: "replacement of the rewrite transform, with $1 for groups"},
+	{Name: "transform.drop", Description: "accessor:pattern pairs of the messages the drop transform drops"},
+	{Name: "transform.redact", Description: "patterns the redact transform masks: credit_card, bearer, email, password"},
+	{Name: "transform.redact.NAME", Description: "regular expression the redact transform masks too"},
+	{Name: "transform.redact_mask", Description: "text replacing what the redact transform masks, default [REDACTED]"},
+	{Name: "lua_timeout", Description: "how long the script may take for a message"},
+	{Name: "leader_only", Description: "true to only route messages on the instance elected with LEADER_ELECTION"},
+	{Name: "dedup", Description: "file to keep the hashes of the messages shipped in, to drop those shipped again after a restart"},
+	{Name: "dedup_size", Description: "latest messages of each container whose hashes are kept"},
+	{Name: "dedup_window", Description: "how long after a container is first seen its duplicates are dropped"},
+	{Name: "quota_bytes", Description: "bytes per hour or day, such as 10GB/day"},
+	{Name: "quota_messages", Description: "messages per hour or day, such as 100000/hour"},
+	{Name: "quota_action", Description: "drop, sample:N or reroute:ROUTE past the quota"},
+	{Name: "encrypt", Description: "aes-gcm to encrypt the message text"},
+	{Name: "encrypt_key_file", Description: "file with the encryption key"},
+	{Name: "sign", Description: "hmac-sha256 or ed25519 to sign messages"},
+	{Name: "sign_key_file", Description: "file with the signing key"},
+	{Name: "sign_key_id", Description: "key ID added to signed messages"},
+	{Name: "schema", Description: "JSON Schema file rendered messages must validate against"},
+	{Name: "dead_letter", Description: "file for the messages the adapter rejected"},
+	{Name: "dead_letter_max_bytes", Description: "size the dead-letter file stops growing at"},
+}
+
+func init() {
+	HTTPHandlers.Register(capabilitiesHandler, "adapters")
+}
+
+// DescribeAdapter records the transports and options of the adapter
+// registered as name, for /adapters
+func DescribeAdapter(name string, info AdapterInfo) {
+	adapterInfos.Lock()
+	defer adapterInfos.Unlock()
+	adapterInfos.infos[name] = info
+}
+
+// capabilities returns the registered adapters with their transports and
+// options, the registered transports, and the options of all routes
+func capabilities() map[string]interface{} {
+	transports := AdapterTransports.Names()
+	sort.Strings(transports)
+	adapterInfos.Lock()
+	defer adapterInfos.Unlock()
+	adapters := make([]adapterCapabilities, 0)
+	for _, name := range AdapterFactories.Names() {
+		info := adapterInfos.infos[name]
+		if info.Transports == nil {
+			info.Transports = transports
+		}
+		adapters = append(adapters, adapterCapabilities{Name: name, AdapterInfo: info})
+	}
+	sort.Slice(adapters, func(i, j int) bool { return adapters[i].Name < adapters[j].Name })
+	return map[string]interface{}{
+		"adapters":      adapters,
+		"transports":    transports,
+		"route_options": routeOptions,
+	}
+}
+
+func capabilitiesHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(capabilities()) //nolint:errcheck
+	})
+}
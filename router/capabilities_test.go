@@ -0,0 +1,70 @@
+package router
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type dummyTransport struct{}
+
+func (dummyTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	return nil, nil
+}
+
+func TestCapabilitiesHandler(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "dummy")
+	AdapterFactories.Register(newDummyAdapter, "described")
+	AdapterTransports.Register(dummyTransport{}, "dummytcp")
+	defer AdapterFactories.Unregister("dummy")
+	defer AdapterFactories.Unregister("described")
+	defer AdapterTransports.Unregister("dummytcp")
+	DescribeAdapter("described", AdapterInfo{
+		DefaultTransport: "dummytcp",
+		Transports:       []string{"dummytcp"},
+		Options:          []AdapterOption{{Name: "described_option", Env: "DESCRIBED_OPTION", Description: "an option"}},
+	})
+
+	recorder := httptest.NewRecorder()
+	capabilitiesHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/adapters", nil))
+	if ct := recorder.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("expected JSON, got %q", ct)
+	}
+	var got struct {
+		Adapters []struct {
+			Name       string          `json:"name"`
+			Transports []string        `json:"transports"`
+			Options    []AdapterOption `json:"options"`
+		} `json:"adapters"`
+		Transports   []string        `json:"transports"`
+		RouteOptions []AdapterOption `json:"route_options"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !sort.SliceIsSorted(got.Adapters, func(i, j int) bool { return got.Adapters[i].Name < got.Adapters[j].Name }) {
+		t.Errorf("expected the adapters sorted by name, got %+v", got.Adapters)
+	}
+	adapters := make(map[string]int)
+	for i, adapter := range got.Adapters {
+		adapters[adapter.Name] = i
+	}
+	i, ok := adapters["described"]
+	j, ok2 := adapters["dummy"]
+	if !ok || !ok2 {
+		t.Fatalf("expected the registered adapters, got %+v", got.Adapters)
+	}
+	described, dummy := got.Adapters[i], got.Adapters[j]
+	if len(described.Options) != 1 || described.Options[0].Env != "DESCRIBED_OPTION" {
+		t.Errorf("expected the described options, got %+v", described.Options)
+	}
+	if !reflect.DeepEqual(dummy.Transports, got.Transports) || len(got.Transports) == 0 {
+		t.Errorf("expected an undescribed adapter to list all transports %v, got %v", got.Transports, dummy.Transports)
+	}
+	if len(got.RouteOptions) != len(routeOptions) {
+		t.Errorf("expected %d route options, got %d", len(routeOptions), len(got.RouteOptions))
+	}
+}
@@ -0,0 +1,188 @@
+package router
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+var (
+	errChaosWrite      = errors.New("chaos: injected write error")
+	errChaosDisconnect = errors.New("chaos: injected disconnect")
+)
+
+// chaosConfig is the fault injection configured with CHAOS, to test how
+// routes cope with failing backends. Each write fails, disconnects or is
+// delayed with the configured probabilities.
+type chaosConfig struct {
+	delay      float64
+	maxDelay   time.Duration
+	errorRate  float64
+	disconnect float64
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+var chaos struct {
+	once   sync.Once
+	config *chaosConfig
+	err    error
+}
+
+// chaosEnabled returns the fault injection configured with CHAOS, or nil when
+// it is off
+func chaosEnabled() (*chaosConfig, error) {
+	chaos.once.Do(func() {
+		chaos.config, chaos.err = parseChaos(cfg.GetEnvDefault("CHAOS", ""), cfg.GetEnvDefault("CHAOS_SEED", ""))
+		if chaos.config != nil {
+			log.Println("chaos: injecting faults into adapter writes:", cfg.GetEnvDefault("CHAOS", ""))
+		}
+	})
+	return chaos.config, chaos.err
+}
+
+// parseChaos parses a comma separated list of delay=probability:max,
+// error=probability and disconnect=probability
+func parseChaos(s, seed string) (*chaosConfig, error) {
+	if s == "" {
+		return nil, nil
+	}
+	c := &chaosConfig{maxDelay: time.Second}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("bad CHAOS: " + part)
+		}
+		value := kv[1]
+		if kv[0] == "delay" {
+			if i := strings.Index(value, ":"); i >= 0 {
+				d, err := time.ParseDuration(value[i+1:])
+				if err != nil || d <= 0 {
+					return nil, errors.New("bad CHAOS delay: " + part)
+				}
+				c.maxDelay = d
+				value = value[:i]
+			}
+		}
+		p, err := strconv.ParseFloat(value, 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, errors.New("bad CHAOS probability: " + part)
+		}
+		switch kv[0] {
+		case "delay":
+			c.delay = p
+		case "error":
+			c.errorRate = p
+		case "disconnect":
+			c.disconnect = p
+		default:
+			return nil, errors.New("bad CHAOS fault: " + part)
+		}
+	}
+	n := time.Now().UnixNano()
+	if seed != "" {
+		var err error
+		if n, err = strconv.ParseInt(seed, 10, 64); err != nil {
+			return nil, errors.New("bad CHAOS_SEED: " + seed)
+		}
+	}
+	c.rand = rand.New(rand.NewSource(n)) //nolint:gosec
+	return c, nil
+}
+
+// fault picks the fault for a write: a delay, and an error that replaces the
+// write
+func (c *chaosConfig) fault() (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var delay time.Duration
+	if c.rand.Float64() < c.delay {
+		delay = time.Duration(c.rand.Int63n(int64(c.maxDelay)))
+	}
+	switch r := c.rand.Float64(); {
+	case r < c.disconnect:
+		return delay, errChaosDisconnect
+	case r < c.disconnect+c.errorRate:
+		return delay, errChaosWrite
+	}
+	return delay, nil
+}
+
+// chaosTransport injects faults into the connections of an AdapterTransport
+type chaosTransport struct {
+	AdapterTransport
+	config *chaosConfig
+}
+
+func (t chaosTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	conn, err := t.AdapterTransport.Dial(addr, options)
+	if err != nil {
+		return nil, err
+	}
+	return &chaosConn{Conn: conn, config: t.config}, nil
+}
+
+type chaosConn struct {
+	net.Conn
+	config *chaosConfig
+}
+
+// NetConn returns the connection under the fault injection of CHAOS, for
+// adapters that check the type of their connection
+func NetConn(conn net.Conn) net.Conn {
+	if c, ok := conn.(*chaosConn); ok {
+		return c.Conn
+	}
+	return conn
+}
+
+func (c *chaosConn) Write(b []byte) (int, error) {
+	delay, err := c.config.fault()
+	time.Sleep(delay)
+	if err == errChaosDisconnect {
+		c.Conn.Close()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+type chaosRoundTripper struct {
+	base   http.RoundTripper
+	config *chaosConfig
+}
+
+// ChaosRoundTripper wraps base with the fault injection configured with
+// CHAOS, for the HTTP clients of adapters. It returns base when that is off.
+func ChaosRoundTripper(base http.RoundTripper) http.RoundTripper {
+	config, _ := chaosEnabled()
+	if config == nil {
+		return base
+	}
+	return &chaosRoundTripper{base: base, config: config}
+}
+
+func (t *chaosRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	delay, err := t.config.fault()
+	time.Sleep(delay)
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		if closer, ok := t.base.(interface{ CloseIdleConnections() }); ok && err == errChaosDisconnect {
+			closer.CloseIdleConnections()
+		}
+		return nil, err
+	}
+	return t.base.RoundTrip(req)
+}
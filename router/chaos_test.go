@@ -0,0 +1,75 @@
+package router
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type pipeTransport struct {
+	server net.Conn
+}
+
+func (t *pipeTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	client, server := net.Pipe()
+	t.server = server
+	return client, nil
+}
+
+func TestParseChaos(t *testing.T) {
+	c, err := parseChaos("delay=0.5:200ms, error=0.1,disconnect=0.01", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.delay != 0.5 || c.maxDelay != 200*time.Millisecond || c.errorRate != 0.1 || c.disconnect != 0.01 {
+		t.Errorf("unexpected config %+v", c)
+	}
+	if c, err = parseChaos("", ""); c != nil || err != nil {
+		t.Errorf("expected chaos to be off by default, got %v %v", c, err)
+	}
+	for _, bad := range []string{"error", "error=2", "delay=0.1:soon", "explode=0.1"} {
+		if _, err = parseChaos(bad, ""); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestChaosConn(t *testing.T) {
+	c, err := parseChaos("disconnect=1", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipe := &pipeTransport{}
+	conn, err := chaosTransport{pipe, c}.Dial("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := NetConn(conn).(*chaosConn); ok {
+		t.Error("expected NetConn to return the wrapped connection")
+	}
+	if _, err = conn.Write([]byte("lost")); err != errChaosDisconnect {
+		t.Fatalf("expected an injected disconnect, got %v", err)
+	}
+	if _, err = pipe.server.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+
+	c, _ = parseChaos("error=0", "1")
+	conn, _ = chaosTransport{pipe, c}.Dial("", nil)
+	go conn.Write([]byte("kept")) //nolint:errcheck
+	buf := make([]byte, 4)
+	if _, err = pipe.server.Read(buf); err != nil || string(buf) != "kept" {
+		t.Errorf("expected writes to pass without faults, got %q %v", buf, err)
+	}
+}
+
+func TestChaosRoundTripper(t *testing.T) {
+	c, _ := parseChaos("error=1", "1")
+	rt := &chaosRoundTripper{base: http.DefaultTransport, config: c}
+	req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:1/", strings.NewReader("body"))
+	if _, err := rt.RoundTrip(req); err != errChaosWrite {
+		t.Errorf("expected an injected error, got %v", err)
+	}
+}
@@ -0,0 +1,191 @@
+package router
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	defaultNTPServer   = "pool.ntp.org"
+	defaultNTPInterval = 15 * time.Minute
+	ntpTimeout         = 5 * time.Second
+	// ntpEpochOffset is the number of seconds from the NTP epoch, 1900, to
+	// the Unix epoch
+	ntpEpochOffset = 2208988800
+)
+
+// clockStage corrects the timestamps of messages from a host whose clock is
+// off, by a fixed offset or by the offset measured against an NTP server
+type clockStage struct {
+	offset time.Duration
+	clock  *ntpClock
+}
+
+// newClockStage returns the stage for the time_offset option: a duration to
+// add to timestamps, or ntp or ntp:SERVER to add the offset to an NTP server
+func newClockStage(route *Route, s string) (stage, error) {
+	if s != "ntp" && !strings.HasPrefix(s, "ntp:") {
+		offset, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, errors.New("bad time_offset: " + s)
+		}
+		return &clockStage{offset: offset}, nil
+	}
+	server := strings.TrimPrefix(strings.TrimPrefix(s, "ntp"), ":")
+	if server == "" {
+		server = defaultNTPServer
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	interval := defaultNTPInterval
+	v := route.Options["time_offset_interval"]
+	if v == "" {
+		v = cfg.GetEnvDefault("TIME_OFFSET_INTERVAL", "")
+	}
+	if v != "" {
+		var err error
+		if interval, err = time.ParseDuration(v); err != nil || interval < time.Minute {
+			return nil, errors.New("bad time_offset_interval: " + v)
+		}
+	}
+	return &clockStage{clock: sharedNTPClock(server, interval)}, nil
+}
+
+func (s *clockStage) process(message *Message) *Message {
+	offset := s.offset
+	if s.clock != nil {
+		offset = s.clock.get()
+	}
+	if offset == 0 {
+		return message
+	}
+	corrected := *message
+	corrected.Time = message.Time.Add(offset)
+	return &corrected
+}
+
+// ntpClock is the offset of the host clock to an NTP server, measured again
+// every interval when it is used
+type ntpClock struct {
+	server   string
+	interval time.Duration
+	query    func(server string) (time.Duration, error)
+
+	mu        sync.Mutex
+	offset    time.Duration
+	measured  time.Time
+	measuring bool
+}
+
+// ntpClocks are shared by server and interval, so routes using the same
+// server measure the offset once
+var ntpClocks = struct {
+	sync.Mutex
+	clocks map[string]*ntpClock
+}{clocks: make(map[string]*ntpClock)}
+
+func sharedNTPClock(server string, interval time.Duration) *ntpClock {
+	ntpClocks.Lock()
+	defer ntpClocks.Unlock()
+	key := server + "/" + interval.String()
+	clock, ok := ntpClocks.clocks[key]
+	if !ok {
+		clock = &ntpClock{server: server, interval: interval, query: queryNTP}
+		ntpClocks.clocks[key] = clock
+	}
+	return clock
+}
+
+// get returns the last measured offset, starting a new measurement when it is
+// out of date. The offset is 0 until the first measurement succeeds.
+func (c *ntpClock) get() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.measuring && time.Since(c.measured) >= c.interval {
+		c.measuring = true
+		go c.measure()
+	}
+	return c.offset
+}
+
+func (c *ntpClock) measure() {
+	offset, err := c.query(c.server)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.measuring = false
+	c.measured = time.Now()
+	if err != nil {
+		debug("ntp:", c.server, err)
+		// try again in a minute rather than a whole interval
+		c.measured = c.measured.Add(time.Minute - c.interval)
+		return
+	}
+	if offset.Round(time.Second) != c.offset.Round(time.Second) {
+		debug("ntp: clock offset to", c.server, "is", offset)
+	}
+	c.offset = offset
+}
+
+// queryNTP returns the offset of the host clock to the NTP server, from a
+// single SNTP (RFC 4330) request
+func queryNTP(server string) (time.Duration, error) {
+	conn, err := net.DialTimeout("udp", server, ntpTimeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ntpTimeout)) //nolint:errcheck
+	request := make([]byte, 48)
+	// leap indicator 0, version 4, client mode
+	request[0] = 0x23
+	sent := time.Now()
+	if _, err = conn.Write(request); err != nil {
+		return 0, err
+	}
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	received := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	return ntpOffset(response[:n], sent, received)
+}
+
+// ntpOffset returns the clock offset from an NTP response to a request sent
+// and received at the given local times
+func ntpOffset(response []byte, sent, received time.Time) (time.Duration, error) {
+	if len(response) < 48 {
+		return 0, errors.New("short NTP response")
+	}
+	if mode := response[0] & 0x7; mode != 4 {
+		return 0, errors.New("NTP response is not from a server")
+	}
+	if response[1] == 0 {
+		return 0, errors.New("NTP server sent kiss-o'-death " + strings.TrimRight(string(response[12:16]), "\x00"))
+	}
+	serverReceived := ntpTime(response[32:40])
+	serverSent := ntpTime(response[40:48])
+	if serverSent.IsZero() {
+		return 0, errors.New("NTP response has no transmit time")
+	}
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+// ntpTime returns the time of an NTP timestamp: seconds since 1900 and a
+// fraction of a second, both 32 bits
+func ntpTime(b []byte) time.Time {
+	seconds := binary.BigEndian.Uint32(b[:4])
+	fraction := binary.BigEndian.Uint32(b[4:])
+	if seconds == 0 && fraction == 0 {
+		return time.Time{}
+	}
+	nanos := (int64(fraction)*1e9 + 1<<31) >> 32
+	return time.Unix(int64(seconds)-ntpEpochOffset, nanos)
+}
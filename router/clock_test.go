@@ -0,0 +1,94 @@
+package router
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func putNTPTime(b []byte, t time.Time) {
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()+ntpEpochOffset))
+	binary.BigEndian.PutUint32(b[4:], uint32((int64(t.Nanosecond())<<32)/1e9))
+}
+
+// fakeNTPServer answers SNTP requests with a clock that is ahead by skew
+func fakeNTPServer(t *testing.T, skew time.Duration) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		request := make([]byte, 48)
+		for {
+			_, addr, err := conn.ReadFrom(request)
+			if err != nil {
+				return
+			}
+			response := make([]byte, 48)
+			response[0] = 0x24 // version 4, server mode
+			response[1] = 2
+			now := time.Now().Add(skew)
+			putNTPTime(response[32:40], now)
+			putNTPTime(response[40:48], now)
+			conn.WriteTo(response, addr) //nolint:errcheck
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestQueryNTP(t *testing.T) {
+	offset, err := queryNTP(fakeNTPServer(t, 3*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset < 2900*time.Millisecond || offset > 3100*time.Millisecond {
+		t.Errorf("expected an offset of about 3s, got %v", offset)
+	}
+
+	response := make([]byte, 48)
+	response[0] = 0x24
+	copy(response[12:], "RATE")
+	if _, err = ntpOffset(response, time.Now(), time.Now()); err == nil {
+		t.Error("expected error for a kiss-o'-death response")
+	}
+	if _, err = ntpOffset(response[:20], time.Now(), time.Now()); err == nil {
+		t.Error("expected error for a short response")
+	}
+}
+
+func TestClockStage(t *testing.T) {
+	s, err := newClockStage(&Route{}, "-2s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	message := &Message{Data: "hello", Time: now}
+	if out := s.process(message); !out.Time.Equal(now.Add(-2*time.Second)) || message.Time != now {
+		t.Errorf("expected a corrected copy, got %v", out.Time.Sub(now))
+	}
+
+	server := fakeNTPServer(t, time.Minute)
+	s, err = newClockStage(&Route{}, "ntp:"+server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := s.process(message); out != message {
+		t.Error("expected messages to pass unchanged until the offset is measured")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.process(message) == message && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d := s.process(message).Time.Sub(now); d < 59*time.Second || d > 61*time.Second {
+		t.Errorf("expected the measured offset of a minute, got %v", d)
+	}
+
+	if _, err = newStages(&Route{Options: map[string]string{"time_offset": "soon"}}); err == nil {
+		t.Error("expected error for a bad offset")
+	}
+	if _, err = newStages(&Route{Options: map[string]string{"time_offset": "ntp", "time_offset_interval": "1s"}}); err == nil {
+		t.Error("expected error for an interval under a minute")
+	}
+}
@@ -0,0 +1,152 @@
+package router
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// routesSchemaID is the $id of the schema, for editors to tell it apart
+const routesSchemaID = "https://github.com/gliderlabs/logspout/routes.schema.json"
+
+// RoutesSchema returns the JSON Schema of a route, as persisted in the
+// routes directory, and of the lists of routes /routes/export returns and
+// /routes/import takes, with the registered adapters and the options of all
+// routes and of the described adapters. Options not in the schema are
+// allowed, as adapters and modules may read options they don't describe.
+func RoutesSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"$id":         routesSchemaID,
+		"title":       "logspout routes",
+		"description": "a route, or a list of routes",
+		"oneOf": []interface{}{
+			map[string]interface{}{"$ref": "#/definitions/route"},
+			map[string]interface{}{"type": "array", "items": map[string]interface{}{"$ref": "#/definitions/route"}},
+		},
+		"definitions": map[string]interface{}{"route": routeSchema()},
+	}
+}
+
+func routeSchema() map[string]interface{} {
+	str := func(description string) map[string]interface{} {
+		return map[string]interface{}{"type": "string", "description": description}
+	}
+	list := func(description string) map[string]interface{} {
+		return map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}, "description": description}
+	}
+	adapters := AdapterFactories.Names()
+	sort.Strings(adapters)
+	transports := AdapterTransports.Names()
+	sort.Strings(transports)
+	// the adapter is given with its transport, as in syslog+tls
+	adapter := "^(" + quoteNames(adapters) + ")"
+	if len(transports) > 0 {
+		adapter += `(\+(` + quoteNames(transports) + "))?"
+	}
+	adapter += "$"
+	adapterInfos.Lock()
+	defer adapterInfos.Unlock()
+	var conditions []interface{}
+	for _, name := range adapters {
+		info, ok := adapterInfos.infos[name]
+		if !ok || len(info.Options) == 0 {
+			continue
+		}
+		conditions = append(conditions, map[string]interface{}{
+			"if": map[string]interface{}{
+				"properties": map[string]interface{}{"adapter": map[string]interface{}{"pattern": "^" + regexp.QuoteMeta(name) + `(\+|$)`}},
+				"required":   []interface{}{"adapter"},
+			},
+			"then": map[string]interface{}{
+				"properties": map[string]interface{}{"options": optionsSchema(info.Options)},
+			},
+		})
+	}
+	route := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"adapter", "address"},
+		"properties": map[string]interface{}{
+			"id":                     str("ID of the route, generated when empty"),
+			"name":                   str("name of the route"),
+			"description":            str("description of the route"),
+			"filter_id":              str("only route the container with this ID prefix"),
+			"filter_name":            str("only route containers with a name matching this pattern"),
+			"filter_sources":         list("only route these sources, stdout or stderr"),
+			"filter_labels":          list("only route containers with labels matching these key:pattern pairs"),
+			"filter_networks":        list("only route containers on a network matching one of these patterns"),
+			"filter_ips":             list("only route containers with an address in one of these networks"),
+			"filter_compose_project": str("only route containers of a Docker Compose project matching this pattern"),
+			"filter_compose_service": str("only route containers of a Docker Compose service matching this pattern"),
+			"adapter": map[string]interface{}{
+				"type":        "string",
+				"pattern":     adapter,
+				"description": "adapter of the route, with its transport as in syslog+tls",
+			},
+			"address": str("address of the backend"),
+			"path":    str("path of the route URI"),
+			"user": map[string]interface{}{
+				"type":        "object",
+				"description": "user info of the route URI",
+				"required":    []interface{}{"username"},
+				"properties": map[string]interface{}{
+					"username": map[string]interface{}{"type": "string"},
+					"password": map[string]interface{}{"type": "string"},
+				},
+				"additionalProperties": false,
+			},
+			"options": optionsSchema(routeOptions),
+			"paused": map[string]interface{}{
+				"type":        "boolean",
+				"description": "whether the route is paused",
+			},
+		},
+		"additionalProperties": false,
+	}
+	if len(conditions) > 0 {
+		route["allOf"] = conditions
+	}
+	return route
+}
+
+// optionsSchema returns the schema of route options, strings like in route
+// URIs. A NAME part of an option name, as in transform.redact.NAME, stands
+// for any name.
+func optionsSchema(options []AdapterOption) map[string]interface{} {
+	properties := make(map[string]interface{})
+	patterns := make(map[string]interface{})
+	for _, option := range options {
+		description := option.Description
+		if option.Env != "" {
+			description += ", default from " + option.Env
+		}
+		schema := map[string]interface{}{"type": "string", "description": description}
+		if strings.Contains(option.Name, "NAME") {
+			parts := strings.Split(option.Name, "NAME")
+			for i, part := range parts {
+				parts[i] = regexp.QuoteMeta(part)
+			}
+			patterns["^"+strings.Join(parts, ".+")+"$"] = schema
+			continue
+		}
+		properties[option.Name] = schema
+	}
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": map[string]interface{}{"type": "string"},
+	}
+	if len(patterns) > 0 {
+		schema["patternProperties"] = patterns
+	}
+	return schema
+}
+
+// quoteNames returns the alternatives of a regular expression matching names
+func quoteNames(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	return strings.Join(quoted, "|")
+}
@@ -0,0 +1,45 @@
+package router
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+func TestRoutesSchema(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "described")
+	AdapterTransports.Register(dummyTransport{}, "dummytcp")
+	defer AdapterFactories.Unregister("described")
+	defer AdapterTransports.Unregister("dummytcp")
+	DescribeAdapter("described", AdapterInfo{
+		Options: []AdapterOption{{Name: "described_option", Description: "an option"}},
+	})
+	data, err := json.Marshal(RoutesSchema())
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for document, valid := range map[string]bool{
+		`{"id":"abc","adapter":"described+dummytcp","address":"logs:514","options":{"filter.match":"x","transform.redact.ssn":"\\d+"}}`: true,
+		`[{"adapter":"described","address":"logs:514","user":{"username":"u","password":"p"},"paused":true}]`:                           true,
+		`{"adapter":"described","address":"logs:514","options":{"undescribed":"kept"}}`:                                                 true,
+		`{"adapter":"missing","address":"logs:514"}`:                                                                                    false,
+		`{"adapter":"described+udp","address":"logs:514"}`:                                                                              false,
+		`{"adapter":"described"}`: false,
+		`{"adapter":"described","address":"logs:514","filter_sources":"stdout"}`:        false,
+		`{"adapter":"described","address":"logs:514","options":{"described_option":1}}`: false,
+		`{"adapter":"described","address":"logs:514","adress":"typo"}`:                  false,
+	} {
+		result, err := schema.Validate(gojsonschema.NewStringLoader(document))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Valid() != valid {
+			t.Errorf("%s: expected valid %v, got %v", document, valid, result.Errors())
+		}
+	}
+}
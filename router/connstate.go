@@ -0,0 +1,72 @@
+package router
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Connection states reported by adapters with SetConnState
+const (
+	ConnConnected = "connected"
+	ConnBackoff   = "backoff"
+	ConnFailed    = "failed"
+)
+
+// ConnState is the connection state of the adapter of a route, kept for the
+// lifetime of the route so the last error stays visible after it recovers
+type ConnState struct {
+	Route         string    `json:"route"`
+	Adapter       string    `json:"adapter"`
+	Address       string    `json:"address"`
+	State         string    `json:"state"`
+	Since         time.Time `json:"since"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastErrorTime time.Time `json:"last_error_time,omitempty"`
+}
+
+var connStates = struct {
+	sync.Mutex
+	routes map[string]*ConnState
+}{routes: make(map[string]*ConnState)}
+
+// SetConnState records the connection state of the adapter of the route.
+// Since only changes with the state, so adapters can call it after every
+// write. A non-nil err becomes the last error.
+func (r *Route) SetConnState(state string, err error) {
+	now := time.Now()
+	connStates.Lock()
+	defer connStates.Unlock()
+	s, ok := connStates.routes[r.ID]
+	if !ok {
+		s = &ConnState{Route: r.ID, Adapter: r.Adapter, Address: r.Address}
+		connStates.routes[r.ID] = s
+	}
+	if s.State != state {
+		s.State = state
+		s.Since = now
+	}
+	if err != nil {
+		s.LastError = err.Error()
+		s.LastErrorTime = now
+	}
+}
+
+// ConnStates returns the connection states of the adapters of all routes,
+// ordered by route
+func ConnStates() []ConnState {
+	connStates.Lock()
+	defer connStates.Unlock()
+	states := make([]ConnState, 0, len(connStates.routes))
+	for _, s := range connStates.routes {
+		states = append(states, *s)
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Route < states[j].Route })
+	return states
+}
+
+func removeConnState(id string) {
+	connStates.Lock()
+	defer connStates.Unlock()
+	delete(connStates.routes, id)
+}
@@ -0,0 +1,40 @@
+package router
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestConnState(t *testing.T) {
+	route := &Route{ID: "connstate", Adapter: "gelf", Address: "graylog:12201"}
+	defer removeConnState(route.ID)
+
+	route.SetConnState(ConnBackoff, errors.New("connection refused"))
+	first := connState(t, route.ID)
+	route.SetConnState(ConnBackoff, errors.New("connection refused again"))
+	second := connState(t, route.ID)
+	if !second.Since.Equal(first.Since) || second.LastError != "connection refused again" {
+		t.Errorf("expected since to stay and the last error to change, got %+v", second)
+	}
+	route.SetConnState(ConnConnected, nil)
+	connected := connState(t, route.ID)
+	if connected.State != ConnConnected || connected.LastError != "connection refused again" {
+		t.Errorf("expected the last error to stay visible after recovering, got %+v", connected)
+	}
+	removeConnState(route.ID)
+	for _, s := range ConnStates() {
+		if s.Route == route.ID {
+			t.Error("expected the state of a removed route to be gone")
+		}
+	}
+}
+
+func connState(t *testing.T, id string) ConnState {
+	for _, s := range ConnStates() {
+		if s.Route == id {
+			return s
+		}
+	}
+	t.Fatalf("no state for route %s", id)
+	return ConnState{}
+}
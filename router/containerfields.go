@@ -0,0 +1,197 @@
+package router
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	containerFieldsNetworks = "networks"
+	containerFieldsMounts   = "mounts"
+	containerFieldsSecurity = "security"
+	maxContainerFields      = 1024
+)
+
+// containerFieldsStage adds fields with the networks, mounts and security
+// context of the container of each message, for pipelines that have to know
+// where a workload could talk to or write, and with which privileges. The
+// fields of each container are built once. It is only used from the
+// runStages goroutine of its route.
+type containerFieldsStage struct {
+	networks bool
+	mounts   bool
+	security bool
+	// destinations are the patterns of the mount points to add, all of them
+	// when empty
+	destinations []string
+	containers   map[string]map[string]string
+}
+
+// newContainerFieldsStage returns the stage for the container_fields option,
+// a comma separated list of networks, mounts and security
+func newContainerFieldsStage(route *Route) (stage, error) {
+	s := &containerFieldsStage{containers: make(map[string]map[string]string)}
+	for _, name := range strings.Split(route.Options["container_fields"], ",") {
+		switch strings.TrimSpace(name) {
+		case containerFieldsNetworks:
+			s.networks = true
+		case containerFieldsMounts:
+			s.mounts = true
+		case containerFieldsSecurity:
+			s.security = true
+		default:
+			return nil, errors.New("bad container_fields: " + route.Options["container_fields"])
+		}
+	}
+	if mounts := route.Options["container_mounts"]; mounts != "" {
+		for _, pattern := range strings.Split(mounts, ",") {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.New("bad container_mounts: " + mounts)
+			}
+			s.destinations = append(s.destinations, pattern)
+		}
+	}
+	return s, nil
+}
+
+func (s *containerFieldsStage) process(message *Message) *Message {
+	if message.Container == nil {
+		return message
+	}
+	fields, ok := s.containers[message.Container.ID]
+	if !ok {
+		if len(s.containers) >= maxContainerFields {
+			s.containers = make(map[string]map[string]string)
+		}
+		fields = s.fields(message.Container)
+		s.containers[message.Container.ID] = fields
+	}
+	return message.WithFields(fields)
+}
+
+func (s *containerFieldsStage) fields(container *docker.Container) map[string]string {
+	fields := make(map[string]string)
+	if s.networks && container.NetworkSettings != nil {
+		var names, ips []string
+		for name := range container.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			network := container.NetworkSettings.Networks[name]
+			for _, ip := range []string{network.IPAddress, network.GlobalIPv6Address} {
+				if ip != "" {
+					ips = append(ips, ip)
+				}
+			}
+		}
+		if len(names) > 0 {
+			fields["networks"] = strings.Join(names, ",")
+		}
+		if len(ips) > 0 {
+			fields["ips"] = strings.Join(ips, ",")
+		}
+	}
+	if s.mounts {
+		var mounts []string
+		for _, mount := range container.Mounts {
+			if !s.selected(mount.Destination) {
+				continue
+			}
+			source := mount.Source
+			if mount.Name != "" {
+				source = mount.Name
+			}
+			mode := "ro"
+			if mount.RW {
+				mode = "rw"
+			}
+			mounts = append(mounts, source+":"+mount.Destination+":"+mode)
+		}
+		if len(mounts) > 0 {
+			sort.Strings(mounts)
+			fields["mounts"] = strings.Join(mounts, ",")
+		}
+	}
+	if s.security {
+		securityFields(container, fields)
+	}
+	return fields
+}
+
+// securityFields adds the user, privileges and confinement of container to
+// fields
+func securityFields(container *docker.Container, fields map[string]string) {
+	user := "root"
+	if container.Config != nil && container.Config.User != "" {
+		user = container.Config.User
+	}
+	fields["user"] = user
+	apparmor := container.AppArmorProfile
+	seccomp := "default"
+	noNewPrivileges := false
+	if host := container.HostConfig; host != nil {
+		fields["privileged"] = strconv.FormatBool(host.Privileged)
+		fields["read_only"] = strconv.FormatBool(host.ReadonlyRootfs)
+		if caps := append(append([]string(nil), host.CapAdd...), host.Capabilities...); len(caps) > 0 {
+			fields["cap_add"] = strings.Join(caps, ",")
+		}
+		if len(host.CapDrop) > 0 {
+			fields["cap_drop"] = strings.Join(host.CapDrop, ",")
+		}
+		if host.UsernsMode != "" {
+			fields["userns_mode"] = host.UsernsMode
+		}
+		for _, opt := range host.SecurityOpt {
+			// options are given as key=value, or as key:value by older clients
+			key, value := opt, ""
+			if i := strings.IndexAny(opt, "=:"); i >= 0 {
+				key, value = opt[:i], opt[i+1:]
+			}
+			switch key {
+			case "seccomp":
+				seccomp = value
+				// the profile is inlined as JSON when it is given as a file
+				if strings.HasPrefix(value, "{") {
+					seccomp = "custom"
+				}
+			case "apparmor":
+				apparmor = value
+			case "no-new-privileges":
+				noNewPrivileges = value == "" || value == "true"
+			}
+		}
+		// privileged containers run without a seccomp profile, whatever the
+		// options say
+		if host.Privileged {
+			seccomp = "unconfined"
+		}
+	}
+	fields["seccomp"] = seccomp
+	if apparmor != "" {
+		fields["apparmor"] = apparmor
+	}
+	fields["no_new_privileges"] = strconv.FormatBool(noNewPrivileges)
+}
+
+// selected returns whether the mount point destination is one to add
+func (s *containerFieldsStage) selected(destination string) bool {
+	if len(s.destinations) == 0 {
+		return true
+	}
+	for _, pattern := range s.destinations {
+		if ok, _ := path.Match(pattern, destination); ok {
+			return true
+		}
+		// a pattern also selects the mount points below it
+		if strings.HasPrefix(destination, strings.TrimSuffix(pattern, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
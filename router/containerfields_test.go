@@ -0,0 +1,122 @@
+package router
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestContainerFieldsStage(t *testing.T) {
+	container := &docker.Container{
+		ID: "abc",
+		NetworkSettings: &docker.NetworkSettings{Networks: map[string]docker.ContainerNetwork{
+			"frontend": {IPAddress: "10.0.1.5", GlobalIPv6Address: "2001:db8::5"},
+			"backend":  {IPAddress: "172.18.0.3"},
+		}},
+		Mounts: []docker.Mount{
+			{Source: "/var/run/docker.sock", Destination: "/var/run/docker.sock", RW: true},
+			{Name: "data", Source: "/var/lib/docker/volumes/data/_data", Destination: "/data", RW: true},
+			{Source: "/etc/app", Destination: "/etc/app", RW: false},
+		},
+	}
+	for _, tt := range []struct {
+		options map[string]string
+		fields  map[string]string
+	}{
+		{map[string]string{"container_fields": "networks"}, map[string]string{
+			"networks": "backend,frontend",
+			"ips":      "172.18.0.3,10.0.1.5,2001:db8::5",
+		}},
+		{map[string]string{"container_fields": "mounts"}, map[string]string{
+			"mounts": "/etc/app:/etc/app:ro,/var/run/docker.sock:/var/run/docker.sock:rw,data:/data:rw",
+		}},
+		{map[string]string{"container_fields": "mounts", "container_mounts": "/var/run,/data"}, map[string]string{
+			"mounts": "/var/run/docker.sock:/var/run/docker.sock:rw,data:/data:rw",
+		}},
+	} {
+		s, err := newContainerFieldsStage(&Route{Options: tt.options})
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := &Message{Container: container, Data: "hello"}
+		got := s.process(original)
+		if len(got.Fields) != len(tt.fields) {
+			t.Errorf("%v: expected %v, got %v", tt.options, tt.fields, got.Fields)
+		}
+		for name, value := range tt.fields {
+			if got.Fields[name] != value {
+				t.Errorf("%v: expected %s=%q, got %q", tt.options, name, value, got.Fields[name])
+			}
+		}
+		if original.Fields != nil {
+			t.Error("expected the original message to be left as it is")
+		}
+	}
+}
+
+func TestContainerFieldsOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"container_fields": "networks,env"},
+		{"container_fields": "mounts", "container_mounts": "[/data"},
+	} {
+		if _, err := newContainerFieldsStage(&Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
+
+func TestContainerSecurityFields(t *testing.T) {
+	s, err := newContainerFieldsStage(&Route{Options: map[string]string{"container_fields": "security"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		container *docker.Container
+		fields    map[string]string
+	}{
+		{&docker.Container{
+			ID:              "a",
+			Config:          &docker.Config{User: "1000:1000"},
+			AppArmorProfile: "docker-default",
+			HostConfig: &docker.HostConfig{
+				CapAdd:         []string{"NET_ADMIN"},
+				CapDrop:        []string{"ALL"},
+				ReadonlyRootfs: true,
+				UsernsMode:     "host",
+				SecurityOpt:    []string{"no-new-privileges", `seccomp={"defaultAction":"SCMP_ACT_ERRNO"}`},
+			},
+		}, map[string]string{
+			"user":              "1000:1000",
+			"privileged":        "false",
+			"read_only":         "true",
+			"cap_add":           "NET_ADMIN",
+			"cap_drop":          "ALL",
+			"userns_mode":       "host",
+			"seccomp":           "custom",
+			"apparmor":          "docker-default",
+			"no_new_privileges": "true",
+		}},
+		{&docker.Container{
+			ID:         "b",
+			Config:     &docker.Config{},
+			HostConfig: &docker.HostConfig{Privileged: true, SecurityOpt: []string{"apparmor:unconfined"}},
+		}, map[string]string{
+			"user":              "root",
+			"privileged":        "true",
+			"read_only":         "false",
+			"seccomp":           "unconfined",
+			"apparmor":          "unconfined",
+			"no_new_privileges": "false",
+		}},
+	} {
+		got := s.process(&Message{Container: tt.container})
+		if len(got.Fields) != len(tt.fields) {
+			t.Errorf("%s: expected %v, got %v", tt.container.ID, tt.fields, got.Fields)
+		}
+		for name, value := range tt.fields {
+			if got.Fields[name] != value {
+				t.Errorf("%s: expected %s=%q, got %q", tt.container.ID, name, value, got.Fields[name])
+			}
+		}
+	}
+}
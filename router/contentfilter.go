@@ -0,0 +1,63 @@
+package router
+
+import (
+	"errors"
+	"io"
+	"regexp"
+)
+
+// newContentFilterStage returns the stage for the filter.match and
+// filter.exclude options, regular expressions of the message text: messages
+// not matching match, or matching exclude, are dropped, like health checks
+// and access logs too noisy to ship. Either can be empty.
+func newContentFilterStage(match, exclude string) (stage, error) {
+	var include, drop *regexp.Regexp
+	var err error
+	if match != "" {
+		if include, err = regexp.Compile(match); err != nil {
+			return nil, errors.New("bad filter.match: " + err.Error())
+		}
+	}
+	if exclude != "" {
+		if drop, err = regexp.Compile(exclude); err != nil {
+			return nil, errors.New("bad filter.exclude: " + err.Error())
+		}
+	}
+	return stageFunc(func(message *Message) *Message {
+		if include != nil && !include.MatchString(message.Data) {
+			return nil
+		}
+		if drop != nil && drop.MatchString(message.Data) {
+			return nil
+		}
+		return message
+	}), nil
+}
+
+// filterCounter counts the messages its stage drops as filtered in the
+// metrics of route
+type filterCounter struct {
+	stage stage
+	route *Route
+}
+
+// countFiltered returns s counting the messages it drops
+func countFiltered(route *Route, s stage) stage {
+	return &filterCounter{stage: s, route: route}
+}
+
+func (c *filterCounter) process(message *Message) *Message {
+	out := c.stage.process(message)
+	if out == nil {
+		c.route.Metrics().Filtered(1)
+	}
+	return out
+}
+
+// Close closes the stage when it holds resources
+func (c *filterCounter) Close() error {
+	if closer, ok := c.stage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package router
+
+import "testing"
+
+func TestContentFilterStage(t *testing.T) {
+	route := &Route{ID: "content-filter", Options: map[string]string{
+		"filter.match":   `^(GET|POST) `,
+		"filter.exclude": `^GET /healthz `,
+	}}
+	defer removeMetrics("content-filter")
+	stages, err := newStages(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		data string
+		pass bool
+	}{
+		{"GET /orders 200", true},
+		{"POST /orders 201", true},
+		{"GET /healthz 200", false},
+		{"starting up", false},
+	} {
+		message := &Message{Data: tc.data}
+		for _, s := range stages {
+			if message = s.process(message); message == nil {
+				break
+			}
+		}
+		if (message != nil) != tc.pass {
+			t.Errorf("%q: expected passed %v", tc.data, tc.pass)
+		}
+	}
+	if filtered := route.Metrics().Snapshot().Filtered; filtered != 2 {
+		t.Errorf("expected 2 filtered messages, got %d", filtered)
+	}
+	for _, options := range []map[string]string{{"filter.match": "("}, {"filter.exclude": "[a"}} {
+		if _, err := newStages(&Route{Options: options}); err == nil {
+			t.Errorf("%v: expected an error", options)
+		}
+	}
+}
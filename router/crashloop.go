@@ -0,0 +1,269 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	defaultCrashLoopWindow = time.Minute
+	// crashLoopGiveUp is how many windows the lines of a run are held after
+	// the container died without starting again
+	crashLoopGiveUp = 10
+	// maxCrashLoopLines is the most lines of a run held back; a run logging
+	// more isn't crashing at startup
+	maxCrashLoopLines = 1000
+)
+
+// containerRuns counts the start events of each container, and whether it
+// died since, so the crash loop stage can tell its runs apart
+var containerRuns = struct {
+	sync.Mutex
+	m map[string]*containerRunState
+}{m: make(map[string]*containerRunState)}
+
+type containerRunState struct {
+	starts int
+	dead   bool
+}
+
+// noteContainerEvent records a start, die or destroy event of a container
+func noteContainerEvent(id, status string) {
+	id = normalID(id)
+	containerRuns.Lock()
+	defer containerRuns.Unlock()
+	state := containerRuns.m[id]
+	if state == nil {
+		state = &containerRunState{}
+		containerRuns.m[id] = state
+	}
+	switch status {
+	case pumpEventStatusStartName:
+		state.starts++
+		state.dead = false
+	case pumpEventStatusDieName:
+		state.dead = true
+	case pumpEventStatusDestroyName:
+		delete(containerRuns.m, id)
+	}
+}
+
+// containerRun returns the number of the current run of a container, and
+// whether it died
+func containerRun(id string) (int, bool) {
+	containerRuns.Lock()
+	defer containerRuns.Unlock()
+	if state := containerRuns.m[normalID(id)]; state != nil {
+		return state.starts, state.dead
+	}
+	return 0, false
+}
+
+// crashLoop is the state of a container for the crash loop stage
+type crashLoop struct {
+	container *docker.Container
+	run       int
+	// fingerprint is of the lines of the run so far, and previous of the
+	// whole previous run
+	fingerprint hash.Hash64
+	lines       int
+	previous    uint64
+	// repeats is the number of runs in a row the same as the one before,
+	// and condensed the number of those dropped since the last summary
+	repeats    int
+	condensed  int
+	summarized time.Time
+	// held are the lines of the run, and lastRun those of the latest run
+	// condensed, passed on as the last run of the loop when it ends
+	held    []*Message
+	lastRun []*Message
+	// started and last are when the run logged its first and last lines
+	started time.Time
+	last    time.Time
+}
+
+// crashLoopStage condenses the logs of containers in a restart loop. Once
+// crash_loop runs of a container in a row logged the same lines, the lines of
+// its next runs are held back, and dropped when the run turns out to log the
+// same lines again. A summary of the runs dropped is sent every
+// crash_loop_window, and when the loop ends the lines of its last run are
+// passed on, followed by those of the run ending it: when a run logs other
+// lines, stays up for crash_loop_window, or
+// the container doesn't start again for crashLoopGiveUp windows. Digits are
+// ignored when comparing lines, so timestamps and process IDs don't tell runs
+// apart. It is only used from the runStages goroutine of its route.
+type crashLoopStage struct {
+	repeats    int
+	window     time.Duration
+	containers map[string]*crashLoop
+	// released are the messages passed on at the next flush, in order
+	released []*Message
+}
+
+// newCrashLoopStage returns the stage of the crash_loop options of route
+func newCrashLoopStage(route *Route) (*crashLoopStage, error) {
+	s := &crashLoopStage{window: defaultCrashLoopWindow, containers: make(map[string]*crashLoop)}
+	v := route.Options["crash_loop"]
+	repeats, err := strconv.Atoi(v)
+	if err != nil || repeats < 1 {
+		return nil, errors.New("bad crash_loop: " + v)
+	}
+	s.repeats = repeats
+	if v := route.Options["crash_loop_window"]; v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			return nil, errors.New("bad crash_loop_window: " + v)
+		}
+		s.window = window
+	}
+	return s, nil
+}
+
+func (s *crashLoopStage) process(message *Message) *Message {
+	if message.Container == nil || message.Replay {
+		return message
+	}
+	id := message.Container.ID
+	run, _ := containerRun(id)
+	c := s.containers[id]
+	if c == nil {
+		c = &crashLoop{container: message.Container, run: run, fingerprint: fnv.New64a()}
+		s.containers[id] = c
+	}
+	now := time.Now()
+	if run != c.run {
+		s.endRun(c, now)
+		c.run = run
+		c.container = message.Container
+	}
+	if c.lines == 0 {
+		c.started = now
+	}
+	c.last = now
+	c.lines++
+	c.fingerprint.Write([]byte(message.Source))   //nolint:errcheck
+	c.fingerprint.Write(maskDigits(message.Data)) //nolint:errcheck
+	c.fingerprint.Write([]byte{0})                //nolint:errcheck
+	if c.repeats >= s.repeats {
+		if len(c.held) < maxCrashLoopLines {
+			c.held = append(c.held, message)
+			return nil
+		}
+		// too long for a crash at startup
+		s.release(c, now)
+		c.repeats = 0
+	}
+	if len(s.released) > 0 {
+		// behind the messages released before it
+		s.released = append(s.released, message)
+		return nil
+	}
+	return message
+}
+
+// endRun compares the run of c that ended with the one before it, dropping
+// its held lines when they are the same
+func (s *crashLoopStage) endRun(c *crashLoop, now time.Time) {
+	sum := c.fingerprint.Sum64()
+	same := c.lines > 0 && sum == c.previous
+	c.previous = sum
+	c.fingerprint.Reset()
+	c.lines = 0
+	if !same {
+		// the loop ended, or never started
+		s.release(c, now)
+		c.repeats = 0
+		return
+	}
+	if c.repeats++; c.repeats == s.repeats {
+		log.Printf("crash_loop: %s restarted %d times with the same logs, condensing its runs", normalName(c.container.Name), c.repeats)
+		c.summarized = now
+	}
+	if c.held != nil {
+		if c.lastRun != nil {
+			c.condensed++
+		}
+		c.lastRun, c.held = c.held, nil
+	}
+	if c.condensed > 0 && now.Sub(c.summarized) >= s.window {
+		s.released = append(s.released, s.summary(c, now))
+	}
+}
+
+// release passes on the summary of the runs of c dropped, and the lines of
+// its last run condensed and of its run held back
+func (s *crashLoopStage) release(c *crashLoop, now time.Time) {
+	if c.condensed > 0 {
+		s.released = append(s.released, s.summary(c, now))
+	}
+	s.released = append(s.released, c.lastRun...)
+	s.released = append(s.released, c.held...)
+	c.lastRun, c.held = nil, nil
+}
+
+func (s *crashLoopStage) summary(c *crashLoop, now time.Time) *Message {
+	runs := "runs"
+	if c.condensed == 1 {
+		runs = "run"
+	}
+	message := &Message{
+		Container: c.container,
+		Source:    "stderr",
+		Data:      fmt.Sprintf("crash loop: %d %s with the same logs condensed", c.condensed, runs),
+		Time:      now,
+		Fields:    map[string]string{"crash_loop_runs": strconv.Itoa(c.condensed)},
+	}
+	c.condensed = 0
+	c.summarized = now
+	return message
+}
+
+// flush passes on the released messages, and the lines of the runs no run
+// followed for the window, as the last runs of their loops
+func (s *crashLoopStage) flush(now time.Time) []*Message {
+	if now.IsZero() {
+		for _, c := range s.containers {
+			s.release(c, time.Now())
+		}
+		return s.take()
+	}
+	for id, c := range s.containers {
+		_, dead := containerRun(id)
+		switch {
+		case len(c.held)+len(c.lastRun) > 0 && (!dead && now.Sub(c.started) >= s.window ||
+			dead && now.Sub(c.last) >= crashLoopGiveUp*s.window):
+			s.release(c, now)
+			c.repeats = 0
+		case len(c.held) == 0 && now.Sub(c.last) >= crashLoopGiveUp*s.window:
+			// forget the containers that stopped logging
+			s.release(c, now)
+			delete(s.containers, id)
+		}
+	}
+	return s.take()
+}
+
+func (s *crashLoopStage) take() []*Message {
+	released := s.released
+	s.released = nil
+	return released
+}
+
+// maskDigits returns s with its digits replaced by 0
+func maskDigits(s string) []byte {
+	masked := []byte(s)
+	for i, b := range masked {
+		if b >= '1' && b <= '9' {
+			masked[i] = '0'
+		}
+	}
+	return masked
+}
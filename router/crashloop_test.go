@@ -0,0 +1,63 @@
+package router
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestCrashLoopStage(t *testing.T) {
+	id := "c0ffee000001"
+	defer noteContainerEvent(id, pumpEventStatusDestroyName)
+	s, err := newCrashLoopStage(&Route{Options: map[string]string{"crash_loop": "2", "crash_loop_window": "1h"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := &docker.Container{ID: id, Name: "/api"}
+	var passed []string
+	run := func(lines ...string) {
+		noteContainerEvent(id, pumpEventStatusStartName)
+		for _, line := range lines {
+			if m := s.process(&Message{Container: container, Source: "stderr", Data: line}); m != nil {
+				passed = append(passed, m.Data)
+			}
+		}
+		noteContainerEvent(id, pumpEventStatusDieName)
+	}
+	for i := 1; i <= 5; i++ {
+		// the pid doesn't tell the runs apart
+		run("starting pid "+strconv.Itoa(i), "panic: no config")
+	}
+	if len(passed) != 6 {
+		t.Fatalf("expected the lines of the first 3 runs, got %q", passed)
+	}
+	run("starting pid 6", "listening")
+	run("serving")
+	for _, m := range s.flush(time.Now()) {
+		passed = append(passed, m.Data)
+	}
+	// the 4th run is condensed, the 5th is the last of the loop
+	expected := []string{
+		"crash loop: 1 run with the same logs condensed",
+		"starting pid 5", "panic: no config",
+		"starting pid 6", "listening",
+		"serving",
+	}
+	if got := passed[6:]; len(got) != len(expected) {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+	for i, data := range expected {
+		if passed[6+i] != data {
+			t.Errorf("expected %q, got %q", expected, passed[6:])
+			break
+		}
+	}
+
+	for _, options := range []map[string]string{{"crash_loop": "0"}, {"crash_loop": "3", "crash_loop_window": "soon"}} {
+		if _, err := newCrashLoopStage(&Route{Options: options}); err == nil {
+			t.Errorf("%v: expected an error", options)
+		}
+	}
+}
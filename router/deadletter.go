@@ -0,0 +1,126 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const defaultDeadLetterMaxBytes = 10 << 20
+
+// DeadLetter appends messages an adapter rejected to the file set with the
+// dead_letter route option, one JSON object per line. Once the file reaches
+// dead_letter_max_bytes further messages are discarded.
+type DeadLetter struct {
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	max      int64
+	full     bool
+	route    string
+	rejected int
+}
+
+type deadLetterEntry struct {
+	Time      time.Time `json:"time"`
+	Route     string    `json:"route"`
+	Container string    `json:"container,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Error     string    `json:"error"`
+	Data      string    `json:"data"`
+	Rendered  string    `json:"rendered,omitempty"`
+}
+
+// NewDeadLetter opens the dead-letter file of route. It returns nil when the
+// route has none, in which case rejected messages are only counted.
+func NewDeadLetter(route *Route) (*DeadLetter, error) {
+	d := &DeadLetter{max: defaultDeadLetterMaxBytes, route: route.String()}
+	if s := route.Options["dead_letter_max_bytes"]; s != "" {
+		max, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || max < 1 {
+			return nil, errors.New("bad dead_letter_max_bytes: " + s)
+		}
+		d.max = max
+	}
+	path := cfg.DataPath(route.Options["dead_letter"])
+	if path == "" {
+		return d, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	d.file = file
+	d.size = info.Size()
+	return d, nil
+}
+
+// Write records message, as rendered by the adapter, together with the reason
+// it was rejected
+func (d *DeadLetter) Write(message *Message, rendered []byte, reason error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.rejected++
+	if d.file == nil || d.full {
+		debug("deadletter:", d.route, "rejected message:", reason)
+		return
+	}
+	entry := deadLetterEntry{
+		Time:     message.Time,
+		Route:    d.route,
+		Source:   message.Source,
+		Error:    reason.Error(),
+		Data:     message.Data,
+		Rendered: string(rendered),
+	}
+	if message.Container != nil {
+		entry.Container = message.Container.Name
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Println("deadletter:", d.route, err)
+		return
+	}
+	line = append(line, '\n')
+	if d.size+int64(len(line)) > d.max {
+		log.Println("deadletter:", d.route, "file is full, discarding rejected messages")
+		d.full = true
+		return
+	}
+	n, err := d.file.Write(line)
+	d.size += int64(n)
+	if err != nil {
+		log.Println("deadletter:", d.route, err)
+	}
+}
+
+// Rejected returns the number of messages written to the dead-letter path,
+// including discarded ones
+func (d *DeadLetter) Rejected() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rejected
+}
+
+// Close closes the dead-letter file
+func (d *DeadLetter) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.file == nil {
+		return nil
+	}
+	err := d.file.Close()
+	d.file = nil
+	return err
+}
@@ -0,0 +1,262 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	defaultDedupSize     = 10000
+	defaultDedupWindow   = time.Minute
+	dedupSaveInterval    = 10 * time.Second
+	dedupContainerMaxAge = 24 * time.Hour
+	// dedupHashes and dedupBitsPerMessage size the filters for about 1% of
+	// false positives
+	dedupHashes         = 7
+	dedupBitsPerMessage = 10
+)
+
+// bloomFilter is a bloom filter of message hashes
+type bloomFilter []byte
+
+func newBloomFilter(messages int) bloomFilter {
+	return make(bloomFilter, (messages*dedupBitsPerMessage+7)/8)
+}
+
+// bits calls fn with the byte and bit of each of the bits of h, by double
+// hashing
+func (f bloomFilter) bits(h uint64, fn func(i int, bit byte)) {
+	n := uint64(len(f)) * 8
+	h1, h2 := h&0xffffffff, h>>32|1
+	for i := uint64(0); i < dedupHashes; i++ {
+		b := (h1 + i*h2) % n
+		fn(int(b/8), 1<<(b%8))
+	}
+}
+
+func (f bloomFilter) add(h uint64) {
+	f.bits(h, func(i int, bit byte) { f[i] |= bit })
+}
+
+func (f bloomFilter) has(h uint64) bool {
+	if len(f) == 0 {
+		return false
+	}
+	has := true
+	f.bits(h, func(i int, bit byte) { has = has && f[i]&bit != 0 })
+	return has
+}
+
+// dedupContainer is the filters of the messages a container shipped: those
+// of the current generation, up to the size of the stage, and of the one
+// before, so at least size of its latest messages are known
+type dedupContainer struct {
+	Seen     time.Time   `json:"seen"`
+	Count    int         `json:"count"`
+	Current  bloomFilter `json:"current"`
+	Previous bloomFilter `json:"previous,omitempty"`
+}
+
+// dedupStage drops the messages a container shipped before, as when a
+// restart with BACKLOG=true or the json-file source reads its logs again. It
+// keeps the hashes of the latest dedup_size messages of each container in
+// bloom filters, saved to the dedup file, and only drops the messages of the
+// first dedup_window after the route sees a container, as later messages
+// are new even when they repeat a line. The filters have false positives,
+// so one message in about a hundred repeated in the window is dropped.
+// Replayed messages are always passed.
+type dedupStage struct {
+	path   string
+	size   int
+	window time.Duration
+
+	mu         sync.Mutex
+	containers map[string]*dedupContainer
+	// started is when the route saw each container first since it started
+	started map[string]time.Time
+	dirty   bool
+	dropped int
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// newDedupStage returns the stage of the dedup option of route, the file its
+// filters are kept in, taken in LOGSPOUT_DATA_DIR when relative
+func newDedupStage(route *Route) (*dedupStage, error) {
+	s := &dedupStage{
+		path:       cfg.DataPath(route.Options["dedup"]),
+		size:       defaultDedupSize,
+		window:     defaultDedupWindow,
+		containers: make(map[string]*dedupContainer),
+		started:    make(map[string]time.Time),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if v := route.Options["dedup_size"]; v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			return nil, errors.New("bad dedup_size: " + v)
+		}
+		s.size = size
+	}
+	if v := route.Options["dedup_window"]; v != "" {
+		window, err := time.ParseDuration(v)
+		if err != nil || window <= 0 {
+			return nil, errors.New("bad dedup_window: " + v)
+		}
+		s.window = window
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	go s.saveEvery()
+	return s, nil
+}
+
+func (s *dedupStage) load() error {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, &s.containers); err != nil {
+		return errors.New("dedup: " + s.path + ": " + err.Error())
+	}
+	for id, c := range s.containers {
+		// filters of another dedup_size are started over
+		if len(c.Current) != len(newBloomFilter(s.size)) {
+			delete(s.containers, id)
+		}
+	}
+	return nil
+}
+
+func (s *dedupStage) process(message *Message) *Message {
+	if message.Container == nil || message.Replay {
+		return message
+	}
+	h := fnv.New64a()
+	h.Write([]byte(message.Source)) //nolint:errcheck
+	h.Write([]byte{0})              //nolint:errcheck
+	h.Write([]byte(message.Data))   //nolint:errcheck
+	sum := h.Sum64()
+	now := time.Now()
+	id := message.Container.ID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	started, ok := s.started[id]
+	if !ok {
+		started = now
+		s.started[id] = now
+	}
+	c := s.containers[id]
+	if c == nil {
+		c = &dedupContainer{Current: newBloomFilter(s.size)}
+		s.containers[id] = c
+	}
+	if now.Sub(started) < s.window && (c.Current.has(sum) || c.Previous.has(sum)) {
+		if s.dropped++; s.dropped == 1 {
+			log.Printf("dedup: dropping messages of %s shipped before", normalID(id))
+		}
+		return nil
+	}
+	c.Current.add(sum)
+	if c.Count++; c.Count >= s.size {
+		c.Previous, c.Current, c.Count = c.Current, newBloomFilter(s.size), 0
+	}
+	c.Seen = now
+	s.dirty = true
+	return message
+}
+
+// saveEvery saves the filters every dedupSaveInterval, and when the stage is
+// closed
+func (s *dedupStage) saveEvery() {
+	defer close(s.done)
+	ticker := time.NewTicker(dedupSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.quit:
+			if err := s.save(); err != nil {
+				log.Println("dedup:", err)
+			}
+			return
+		}
+		if err := s.save(); err != nil {
+			log.Println("dedup:", err)
+		}
+	}
+}
+
+// save writes the filters of the containers seen in the last day to a
+// temporary file that replaces the dedup file, like the ledger
+func (s *dedupStage) save() error {
+	s.mu.Lock()
+	if s.dropped > 0 {
+		log.Println("dedup: dropped", s.dropped, "messages shipped before")
+		s.dropped = 0
+	}
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	for id, c := range s.containers {
+		if time.Since(c.Seen) > dedupContainerMaxAge {
+			delete(s.containers, id)
+			delete(s.started, id)
+		}
+	}
+	data, err := json.Marshal(s.containers)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Close saves the filters when the route is removed
+func (s *dedupStage) Close() error {
+	select {
+	case <-s.quit:
+	default:
+		close(s.quit)
+	}
+	<-s.done
+	return nil
+}
@@ -0,0 +1,85 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f := newBloomFilter(1000)
+	for h := uint64(0); h < 1000; h++ {
+		f.add(h * 0x9e3779b97f4a7c15)
+	}
+	falsePositives := 0
+	for h := uint64(0); h < 1000; h++ {
+		if !f.has(h * 0x9e3779b97f4a7c15) {
+			t.Fatalf("expected %d to be in the filter", h)
+		}
+		if f.has((h + 1000) * 0x9e3779b97f4a7c15) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 30 {
+		t.Errorf("expected about 1%% of false positives, got %d in 1000", falsePositives)
+	}
+}
+
+func TestDedupStage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dedup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	route := &Route{Options: map[string]string{"dedup": filepath.Join(dir, "dedup.json"), "dedup_size": "100"}}
+	container := &docker.Container{ID: "8dfafdbc3a40"}
+	passed := func(s stage, data ...string) []string {
+		var got []string
+		for _, d := range data {
+			if m := s.process(&Message{Container: container, Source: "stdout", Data: d}); m != nil {
+				got = append(got, m.Data)
+			}
+		}
+		return got
+	}
+
+	s, err := newDedupStage(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := passed(s, "one", "two", "three"); len(got) != 3 {
+		t.Errorf("expected all new messages, got %q", got)
+	}
+	s.Close()
+
+	// after a restart, the latest messages read again are dropped
+	s, err = newDedupStage(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := passed(s, "one", "two", "three", "four"); len(got) != 1 || got[0] != "four" {
+		t.Errorf("expected only the new message, got %q", got)
+	}
+	if m := s.process(&Message{Container: container, Source: "stdout", Data: "four", Replay: true}); m == nil {
+		t.Error("expected replayed messages to pass")
+	}
+	// past the window repeated lines are new messages
+	s.started[container.ID] = time.Now().Add(-2 * defaultDedupWindow)
+	if got := passed(s, "four"); len(got) != 1 {
+		t.Errorf("expected repeated messages to pass after the window, got %q", got)
+	}
+	s.Close()
+
+	for _, options := range []map[string]string{
+		{"dedup": filepath.Join(dir, "bad.json"), "dedup_size": "0"},
+		{"dedup": filepath.Join(dir, "bad.json"), "dedup_window": "soon"},
+	} {
+		if _, err := newDedupStage(&Route{Options: options}); err == nil {
+			t.Errorf("%v: expected an error", options)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package router
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// dockerSocket is the socket of a system Docker daemon
+var dockerSocket = "/var/run/docker.sock"
+
+// newDockerClient returns the client for DOCKER_HOST, or for the rootless
+// Docker socket of the user when DOCKER_HOST isn't set and there is no
+// system socket
+func newDockerClient() (*docker.Client, error) {
+	if os.Getenv("DOCKER_HOST") == "" {
+		if socket := rootlessSocket(); socket != "" {
+			debug("pump: using the rootless Docker socket", socket)
+			return docker.NewClient("unix://" + socket)
+		}
+	}
+	return docker.NewClientFromEnv()
+}
+
+// rootlessSocket returns the socket of a rootless Docker daemon, or "" when
+// the system socket exists or there is no rootless one
+func rootlessSocket() string {
+	if _, err := os.Stat(dockerSocket); err == nil {
+		return ""
+	}
+	var candidates []string
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, "docker.sock"))
+	}
+	candidates = append(candidates, filepath.Join("/run/user", strconv.Itoa(os.Getuid()), "docker.sock"))
+	for _, socket := range candidates {
+		if info, err := os.Stat(socket); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return socket
+		}
+	}
+	return ""
+}
+
+// endpointDenied returns whether err means the Docker API doesn't serve an
+// endpoint at all, as a docker-socket-proxy answers for the endpoints it
+// doesn't allow, rather than that a request failed
+func endpointDenied(err error) bool {
+	if e, ok := err.(*docker.Error); ok {
+		switch e.Status {
+		case http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+			return true
+		}
+	}
+	return false
+}
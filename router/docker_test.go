@@ -0,0 +1,53 @@
+package router
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRootlessSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rootless")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(socket, runtime string) {
+		dockerSocket = socket
+		os.Setenv("XDG_RUNTIME_DIR", runtime)
+	}(dockerSocket, os.Getenv("XDG_RUNTIME_DIR"))
+	dockerSocket = filepath.Join(dir, "missing.sock")
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+	if socket := rootlessSocket(); socket != "" {
+		t.Errorf("expected no rootless socket, got %s", socket)
+	}
+	l, err := net.Listen("unix", filepath.Join(dir, "docker.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if socket := rootlessSocket(); socket != filepath.Join(dir, "docker.sock") {
+		t.Errorf("expected the socket in XDG_RUNTIME_DIR, got %q", socket)
+	}
+	dockerSocket = filepath.Join(dir, "docker.sock")
+	if socket := rootlessSocket(); socket != "" {
+		t.Errorf("expected the system socket to be used, got %s", socket)
+	}
+}
+
+func TestStatsDenied(t *testing.T) {
+	rt := &FakeRoundTripper{message: map[string]string{"message": "forbidden"}, status: http.StatusForbidden}
+	client := newTestClient(rt)
+	p := &LogsPump{client: &client}
+	for i := 0; i < 2; i++ {
+		if _, err := p.Stats("abc"); err != errStatsUnavailable {
+			t.Fatalf("expected %v, got %v", errStatsUnavailable, err)
+		}
+	}
+	if len(rt.requests) != 1 {
+		t.Errorf("expected stats to be asked for once, got %d requests", len(rt.requests))
+	}
+}
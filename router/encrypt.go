@@ -0,0 +1,69 @@
+package router
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const encryptAESGCM = "aes-gcm"
+
+// readKey reads a key kept hex or base64 encoded in filename, such as a
+// mounted secret
+func readKey(option, filename string) ([]byte, error) {
+	if filename == "" {
+		return nil, errors.New(option + " is required")
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	s := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(s); err == nil {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return key, nil
+	}
+	return nil, errors.New(option + ": " + filename + " is neither hex nor base64")
+}
+
+// newEncryptStage returns a stage that encrypts the Data of messages with
+// AES-GCM, for backends that pass logs through parties that shouldn't read
+// them. The container, source, time and fields stay readable for routing.
+// Data becomes the base64 encoded nonce followed by the ciphertext.
+func newEncryptStage(mode, keyFile string) (stage, error) {
+	if mode != encryptAESGCM {
+		return nil, errors.New("bad encrypt: " + mode)
+	}
+	key, err := readKey("encrypt_key_file", keyFile)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.New("encrypt_key_file: " + err.Error())
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	fields := map[string]string{"encrypted": encryptAESGCM}
+	return stageFunc(func(message *Message) *Message {
+		nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(message.Data)+aead.Overhead())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			debug("encrypt: dropping message:", err)
+			return nil
+		}
+		sealed := aead.Seal(nonce, nonce, []byte(message.Data), nil)
+		encrypted := message.WithFields(fields)
+		encrypted.Data = base64.StdEncoding.EncodeToString(sealed)
+		return encrypted
+	}), nil
+}
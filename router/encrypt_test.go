@@ -0,0 +1,65 @@
+package router
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeKeyFile(t *testing.T, dir, content string) string {
+	filename := filepath.Join(dir, "key")
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestEncryptStage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := []byte("0123456789abcdef0123456789abcdef")
+	s, err := newEncryptStage("aes-gcm", writeKeyFile(t, dir, base64.StdEncoding.EncodeToString(key)+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := imageContainer("abc", "app")
+	original := &Message{Container: container, Source: "stdout", Data: "secret"}
+	encrypted := s.process(original)
+	if encrypted.Fields["encrypted"] != "aes-gcm" || encrypted.Container != container || original.Data != "secret" {
+		t.Fatalf("expected an encrypted copy with the metadata, got %+v", encrypted)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encrypted.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil || string(plain) != "secret" {
+		t.Errorf("expected to decrypt the payload, got %q %v", plain, err)
+	}
+	if again := s.process(original); again.Data == encrypted.Data {
+		t.Error("expected a new nonce for each message")
+	}
+
+	for _, test := range []struct{ mode, key string }{
+		{"rot13", "00"},
+		{"aes-gcm", "abcd"},
+		{"aes-gcm", "not a key!"},
+	} {
+		if _, err := newEncryptStage(test.mode, writeKeyFile(t, dir, test.key)); err == nil {
+			t.Errorf("expected error for %s with key %q", test.mode, test.key)
+		}
+	}
+	if _, err := newEncryptStage("aes-gcm", ""); err == nil {
+		t.Error("expected error without a key file")
+	}
+}
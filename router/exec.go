@@ -0,0 +1,261 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	defaultExecTimeout = 5 * time.Second
+	minExecBackoff     = time.Second
+	maxExecBackoff     = 30 * time.Second
+	// maxExecLine bounds the lines read from the command
+	maxExecLine = 1 << 20
+)
+
+// execMessage is a message as the command reads and writes it, one JSON
+// object per line
+type execMessage struct {
+	Time      *time.Time        `json:"time,omitempty"`
+	Source    *string           `json:"source,omitempty"`
+	Data      *string           `json:"data,omitempty"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Container *execContainer    `json:"container,omitempty"`
+	Replay    bool              `json:"replay,omitempty"`
+}
+
+type execContainer struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Image string `json:"image,omitempty"`
+}
+
+// execStage pipes messages through an external command, which answers every
+// message it reads on stdin with a line on stdout: the message, changed as it
+// likes, or null to drop it. Keys left out of the answer keep their value.
+// The command is started again when it exits or doesn't answer in time, with
+// a growing delay; messages meanwhile pass unchanged, or are dropped with
+// exec_failure=drop.
+type execStage struct {
+	command []string
+	timeout time.Duration
+	drop    bool
+
+	// mu guards the command against Close, as messages are processed on the
+	// goroutine of the route
+	mu      sync.Mutex
+	closed  bool
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	lines   chan []byte
+	backoff time.Duration
+	retry   time.Time
+}
+
+func newExecStage(route *Route) (*execStage, error) {
+	s := &execStage{
+		command: strings.Fields(route.Options["exec"]),
+		timeout: defaultExecTimeout,
+		backoff: minExecBackoff,
+	}
+	if len(s.command) == 0 {
+		return nil, errors.New("bad exec: " + route.Options["exec"])
+	}
+	if !execAllowed(s.command[0]) {
+		return nil, errors.New("exec: " + s.command[0] + " is not one of the EXEC_COMMANDS")
+	}
+	if v := route.Options["exec_timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, errors.New("bad exec_timeout: " + v)
+		}
+		s.timeout = d
+	}
+	switch v := route.Options["exec_failure"]; v {
+	case "", "pass":
+	case "drop":
+		s.drop = true
+	default:
+		return nil, errors.New("bad exec_failure: " + v)
+	}
+	if _, err := exec.LookPath(s.command[0]); err != nil {
+		return nil, errors.New("exec: " + err.Error())
+	}
+	return s, nil
+}
+
+// execAllowed returns whether command is one of the comma separated commands
+// of EXEC_COMMANDS. Routes can be added through the API and by the
+// controller, and logspout usually has the Docker socket, so only the
+// commands the operator allows can be run, none by default.
+func execAllowed(command string) bool {
+	for _, allowed := range strings.Split(cfg.GetEnvDefault("EXEC_COMMANDS", ""), ",") {
+		if allowed = strings.TrimSpace(allowed); allowed != "" && filepath.Clean(allowed) == filepath.Clean(command) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *execStage) start() error {
+	cmd := exec.Command(s.command[0], s.command[1:]...) //nolint:gosec
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(stdout)
+		scanner.Buffer(make([]byte, 64*1024), maxExecLine)
+		for scanner.Scan() {
+			lines <- append([]byte(nil), scanner.Bytes()...)
+		}
+	}()
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Printf("exec: %s: %s", s.command[0], scanner.Text())
+		}
+	}()
+	s.cmd, s.stdin, s.lines = cmd, stdin, lines
+	return nil
+}
+
+// stop kills the command and waits for it to exit. Wait closes the pipes, so
+// children of the command that keep them open don't hold up the route.
+func (s *execStage) stop() {
+	if s.cmd == nil {
+		return
+	}
+	s.stdin.Close()
+	s.cmd.Process.Kill() //nolint:errcheck
+	s.cmd.Wait()         //nolint:errcheck
+	for range s.lines {
+	}
+	s.cmd = nil
+}
+
+// fail stops the command after err, to start it again after the backoff
+func (s *execStage) fail(err error) {
+	log.Printf("exec: %s: %v, restarting in %v", s.command[0], err, s.backoff)
+	s.stop()
+	s.retry = time.Now().Add(s.backoff)
+	if s.backoff *= 2; s.backoff > maxExecBackoff {
+		s.backoff = maxExecBackoff
+	}
+}
+
+func (s *execStage) failed(message *Message) *Message {
+	if s.drop {
+		return nil
+	}
+	return message
+}
+
+func (s *execStage) process(message *Message) *Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return message
+	}
+	if s.cmd == nil {
+		if time.Now().Before(s.retry) {
+			return s.failed(message)
+		}
+		if err := s.start(); err != nil {
+			s.fail(err)
+			return s.failed(message)
+		}
+	}
+	line, err := json.Marshal(newExecMessage(message))
+	if err != nil {
+		return s.failed(message)
+	}
+	if _, err = s.stdin.Write(append(line, '\n')); err != nil {
+		s.fail(err)
+		return s.failed(message)
+	}
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case answer, ok := <-s.lines:
+		if !ok {
+			s.fail(errors.New("command exited"))
+			return s.failed(message)
+		}
+		s.backoff = minExecBackoff
+		return applyExecAnswer(message, answer)
+	case <-timer.C:
+		s.fail(errors.New("no answer within " + s.timeout.String()))
+		return s.failed(message)
+	}
+}
+
+// Close stops the command when the route is removed
+func (s *execStage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	s.stop()
+	return nil
+}
+
+func newExecMessage(m *Message) *execMessage {
+	e := &execMessage{Time: &m.Time, Source: &m.Source, Data: &m.Data, Fields: m.Fields, Replay: m.Replay}
+	if m.Container != nil {
+		e.Container = &execContainer{ID: m.Container.ID, Name: strings.TrimPrefix(m.Container.Name, "/")}
+		if m.Container.Config != nil {
+			e.Container.Image = m.Container.Config.Image
+		}
+	}
+	return e
+}
+
+// applyExecAnswer returns a copy of message changed by the answer of the
+// command, nil for null, or message itself for an answer that isn't valid
+func applyExecAnswer(message *Message, answer []byte) *Message {
+	var e *execMessage
+	if err := json.Unmarshal(answer, &e); err != nil {
+		debug("exec: bad answer:", err)
+		return message
+	}
+	if e == nil {
+		return nil
+	}
+	changed := *message
+	if e.Time != nil {
+		changed.Time = *e.Time
+	}
+	if e.Source != nil {
+		changed.Source = *e.Source
+	}
+	if e.Data != nil {
+		changed.Data = *e.Data
+	}
+	if e.Fields != nil {
+		changed.Fields = e.Fields
+	}
+	return &changed
+}
@@ -0,0 +1,94 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const execTestScript = `#!/bin/sh
+while read -r line; do
+	case "$line" in
+	*drop*) echo null ;;
+	*hang*) sleep 5 ;;
+	*) echo '{"data":"changed","fields":{"hook":"1"}}' ;;
+	esac
+done
+`
+
+func execTestRoute(t *testing.T, options map[string]string) (*execStage, func()) {
+	dir, err := ioutil.TempDir("", "exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "hook.sh")
+	if err = ioutil.WriteFile(script, []byte(execTestScript), 0700); err != nil {
+		t.Fatal(err)
+	}
+	options["exec"] = script
+	os.Setenv("EXEC_COMMANDS", "/bin/true,"+script)
+	s, err := newExecStage(&Route{Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.Unsetenv("EXEC_COMMANDS")
+		os.RemoveAll(dir)
+	}
+}
+
+func TestExecStage(t *testing.T) {
+	s, cleanup := execTestRoute(t, map[string]string{"exec_timeout": "200ms"})
+	defer cleanup()
+
+	message := &Message{Data: "hello", Source: "stdout"}
+	out := s.process(message)
+	if out == nil || out.Data != "changed" || out.Fields["hook"] != "1" || out.Source != "stdout" {
+		t.Errorf("expected the message changed by the command, got %+v", out)
+	}
+	if message.Data != "hello" {
+		t.Error("expected the original message to be left as is")
+	}
+	if out = s.process(&Message{Data: "please drop"}); out != nil {
+		t.Errorf("expected null to drop the message, got %+v", out)
+	}
+	hang := &Message{Data: "hang"}
+	if out = s.process(hang); out != hang {
+		t.Errorf("expected a message the command doesn't answer to pass, got %+v", out)
+	}
+	if s.cmd != nil {
+		t.Error("expected the command to be stopped after the timeout")
+	}
+	if out = s.process(message); out != message {
+		t.Errorf("expected messages to pass until the command is restarted, got %+v", out)
+	}
+}
+
+func TestExecStageFailureDrop(t *testing.T) {
+	s, cleanup := execTestRoute(t, map[string]string{"exec_timeout": "200ms", "exec_failure": "drop"})
+	defer cleanup()
+	if out := s.process(&Message{Data: "hang"}); out != nil {
+		t.Errorf("expected exec_failure=drop to drop the message, got %+v", out)
+	}
+}
+
+func TestExecStageOptions(t *testing.T) {
+	os.Setenv("EXEC_COMMANDS", "/no/such/command,cat")
+	defer os.Unsetenv("EXEC_COMMANDS")
+	for _, options := range []map[string]string{
+		{"exec": "/no/such/command"},
+		{"exec": "sh -c true"},
+		{"exec": "cat", "exec_timeout": "never"},
+		{"exec": "cat", "exec_failure": "retry"},
+	} {
+		if _, err := newStages(&Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+	os.Unsetenv("EXEC_COMMANDS")
+	if _, err := newStages(&Route{Options: map[string]string{"exec": "cat"}}); err == nil {
+		t.Error("expected exec to be rejected without EXEC_COMMANDS")
+	}
+}
@@ -226,6 +226,9 @@ func (ep *adapterTransportExt) Lookup(name string) (AdapterTransport, bool) {
 	if !ok {
 		return nil, ok
 	}
+	if config, _ := chaosEnabled(); config != nil {
+		return chaosTransport{ext.(AdapterTransport), config}, ok
+	}
 	return ext.(AdapterTransport), ok
 }
 
@@ -328,3 +331,45 @@ func (ep *logRouterExt) Names() []string {
 	}
 	return names
 }
+
+// TransformFactory
+
+var TransformFactories = &transformFactoryExt{
+	newExtensionPoint(new(TransformFactory)),
+}
+
+type transformFactoryExt struct {
+	*extensionPoint
+}
+
+func (ep *transformFactoryExt) Unregister(name string) bool {
+	return ep.unregister(name)
+}
+
+func (ep *transformFactoryExt) Register(component TransformFactory, name string) bool {
+	return ep.register(component, name)
+}
+
+func (ep *transformFactoryExt) Lookup(name string) (TransformFactory, bool) {
+	ext, ok := ep.lookup(name)
+	if !ok {
+		return nil, ok
+	}
+	return ext.(TransformFactory), ok
+}
+
+func (ep *transformFactoryExt) All() map[string]TransformFactory {
+	all := make(map[string]TransformFactory)
+	for k, v := range ep.all() {
+		all[k] = v.(TransformFactory)
+	}
+	return all
+}
+
+func (ep *transformFactoryExt) Names() []string {
+	var names []string
+	for k := range ep.all() {
+		names = append(names, k)
+	}
+	return names
+}
@@ -0,0 +1,225 @@
+package router
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	defaultHostnameProviders = "file,env,template"
+	defaultHostnameFile      = "/etc/host_hostname"
+	// defaultHostnameEnv is the variable the node name is usually given in
+	// by the Kubernetes downward API
+	defaultHostnameEnv = "NODE_NAME"
+	// hostnameTimeout bounds the requests of the cloud and docker providers
+	hostnameTimeout = 2 * time.Second
+)
+
+// HostnameProvider returns the name of the host logspout runs on, or "" when
+// it doesn't know it
+type HostnameProvider func() (string, error)
+
+var hostnameProviders = struct {
+	sync.Mutex
+	providers map[string]HostnameProvider
+}{providers: map[string]HostnameProvider{
+	"file":     fileHostname,
+	"env":      envHostname,
+	"cloud":    cloudHostname,
+	"docker":   dockerHostname,
+	"template": templateHostname,
+	"os":       os.Hostname,
+}}
+
+var hostname = struct {
+	once     sync.Once
+	name     string
+	provider string
+}{}
+
+// metadataURL is the metadata server of the cloud provider, which EC2, GCE
+// and Azure all serve at the link local address
+var metadataURL = "http://169.254.169.254"
+
+// RegisterHostnameProvider adds a provider HOSTNAME_PROVIDERS can name, or
+// replaces the one of name
+func RegisterHostnameProvider(name string, provider HostnameProvider) {
+	hostnameProviders.Lock()
+	defer hostnameProviders.Unlock()
+	hostnameProviders.providers[name] = provider
+}
+
+// Hostname returns the name of the host logspout runs on, from the first of
+// the HOSTNAME_PROVIDERS that knows it, or "" when none does, in which case
+// adapters use the hostname of the container of each message. It is resolved
+// once and kept.
+func Hostname() string {
+	hostname.once.Do(func() {
+		providers := cfg.GetEnvDefault("HOSTNAME_PROVIDERS", defaultHostnameProviders)
+		hostname.name, hostname.provider = resolveHostname(providers)
+		if hostname.name == "" {
+			log.Printf("hostname: none of the HOSTNAME_PROVIDERS %s knows the host, using the hostnames of containers", providers)
+			return
+		}
+		log.Printf("hostname: %s, from %s", hostname.name, hostname.provider)
+	})
+	return hostname.name
+}
+
+// resolveHostname returns the hostname of the first of the comma separated
+// providers that knows it, and that provider. Providers that fail are
+// logged and skipped.
+func resolveHostname(providers string) (string, string) {
+	for _, name := range strings.Split(providers, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		hostnameProviders.Lock()
+		provider, ok := hostnameProviders.providers[name]
+		hostnameProviders.Unlock()
+		if !ok {
+			log.Println("hostname: unknown provider:", name)
+			continue
+		}
+		host, err := provider()
+		if err != nil {
+			log.Printf("hostname: provider %s failed: %v", name, err)
+			continue
+		}
+		if host = strings.TrimSpace(host); host != "" {
+			return host, name
+		}
+		debug("hostname: provider", name, "doesn't know the host")
+	}
+	return "", ""
+}
+
+// fileHostname reads HOSTNAME_FILE, like the /etc/hostname of the host
+// mounted readonly. A missing file is no error.
+func fileHostname() (string, error) {
+	content, err := ioutil.ReadFile(cfg.GetEnvDefault("HOSTNAME_FILE", defaultHostnameFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(content), err
+}
+
+// envHostname returns the first set of the comma separated variables of
+// HOSTNAME_ENV, which the downward API sets to the node name
+func envHostname() (string, error) {
+	for _, name := range strings.Split(cfg.GetEnvDefault("HOSTNAME_ENV", defaultHostnameEnv), ",") {
+		if v := strings.TrimSpace(os.Getenv(strings.TrimSpace(name))); v != "" {
+			return v, nil
+		}
+	}
+	return "", nil
+}
+
+// templateHostname executes HOSTNAME_TEMPLATE with the variables in .Env and
+// the hostname of the container in .Hostname
+func templateHostname() (string, error) {
+	text := cfg.GetEnvDefault("HOSTNAME_TEMPLATE", "")
+	if text == "" {
+		return "", nil
+	}
+	tmpl, err := template.New("hostname").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	data := struct {
+		Env      map[string]string
+		Hostname string
+	}{Env: make(map[string]string)}
+	for _, v := range os.Environ() {
+		if i := strings.IndexByte(v, '='); i > 0 {
+			data.Env[v[:i]] = v[i+1:]
+		}
+	}
+	data.Hostname, _ = os.Hostname()
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// dockerHostname returns the name the Docker daemon gives its host
+func dockerHostname() (string, error) {
+	client, err := newDockerClient()
+	if err != nil {
+		return "", err
+	}
+	client.SetTimeout(hostnameTimeout)
+	info, err := client.Info()
+	if err != nil {
+		return "", err
+	}
+	return info.Name, nil
+}
+
+// cloudHostname asks the metadata servers of GCE, Azure and EC2 in turn. It
+// gives up at the first that can't be reached, as off a cloud none can.
+func cloudHostname() (string, error) {
+	client := &http.Client{Timeout: hostnameTimeout}
+	for _, request := range []struct {
+		path   string
+		header map[string]string
+	}{
+		{"/computeMetadata/v1/instance/hostname", map[string]string{"Metadata-Flavor": "Google"}},
+		{"/metadata/instance/compute/name?api-version=2021-02-01&format=text", map[string]string{"Metadata": "true"}},
+		{"/latest/meta-data/local-hostname", nil},
+	} {
+		header := request.header
+		if header == nil {
+			// EC2 instances may only serve IMDSv2, which needs a token
+			token, err := metadata(client, http.MethodPut, "/latest/api/token",
+				map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+			if err != nil {
+				return "", err
+			}
+			if token != "" {
+				header = map[string]string{"X-aws-ec2-metadata-token": token}
+			}
+		}
+		host, err := metadata(client, http.MethodGet, request.path, header)
+		if err != nil {
+			return "", err
+		}
+		if host != "" {
+			return host, nil
+		}
+	}
+	return "", nil
+}
+
+// metadata returns the answer of the metadata server to a request, or ""
+// when it doesn't serve path. Errors mean it can't be reached.
+func metadata(client *http.Client, method, path string, header map[string]string) (string, error) {
+	req, err := http.NewRequest(method, metadataURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return "", err
+	}
+	return string(body), nil
+}
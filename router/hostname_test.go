@@ -0,0 +1,70 @@
+package router
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveHostname(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "host_hostname")
+	if err := ioutil.WriteFile(file, []byte("node-1\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	RegisterHostnameProvider("failing", func() (string, error) { return "", errors.New("down") })
+	defer os.Unsetenv("HOSTNAME_FILE")
+	defer os.Unsetenv("HOSTNAME_ENV")
+	defer os.Unsetenv("HOSTNAME_TEMPLATE")
+	defer os.Unsetenv("TEST_NODE")
+	os.Setenv("HOSTNAME_ENV", "UNSET_NODE, TEST_NODE")
+	os.Setenv("TEST_NODE", "node-2")
+	os.Setenv("HOSTNAME_TEMPLATE", "{{.Env.TEST_NODE}}.example.com")
+
+	for _, test := range []struct {
+		file, providers string
+		host, provider  string
+	}{
+		{file, "file,env", "node-1", "file"},
+		{filepath.Join(dir, "missing"), "file,env", "node-2", "env"},
+		{file, "failing,unknown, template", "node-2.example.com", "template"},
+		{filepath.Join(dir, "missing"), "file", "", ""},
+	} {
+		os.Setenv("HOSTNAME_FILE", test.file)
+		host, provider := resolveHostname(test.providers)
+		if host != test.host || provider != test.provider {
+			t.Errorf("%s: expected %q from %q, got %q from %q", test.providers, test.host, test.provider, host, provider)
+		}
+	}
+}
+
+func TestCloudHostname(t *testing.T) {
+	defer func(url string) { metadataURL = url }(metadataURL)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			w.Write([]byte("token")) //nolint:errcheck
+		case r.URL.Path == "/latest/meta-data/local-hostname" && r.Header.Get("X-aws-ec2-metadata-token") == "token":
+			w.Write([]byte("ip-10-0-0-1.ec2.internal")) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	metadataURL = server.URL
+	if host, err := cloudHostname(); err != nil || host != "ip-10-0-0-1.ec2.internal" {
+		t.Errorf("expected the EC2 hostname, got %q, %v", host, err)
+	}
+
+	server.Close()
+	if _, err := cloudHostname(); err == nil {
+		t.Error("expected an error without a metadata server")
+	}
+}
@@ -1,32 +1,74 @@
 package router
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"net"
 	"net/http"
+	"os"
 	"strings"
+	"syscall"
 
 	"github.com/gliderlabs/logspout/cfg"
 )
 
+// defaultAPIHandlers are the handlers only served by the API listener when
+// there is one: those changing or exposing the configuration
+const defaultAPIHandlers = "routes,ledger,adapters"
+
 func init() {
 	bindAddress := cfg.GetEnvDefault("HTTP_BIND_ADDRESS", "0.0.0.0")
-	port := cfg.GetEnvDefault("PORT", cfg.GetEnvDefault("HTTP_PORT", "80"))
-	Jobs.Register(&httpService{bindAddress, port}, "http")
+	port := cfg.HTTPPort()
+	apiAddress, apiSocket := cfg.APIAddress()
+	apiHandlers := make(map[string]bool)
+	for _, name := range strings.Split(cfg.GetEnvDefault("HTTP_API_HANDLERS", defaultAPIHandlers), ",") {
+		apiHandlers[strings.TrimSpace(name)] = true
+	}
+	Jobs.Register(&httpService{
+		bindAddress: bindAddress,
+		port:        port,
+		apiAddress:  apiAddress,
+		apiSocket:   apiSocket,
+		apiHandlers: apiHandlers,
+	}, "http")
 }
 
 type httpService struct {
 	bindAddress string
 	port        string
+	// apiAddress is the address of the API listener set with
+	// HTTP_API_ADDRESS, serving all handlers, so the apiHandlers can be left
+	// out of the main listener. apiSocket is its path when it is a unix
+	// socket.
+	apiAddress  string
+	apiSocket   string
+	apiHandlers map[string]bool
+	api         *http.ServeMux
 }
 
 func (s *httpService) Name() string {
-	return fmt.Sprintf("http[%s]:%s",
+	name := fmt.Sprintf("http[%s]:%s",
 		strings.Join(HTTPHandlers.Names(), ","), s.port)
+	if s.apiAddress != "" {
+		name += " api:" + s.apiAddress
+	}
+	return name
 }
 
 func (s *httpService) Setup() error {
+	if s.apiAddress != "" {
+		s.api = http.NewServeMux()
+	}
 	for name, handler := range HTTPHandlers.All() {
 		h := handler()
+		if s.api != nil {
+			s.api.Handle("/"+name, h)
+			s.api.Handle("/"+name+"/", h)
+			if s.apiHandlers[name] {
+				continue
+			}
+		}
 		http.Handle("/"+name, h)
 		http.Handle("/"+name+"/", h)
 	}
@@ -34,5 +76,58 @@ func (s *httpService) Setup() error {
 }
 
 func (s *httpService) Run() error {
-	return http.ListenAndServe(s.bindAddress+":"+s.port, nil)
+	main, err := s.listener()
+	if err != nil {
+		return err
+	}
+	if s.api == nil {
+		return http.Serve(main, nil)
+	}
+	listener, err := s.apiListener()
+	if err != nil {
+		main.Close()
+		return err
+	}
+	errc := make(chan error, 2)
+	go func() {
+		errc <- http.Serve(listener, s.api)
+	}()
+	go func() {
+		errc <- http.Serve(main, nil)
+	}()
+	return <-errc
+}
+
+// listener listens on the port of the main listener. Binding a port below
+// 1024 is the only privileged operation of logspout, which without root
+// needs CAP_NET_BIND_SERVICE, or a runtime allowing it, so that error says
+// how to do without.
+func (s *httpService) listener() (net.Listener, error) {
+	listener, err := net.Listen("tcp", s.bindAddress+":"+s.port)
+	if err != nil && errors.Is(err, syscall.EACCES) {
+		return nil, fmt.Errorf("%v: ports below 1024 need root or CAP_NET_BIND_SERVICE, set PORT to a higher port to run without them", err)
+	}
+	return listener, err
+}
+
+// apiListener listens on the API address. A unix socket left by an earlier
+// run is replaced, and the new one can be used by the group of the process,
+// so clients don't need to run as the same user.
+func (s *httpService) apiListener() (net.Listener, error) {
+	if s.apiSocket == "" {
+		return net.Listen("tcp", s.apiAddress)
+	}
+	if info, err := os.Lstat(s.apiSocket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err = os.Remove(s.apiSocket); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", s.apiSocket)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(s.apiSocket, 0660); err != nil {
+		log.Println("http:", err)
+	}
+	return listener, nil
 }
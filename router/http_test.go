@@ -0,0 +1,85 @@
+package router
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTTPServiceAPIListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "api.sock")
+	// a socket left by an earlier run
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ok := func() http.Handler { return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}) }
+	HTTPHandlers.Register(ok, "testadmin")
+	HTTPHandlers.Register(ok, "testpublic")
+	defer HTTPHandlers.Unregister("testadmin")
+	defer HTTPHandlers.Unregister("testpublic")
+	s := &httpService{apiAddress: "unix:" + socket, apiSocket: socket, apiHandlers: map[string]bool{"testadmin": true}}
+	if err = s.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	for path, served := range map[string]bool{"/testpublic": true, "/testadmin": false} {
+		if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest("GET", path, nil)); (pattern != "") != served {
+			t.Errorf("%s: expected the main listener to serve it: %v, got pattern %q", path, served, pattern)
+		}
+	}
+
+	listener, err := s.apiListener()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go http.Serve(listener, s.api)
+	defer listener.Close()
+	if info, err := os.Stat(socket); err != nil || info.Mode().Perm() != 0660 {
+		t.Errorf("expected a socket for the group, got %v, %v", info, err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}}
+	for _, path := range []string{"/testpublic", "/testadmin/"} {
+		resp, err := client.Get("http://logspout" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected the API listener to serve it, got %s", path, resp.Status)
+		}
+	}
+}
+
+func TestHTTPServiceListenerPrivilegedPort(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can bind any port")
+	}
+	listener, err := (&httpService{bindAddress: "127.0.0.1", port: "1"}).listener()
+	if err == nil {
+		// the runtime lets anyone bind low ports
+		listener.Close()
+		return
+	}
+	if !strings.Contains(err.Error(), "CAP_NET_BIND_SERVICE") {
+		t.Errorf("expected the error to tell how to run without root, got %v", err)
+	}
+}
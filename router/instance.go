@@ -0,0 +1,67 @@
+package router
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	instanceFieldVersion  = "version"
+	instanceFieldInstance = "instance"
+	instanceFieldNode     = "node"
+)
+
+// Version is the version of the running logspout, set by its main package
+var Version string
+
+// newInstanceFieldsStage returns the stage for the instance_fields option or
+// INSTANCE_FIELDS, a comma separated list of version, instance and node, or
+// true for all of them, or nil when there is none. It adds the version of
+// logspout, the ID of the instance and the host it runs on to each message,
+// so backends can tell which shipper sent a message during rollouts.
+func newInstanceFieldsStage(route *Route) (stage, error) {
+	s := route.Options["instance_fields"]
+	if s == "" {
+		s = cfg.GetEnvDefault("INSTANCE_FIELDS", "")
+	}
+	if s == "" || s == "false" {
+		return nil, nil
+	}
+	if s == "true" {
+		s = strings.Join([]string{instanceFieldVersion, instanceFieldInstance, instanceFieldNode}, ",")
+	}
+	fields := make(map[string]string)
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(name) {
+		case instanceFieldVersion:
+			fields["logspout_version"] = Version
+		case instanceFieldInstance:
+			fields["logspout_instance"] = instanceID()
+		case instanceFieldNode:
+			fields["logspout_node"] = Hostname()
+		default:
+			return nil, errors.New("bad instance_fields: " + s)
+		}
+	}
+	for name, value := range fields {
+		if value == "" {
+			delete(fields, name)
+		}
+	}
+	return stageFunc(func(message *Message) *Message {
+		return message.WithFields(fields)
+	}), nil
+}
+
+// instanceID returns INSTANCE_ID, by default the hostname of the logspout
+// container, like the name of its pod
+func instanceID() string {
+	if id := cfg.GetEnvDefault("INSTANCE_ID", ""); id != "" {
+		return id
+	}
+	id, _ := os.Hostname()
+	return id
+}
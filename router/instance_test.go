@@ -0,0 +1,39 @@
+package router
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestInstanceFieldsStage(t *testing.T) {
+	defer func(version string) { Version = version }(Version)
+	Version = "v3.3-test"
+	defer os.Unsetenv("INSTANCE_ID")
+	os.Setenv("INSTANCE_ID", "logspout-abc12")
+
+	for _, tt := range []struct {
+		option string
+		fields map[string]string
+	}{
+		{"version", map[string]string{"logspout_version": "v3.3-test"}},
+		{"version, instance", map[string]string{"logspout_version": "v3.3-test", "logspout_instance": "logspout-abc12"}},
+	} {
+		s, err := newInstanceFieldsStage(&Route{Options: map[string]string{"instance_fields": tt.option}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := s.process(&Message{Data: "hello", Fields: map[string]string{"app": "web"}})
+		tt.fields["app"] = "web"
+		if !reflect.DeepEqual(m.Fields, tt.fields) {
+			t.Errorf("%s: expected %v, got %v", tt.option, tt.fields, m.Fields)
+		}
+	}
+
+	if s, err := newInstanceFieldsStage(&Route{Options: map[string]string{}}); s != nil || err != nil {
+		t.Errorf("expected no stage without instance_fields, got %v, %v", s, err)
+	}
+	if _, err := newInstanceFieldsStage(&Route{Options: map[string]string{"instance_fields": "version,pod"}}); err == nil {
+		t.Error("expected an error for an unknown field")
+	}
+}
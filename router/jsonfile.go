@@ -0,0 +1,165 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	logsSourceJSONFile = "json-file"
+	// jsonFilePoll is how often a log file at its end is read again
+	jsonFilePoll = 250 * time.Millisecond
+	// maxJSONFileEntry bounds a log message joined from partial entries
+	maxJSONFileEntry = 1 << 20
+)
+
+// jsonFileCheck is how often the tail of an idle log file checks whether the
+// container still runs
+var jsonFileCheck = 5 * time.Second
+
+// jsonFileEntry is a line of the log file of the json-file log driver
+type jsonFileEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+// readsJSONFile returns whether the logs of container are read from the file
+// of the json-file log driver, as configured with LOGS_SOURCE=json-file,
+// rather than through the Docker API. Reading the file spares dockerd
+// copying every line to logspout.
+func readsJSONFile(container *docker.Container) bool {
+	return cfg.GetEnvDefault("LOGS_SOURCE", "") == logsSourceJSONFile &&
+		container.HostConfig != nil && container.HostConfig.LogConfig.Type == "json-file" &&
+		container.LogPath != ""
+}
+
+// jsonFilePath returns the path of the log file of container, below
+// LOGS_JSON_FILE_ROOT when the containers directory of Docker is mounted
+// elsewhere
+func jsonFilePath(container *docker.Container) string {
+	root := cfg.GetEnvDefault("LOGS_JSON_FILE_ROOT", "")
+	if root == "" {
+		return container.LogPath
+	}
+	dir, file := filepath.Split(container.LogPath)
+	return filepath.Join(root, filepath.Base(dir), file)
+}
+
+// tailJSONFile sends the messages written to the log file at path to cp until
+// running returns false and the file is read to its end, starting at the
+// beginning of the file for the backlog or at its end. A rotated file is read
+// to its end before the new file is opened.
+func tailJSONFile(cp *containerPump, path string, backlog bool, running func() bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { file.Close() }()
+	if !backlog {
+		if _, err = file.Seek(0, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	reader := bufio.NewReader(file)
+	var partial strings.Builder
+	checked := time.Now()
+	stopping, rotating := false, false
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == nil {
+			cp.sendJSONFileEntry(line, &partial)
+			continue
+		}
+		if err != io.EOF {
+			return err
+		}
+		// at the end of the file: put back the incomplete line and wait for
+		// more, for a rotation or for the container to stop
+		if len(line) > 0 {
+			reader = bufio.NewReader(io.MultiReader(strings.NewReader(string(line)), file))
+		}
+		if rotating || rotated(file, path) {
+			if !rotating {
+				// read what was written before the rotation first
+				rotating = true
+				continue
+			}
+			rotating = false
+			if next, err := os.Open(path); err == nil {
+				file.Close()
+				file = next
+				reader = bufio.NewReader(file)
+				continue
+			}
+		}
+		if stopping {
+			return nil
+		}
+		if time.Since(checked) >= jsonFileCheck {
+			checked = time.Now()
+			// read what was written before the container stopped
+			stopping = !running()
+			continue
+		}
+		time.Sleep(jsonFilePoll)
+	}
+}
+
+// rotated returns whether path is no longer the open file, or the file was
+// truncated
+func rotated(file *os.File, path string) bool {
+	opened, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if !os.SameFile(opened, current) {
+		return true
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	return err == nil && current.Size() < offset
+}
+
+// sendJSONFileEntry sends the message of a log file line. Docker splits long
+// lines into entries without the trailing newline, which are joined in
+// partial up to maxJSONFileEntry bytes.
+func (cp *containerPump) sendJSONFileEntry(line []byte, partial *strings.Builder) {
+	var entry jsonFileEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		debug("pump.tailJSONFile():", normalID(cp.container.ID), err)
+		return
+	}
+	if !strings.HasSuffix(entry.Log, "\n") {
+		if partial.Len()+len(entry.Log) <= maxJSONFileEntry {
+			partial.WriteString(entry.Log)
+		}
+		return
+	}
+	data := strings.TrimSuffix(entry.Log, "\n")
+	if partial.Len() > 0 {
+		if partial.Len()+len(data) <= maxJSONFileEntry {
+			partial.WriteString(data)
+		}
+		data = partial.String()
+		partial.Reset()
+	}
+	cp.send(&Message{
+		Data:      data,
+		Container: cp.container,
+		Time:      entry.Time,
+		Source:    entry.Stream,
+	})
+}
@@ -0,0 +1,88 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func appendFile(t *testing.T, path, data string) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTailJSONFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "abc-json.log")
+	defer func(check time.Duration) { jsonFileCheck = check }(jsonFileCheck)
+	jsonFileCheck = 100 * time.Millisecond
+	appendFile(t, path, `{"log":"first\n","stream":"stdout","time":"2026-10-14T10:00:00.000000001Z"}`+"\n")
+
+	cp := newContainerPump(&docker.Container{ID: "abc"}, nil, nil)
+	logstream := make(chan *Message, 10)
+	cp.add(logstream, &Route{})
+	var stopped int32
+	done := make(chan error)
+	go func() {
+		done <- tailJSONFile(cp, path, true, func() bool { return atomic.LoadInt32(&stopped) == 0 })
+	}()
+
+	receive := func(expected Message) {
+		t.Helper()
+		select {
+		case message := <-logstream:
+			if message.Data != expected.Data || message.Source != expected.Source || !message.Time.Equal(expected.Time) {
+				t.Errorf("expected %s on %s at %v, got %s on %s at %v", expected.Data, expected.Source, expected.Time,
+					message.Data, message.Source, message.Time)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting for %q", expected.Data)
+		}
+	}
+	receive(Message{Data: "first", Source: "stdout", Time: time.Date(2026, 10, 14, 10, 0, 0, 1, time.UTC)})
+	appendFile(t, path, `{"log":"long ","stream":"stderr","time":"2026-10-14T10:00:01Z"}`+"\n")
+	appendFile(t, path, `{"log":"line\n","stream":"stderr","time":"2026-10-14T10:00:01Z"}`+"\n")
+	if err = os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, `{"log":"rotated\n","stream":"stdout","time":"2026-10-14T10:00:02Z"}`+"\n")
+	receive(Message{Data: "long line", Source: "stderr", Time: time.Date(2026, 10, 14, 10, 0, 1, 0, time.UTC)})
+	receive(Message{Data: "rotated", Source: "stdout", Time: time.Date(2026, 10, 14, 10, 0, 2, 0, time.UTC)})
+	atomic.StoreInt32(&stopped, 1)
+
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("expected the tail to stop with the container")
+	}
+}
+
+func TestJSONFilePath(t *testing.T) {
+	container := &docker.Container{LogPath: "/var/lib/docker/containers/abc/abc-json.log"}
+	if path := jsonFilePath(container); path != container.LogPath {
+		t.Errorf("expected the log path, got %s", path)
+	}
+	os.Setenv("LOGS_JSON_FILE_ROOT", "/mnt/containers")
+	defer os.Unsetenv("LOGS_JSON_FILE_ROOT")
+	if path := jsonFilePath(container); path != "/mnt/containers/abc/abc-json.log" {
+		t.Errorf("expected the path below the root, got %s", path)
+	}
+}
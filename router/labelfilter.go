@@ -0,0 +1,174 @@
+package router
+
+import (
+	"errors"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// excludeLabel opts a container out of shipping when it is true, whatever
+// EXCLUDE_LABELS is
+const excludeLabel = "logspout.exclude"
+
+// labelMatcher matches containers by their labels, with the ; separated
+// key:value entries of EXCLUDE_LABELS and INCLUDE_LABELS. Keys and values are
+// literals, globs like team-*, or regular expressions between slashes like
+// /^(dev|test)$/. A key without a value matches the value true. A container
+// matches when one of its labels matches one of the entries.
+type labelMatcher []labelRule
+
+type labelRule struct {
+	key, value textPattern
+}
+
+// textPattern is a literal, a glob or a regular expression
+type textPattern struct {
+	literal string
+	// fold compares a literal regardless of case, as label values always
+	// were
+	fold bool
+	glob string
+	re   *regexp.Regexp
+}
+
+func newTextPattern(s string, fold bool) (textPattern, error) {
+	if len(s) >= 2 && s[0] == '/' && s[len(s)-1] == '/' {
+		re, err := regexp.Compile(s[1 : len(s)-1])
+		return textPattern{re: re}, err
+	}
+	if strings.ContainsAny(s, `*?[\`) {
+		_, err := path.Match(s, "")
+		return textPattern{glob: s}, err
+	}
+	return textPattern{literal: s, fold: fold}, nil
+}
+
+func (p textPattern) match(s string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(s)
+	case p.glob != "":
+		match, _ := path.Match(p.glob, s)
+		return match
+	case p.fold:
+		return strings.EqualFold(p.literal, s)
+	}
+	return p.literal == s
+}
+
+// parseLabelMatcher parses the entries of s
+func parseLabelMatcher(s string) (labelMatcher, error) {
+	var m labelMatcher
+	for _, entry := range strings.Split(s, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		// the key ends at the first colon, or after the slash closing its
+		// regular expression, which can hold colons
+		end := strings.IndexByte(entry, ':')
+		if entry[0] == '/' {
+			end = -1
+			for i := 1; i < len(entry); i++ {
+				if entry[i] == '/' && entry[i-1] != '\\' {
+					end = i + 1
+					break
+				}
+			}
+			if end < 0 || (end < len(entry) && entry[end] != ':') {
+				return nil, errors.New("bad label entry: " + entry)
+			}
+			if end == len(entry) {
+				end = -1
+			}
+		}
+		key, value := entry, "true"
+		if end >= 0 {
+			key, value = entry[:end], entry[end+1:]
+		}
+		var rule labelRule
+		var err error
+		if rule.key, err = newTextPattern(key, false); err != nil {
+			return nil, errors.New("bad label entry: " + entry + ": " + err.Error())
+		}
+		if rule.value, err = newTextPattern(value, true); err != nil {
+			return nil, errors.New("bad label entry: " + entry + ": " + err.Error())
+		}
+		m = append(m, rule)
+	}
+	return m, nil
+}
+
+// match returns whether one of labels matches one of the entries
+func (m labelMatcher) match(labels map[string]string) bool {
+	for _, rule := range m {
+		if rule.key.literal != "" {
+			if value, ok := labels[rule.key.literal]; ok && rule.value.match(value) {
+				return true
+			}
+			continue
+		}
+		for key, value := range labels {
+			if rule.key.match(key) && rule.value.match(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// labelMatchers caches the matchers of the environment variables, by value
+var labelMatchers = struct {
+	sync.Mutex
+	m map[string]labelMatcher
+}{m: make(map[string]labelMatcher)}
+
+// envLabelMatcher returns the matcher of the environment variable name, of
+// EXCLUDE_LABEL when name is EXCLUDE_LABELS and unset
+func envLabelMatcher(name string) (labelMatcher, error) {
+	s := cfg.GetEnvDefault(name, "")
+	if s == "" && name == "EXCLUDE_LABELS" {
+		s = cfg.GetEnvDefault("EXCLUDE_LABEL", "")
+	}
+	if s == "" {
+		return nil, nil
+	}
+	labelMatchers.Lock()
+	defer labelMatchers.Unlock()
+	if m, ok := labelMatchers.m[s]; ok {
+		return m, nil
+	}
+	m, err := parseLabelMatcher(s)
+	if err != nil {
+		return nil, errors.New("bad " + name + ": " + err.Error())
+	}
+	labelMatchers.m[s] = m
+	return m, nil
+}
+
+// checkLabelMatchers returns the error of a bad EXCLUDE_LABELS or
+// INCLUDE_LABELS, so logspout doesn't start with it
+func checkLabelMatchers() error {
+	for _, name := range []string{"EXCLUDE_LABELS", "INCLUDE_LABELS"} {
+		if _, err := envLabelMatcher(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ignoreLabels returns whether the labels of a container exclude it, or
+// don't include it when INCLUDE_LABELS is set. Exclusion wins.
+func ignoreLabels(labels map[string]string) bool {
+	if strings.EqualFold(labels[excludeLabel], "true") {
+		return true
+	}
+	if exclude, _ := envLabelMatcher("EXCLUDE_LABELS"); exclude.match(labels) {
+		return true
+	}
+	include, _ := envLabelMatcher("INCLUDE_LABELS")
+	return include != nil && !include.match(labels)
+}
@@ -0,0 +1,66 @@
+package router
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLabelMatcher(t *testing.T) {
+	for _, tt := range []struct {
+		entries string
+		labels  map[string]string
+		match   bool
+	}{
+		{"logspout.skip", map[string]string{"logspout.skip": "TRUE"}, true},
+		{"logspout.skip", map[string]string{"logspout.skip": "false"}, false},
+		{"team:core", map[string]string{"team": "Core"}, true},
+		{"team:core-*", map[string]string{"team": "core-api"}, true},
+		{"team:core-*", map[string]string{"team": "edge-api"}, false},
+		{"com.example.*:*", map[string]string{"com.example.tier": "db"}, true},
+		{"env:/^(dev|test)$/", map[string]string{"env": "test"}, true},
+		{"env:/^(dev|test)$/", map[string]string{"env": "testing"}, false},
+		{"/^io\\.kubernetes\\..*:ns$/:kube-*", map[string]string{"io.kubernetes.pod:ns": "kube-system"}, true},
+		{"/^logspout\\.optout/", map[string]string{"logspout.optout.all": "true"}, true},
+		{"a:x; b:y", map[string]string{"b": "y"}, true},
+		{"a:x;b:y", map[string]string{"c": "x"}, false},
+	} {
+		m, err := parseLabelMatcher(tt.entries)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.entries, err)
+		}
+		if match := m.match(tt.labels); match != tt.match {
+			t.Errorf("%s: expected %v for %v, got %v", tt.entries, tt.match, tt.labels, match)
+		}
+	}
+	for _, entries := range []string{"team:[core", "/(/:x", "/team", "/team/x:y"} {
+		if _, err := parseLabelMatcher(entries); err == nil {
+			t.Errorf("%s: expected an error", entries)
+		}
+	}
+}
+
+func TestIgnoreLabels(t *testing.T) {
+	defer os.Unsetenv("INCLUDE_LABELS")
+	defer os.Unsetenv("EXCLUDE_LABELS")
+	os.Setenv("INCLUDE_LABELS", "ship:true;team:core-*")
+	os.Setenv("EXCLUDE_LABELS", "env:/^(dev|test)$/")
+	for _, tt := range []struct {
+		labels map[string]string
+		ignore bool
+	}{
+		{map[string]string{"ship": "true"}, false},
+		{map[string]string{"team": "core-api"}, false},
+		{map[string]string{"team": "edge"}, true},
+		{map[string]string{}, true},
+		{map[string]string{"team": "core-api", "env": "dev"}, true},
+		{map[string]string{"ship": "true", excludeLabel: "true"}, true},
+	} {
+		if ignore := ignoreLabels(tt.labels); ignore != tt.ignore {
+			t.Errorf("expected %v for %v, got %v", tt.ignore, tt.labels, ignore)
+		}
+	}
+	os.Setenv("INCLUDE_LABELS", "team:[core")
+	if err := checkLabelMatchers(); err == nil {
+		t.Error("expected an error for a bad INCLUDE_LABELS")
+	}
+}
@@ -0,0 +1,307 @@
+package router
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	leaderElectionKubernetes = "kubernetes"
+	defaultLeaseName         = "logspout"
+	defaultLeaseDuration     = 15 * time.Second
+	serviceAccountDir        = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// leaseTimeFormat is the MicroTime format of the Kubernetes API
+	leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// leader is the election of the instance, when LEADER_ELECTION is set
+var leader *leaderElection
+
+func init() {
+	switch s := cfg.GetEnvDefault("LEADER_ELECTION", ""); s {
+	case "":
+	case leaderElectionKubernetes:
+		leader = &leaderElection{}
+		Jobs.Register(leader, "leader")
+	default:
+		log.Println("bad LEADER_ELECTION:", s)
+	}
+}
+
+// lease is the part of a coordination.k8s.io/v1 Lease the election uses
+type lease struct {
+	APIVersion string        `json:"apiVersion"`
+	Kind       string        `json:"kind"`
+	Metadata   leaseMetadata `json:"metadata"`
+	Spec       leaseSpec     `json:"spec"`
+}
+
+type leaseMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+// leaderElection elects one of the instances of a daemon set as the leader,
+// through a Kubernetes Lease, so routes with leader_only=true run on one node
+// only. The leader renews the lease every third of its duration; the others
+// take it over once it expired.
+type leaderElection struct {
+	// leases is the URL of the leases of the namespace, name the lease
+	leases   string
+	name     string
+	token    string
+	identity string
+	duration time.Duration
+	client   *http.Client
+
+	mu sync.Mutex
+	// until is when the lease held by the instance expires
+	until time.Time
+}
+
+// Name returns the name of the leader election job
+func (l *leaderElection) Name() string {
+	return "leader"
+}
+
+// Setup configures the election with the service account of the pod
+func (l *leaderElection) Setup() error {
+	l.identity = cfg.GetEnvDefault("LEADER_IDENTITY", "")
+	if l.identity == "" {
+		l.identity, _ = os.Hostname()
+	}
+	l.duration = defaultLeaseDuration
+	if s := cfg.GetEnvDefault("LEADER_LEASE_DURATION", ""); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 3*time.Second {
+			return errors.New("bad LEADER_LEASE_DURATION: " + s)
+		}
+		l.duration = d
+	}
+	if l.client != nil {
+		return nil
+	}
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return errors.New("leader: LEADER_ELECTION=kubernetes needs to run in a pod")
+	}
+	token, err := ioutil.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return errors.New("leader: " + err.Error())
+	}
+	ca, err := ioutil.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return errors.New("leader: " + err.Error())
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	namespace := cfg.GetEnvDefault("LEADER_LEASE_NAMESPACE", "")
+	if namespace == "" {
+		ns, err := ioutil.ReadFile(serviceAccountDir + "/namespace")
+		if err != nil {
+			return errors.New("leader: " + err.Error())
+		}
+		namespace = strings.TrimSpace(string(ns))
+	}
+	l.token = strings.TrimSpace(string(token))
+	l.leases = "https://" + net.JoinHostPort(host, port) + "/apis/coordination.k8s.io/v1/namespaces/" +
+		namespace + "/leases"
+	l.name = cfg.GetEnvDefault("LEADER_LEASE", defaultLeaseName)
+	l.client = &http.Client{
+		Timeout:   l.duration / 3,
+		Transport: &http.Transport{TLSClientConfig: cfg.RestrictTLS(&tls.Config{RootCAs: pool})}, //nolint:gosec
+	}
+	return nil
+}
+
+// Run tries to acquire or renew the lease every third of its duration
+func (l *leaderElection) Run() error {
+	ticker := time.NewTicker(l.duration / 3)
+	defer ticker.Stop()
+	for {
+		l.elect(time.Now())
+		<-ticker.C
+	}
+}
+
+// isLeader returns whether the instance holds the lease
+func (l *leaderElection) isLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().Before(l.until)
+}
+
+func (l *leaderElection) elect(now time.Time) {
+	was := l.isLeader()
+	held, err := l.acquire(now)
+	if err != nil {
+		debug("leader:", err)
+	}
+	l.mu.Lock()
+	if held {
+		l.until = now.Add(l.duration)
+	} else if err == nil {
+		l.until = time.Time{}
+	}
+	l.mu.Unlock()
+	// after an error the instance stays leader until its lease expires,
+	// as the others can't take it over before then either
+	if is := l.isLeader(); is != was {
+		log.Printf("leader: %s is leader: %v", l.identity, is)
+	}
+}
+
+// acquire takes the lease when it is free or expired, or renews it when the
+// instance holds it, and returns whether the instance holds it
+func (l *leaderElection) acquire(now time.Time) (bool, error) {
+	current, err := l.get()
+	if err != nil {
+		return false, err
+	}
+	stamp := now.UTC().Format(leaseTimeFormat)
+	if current == nil {
+		return l.write("POST", l.leases, &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMetadata{Name: l.name},
+			Spec: leaseSpec{
+				HolderIdentity:       l.identity,
+				LeaseDurationSeconds: int(l.duration / time.Second),
+				AcquireTime:          stamp,
+				RenewTime:            stamp,
+			},
+		})
+	}
+	spec := &current.Spec
+	if spec.HolderIdentity != l.identity {
+		if spec.HolderIdentity != "" && !leaseExpired(spec, now) {
+			return false, nil
+		}
+		spec.HolderIdentity = l.identity
+		spec.AcquireTime = stamp
+		spec.LeaseTransitions++
+	}
+	spec.LeaseDurationSeconds = int(l.duration / time.Second)
+	spec.RenewTime = stamp
+	// the resource version makes the update fail when another instance
+	// changed the lease in the meantime
+	return l.write("PUT", l.leases+"/"+l.name, current)
+}
+
+func leaseExpired(spec *leaseSpec, now time.Time) bool {
+	renewed, err := time.Parse(leaseTimeFormat, spec.RenewTime)
+	if err != nil {
+		return true
+	}
+	return now.After(renewed.Add(time.Duration(spec.LeaseDurationSeconds) * time.Second))
+}
+
+func (l *leaderElection) request(method, url string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+l.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return l.client.Do(req)
+}
+
+// get returns the lease, or nil when it doesn't exist
+func (l *leaderElection) get() (*lease, error) {
+	resp, err := l.request("GET", l.leases+"/"+l.name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var current lease
+		err = json.NewDecoder(resp.Body).Decode(&current)
+		return &current, err
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("GET lease: %s", resp.Status)
+	}
+}
+
+// write creates or updates the lease, and returns whether it was written. A
+// conflict means another instance got there first.
+func (l *leaderElection) write(method, url string, update *lease) (bool, error) {
+	resp, err := l.request(method, url, update)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body) //nolint:errcheck
+	switch {
+	case resp.StatusCode/100 == 2:
+		return true, nil
+	case resp.StatusCode == http.StatusConflict:
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s lease: %s", method, resp.Status)
+	}
+}
+
+// leaderStage drops the messages of a route with leader_only=true unless the
+// instance is the leader
+type leaderStage struct {
+	election *leaderElection
+}
+
+func newLeaderStage(route *Route) (stage, error) {
+	switch s := route.Options["leader_only"]; s {
+	case "true":
+	case "false":
+		return nil, nil
+	default:
+		return nil, errors.New("bad leader_only: " + s)
+	}
+	if leader == nil {
+		return nil, errors.New("leader_only needs LEADER_ELECTION")
+	}
+	return &leaderStage{election: leader}, nil
+}
+
+func (s *leaderStage) process(message *Message) *Message {
+	if !s.election.isLeader() {
+		return nil
+	}
+	return message
+}
@@ -0,0 +1,103 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLeases serves a single lease the way the Kubernetes API does, failing
+// updates of an outdated resource version with a conflict
+func fakeLeases(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	var current *lease
+	version := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if req.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case req.Method == "GET" && req.URL.Path == "/leases/logspout":
+			if current == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(current)
+		case req.Method == "POST" && req.URL.Path == "/leases", req.Method == "PUT" && req.URL.Path == "/leases/logspout":
+			var update lease
+			json.NewDecoder(req.Body).Decode(&update)
+			if (req.Method == "POST") != (current == nil) ||
+				current != nil && update.Metadata.ResourceVersion != current.Metadata.ResourceVersion {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			version++
+			update.Metadata.ResourceVersion = strconv.Itoa(version)
+			current = &update
+			json.NewEncoder(w).Encode(current)
+		default:
+			t.Errorf("unexpected request %s %s", req.Method, req.URL)
+			http.NotFound(w, req)
+		}
+	}))
+}
+
+func TestLeaderElection(t *testing.T) {
+	server := fakeLeases(t)
+	defer server.Close()
+	newElection := func(identity string) *leaderElection {
+		return &leaderElection{leases: server.URL + "/leases", name: "logspout", token: "token",
+			identity: identity, duration: 15 * time.Second, client: server.Client()}
+	}
+	a, b := newElection("a"), newElection("b")
+
+	now := time.Now()
+	a.elect(now)
+	b.elect(now)
+	if !a.isLeader() || b.isLeader() {
+		t.Fatalf("expected the first instance to be leader, got %v and %v", a.isLeader(), b.isLeader())
+	}
+	a.elect(now.Add(5 * time.Second))
+	b.elect(now.Add(10 * time.Second))
+	if !a.isLeader() || b.isLeader() {
+		t.Fatal("expected the leader to renew its lease")
+	}
+
+	// the leader stops renewing, and the other takes over once it expired
+	b.elect(now.Add(time.Minute))
+	a.elect(now.Add(time.Minute))
+	if a.isLeader() || !b.isLeader() {
+		t.Errorf("expected the second instance to take over, got %v and %v", a.isLeader(), b.isLeader())
+	}
+}
+
+func TestLeaderStage(t *testing.T) {
+	defer func(l *leaderElection) { leader = l }(leader)
+	leader = nil
+	if _, err := newStages(&Route{Options: map[string]string{"leader_only": "true"}}); err == nil {
+		t.Error("expected leader_only to need LEADER_ELECTION")
+	}
+	leader = &leaderElection{}
+	if _, err := newStages(&Route{Options: map[string]string{"leader_only": "yes"}}); err == nil {
+		t.Error("expected error for a bad leader_only")
+	}
+	s, err := newLeaderStage(&Route{Options: map[string]string{"leader_only": "true"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &Message{Data: "hello"}
+	if out := s.process(message); out != nil {
+		t.Error("expected messages to be dropped unless leader")
+	}
+	leader.until = time.Now().Add(time.Minute)
+	if out := s.process(message); out != message {
+		t.Error("expected messages to pass on the leader")
+	}
+}
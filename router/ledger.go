@@ -0,0 +1,218 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	defaultLedgerFlushInterval = 10 * time.Second
+	// maxLedgerEntries bounds the ledger against labels with many values;
+	// further values are counted as otherLedgerLabel
+	maxLedgerEntries = 10000
+	otherLedgerLabel = "(other)"
+)
+
+// LedgerEntry is the bytes and messages a route shipped in a month, for the
+// containers with one value of the ledger label
+type LedgerEntry struct {
+	Month    string `json:"month"`
+	Route    string `json:"route"`
+	Label    string `json:"label,omitempty"`
+	Bytes    int64  `json:"bytes"`
+	Messages int64  `json:"messages"`
+}
+
+type ledgerKey struct {
+	month, route, label string
+}
+
+// Ledger counts the bytes and messages routes hand to their adapters, by
+// month and by the value of a container label, and keeps the counts in a file
+// across restarts. It is enabled with LEDGER_PATH.
+type Ledger struct {
+	path     string
+	label    string
+	interval time.Duration
+
+	mu      sync.Mutex
+	entries map[ledgerKey]*LedgerEntry
+	dirty   bool
+}
+
+var ledger *Ledger
+
+func init() {
+	if path := cfg.DataPath(cfg.GetEnvDefault("LEDGER_PATH", "")); path != "" {
+		ledger = &Ledger{
+			path:     path,
+			label:    cfg.GetEnvDefault("LEDGER_LABEL", ""),
+			interval: defaultLedgerFlushInterval,
+			entries:  make(map[ledgerKey]*LedgerEntry),
+		}
+		Jobs.Register(ledger, "ledger")
+		HTTPHandlers.Register(func() http.Handler { return ledger }, "ledger")
+	}
+}
+
+// Name returns the name of the ledger job
+func (l *Ledger) Name() string {
+	return "ledger"
+}
+
+// Setup loads the counts kept in the ledger file
+func (l *Ledger) Setup() error {
+	if s := cfg.GetEnvDefault("LEDGER_FLUSH_INTERVAL", ""); s != "" {
+		interval, err := time.ParseDuration(s)
+		if err != nil || interval <= 0 {
+			return errors.New("bad LEDGER_FLUSH_INTERVAL: " + s)
+		}
+		l.interval = interval
+	}
+	return l.load()
+}
+
+// Run writes the counts to the ledger file every flush interval
+func (l *Ledger) Run() error {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := l.save(); err != nil {
+			log.Println("ledger:", err)
+		}
+	}
+	return nil
+}
+
+func (l *Ledger) load() error {
+	data, err := ioutil.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*LedgerEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return errors.New("ledger: " + l.path + ": " + err.Error())
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, e := range entries {
+		l.entries[ledgerKey{e.Month, e.Route, e.Label}] = e
+	}
+	return nil
+}
+
+// save writes the counts to a temporary file, synced to disk, that replaces
+// the ledger file, so a crash leaves either the old or the new counts
+func (l *Ledger) save() error {
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(l.entriesLocked("", ""))
+	l.dirty = false
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), l.path)
+	}
+	if err != nil {
+		l.mu.Lock()
+		l.dirty = true
+		l.mu.Unlock()
+	}
+	return err
+}
+
+// add counts message as shipped by route
+func (l *Ledger) add(route *Route, message *Message) {
+	label := ""
+	if l.label != "" && message.Container != nil && message.Container.Config != nil {
+		label = message.Container.Config.Labels[l.label]
+	}
+	key := ledgerKey{time.Now().UTC().Format("2006-01"), route.String(), label}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[key]
+	if !ok {
+		if len(l.entries) >= maxLedgerEntries {
+			key.label = otherLedgerLabel
+			e = l.entries[key]
+		}
+		if e == nil {
+			e = &LedgerEntry{Month: key.month, Route: key.route, Label: key.label}
+			l.entries[key] = e
+		}
+	}
+	e.Bytes += int64(len(message.Data))
+	e.Messages++
+	l.dirty = true
+}
+
+// entriesLocked returns the entries for month and route, or all of them for
+// empty ones, ordered by month, route and label
+func (l *Ledger) entriesLocked(month, route string) []LedgerEntry {
+	entries := make([]LedgerEntry, 0, len(l.entries))
+	for _, e := range l.entries {
+		if (month == "" || e.Month == month) && (route == "" || e.Route == route) {
+			entries = append(entries, *e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Month != b.Month {
+			return a.Month < b.Month
+		}
+		if a.Route != b.Route {
+			return a.Route < b.Route
+		}
+		return a.Label < b.Label
+	})
+	return entries
+}
+
+// ServeHTTP returns the entries of the ledger as JSON, filtered by the month
+// and route query parameters
+func (l *Ledger) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	l.mu.Lock()
+	entries := l.entriesLocked(query.Get("month"), query.Get("route"))
+	l.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries) //nolint:errcheck
+}
+
+// countShipped passes messages from in to out, counting them in the ledger
+func countShipped(route *Route, in <-chan *Message, out chan<- *Message) {
+	defer close(out)
+	for message := range in {
+		ledger.add(route, message)
+		out <- message
+	}
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func newTestLedger(path string) *Ledger {
+	return &Ledger{path: path, label: "team", interval: time.Hour, entries: make(map[ledgerKey]*LedgerEntry)}
+}
+
+func TestLedger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ledger")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ledger.json")
+
+	l := newTestLedger(path)
+	if err = l.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	core := &docker.Container{Config: &docker.Config{Labels: map[string]string{"team": "core"}}}
+	route := &Route{ID: "abc", Name: "graylog"}
+	l.add(route, &Message{Container: core, Data: "hello"})
+	l.add(route, &Message{Container: core, Data: "world!"})
+	l.add(route, &Message{Data: "x"})
+	if err = l.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newTestLedger(path)
+	if err = restarted.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	restarted.add(route, &Message{Container: core, Data: "again"})
+	month := time.Now().UTC().Format("2006-01")
+	recorder := httptest.NewRecorder()
+	restarted.ServeHTTP(recorder, httptest.NewRequest("GET", "/ledger?route=graylog&month="+month, nil))
+	var entries []LedgerEntry
+	if err = json.Unmarshal(recorder.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	expected := []LedgerEntry{
+		{Month: month, Route: "graylog", Bytes: 1, Messages: 1},
+		{Month: month, Route: "graylog", Label: "core", Bytes: 16, Messages: 3},
+	}
+	if len(entries) != 2 || entries[0] != expected[0] || entries[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, entries)
+	}
+}
+
+func TestLedgerBounded(t *testing.T) {
+	l := newTestLedger("")
+	route := &Route{ID: "abc"}
+	for i := 0; i < maxLedgerEntries+10; i++ {
+		container := &docker.Container{Config: &docker.Config{Labels: map[string]string{"team": strconv.Itoa(i)}}}
+		l.add(route, &Message{Container: container, Data: "x"})
+	}
+	if len(l.entries) != maxLedgerEntries+1 {
+		t.Errorf("expected the labels beyond the maximum to be counted together, got %d entries", len(l.entries))
+	}
+}
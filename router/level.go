@@ -0,0 +1,55 @@
+package router
+
+import (
+	"errors"
+	"strings"
+)
+
+// severities are the syslog severities of the level field set by parse
+var severities = map[string]int{
+	"emergency": 0,
+	"alert":     1,
+	"critical":  2,
+	"error":     3,
+	"warning":   4,
+	"notice":    5,
+	"info":      6,
+	"debug":     7,
+}
+
+// severity returns the syslog severity of a level name, as set by parse or
+// used by common software, or of a severity from 0 to 7
+func severity(level string) (int, bool) {
+	if len(level) == 1 && level[0] >= '0' && level[0] <= '7' {
+		return int(level[0] - '0'), true
+	}
+	lower := strings.ToLower(level)
+	if s, ok := severities[lower]; ok {
+		return s, true
+	}
+	s, ok := severities[commonLevels[strings.TrimRight(lower, "0123456789")]]
+	return s, ok
+}
+
+// newMinLevelStage returns a stage that drops the messages below level, by
+// the level field parse sets. Messages without a known level are taken as
+// errors on stderr and as info on stdout, as the GELF adapter does.
+func newMinLevelStage(level string) (stage, error) {
+	threshold, ok := severity(level)
+	if !ok {
+		return nil, errors.New("bad filter.min_level: " + level)
+	}
+	return stageFunc(func(message *Message) *Message {
+		s, ok := severity(message.Fields["level"])
+		if !ok {
+			s = severities["info"]
+			if message.Source == "stderr" {
+				s = severities["error"]
+			}
+		}
+		if s > threshold {
+			return nil
+		}
+		return message
+	}), nil
+}
@@ -0,0 +1,37 @@
+package router
+
+import "testing"
+
+func TestMinLevelStage(t *testing.T) {
+	s, err := newMinLevelStage("warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		source, level string
+		pass          bool
+	}{
+		{"stdout", "error", true},
+		{"stdout", "warning", true},
+		{"stdout", "notice", false},
+		{"stdout", "WARN", true},
+		{"stdout", "3", true},
+		{"stdout", "", false},
+		{"stderr", "", true},
+		{"stderr", "debug", false},
+		{"stderr", "verbose", true},
+	} {
+		message := &Message{Source: tc.source}
+		if tc.level != "" {
+			message.Fields = map[string]string{"level": tc.level}
+		}
+		if got := s.process(message); (got != nil) != tc.pass {
+			t.Errorf("%s %q: expected passed %v", tc.source, tc.level, tc.pass)
+		}
+	}
+	for _, level := range []string{"", "8", "loud"} {
+		if _, err := newMinLevelStage(level); err == nil {
+			t.Errorf("%q: expected an error", level)
+		}
+	}
+}
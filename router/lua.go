@@ -0,0 +1,171 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+const defaultLuaTimeout = time.Second
+
+// luaLibs are the libraries open to scripts: none giving access to files,
+// processes or the environment
+var luaLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// luaStage passes messages to the process function of a Lua script, for
+// filtering and transformations the other options can't express. The function
+// gets the message as a table with time, source, data, fields, replay and
+// container, and returns it, changed as it likes, or nil or false to drop it.
+// A message the script fails on passes unchanged.
+type luaStage struct {
+	path    string
+	timeout time.Duration
+	state   *lua.LState
+	fn      lua.LValue
+	// failure is the last error logged, so a script failing on every message
+	// doesn't flood the log
+	failure string
+}
+
+func newLuaStage(route *Route) (*luaStage, error) {
+	s := &luaStage{path: route.Options["lua"], timeout: defaultLuaTimeout}
+	if v := route.Options["lua_timeout"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, errors.New("bad lua_timeout: " + v)
+		}
+		s.timeout = d
+	}
+	s.state = lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range luaLibs {
+		s.state.Push(s.state.NewFunction(lib.open))
+		s.state.Push(lua.LString(lib.name))
+		s.state.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "require"} {
+		s.state.SetGlobal(name, lua.LNil)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	s.state.SetContext(ctx)
+	err := s.state.DoFile(s.path)
+	s.state.RemoveContext()
+	if err != nil {
+		s.state.Close()
+		return nil, errors.New("bad lua: " + err.Error())
+	}
+	s.fn = s.state.GetGlobal("process")
+	if s.fn.Type() != lua.LTFunction {
+		s.state.Close()
+		return nil, errors.New("bad lua: " + s.path + " defines no process function")
+	}
+	return s, nil
+}
+
+func (s *luaStage) process(message *Message) *Message {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	s.state.SetContext(ctx)
+	defer s.state.RemoveContext()
+	err := s.state.CallByParam(lua.P{Fn: s.fn, NRet: 1, Protect: true}, s.table(message))
+	if err != nil {
+		s.failed(err)
+		return message
+	}
+	answer := s.state.Get(-1)
+	s.state.Pop(1)
+	switch answer := answer.(type) {
+	case *lua.LNilType:
+		return nil
+	case lua.LBool:
+		if !answer {
+			return nil
+		}
+		return message
+	case *lua.LTable:
+		changed, err := s.message(message, answer)
+		if err != nil {
+			s.failed(err)
+			return message
+		}
+		return changed
+	default:
+		s.failed(errors.New("process returned a " + answer.Type().String()))
+		return message
+	}
+}
+
+func (s *luaStage) failed(err error) {
+	if err.Error() != s.failure {
+		s.failure = err.Error()
+		log.Printf("lua: %s: %v", s.path, err)
+	}
+}
+
+// Close releases the Lua state when the route is removed
+func (s *luaStage) Close() error {
+	s.state.Close()
+	return nil
+}
+
+func (s *luaStage) table(m *Message) *lua.LTable {
+	t := s.state.NewTable()
+	t.RawSetString("time", lua.LString(m.Time.Format(time.RFC3339Nano)))
+	t.RawSetString("source", lua.LString(m.Source))
+	t.RawSetString("data", lua.LString(m.Data))
+	t.RawSetString("replay", lua.LBool(m.Replay))
+	fields := s.state.NewTable()
+	for k, v := range m.Fields {
+		fields.RawSetString(k, lua.LString(v))
+	}
+	t.RawSetString("fields", fields)
+	if m.Container != nil {
+		container := s.state.NewTable()
+		container.RawSetString("id", lua.LString(m.Container.ID))
+		container.RawSetString("name", lua.LString(strings.TrimPrefix(m.Container.Name, "/")))
+		if m.Container.Config != nil {
+			container.RawSetString("image", lua.LString(m.Container.Config.Image))
+		}
+		t.RawSetString("container", container)
+	}
+	return t
+}
+
+// message returns a copy of m changed to the table returned by the script.
+// Keys left out of the table keep their value.
+func (s *luaStage) message(m *Message, t *lua.LTable) (*Message, error) {
+	changed := *m
+	if v := t.RawGetString("time"); v != lua.LNil && v.String() != m.Time.Format(time.RFC3339Nano) {
+		parsed, err := time.Parse(time.RFC3339Nano, v.String())
+		if err != nil {
+			return nil, errors.New("bad time: " + v.String())
+		}
+		changed.Time = parsed
+	}
+	if v := t.RawGetString("source"); v != lua.LNil {
+		changed.Source = v.String()
+	}
+	if v := t.RawGetString("data"); v != lua.LNil {
+		changed.Data = v.String()
+	}
+	if v, ok := t.RawGetString("fields").(*lua.LTable); ok {
+		fields := make(map[string]string)
+		v.ForEach(func(k, v lua.LValue) {
+			fields[k.String()] = v.String()
+		})
+		changed.Fields = fields
+	}
+	return &changed, nil
+}
@@ -0,0 +1,109 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const luaTestScript = `
+function process(msg)
+	if string.find(msg.data, "debug") then
+		return nil
+	end
+	if msg.data == "loop" then
+		while true do end
+	end
+	if msg.data == "error" then
+		error("bad message")
+	end
+	msg.data = string.upper(msg.data)
+	msg.fields.container = msg.container.name
+	return msg
+end
+`
+
+func writeLuaScript(t *testing.T, script string) (string, func()) {
+	dir, err := ioutil.TempDir("", "lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "script.lua")
+	if err = ioutil.WriteFile(path, []byte(script), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, func() { os.RemoveAll(dir) }
+}
+
+func TestLuaStage(t *testing.T) {
+	path, cleanup := writeLuaScript(t, luaTestScript)
+	defer cleanup()
+	s, err := newLuaStage(&Route{Options: map[string]string{"lua": path, "lua_timeout": "100ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	now := time.Now()
+	message := &Message{
+		Data:      "hello",
+		Source:    "stdout",
+		Time:      now,
+		Fields:    map[string]string{"level": "info"},
+		Container: &docker.Container{ID: "abc", Name: "/web"},
+	}
+	out := s.process(message)
+	if out == nil || out.Data != "HELLO" || out.Source != "stdout" || !out.Time.Equal(now) {
+		t.Fatalf("expected the message changed by the script, got %+v", out)
+	}
+	if out.Fields["level"] != "info" || out.Fields["container"] != "web" {
+		t.Errorf("expected fields changed by the script, got %v", out.Fields)
+	}
+	if message.Data != "hello" || len(message.Fields) != 1 {
+		t.Error("expected the original message to be left as is")
+	}
+	if out = s.process(&Message{Data: "a debug line"}); out != nil {
+		t.Errorf("expected nil to drop the message, got %+v", out)
+	}
+	for _, data := range []string{"loop", "error"} {
+		failing := &Message{Data: data}
+		if out = s.process(failing); out != failing {
+			t.Errorf("expected a message the script fails on to pass, got %+v", out)
+		}
+	}
+	if out = s.process(message); out == nil || out.Data != "HELLO" {
+		t.Errorf("expected the script to work after a failure, got %+v", out)
+	}
+}
+
+func TestLuaStageSandbox(t *testing.T) {
+	path, cleanup := writeLuaScript(t, `function process(msg) return os.getenv("HOME") end`)
+	defer cleanup()
+	s, err := newLuaStage(&Route{Options: map[string]string{"lua": path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	message := &Message{Data: "hello"}
+	if out := s.process(message); out != message {
+		t.Errorf("expected the os library to be missing, got %+v", out)
+	}
+}
+
+func TestLuaStageOptions(t *testing.T) {
+	path, cleanup := writeLuaScript(t, `x = 1`)
+	defer cleanup()
+	for _, options := range []map[string]string{
+		{"lua": "/no/such/script.lua"},
+		{"lua": path},
+		{"lua": path, "lua_timeout": "soon"},
+	} {
+		if _, err := newStages(&Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
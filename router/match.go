@@ -0,0 +1,174 @@
+package router
+
+import (
+	"net"
+	"path"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// The labels Docker Compose gives the containers it runs, matched by
+// filter.compose.project and filter.compose.service
+const (
+	composeProjectLabel = "com.docker.compose.project"
+	composeServiceLabel = "com.docker.compose.service"
+)
+
+// routeFilter is the compiled form of the filters of a route. Routes are
+// matched against every container that starts and every message that is
+// sent, so the filters are parsed once when the route is added rather than
+// on each match.
+type routeFilter struct {
+	all     bool
+	id      string
+	name    pattern
+	labels  []labelFilter
+	sources map[string]struct{}
+	// networks and ipFilter are whether the route has network and IP
+	// filters, which don't match containers without network settings
+	networks     bool
+	networkNames []pattern
+	ipFilter     bool
+	nets         []*net.IPNet
+}
+
+type labelFilter struct {
+	key   string
+	value pattern
+	// required is whether containers without the label don't match, even
+	// when the pattern matches the empty value
+	required bool
+}
+
+// pattern is a path.Match pattern, compared as a plain string when it has no
+// special characters
+type pattern struct {
+	pattern string
+	literal bool
+}
+
+func newPattern(s string) pattern {
+	return pattern{pattern: s, literal: !strings.ContainsAny(s, `*?[\`)}
+}
+
+func (p pattern) match(s string) bool {
+	if p.literal {
+		return p.pattern == s
+	}
+	match, err := path.Match(p.pattern, s)
+	return err == nil && match
+}
+
+// compileFilter compiles the filters of the route. Invalid filter.ips entries
+// are rejected when the route is added, and match nothing here.
+func compileFilter(r *Route) *routeFilter {
+	f := &routeFilter{
+		all:      r.matchAll(),
+		id:       r.FilterID,
+		name:     newPattern(r.FilterName),
+		networks: len(r.FilterNetworks) > 0,
+		ipFilter: len(r.FilterIPs) > 0,
+	}
+	for _, label := range r.FilterLabels {
+		labelParts := strings.SplitN(label, ":", 2)
+		if len(labelParts) > 1 && labelParts[1] != "" {
+			f.labels = append(f.labels, labelFilter{key: labelParts[0], value: newPattern(labelParts[1])})
+		}
+	}
+	if r.FilterComposeProject != "" {
+		f.labels = append(f.labels, labelFilter{key: composeProjectLabel, value: newPattern(r.FilterComposeProject), required: true})
+	}
+	if r.FilterComposeService != "" {
+		f.labels = append(f.labels, labelFilter{key: composeServiceLabel, value: newPattern(r.FilterComposeService), required: true})
+	}
+	if len(r.FilterSources) > 0 {
+		f.sources = make(map[string]struct{}, len(r.FilterSources))
+		for _, source := range r.FilterSources {
+			f.sources[source] = struct{}{}
+		}
+	}
+	for _, network := range r.FilterNetworks {
+		f.networkNames = append(f.networkNames, newPattern(network))
+	}
+	for _, cidr := range r.FilterIPs {
+		if ipnet, err := parseCIDR(cidr); err == nil {
+			f.nets = append(f.nets, ipnet)
+		}
+	}
+	return f
+}
+
+// matchFilter returns the compiled filters of the route, compiling them on
+// each match for routes that weren't added through the RouteManager
+func (r *Route) matchFilter() *routeFilter {
+	if r.filter != nil {
+		return r.filter
+	}
+	return compileFilter(r)
+}
+
+func (f *routeFilter) matchContainer(id, name string, labels map[string]string) bool {
+	if f.all {
+		return true
+	}
+	if f.id != "" && !strings.HasPrefix(id, f.id) {
+		return false
+	}
+	if f.name.pattern != "" && !f.name.match(name) {
+		return false
+	}
+	for _, label := range f.labels {
+		value, ok := labels[label.key]
+		if (label.required && !ok) || !label.value.match(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *routeFilter) matchNetworks(settings *docker.NetworkSettings) bool {
+	if !f.networks && !f.ipFilter {
+		return true
+	}
+	if settings == nil {
+		return false
+	}
+	if f.networks && !f.matchNetworkName(settings) {
+		return false
+	}
+	if f.ipFilter && !f.matchIP(settings) {
+		return false
+	}
+	return true
+}
+
+func (f *routeFilter) matchNetworkName(settings *docker.NetworkSettings) bool {
+	for name := range settings.Networks {
+		for _, network := range f.networkNames {
+			if network.match(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *routeFilter) matchIP(settings *docker.NetworkSettings) bool {
+	for _, addr := range containerIPs(settings) {
+		for _, ipnet := range f.nets {
+			if ipnet.Contains(addr) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *routeFilter) matchMessage(message *Message) bool {
+	if f.sources == nil {
+		return true
+	}
+	_, ok := f.sources[message.Source]
+	return ok
+}
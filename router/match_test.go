@@ -0,0 +1,110 @@
+package router
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRouteMatchCompiled(t *testing.T) {
+	labels := map[string]string{"com.example.team": "core", "tier": "web-1"}
+	tests := []struct {
+		route *Route
+		out   bool
+	}{
+		{&Route{}, true},
+		{&Route{FilterID: "abc"}, true},
+		{&Route{FilterID: "abd"}, false},
+		{&Route{FilterName: "shop_web_1"}, true},
+		{&Route{FilterName: "shop_*"}, true},
+		{&Route{FilterName: "shop_db_*"}, false},
+		{&Route{FilterName: "shop_[web"}, false},
+		{&Route{FilterLabels: []string{"com.example.team:core"}}, true},
+		{&Route{FilterLabels: []string{"tier:web-*", "com.example.team:core"}}, true},
+		{&Route{FilterLabels: []string{"tier:db-*"}}, false},
+		{&Route{FilterLabels: []string{"missing:*"}}, true},
+		{&Route{FilterLabels: []string{"missing:x"}}, false},
+		{&Route{FilterLabels: []string{"tier"}}, true},
+	}
+	for _, test := range tests {
+		if actual := test.route.MatchContainer("abc123", "shop_web_1", labels); actual != test.out {
+			t.Errorf("uncompiled %+v: expected %v got %v", test.route, test.out, actual)
+		}
+		test.route.filter = compileFilter(test.route)
+		if actual := test.route.MatchContainer("abc123", "shop_web_1", labels); actual != test.out {
+			t.Errorf("compiled %+v: expected %v got %v", test.route, test.out, actual)
+		}
+	}
+
+	compose := map[string]string{composeProjectLabel: "shop", composeServiceLabel: "api-v2"}
+	for _, test := range []struct {
+		route  *Route
+		labels map[string]string
+		out    bool
+	}{
+		{&Route{FilterComposeProject: "shop"}, compose, true},
+		{&Route{FilterComposeProject: "shop", FilterComposeService: "api*"}, compose, true},
+		{&Route{FilterComposeProject: "shop", FilterComposeService: "web"}, compose, false},
+		{&Route{FilterComposeProject: "blog"}, compose, false},
+		{&Route{FilterComposeProject: "*"}, labels, false},
+		{&Route{FilterComposeService: "*"}, compose, true},
+	} {
+		test.route.filter = compileFilter(test.route)
+		if actual := test.route.MatchContainer("abc123", "shop_api-v2_1", test.labels); actual != test.out {
+			t.Errorf("%+v: expected %v got %v", test.route, test.out, actual)
+		}
+	}
+	parsed, err := ParseRouteURI("gelf://graylog:12201?filter.compose.project=shop&filter.compose.service=api%2A")
+	if err != nil || parsed.FilterComposeProject != "shop" || parsed.FilterComposeService != "api*" {
+		t.Errorf("expected the compose filters of the URI, got %+v, %v", parsed, err)
+	}
+
+	route := &Route{FilterSources: []string{"stderr"}}
+	route.filter = compileFilter(route)
+	if route.MatchMessage(&Message{Source: "stdout"}) || !route.MatchMessage(&Message{Source: "stderr"}) {
+		t.Error("expected only stderr messages to match")
+	}
+}
+
+func BenchmarkMatchContainer(b *testing.B) {
+	var routes []*Route
+	for i := 0; i < 500; i++ {
+		route := &Route{
+			FilterName:   fmt.Sprintf("app_%d_*", i),
+			FilterLabels: []string{fmt.Sprintf("com.example.team:team-%d", i%20)},
+		}
+		route.filter = compileFilter(route)
+		routes = append(routes, route)
+	}
+	labels := map[string]string{"com.example.team": "team-7"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, route := range routes {
+			route.MatchContainer("abc123", "app_7_web", labels)
+		}
+	}
+}
+
+func BenchmarkContainerPumpSend(b *testing.B) {
+	cp := &containerPump{logstreams: make(map[chan *Message]*Route)}
+	for i := 0; i < 100; i++ {
+		route := &Route{FilterSources: []string{"stdout"}}
+		if i%2 == 0 {
+			route.FilterSources = []string{"stderr"}
+		}
+		route.filter = compileFilter(route)
+		logstream := make(chan *Message, 1024)
+		go func() {
+			for range logstream {
+			}
+		}()
+		defer close(logstream)
+		cp.logstreams[logstream] = route
+	}
+	message := &Message{Source: "stdout", Data: "hello"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cp.send(message)
+	}
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"errors"
+	"log"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	defaultMemoryPressurePercent = 80
+	memoryCheckInterval          = time.Second
+	// pressureShrink is what buffers are divided by under memory pressure
+	pressureShrink = 4
+)
+
+// underPressure is 1 while the heap is close to the memory limit
+var underPressure int32
+
+// memoryWatch samples the heap against GOMEMLIMIT or the memory limit of the
+// container, so buffers shrink and batches are sent early before the process
+// gets killed for running out of memory, with all the messages it held. It
+// is only registered when there is a limit.
+type memoryWatch struct {
+	limit     int64
+	threshold int64
+}
+
+func init() {
+	if limit := cfg.MemoryLimit(); limit > 0 {
+		Jobs.Register(&memoryWatch{limit: limit}, "memory")
+	}
+}
+
+// MemoryPressure returns whether the heap is close to the memory limit, in
+// which case adapters should send what they hold rather than wait to batch
+// more, and keep less
+func MemoryPressure() bool {
+	return atomic.LoadInt32(&underPressure) != 0
+}
+
+// BufferSize returns the number of messages a buffer of size may hold now:
+// size, or a fraction of it under memory pressure
+func BufferSize(size int) int {
+	if size > 0 && MemoryPressure() {
+		if size /= pressureShrink; size < 1 {
+			size = 1
+		}
+	}
+	return size
+}
+
+// Name returns the name of the memory job
+func (w *memoryWatch) Name() string {
+	return "memory"
+}
+
+// Setup reads MEMORY_PRESSURE_PERCENT, the share of the limit the heap is
+// under pressure from
+func (w *memoryWatch) Setup() error {
+	percent := defaultMemoryPressurePercent
+	if s := cfg.GetEnvDefault("MEMORY_PRESSURE_PERCENT", ""); s != "" {
+		var err error
+		if percent, err = strconv.Atoi(s); err != nil || percent < 1 || percent > 100 {
+			return errors.New("bad MEMORY_PRESSURE_PERCENT: " + s)
+		}
+	}
+	w.threshold = w.limit / 100 * int64(percent)
+	return nil
+}
+
+// Run samples the heap every second
+func (w *memoryWatch) Run() error {
+	ticker := time.NewTicker(memoryCheckInterval)
+	defer ticker.Stop()
+	var stats runtime.MemStats
+	for range ticker.C {
+		runtime.ReadMemStats(&stats)
+		w.check(int64(stats.HeapInuse))
+	}
+	return nil
+}
+
+// check updates the memory pressure for heap bytes in use. Pressure ends
+// below 90% of the threshold, so it doesn't flap around it.
+func (w *memoryWatch) check(heap int64) {
+	switch {
+	case heap >= w.threshold && atomic.CompareAndSwapInt32(&underPressure, 0, 1):
+		log.Printf("memory: heap at %d of %d bytes, shrinking buffers", heap, w.limit)
+	case heap < w.threshold/10*9 && atomic.CompareAndSwapInt32(&underPressure, 1, 0):
+		log.Printf("memory: heap back at %d of %d bytes", heap, w.limit)
+	}
+}
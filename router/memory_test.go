@@ -0,0 +1,56 @@
+package router
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMemoryPressure(t *testing.T) {
+	defer atomic.StoreInt32(&underPressure, 0)
+	w := &memoryWatch{limit: 1000}
+	if err := w.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		heap     int64
+		pressure bool
+		size     int
+	}{
+		{500, false, 100},
+		{800, true, 25},
+		{750, true, 25},
+		{700, false, 100},
+	} {
+		w.check(test.heap)
+		if MemoryPressure() != test.pressure || BufferSize(100) != test.size {
+			t.Errorf("heap %d: expected pressure %v and size %d, got %v and %d", test.heap, test.pressure, test.size, MemoryPressure(), BufferSize(100))
+		}
+	}
+	atomic.StoreInt32(&underPressure, 1)
+	if size := BufferSize(2); size != 1 {
+		t.Errorf("expected buffers to keep one message, got %d", size)
+	}
+}
+
+func TestPauseBufferShrinksUnderPressure(t *testing.T) {
+	defer atomic.StoreInt32(&underPressure, 0)
+	pc, err := newPauseControl(&Route{Options: map[string]string{"pause_policy": "buffer", "pause_buffer": "8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.set(true)
+	for i := 0; i < 8; i++ {
+		pc.hold(&Message{Data: strconv.Itoa(i)})
+	}
+	atomic.StoreInt32(&underPressure, 1)
+	pc.hold(&Message{Data: "8"})
+	pc.set(false)
+	var got []string
+	for _, message := range pc.release() {
+		got = append(got, message.Data)
+	}
+	if len(got) != 2 || got[0] != "7" || got[1] != "8" {
+		t.Errorf("expected the newest 2 messages, got %v", got)
+	}
+}
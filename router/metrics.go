@@ -0,0 +1,164 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics are the delivery counters of the adapter of a route, which tell
+// whether its messages reach the backend. Adapters get them with
+// Route.Metrics and update them as they write; all methods are safe for
+// concurrent use.
+type Metrics struct {
+	route, adapter, address string
+
+	sent       int64
+	bytes      int64
+	errors     int64
+	reconnects int64
+	dropped    int64
+	filtered   int64
+}
+
+// MetricsSnapshot is the value of the Metrics of a route at one time
+type MetricsSnapshot struct {
+	Route      string `json:"route"`
+	Adapter    string `json:"adapter"`
+	Address    string `json:"address"`
+	Sent       int64  `json:"sent"`
+	Bytes      int64  `json:"bytes"`
+	Errors     int64  `json:"errors"`
+	Reconnects int64  `json:"reconnects"`
+	Dropped    int64  `json:"dropped"`
+	Filtered   int64  `json:"filtered"`
+}
+
+var metrics = struct {
+	sync.Mutex
+	routes map[string]*Metrics
+}{routes: make(map[string]*Metrics)}
+
+func init() {
+	HTTPHandlers.Register(metricsHandler, "metrics")
+}
+
+// Metrics returns the delivery counters of the adapter of the route, kept
+// for the lifetime of the route
+func (r *Route) Metrics() *Metrics {
+	metrics.Lock()
+	defer metrics.Unlock()
+	m, ok := metrics.routes[r.ID]
+	if !ok {
+		m = &Metrics{route: r.ID, adapter: r.Adapter, address: r.Address}
+		metrics.routes[r.ID] = m
+	}
+	return m
+}
+
+// Sent counts messages that were written, in bytes as sent to the backend,
+// or 0 when the adapter doesn't know
+func (m *Metrics) Sent(messages, bytes int) {
+	atomic.AddInt64(&m.sent, int64(messages))
+	atomic.AddInt64(&m.bytes, int64(bytes))
+}
+
+// Error counts a write that failed
+func (m *Metrics) Error() {
+	atomic.AddInt64(&m.errors, 1)
+}
+
+// Reconnect counts a connection that was made again after it failed
+func (m *Metrics) Reconnect() {
+	atomic.AddInt64(&m.reconnects, 1)
+}
+
+// Dropped counts messages that were given up on
+func (m *Metrics) Dropped(messages int) {
+	atomic.AddInt64(&m.dropped, int64(messages))
+}
+
+// Filtered counts messages the filters of the route dropped on purpose,
+// before they reached the adapter
+func (m *Metrics) Filtered(messages int) {
+	atomic.AddInt64(&m.filtered, int64(messages))
+}
+
+// Snapshot returns the current counts
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	return MetricsSnapshot{
+		Route:      m.route,
+		Adapter:    m.adapter,
+		Address:    m.address,
+		Sent:       atomic.LoadInt64(&m.sent),
+		Bytes:      atomic.LoadInt64(&m.bytes),
+		Errors:     atomic.LoadInt64(&m.errors),
+		Reconnects: atomic.LoadInt64(&m.reconnects),
+		Dropped:    atomic.LoadInt64(&m.dropped),
+		Filtered:   atomic.LoadInt64(&m.filtered),
+	}
+}
+
+// AllMetrics returns the delivery counters of the adapters of all routes,
+// ordered by route
+func AllMetrics() []MetricsSnapshot {
+	metrics.Lock()
+	defer metrics.Unlock()
+	snapshots := make([]MetricsSnapshot, 0, len(metrics.routes))
+	for _, m := range metrics.routes {
+		snapshots = append(snapshots, m.Snapshot())
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Route < snapshots[j].Route })
+	return snapshots
+}
+
+func removeMetrics(id string) {
+	metrics.Lock()
+	defer metrics.Unlock()
+	delete(metrics.routes, id)
+}
+
+// metricsHandler serves the counters as JSON, or in the Prometheus text
+// format with ?format=prometheus
+func metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshots := AllMetrics()
+		if r.URL.Query().Get("format") != "prometheus" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(snapshots) //nolint:errcheck
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, counter := range []struct {
+			name, help string
+			value      func(MetricsSnapshot) int64
+		}{
+			{"logspout_messages_sent_total", "Messages written by the adapter of a route.", func(s MetricsSnapshot) int64 { return s.Sent }},
+			{"logspout_bytes_sent_total", "Bytes written by the adapter of a route.", func(s MetricsSnapshot) int64 { return s.Bytes }},
+			{"logspout_write_errors_total", "Failed writes of the adapter of a route.", func(s MetricsSnapshot) int64 { return s.Errors }},
+			{"logspout_reconnects_total", "Reconnects of the adapter of a route.", func(s MetricsSnapshot) int64 { return s.Reconnects }},
+			{"logspout_messages_dropped_total", "Messages the adapter of a route gave up on.", func(s MetricsSnapshot) int64 { return s.Dropped }},
+			{"logspout_messages_filtered_total", "Messages the filters of a route dropped.", func(s MetricsSnapshot) int64 { return s.Filtered }},
+		} {
+			fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", counter.name, counter.help, counter.name)
+			for _, s := range snapshots {
+				fmt.Fprintf(w, "%s{route=%q,adapter=%q} %d\n", counter.name, promLabel(s.Route), promLabel(s.Adapter), counter.value(s))
+			}
+		}
+	})
+}
+
+// promLabel returns s without the characters %q would escape differently
+// than Prometheus label values
+func promLabel(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,91 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	route := &Route{ID: "metrics1", Adapter: "gelf+tcp", Address: "graylog:12201"}
+	m := route.Metrics()
+	if route.Metrics() != m {
+		t.Fatal("expected the same metrics for the route")
+	}
+	m.Sent(3, 120)
+	m.Sent(1, 40)
+	m.Error()
+	m.Reconnect()
+	m.Dropped(2)
+	m.Filtered(5)
+	expected := MetricsSnapshot{Route: "metrics1", Adapter: "gelf+tcp", Address: "graylog:12201", Sent: 4, Bytes: 160, Errors: 1, Reconnects: 1, Dropped: 2, Filtered: 5}
+	if got := m.Snapshot(); got != expected {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	defer removeMetrics("metrics1")
+
+	rec := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	var snapshots []MetricsSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snapshots); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, s := range snapshots {
+		found = found || s == expected
+	}
+	if !found {
+		t.Errorf("expected %+v in %s", expected, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	metricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics?format=prometheus", nil))
+	for _, line := range []string{
+		"# TYPE logspout_messages_sent_total counter",
+		`logspout_messages_sent_total{route="metrics1",adapter="gelf+tcp"} 4`,
+		`logspout_messages_dropped_total{route="metrics1",adapter="gelf+tcp"} 2`,
+		`logspout_messages_filtered_total{route="metrics1",adapter="gelf+tcp"} 5`,
+	} {
+		if !strings.Contains(rec.Body.String(), line+"\n") {
+			t.Errorf("expected %q in\n%s", line, rec.Body.String())
+		}
+	}
+
+	removeMetrics("metrics1")
+	for _, s := range AllMetrics() {
+		if s.Route == "metrics1" {
+			t.Error("expected the metrics of a removed route to be gone")
+		}
+	}
+}
+
+func TestMetricsOfRoutesWithoutID(t *testing.T) {
+	var taken []*Metrics
+	AdapterFactories.Register(func(route *Route) (LogAdapter, error) {
+		// adapters take the metrics of their route when they are created
+		taken = append(taken, route.Metrics())
+		return &DummyAdapter{}, nil
+	}, "metered")
+	defer AdapterFactories.Unregister("metered")
+	rm := &RouteManager{routes: make(map[string]*Route)}
+	first, second := &Route{Adapter: "metered"}, &Route{Adapter: "metered"}
+	for _, route := range []*Route{first, second} {
+		if err := rm.Add(route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer removeMetrics(first.ID)
+	defer removeMetrics(second.ID)
+	if len(taken) != 2 || taken[0] == taken[1] {
+		t.Fatalf("expected metrics of each route, got %v", taken)
+	}
+	if taken[0].route != first.ID || taken[1].route != second.ID {
+		t.Errorf("expected the metrics of routes %s and %s, got %s and %s", first.ID, second.ID, taken[0].route, taken[1].route)
+	}
+	for _, m := range AllMetrics() {
+		if m.Route == "" {
+			t.Errorf("expected no metrics without a route ID, got %+v", m)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package router
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// DisableModules unregisters the adapters, transports and HTTP endpoints
+// listed in DISABLE_ADAPTERS, DISABLE_TRANSPORTS and DISABLE_HTTP, so one
+// build can be locked down per environment. Routes using a disabled adapter
+// or transport fail to be added. Unknown names are an error, so a typo
+// doesn't leave a module enabled.
+func DisableModules() error {
+	for _, m := range []struct {
+		env        string
+		unregister func(name string) bool
+	}{
+		{"DISABLE_ADAPTERS", AdapterFactories.Unregister},
+		{"DISABLE_TRANSPORTS", AdapterTransports.Unregister},
+		{"DISABLE_HTTP", HTTPHandlers.Unregister},
+	} {
+		for _, name := range strings.Split(cfg.GetEnvDefault(m.env, ""), ",") {
+			if name = strings.TrimSpace(name); name != "" && !m.unregister(name) {
+				return errors.New("bad " + m.env + ": " + name + " is not registered")
+			}
+		}
+	}
+	return nil
+}
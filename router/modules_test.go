@@ -0,0 +1,33 @@
+package router
+
+import (
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestDisableModules(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "dummy")
+	HTTPHandlers.Register(func() http.Handler { return http.NotFoundHandler() }, "dummy")
+	os.Setenv("DISABLE_ADAPTERS", "dummy")
+	os.Setenv("DISABLE_HTTP", " dummy ")
+	defer os.Unsetenv("DISABLE_ADAPTERS")
+	defer os.Unsetenv("DISABLE_HTTP")
+	if err := DisableModules(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := AdapterFactories.Lookup("dummy"); ok {
+		t.Error("expected the adapter to be disabled")
+	}
+	if _, ok := HTTPHandlers.Lookup("dummy"); ok {
+		t.Error("expected the HTTP endpoint to be disabled")
+	}
+	if err := (&RouteManager{routes: make(map[string]*Route)}).Add(&Route{Adapter: "dummy"}); err == nil {
+		t.Error("expected routes with a disabled adapter to fail")
+	}
+
+	os.Setenv("DISABLE_ADAPTERS", "nope")
+	if err := DisableModules(); err == nil {
+		t.Error("expected error for an unknown adapter")
+	}
+}
@@ -0,0 +1,118 @@
+package router
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	orderContainer       = "container"
+	defaultOrderHoldback = time.Second
+	defaultOrderBuffer   = 1000
+)
+
+type orderedMessage struct {
+	message  *Message
+	received time.Time
+}
+
+// orderStage holds messages back for a while and passes them on in the order
+// of their timestamps, so the messages of a container reach the adapter in
+// the order they were written even when they arrive out of order, like when
+// the log stream of a container is attached again after a reconnect. A
+// message that arrives after a newer message of its container was passed on
+// is late; it is passed on right away.
+type orderStage struct {
+	holdback time.Duration
+	size     int
+	now      func() time.Time
+
+	// held is ordered by message time, and by arrival for equal times
+	held []orderedMessage
+	// last is the time of the last message passed on for each container, and
+	// when that was, to recognize late messages
+	last map[string]orderedMessage
+	late int
+}
+
+func newOrderStage(route *Route) (*orderStage, error) {
+	if mode := route.Options["order"]; mode != orderContainer {
+		return nil, errors.New("bad order: " + mode)
+	}
+	s := &orderStage{
+		holdback: defaultOrderHoldback,
+		size:     defaultOrderBuffer,
+		now:      time.Now,
+		last:     make(map[string]orderedMessage),
+	}
+	if v := route.Options["order_holdback"]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, errors.New("bad order_holdback: " + v)
+		}
+		s.holdback = d
+	}
+	if v := route.Options["order_buffer"]; v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil || size < 1 {
+			return nil, errors.New("bad order_buffer: " + v)
+		}
+		s.size = size
+	}
+	return s, nil
+}
+
+func containerID(message *Message) string {
+	if message.Container == nil {
+		return ""
+	}
+	return message.Container.ID
+}
+
+func (s *orderStage) process(message *Message) *Message {
+	if last, ok := s.last[containerID(message)]; ok && message.Time.Before(last.message.Time) {
+		s.late++
+		return message
+	}
+	i := sort.Search(len(s.held), func(i int) bool {
+		return s.held[i].message.Time.After(message.Time)
+	})
+	s.held = append(s.held, orderedMessage{})
+	copy(s.held[i+1:], s.held[i:])
+	s.held[i] = orderedMessage{message: message, received: s.now()}
+	if len(s.held) > s.size {
+		// make room by passing on the oldest message early
+		return s.pop(s.now())
+	}
+	return nil
+}
+
+func (s *orderStage) pop(now time.Time) *Message {
+	message := s.held[0].message
+	s.held[0] = orderedMessage{}
+	s.held = s.held[1:]
+	s.last[containerID(message)] = orderedMessage{message: message, received: now}
+	return message
+}
+
+// flush passes on the held messages in order up to the first one that is not
+// due yet. A message is due once it was held for the holdback; the messages
+// after it wait for it, for at most the holdback.
+func (s *orderStage) flush(now time.Time) []*Message {
+	var messages []*Message
+	for len(s.held) > 0 && (now.IsZero() || now.Sub(s.held[0].received) >= s.holdback) {
+		messages = append(messages, s.pop(now))
+	}
+	for id, last := range s.last {
+		if now.IsZero() || now.Sub(last.received) > s.holdback {
+			delete(s.last, id)
+		}
+	}
+	if s.late > 0 {
+		debug("order: passed on", s.late, "late messages")
+		s.late = 0
+	}
+	return messages
+}
@@ -0,0 +1,78 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func orderedData(messages []*Message) []string {
+	var data []string
+	for _, message := range messages {
+		data = append(data, message.Data)
+	}
+	return data
+}
+
+func TestOrderStage(t *testing.T) {
+	s, err := newOrderStage(&Route{Options: map[string]string{"order": "container", "order_holdback": "1s"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+	base := time.Unix(500, 0)
+	a, b := imageContainer("a", "app"), imageContainer("b", "app")
+	for _, m := range []*Message{
+		{Container: a, Data: "a2", Time: base.Add(2 * time.Millisecond)},
+		{Container: b, Data: "b1", Time: base.Add(time.Millisecond)},
+		{Container: a, Data: "a1", Time: base},
+		{Container: a, Data: "a3", Time: base.Add(2 * time.Millisecond)},
+	} {
+		if out := s.process(m); out != nil {
+			t.Fatalf("expected %s to be held back", out.Data)
+		}
+	}
+	if messages := s.flush(now.Add(500 * time.Millisecond)); len(messages) != 0 {
+		t.Errorf("expected nothing due before the holdback, got %v", orderedData(messages))
+	}
+	got := orderedData(s.flush(now.Add(time.Second)))
+	if want := []string{"a1", "b1", "a2", "a3"}; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] || got[3] != want[3] {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	late := &Message{Container: a, Data: "late", Time: base.Add(time.Millisecond)}
+	if out := s.process(late); out != late {
+		t.Error("expected a late message to be passed on right away")
+	}
+	if out := s.process(&Message{Container: b, Data: "b2", Time: base.Add(3 * time.Millisecond)}); out != nil {
+		t.Error("expected the message of another container to be held back")
+	}
+}
+
+func TestOrderStageBuffer(t *testing.T) {
+	s, err := newOrderStage(&Route{Options: map[string]string{"order": "container", "order_buffer": "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Unix(500, 0)
+	s.process(&Message{Data: "2", Time: base.Add(2)})
+	s.process(&Message{Data: "3", Time: base.Add(3)})
+	if out := s.process(&Message{Data: "1", Time: base.Add(1)}); out == nil || out.Data != "1" {
+		t.Errorf("expected the oldest message to be passed on when the buffer is full, got %v", out)
+	}
+	if got := orderedData(s.flush(time.Time{})); len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Errorf("expected the held messages on a final flush, got %v", got)
+	}
+}
+
+func TestOrderStageOptions(t *testing.T) {
+	for _, options := range []map[string]string{
+		{"order": "global"},
+		{"order": "container", "order_holdback": "0s"},
+		{"order": "container", "order_buffer": "0"},
+	} {
+		if _, err := newStages(&Route{Options: options}); err == nil {
+			t.Errorf("expected error for %v", options)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const (
+	multilineFlushAfter = 500 * time.Millisecond
+	maxMultilineLines   = 500
+	maxMultilineBytes   = 64 * 1024
+	maxProfileImages    = 1024
+)
+
+// parseProfile describes how to parse the logs of the containers running an
+// image matching one of Images
+type parseProfile struct {
+	Name   string   `json:"name"`
+	Images []string `json:"images"`
+	// Multiline matches the lines that continue the previous line
+	Multiline string `json:"multiline,omitempty"`
+	// Patterns are tried in order; the named groups of the first one matching
+	// become fields of the message
+	Patterns []string `json:"patterns,omitempty"`
+	// Levels maps the values of the level group to the level field, on top
+	// of the common level names
+	Levels map[string]string `json:"levels,omitempty"`
+
+	multiline *regexp.Regexp
+	patterns  []*regexp.Regexp
+}
+
+// commonLevels maps the level names used by common software to the level
+// field
+var commonLevels = map[string]string{
+	"trace":     "debug",
+	"debug":     "debug",
+	"info":      "info",
+	"log":       "info",
+	"notice":    "notice",
+	"warn":      "warning",
+	"warning":   "warning",
+	"err":       "error",
+	"error":     "error",
+	"crit":      "critical",
+	"critical":  "critical",
+	"fatal":     "critical",
+	"panic":     "critical",
+	"alert":     "alert",
+	"emerg":     "emergency",
+	"emergency": "emergency",
+}
+
+func (p *parseProfile) compile() (err error) {
+	if p.Name == "" || len(p.Images) == 0 {
+		return errors.New("parse profile needs a name and images")
+	}
+	if p.Multiline != "" {
+		if p.multiline, err = regexp.Compile(p.Multiline); err != nil {
+			return errors.New("parse profile " + p.Name + ": bad multiline: " + err.Error())
+		}
+	}
+	p.patterns = nil
+	for _, pattern := range p.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.New("parse profile " + p.Name + ": bad pattern: " + err.Error())
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	return nil
+}
+
+// matches reports whether the profile applies to image, as normalized by
+// imageName. Patterns without a tag match any tag.
+func (p *parseProfile) matches(image string) bool {
+	for _, pattern := range p.Images {
+		if !strings.Contains(path.Base(pattern), ":") {
+			pattern += ":*"
+		}
+		if ok, _ := path.Match(pattern, image); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parse returns a copy of message with the fields extracted by the first
+// pattern matching it
+func (p *parseProfile) parse(message *Message) *Message {
+	fields := map[string]string{"parse_profile": p.Name}
+	for _, re := range p.patterns {
+		match := re.FindStringSubmatch(message.Data)
+		if match == nil {
+			continue
+		}
+		for i, name := range re.SubexpNames() {
+			if name != "" && match[i] != "" {
+				fields[name] = match[i]
+			}
+		}
+		if level, ok := fields["level"]; ok {
+			fields["level"] = p.level(level)
+		}
+		break
+	}
+	return message.WithFields(fields)
+}
+
+func (p *parseProfile) level(value string) string {
+	if level, ok := p.Levels[value]; ok {
+		return level
+	}
+	lower := strings.ToLower(value)
+	if level, ok := commonLevels[strings.TrimRight(lower, "0123456789")]; ok {
+		return level
+	}
+	return lower
+}
+
+// imageName normalizes image to name:tag, without the digest and the default
+// registry and namespace, so nginx, docker.io/library/nginx:latest and
+// nginx@sha256:... are all nginx:latest
+func imageName(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	image = strings.TrimPrefix(image, "docker.io/")
+	image = strings.TrimPrefix(image, "library/")
+	if !strings.Contains(path.Base(image), ":") {
+		image += ":latest"
+	}
+	return image
+}
+
+// loadProfiles returns the profiles in the JSON file at filename followed by
+// the built-in ones they don't replace
+func loadProfiles(filename string) ([]*parseProfile, error) {
+	var profiles []*parseProfile
+	if filename != "" {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &profiles); err != nil {
+			return nil, errors.New("bad PARSE_PROFILES: " + err.Error())
+		}
+	}
+	custom := make(map[string]bool, len(profiles))
+	for _, profile := range profiles {
+		custom[profile.Name] = true
+	}
+	for _, profile := range builtinProfiles {
+		if !custom[profile.Name] {
+			copied := *profile
+			profiles = append(profiles, &copied)
+		}
+	}
+	for _, profile := range profiles {
+		if err := profile.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
+
+// heldMessage is an entry being joined from multiple lines, held until its
+// next entry starts or no line was added for multilineFlushAfter
+type heldMessage struct {
+	message *Message
+	profile *parseProfile
+	since   time.Time
+	lines   int
+}
+
+// parseStage applies the parse profile matching the image of the container of
+// each message. It is only used from the runStages goroutine of its route.
+type parseStage struct {
+	profiles []*parseProfile
+	images   map[string]*parseProfile
+	held     map[string]*heldMessage
+}
+
+// newParseStage returns a stage using the profiles named in names, a comma
+// separated list, or all of them for "true"
+func newParseStage(names string) (*parseStage, error) {
+	profiles, err := loadProfiles(cfg.GetEnvDefault("PARSE_PROFILES", ""))
+	if err != nil {
+		return nil, err
+	}
+	s := &parseStage{images: make(map[string]*parseProfile), held: make(map[string]*heldMessage)}
+	if names == "true" {
+		s.profiles = profiles
+		return s, nil
+	}
+	for _, name := range strings.Split(names, ",") {
+		found := false
+		for _, profile := range profiles {
+			if profile.Name == strings.TrimSpace(name) {
+				s.profiles = append(s.profiles, profile)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("unknown parse profile: " + name)
+		}
+	}
+	return s, nil
+}
+
+func (s *parseStage) profile(container *docker.Container) *parseProfile {
+	if container == nil || container.Config == nil {
+		return nil
+	}
+	image := container.Config.Image
+	if profile, ok := s.images[image]; ok {
+		return profile
+	}
+	if len(s.images) >= maxProfileImages {
+		s.images = make(map[string]*parseProfile)
+	}
+	var match *parseProfile
+	name := imageName(image)
+	for _, profile := range s.profiles {
+		if profile.matches(name) {
+			match = profile
+			break
+		}
+	}
+	s.images[image] = match
+	return match
+}
+
+func (s *parseStage) process(message *Message) *Message {
+	profile := s.profile(message.Container)
+	if profile == nil {
+		return message
+	}
+	if profile.multiline == nil {
+		return profile.parse(message)
+	}
+	key := message.Container.ID + "/" + message.Source
+	held := s.held[key]
+	if held != nil && held.profile == profile && held.lines < maxMultilineLines &&
+		len(held.message.Data) < maxMultilineBytes && profile.multiline.MatchString(message.Data) {
+		held.message.Data += "\n" + message.Data
+		held.since = time.Now()
+		held.lines++
+		return nil
+	}
+	copied := *message
+	s.held[key] = &heldMessage{message: &copied, profile: profile, since: time.Now(), lines: 1}
+	if held == nil {
+		return nil
+	}
+	return held.profile.parse(held.message)
+}
+
+func (s *parseStage) flush(now time.Time) []*Message {
+	var messages []*Message
+	for key, held := range s.held {
+		if now.IsZero() || now.Sub(held.since) >= multilineFlushAfter {
+			messages = append(messages, held.profile.parse(held.message))
+			delete(s.held, key)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,189 @@
+package router
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func imageContainer(id, image string) *docker.Container {
+	return &docker.Container{ID: id, Config: &docker.Config{Image: image}}
+}
+
+func TestImageName(t *testing.T) {
+	for image, expected := range map[string]string{
+		"nginx":                               "nginx:latest",
+		"docker.io/library/nginx:1.25":        "nginx:1.25",
+		"nginx@sha256:abcd":                   "nginx:latest",
+		"registry.example.com:5000/app":       "registry.example.com:5000/app:latest",
+		"registry.example.com:5000/app:2-dev": "registry.example.com:5000/app:2-dev",
+	} {
+		if got := imageName(image); got != expected {
+			t.Errorf("expected %s for %s, got %s", expected, image, got)
+		}
+	}
+}
+
+func TestParseNginxAccessLog(t *testing.T) {
+	s, err := newParseStage("true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &Message{
+		Container: imageContainer("abc", "nginx:1.25-alpine"),
+		Source:    "stdout",
+		Data:      `172.17.0.1 - - [10/Oct/2026:13:55:36 +0000] "GET /index.html HTTP/1.1" 404 153 "-" "curl/8.0"`,
+	}
+	parsed := s.process(message)
+	for name, expected := range map[string]string{
+		"parse_profile": "nginx",
+		"client":        "172.17.0.1",
+		"method":        "GET",
+		"path":          "/index.html",
+		"status":        "404",
+		"user_agent":    "curl/8.0",
+	} {
+		if parsed.Fields[name] != expected {
+			t.Errorf("expected %s=%s, got %q", name, expected, parsed.Fields[name])
+		}
+	}
+	if other := s.process(&Message{Container: imageContainer("def", "redisx:7"), Data: "x"}); other.Fields != nil {
+		t.Errorf("expected messages of other images to pass unchanged, got %v", other.Fields)
+	}
+}
+
+func TestParsePostgresMultiline(t *testing.T) {
+	s, err := newParseStage("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := imageContainer("abc", "postgres:16")
+	lines := []string{
+		"2026-10-14 09:00:00.123 UTC [42] ERROR:  syntax error at or near \"SELEC\"",
+		"\tat character 1",
+		"2026-10-14 09:00:01.000 UTC [42] LOG:  checkpoint starting: time",
+	}
+	var out []*Message
+	for _, line := range lines {
+		if message := s.process(&Message{Container: container, Source: "stderr", Data: line}); message != nil {
+			out = append(out, message)
+		}
+	}
+	if len(out) != 1 {
+		t.Fatalf("expected the first entry once the next one starts, got %d messages", len(out))
+	}
+	if out[0].Data != lines[0]+"\n"+lines[1] || out[0].Fields["level"] != "error" || out[0].Fields["pid"] != "42" {
+		t.Errorf("unexpected first entry %q %v", out[0].Data, out[0].Fields)
+	}
+	if held := s.flush(time.Now()); len(held) != 0 {
+		t.Errorf("expected the last entry to be held until it is due, got %v", held)
+	}
+	held := s.flush(time.Now().Add(multilineFlushAfter))
+	if len(held) != 1 || held[0].Fields["level"] != "info" {
+		t.Errorf("expected the last entry to be flushed as info, got %v", held)
+	}
+}
+
+func TestRunStagesFlushesOnClose(t *testing.T) {
+	s, err := newParseStage("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan *Message, 1)
+	out := make(chan *Message, 1)
+	in <- &Message{Container: imageContainer("abc", "postgres"), Data: "2026-10-14 09:00:00 UTC [1] LOG:  ready"}
+	close(in)
+	runStages([]stage{s}, in, out)
+	if message := <-out; message == nil || message.Fields["parse_profile"] != "postgres" {
+		t.Errorf("expected the held entry on close, got %v", message)
+	}
+}
+
+func TestParseCustomProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "profiles.json")
+	profiles := `[{"name": "nginx", "images": ["example/*"], "patterns": ["^(?P<level>[A-Z]+) "], "levels": {"OOPS": "error"}}]`
+	if err = ioutil.WriteFile(filename, []byte(profiles), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("PARSE_PROFILES", filename)
+	defer os.Unsetenv("PARSE_PROFILES")
+
+	s, err := newParseStage("nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message := s.process(&Message{Container: imageContainer("a", "example/app:1"), Data: "OOPS broke"}); message.Fields["level"] != "error" {
+		t.Errorf("expected the custom profile with its level mapping, got %v", message.Fields)
+	}
+	if message := s.process(&Message{Container: imageContainer("b", "nginx"), Data: "x"}); message.Fields != nil {
+		t.Errorf("expected the custom profile to replace the built-in one, got %v", message.Fields)
+	}
+	if _, err = newParseStage("nginx,nope"); err == nil {
+		t.Error("expected error for an unknown profile")
+	}
+}
+
+func TestBuiltinProfiles(t *testing.T) {
+	s, err := newParseStage("true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		image    string
+		line     string
+		expected map[string]string
+	}{
+		{
+			"nginx", `2026/10/14 09:00:00 [warn] 29#29: *1 upstream response is buffered`,
+			map[string]string{"parse_profile": "nginx", "level": "warning", "pid": "29"},
+		},
+		{
+			"httpd:2.4", `[Wed Oct 14 09:00:00.123456 2026] [core:error] [pid 12:tid 34] [client 10.0.0.1:5678] AH00126: Invalid URI`,
+			map[string]string{"parse_profile": "apache", "module": "core", "level": "error", "pid": "12", "client": "10.0.0.1:5678"},
+		},
+		{
+			"php:8.2-apache", `10.0.0.1 - bob [14/Oct/2026:09:00:00 +0000] "POST /login HTTP/1.1" 302 5`,
+			map[string]string{"parse_profile": "apache", "user": "bob", "method": "POST", "status": "302", "bytes": "5"},
+		},
+		{
+			"redis:7-alpine", `1:M 14 Oct 2026 09:00:00.123 # WARNING overcommit_memory is set to 0`,
+			map[string]string{"parse_profile": "redis", "pid": "1", "role": "M", "level": "warning"},
+		},
+		{
+			"haproxy:2.9", `10.0.1.2:33317 [14/Oct/2026:09:00:00.123] http-in static/srv1 10/0/30/69/109 200 2750 - - ---- 1/1/1/1/0 0/0 "GET /index.html HTTP/1.1"`,
+			map[string]string{"parse_profile": "haproxy", "frontend": "http-in", "backend": "static", "server": "srv1", "status": "200", "path": "/index.html"},
+		},
+		{
+			"haproxy", `10.0.1.2:33318 [14/Oct/2026:09:00:01.000] tcp-in db/pg1 0/0/7 212 -- 1/1/1/1/0 0/0`,
+			map[string]string{"parse_profile": "haproxy", "backend": "db", "bytes": "212", "termination_state": "--"},
+		},
+		{
+			"traefik:v3.1", `10.0.0.1 - - [14/Oct/2026:09:00:00 +0000] "GET / HTTP/2.0" 200 10 "-" "curl/8.0" 7 "web@docker" "http://172.18.0.2:80" 3ms`,
+			map[string]string{"parse_profile": "traefik", "router": "web@docker", "service_url": "http://172.18.0.2:80", "duration": "3ms"},
+		},
+		{
+			"traefik:v3.1", `2026-10-14T09:00:00Z WRN Router uses a non-existent service`,
+			map[string]string{"parse_profile": "traefik", "level": "warning"},
+		},
+		{
+			"traefik:v2.11", `time="2026-10-14T09:00:00Z" level=error msg="Cannot start the provider"`,
+			map[string]string{"parse_profile": "traefik", "level": "error"},
+		},
+	} {
+		message := s.process(&Message{Container: imageContainer(test.image, test.image), Data: test.line})
+		for name, expected := range test.expected {
+			if message.Fields[name] != expected {
+				t.Errorf("%s: expected %s=%s, got %q", test.image, name, expected, message.Fields[name])
+			}
+		}
+	}
+}
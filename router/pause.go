@@ -0,0 +1,200 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+const (
+	pausePolicyDrop      = "drop"
+	pausePolicyBuffer    = "buffer"
+	defaultPauseBufferSz = 1000
+)
+
+// pauseControl holds the pause state of a route, which is shared with the
+// goroutine relaying messages to the adapter
+type pauseControl struct {
+	mu     sync.Mutex
+	paused bool
+	policy string
+	size   int
+	// catchup is the number of buffered messages sent per live message after a
+	// resume, or 0 to send all buffered messages first
+	catchup int
+	buffer  []*Message
+	dropped int
+	resumed chan struct{}
+}
+
+func newPauseControl(route *Route) (*pauseControl, error) {
+	pc := &pauseControl{
+		paused:  route.Paused,
+		policy:  pausePolicyDrop,
+		size:    defaultPauseBufferSz,
+		resumed: make(chan struct{}, 1),
+	}
+	if policy := route.Options["pause_policy"]; policy != "" {
+		if policy != pausePolicyDrop && policy != pausePolicyBuffer {
+			return nil, errors.New("bad pause_policy: " + policy)
+		}
+		pc.policy = policy
+	}
+	if s := route.Options["pause_buffer"]; s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 {
+			return nil, errors.New("bad pause_buffer: " + s)
+		}
+		pc.size = size
+	}
+	if s := route.Options["pause_catchup_ratio"]; s != "" {
+		ratio, err := strconv.Atoi(s)
+		if err != nil || ratio < 1 {
+			return nil, errors.New("bad pause_catchup_ratio: " + s)
+		}
+		pc.catchup = ratio
+	}
+	return pc, nil
+}
+
+func (pc *pauseControl) set(paused bool) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.paused = paused
+	if !paused {
+		select {
+		case pc.resumed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// hold keeps message back while the route is paused, returning whether it did
+func (pc *pauseControl) hold(message *Message) bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if !pc.paused {
+		return false
+	}
+	if pc.policy == pausePolicyBuffer {
+		if size := BufferSize(pc.size); len(pc.buffer) == size {
+			// make room by dropping the oldest message
+			pc.buffer = pc.buffer[1:]
+		} else if len(pc.buffer) > size {
+			// the buffer shrank under memory pressure: copy what is kept, so
+			// the memory of the rest can be freed
+			pc.buffer = append([]*Message(nil), pc.buffer[len(pc.buffer)-size+1:]...)
+		}
+		pc.buffer = append(pc.buffer, message)
+	} else {
+		pc.dropped++
+	}
+	return true
+}
+
+// release returns the messages buffered while the route was paused
+func (pc *pauseControl) release() []*Message {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.paused {
+		return nil
+	}
+	buffer := pc.buffer
+	pc.buffer = nil
+	if pc.dropped > 0 {
+		debug("pause: dropped", pc.dropped, "messages while paused")
+		pc.dropped = 0
+	}
+	return buffer
+}
+
+// requeue puts the buffered messages that weren't sent before a pause back
+// in front of the buffer
+func (pc *pauseControl) requeue(backlog []*Message) {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.buffer = append(backlog, pc.buffer...)
+	if size := BufferSize(pc.size); len(pc.buffer) > size {
+		pc.buffer = pc.buffer[len(pc.buffer)-size:]
+	}
+}
+
+func (pc *pauseControl) isPaused() bool {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	return pc.paused
+}
+
+// relay forwards messages from in to out, holding them back while paused
+func (pc *pauseControl) relay(in <-chan *Message, out chan<- *Message) {
+	defer close(out)
+	if pc.catchup > 0 {
+		pc.relayCatchup(in, out)
+		return
+	}
+	flush := func() {
+		for _, message := range pc.release() {
+			out <- message
+		}
+	}
+	for {
+		select {
+		case message, ok := <-in:
+			if !ok {
+				return
+			}
+			if pc.hold(message) {
+				continue
+			}
+			// buffered messages go first to keep the order
+			flush()
+			out <- message
+		case <-pc.resumed:
+			flush()
+		}
+	}
+}
+
+// relayCatchup forwards messages like relay, but sends the messages buffered
+// while paused alongside the live ones: at least catchup buffered messages
+// follow each live message, and the rest go out while there is no live
+// traffic. Live messages don't wait behind the whole backlog, at the cost of
+// their order relative to the backlog.
+func (pc *pauseControl) relayCatchup(in <-chan *Message, out chan<- *Message) {
+	var backlog []*Message
+	for {
+		if len(backlog) > 0 && pc.isPaused() {
+			pc.requeue(backlog)
+			backlog = nil
+		}
+		var next chan<- *Message
+		var first *Message
+		if len(backlog) > 0 {
+			next, first = out, backlog[0]
+		}
+		select {
+		case message, ok := <-in:
+			if !ok {
+				for _, message := range backlog {
+					out <- message
+				}
+				return
+			}
+			if pc.hold(message) {
+				continue
+			}
+			backlog = append(backlog, pc.release()...)
+			out <- message
+			for i := 0; i < pc.catchup && len(backlog) > 0; i++ {
+				out <- backlog[0]
+				backlog[0] = nil
+				backlog = backlog[1:]
+			}
+		case next <- first:
+			backlog[0] = nil
+			backlog = backlog[1:]
+		case <-pc.resumed:
+			backlog = append(backlog, pc.release()...)
+		}
+	}
+}
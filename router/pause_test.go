@@ -0,0 +1,147 @@
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitHeld waits until n messages are held back, as the relay may still be
+// handling the last message sent
+func waitHeld(t *testing.T, pc *pauseControl, n int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		pc.mu.Lock()
+		held := len(pc.buffer) + pc.dropped
+		pc.mu.Unlock()
+		if held >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timeout waiting for %d held messages", n)
+}
+
+func TestPauseBufferKeepsOrder(t *testing.T) {
+	pc, err := newPauseControl(&Route{Options: map[string]string{"pause_policy": "buffer"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan *Message)
+	out := make(chan *Message, 10)
+	go pc.relay(in, out)
+
+	in <- &Message{Data: "1"}
+	pc.set(true)
+	in <- &Message{Data: "2"}
+	in <- &Message{Data: "3"}
+	waitHeld(t, pc, 2)
+	pc.set(false)
+	in <- &Message{Data: "4"}
+	close(in)
+
+	var got []string
+	for message := range out {
+		got = append(got, message.Data)
+	}
+	if strings.Join(got, ",") != "1,2,3,4" {
+		t.Errorf("expected messages in order, got %v", got)
+	}
+}
+
+func TestPauseBufferDropsOldest(t *testing.T) {
+	pc, err := newPauseControl(&Route{Options: map[string]string{"pause_policy": "buffer", "pause_buffer": "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.set(true)
+	for _, data := range []string{"1", "2", "3"} {
+		if !pc.hold(&Message{Data: data}) {
+			t.Fatal("expected message to be held while paused")
+		}
+	}
+	pc.set(false)
+	buffered := pc.release()
+	if len(buffered) != 2 || buffered[0].Data != "2" || buffered[1].Data != "3" {
+		t.Errorf("expected the two newest messages, got %v", buffered)
+	}
+}
+
+func TestPauseDrop(t *testing.T) {
+	pc, err := newPauseControl(&Route{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan *Message)
+	out := make(chan *Message, 10)
+	go pc.relay(in, out)
+
+	pc.set(true)
+	in <- &Message{Data: "1"}
+	waitHeld(t, pc, 1)
+	pc.set(false)
+	in <- &Message{Data: "2"}
+	close(in)
+
+	var got []string
+	for message := range out {
+		got = append(got, message.Data)
+	}
+	if len(got) != 1 || got[0] != "2" {
+		t.Errorf("expected only the message sent after resuming, got %v", got)
+	}
+}
+
+func TestPauseBadPolicy(t *testing.T) {
+	if _, err := newPauseControl(&Route{Options: map[string]string{"pause_policy": "queue"}}); err == nil {
+		t.Error("expected error for unknown pause_policy")
+	}
+}
+
+func TestPauseCatchupInterleaves(t *testing.T) {
+	pc, err := newPauseControl(&Route{Options: map[string]string{"pause_policy": "buffer", "pause_catchup_ratio": "2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := make(chan *Message)
+	out := make(chan *Message)
+	go pc.relay(in, out)
+
+	pc.set(true)
+	for _, data := range []string{"b1", "b2", "b3", "b4"} {
+		in <- &Message{Data: data}
+	}
+	waitHeld(t, pc, 4)
+	pc.set(false)
+	in <- &Message{Data: "live"}
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, (<-out).Data)
+	}
+	close(in)
+	for message := range out {
+		got = append(got, message.Data)
+	}
+	if strings.Join(got, ",") != "live,b1,b2,b3,b4" {
+		t.Errorf("expected the live message ahead of the backlog, got %v", got)
+	}
+
+	if _, err := newPauseControl(&Route{Options: map[string]string{"pause_catchup_ratio": "0"}}); err == nil {
+		t.Error("expected error for a ratio of 0")
+	}
+}
+
+func TestPauseCatchupRequeuesOnPause(t *testing.T) {
+	pc, err := newPauseControl(&Route{Options: map[string]string{"pause_policy": "buffer", "pause_catchup_ratio": "1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.set(true)
+	pc.hold(&Message{Data: "3"})
+	pc.requeue([]*Message{{Data: "1"}, {Data: "2"}})
+	pc.set(false)
+	buffered := pc.release()
+	if len(buffered) != 3 || buffered[0].Data != "1" || buffered[2].Data != "3" {
+		t.Errorf("expected the requeued messages first, got %v", buffered)
+	}
+}
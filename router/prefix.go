@@ -0,0 +1,54 @@
+package router
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+)
+
+// criPrefix is the prefix of the lines of the CRI log format, a timestamp,
+// the stream and whether the line is partial
+const criPrefix = `\S+ (stdout|stderr) [FP] `
+
+// newPrefixStage returns a stage that strips the prefix some runtimes write
+// before each line, so its timestamps and levels aren't shipped twice. The
+// prefix is a number of bytes, cri for the prefix of the CRI log format, or a
+// regular expression matched at the start of the line. Lines without the
+// prefix pass unchanged. The stripped prefix is kept in field when it is set.
+func newPrefixStage(prefix, field string) (stage, error) {
+	if width, err := strconv.Atoi(prefix); err == nil {
+		if width < 1 {
+			return nil, errors.New("bad strip_prefix: " + prefix)
+		}
+		return stageFunc(func(message *Message) *Message {
+			if len(message.Data) < width {
+				return message
+			}
+			return stripPrefix(message, width, field)
+		}), nil
+	}
+	if prefix == "cri" {
+		prefix = criPrefix
+	}
+	re, err := regexp.Compile(`^(?:` + prefix + `)`)
+	if err != nil {
+		return nil, errors.New("bad strip_prefix: " + prefix + ": " + err.Error())
+	}
+	return stageFunc(func(message *Message) *Message {
+		loc := re.FindStringIndex(message.Data)
+		if loc == nil || loc[1] == 0 {
+			return message
+		}
+		return stripPrefix(message, loc[1], field)
+	}), nil
+}
+
+// stripPrefix returns a copy of message without the first n bytes of Data
+func stripPrefix(message *Message, n int, field string) *Message {
+	stripped := *message
+	if field != "" {
+		stripped = *message.WithFields(map[string]string{field: message.Data[:n]})
+	}
+	stripped.Data = message.Data[n:]
+	return &stripped
+}
@@ -0,0 +1,34 @@
+package router
+
+import "testing"
+
+func TestPrefixStage(t *testing.T) {
+	for _, tc := range []struct {
+		prefix, field, data, want, kept string
+	}{
+		{"5", "", "12:00 hello", " hello", ""},
+		{"5", "", "1234", "1234", ""},
+		{"cri", "", "2024-01-02T03:04:05.678Z stdout F hello", "hello", ""},
+		{"cri", "", "hello", "hello", ""},
+		{`\S+ (INFO|WARN) `, "prefix", "03:04:05 WARN disk full", "disk full", "03:04:05 WARN "},
+		{`\S+ (INFO|WARN) `, "prefix", "disk full", "disk full", ""},
+	} {
+		s, err := newPrefixStage(tc.prefix, tc.field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := &Message{Data: tc.data}
+		got := s.process(original)
+		if got.Data != tc.want || got.Fields["prefix"] != tc.kept {
+			t.Errorf("%s: %q: expected %q with prefix %q, got %q with %q", tc.prefix, tc.data, tc.want, tc.kept, got.Data, got.Fields["prefix"])
+		}
+		if original.Data != tc.data {
+			t.Errorf("%s: expected a copy, the original became %q", tc.prefix, original.Data)
+		}
+	}
+	for _, prefix := range []string{"0", "-3", "(unclosed"} {
+		if _, err := newPrefixStage(prefix, ""); err == nil {
+			t.Errorf("%s: expected an error", prefix)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package router
+
+const (
+	// commonLogPattern matches the Common Log Format of access logs
+	commonLogPattern = `^(?P<client>\S+) \S+ (?P<user>\S+) \[(?P<time>[^\]]+)\] "(?P<method>[A-Z]+) (?P<path>\S+) (?P<protocol>[^"]+)" (?P<status>\d{3}) (?P<bytes>\d+|-)`
+	// combinedLogPattern adds the referer and user agent of the Combined Log
+	// Format
+	combinedLogPattern = commonLogPattern + `(?: "(?P<referer>[^"]*)" "(?P<user_agent>[^"]*)")?`
+)
+
+// builtinProfiles are the profiles shipped with logspout. Profiles loaded from
+// PARSE_PROFILES replace the ones with the same name.
+var builtinProfiles = []*parseProfile{
+	{
+		Name:   "nginx",
+		Images: []string{"nginx", "nginxinc/*", "bitnami/nginx"},
+		Patterns: []string{
+			combinedLogPattern,
+			`^(?P<time>\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2}) \[(?P<level>[a-z]+)\] (?P<pid>\d+)#\d+: `,
+		},
+	},
+	{
+		Name:   "apache",
+		Images: []string{"httpd", "bitnami/apache", "php:*-apache*", "wordpress"},
+		Patterns: []string{
+			combinedLogPattern,
+			`^\[(?P<time>[^\]]+)\] \[(?P<module>[a-z_]+):(?P<level>[a-z]+[0-9]?)\] \[pid (?P<pid>\d+)(?::tid \d+)?\](?: \[client (?P<client>[^\]]+)\])?`,
+		},
+	},
+	{
+		Name:      "postgres",
+		Images:    []string{"postgres", "bitnami/postgresql", "postgis/postgis", "timescale/timescaledb"},
+		Multiline: `^\s`,
+		Patterns: []string{
+			`^(?P<time>\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}(?:\.\d+)? \S+) \[(?P<pid>\d+)\] (?P<level>[A-Z]+[0-9]?):  `,
+		},
+		Levels: map[string]string{"LOG": "info", "STATEMENT": "info", "DETAIL": "info", "HINT": "info"},
+	},
+	{
+		Name:   "redis",
+		Images: []string{"redis", "bitnami/redis", "redis/redis-stack*", "valkey/valkey"},
+		Patterns: []string{
+			`^(?P<pid>\d+):(?P<role>[XCSM]) (?P<time>\d{1,2} [A-Z][a-z]{2} \d{4} \d{2}:\d{2}:\d{2}\.\d{3}) (?P<level>[.*#-]) `,
+		},
+		Levels: map[string]string{".": "debug", "-": "info", "*": "notice", "#": "warning"},
+	},
+	{
+		Name:   "haproxy",
+		Images: []string{"haproxy", "haproxytech/haproxy-*", "bitnami/haproxy"},
+		Patterns: []string{
+			`^(?P<client>\S+):\d+ \[(?P<time>[^\]]+)\] (?P<frontend>\S+) (?P<backend>[^/\s]+)/(?P<server>\S+) (?P<timers>\S+) (?P<status>\d{3}) (?P<bytes>\d+) \S+ \S+ (?P<termination_state>\S+) \S+ \S+ (?:\{[^}]*\} )*"(?P<method>[A-Z]+) (?P<path>\S+)(?: (?P<protocol>[^"]+))?"`,
+			`^(?P<client>\S+):\d+ \[(?P<time>[^\]]+)\] (?P<frontend>\S+) (?P<backend>[^/\s]+)/(?P<server>\S+) (?P<timers>\S+) (?P<bytes>\d+) (?P<termination_state>\S+) `,
+		},
+	},
+	{
+		Name:   "traefik",
+		Images: []string{"traefik", "bitnami/traefik"},
+		Patterns: []string{
+			combinedLogPattern + ` (?P<requests>\d+) "(?P<router>[^"]*)" "(?P<service_url>[^"]*)" (?P<duration>\d+ms)`,
+			`^time="(?P<time>[^"]+)" level=(?P<level>\w+) `,
+			`^(?P<time>\d{4}-\d{2}-\d{2}T\S+) (?P<level>TRC|DBG|INF|WRN|ERR|FTL|PNC) `,
+		},
+		Levels: map[string]string{"TRC": "debug", "DBG": "debug", "INF": "info", "WRN": "warning", "ERR": "error", "FTL": "critical", "PNC": "critical"},
+	},
+}
@@ -2,6 +2,8 @@ package router
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"io"
 	"log"
@@ -21,8 +23,10 @@ const (
 	pumpEventStatusRestartName = "restart"
 	pumpEventStatusRenameName  = "rename"
 	pumpEventStatusDieName     = "die"
+	pumpEventStatusDestroyName = "destroy"
 	trueString                 = "true"
 	pumpMaxIDLen               = 12
+	defaultDrainTimeout        = "5s"
 )
 
 var (
@@ -90,30 +94,7 @@ func ignoreContainer(container *docker.Container) bool {
 		}
 	}
 
-	excludeLabel := cfg.GetEnvDefault("EXCLUDE_LABELS", "")
-
-	if excludeLabel == "" {
-		excludeLabel = cfg.GetEnvDefault("EXCLUDE_LABEL", "")
-	}
-	excludeValue := "true"
-	// support EXCLUDE_LABEL having multiple custom label values
-	excludeLabelArr := strings.Split(excludeLabel, ";")
-
-	for _, label := range excludeLabelArr {
-		labelParts := strings.Split(label, ":")
-
-		if len(labelParts) == 2 { //nolint:gomnd
-			excludeLabel = labelParts[0]
-			excludeValue = labelParts[1]
-		}
-
-		if value, ok := container.Config.Labels[excludeLabel]; ok {
-			if len(excludeLabel) > 0 && strings.EqualFold(value, strings.ToLower(excludeValue)) {
-				return true
-			}
-		}
-	}
-	return false
+	return ignoreLabels(container.Config.Labels)
 }
 
 func ignoreContainerTTY(container *docker.Container) bool {
@@ -129,6 +110,14 @@ func getInactivityTimeoutFromEnv() time.Duration {
 	return inactivityTimeout
 }
 
+// getDrainTimeoutFromEnv returns how long the logs of a container that died
+// are drained before its routes stop, DRAIN_TIMEOUT
+func getDrainTimeoutFromEnv() time.Duration {
+	drainTimeout, err := time.ParseDuration(cfg.GetEnvDefault("DRAIN_TIMEOUT", defaultDrainTimeout))
+	assert(err, "Couldn't parse env var DRAIN_TIMEOUT. See https://golang.org/pkg/time/#ParseDuration for valid format.")
+	return drainTimeout
+}
+
 type update struct {
 	*docker.APIEvents
 	pump *containerPump
@@ -140,6 +129,9 @@ type LogsPump struct {
 	pumps  map[string]*containerPump
 	routes map[chan *update]struct{}
 	client *docker.Client
+	// statsDenied is set when the Docker API refuses stats, so they aren't
+	// asked for again
+	statsDenied int32
 }
 
 // Name returns the name of the pump
@@ -149,8 +141,11 @@ func (p *LogsPump) Name() string {
 
 // Setup configures the pump
 func (p *LogsPump) Setup() error {
+	if err := checkLabelMatchers(); err != nil {
+		return err
+	}
 	var err error
-	p.client, err = docker.NewClientFromEnv()
+	p.client, err = newDockerClient()
 	return err
 }
 
@@ -191,11 +186,15 @@ func (p *LogsPump) Run() error {
 		debug("pump.Run() event:", normalID(event.ID), event.Status)
 		switch event.Status {
 		case pumpEventStatusStartName, pumpEventStatusRestartName:
+			noteContainerEvent(event.ID, event.Status)
 			go p.pumpLogs(event, backlog(), inactivityTimeout)
 		case pumpEventStatusRenameName:
 			go p.rename(event)
 		case pumpEventStatusDieName:
+			noteContainerEvent(event.ID, event.Status)
 			go p.update(event)
+		case pumpEventStatusDestroyName:
+			noteContainerEvent(event.ID, event.Status)
 		}
 	}
 	return errors.New("docker event stream closed")
@@ -233,6 +232,14 @@ func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTim
 		return
 	}
 
+	if readsJSONFile(container) {
+		p.pumps[id] = newContainerPump(container, nil, nil)
+		p.mu.Unlock()
+		p.update(event)
+		go p.tailLogs(id, container, backlog)
+		return
+	}
+
 	// RawTerminal with container Tty=false injects binary headers into
 	// the log stream that show up as garbage unicode characters
 	rawTerminal := false
@@ -241,16 +248,18 @@ func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTim
 	}
 	outrd, outwr := io.Pipe()
 	errrd, errwr := io.Pipe()
+	received := &receivedWriters{}
 	p.pumps[id] = newContainerPump(container, outrd, errrd)
 	p.mu.Unlock()
 	p.update(event)
 	go func() {
 		for {
 			debug("pump.pumpLogs():", id, "started, tail:", tail)
+			streamSince := sinceTime
 			err := p.client.Logs(docker.LogsOptions{
 				Container:         id,
-				OutputStream:      outwr,
-				ErrorStream:       errwr,
+				OutputStream:      received.writer(outwr),
+				ErrorStream:       received.writer(errwr),
 				Stdout:            true,
 				Stderr:            true,
 				Follow:            true,
@@ -281,6 +290,7 @@ func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTim
 			}
 
 			debug("pump.pumpLogs():", id, "dead")
+			p.drainLogs(id, received.after(streamSince), tail, rawTerminal, outwr, errwr)
 			outwr.Close()
 			errwr.Close()
 			p.mu.Lock()
@@ -291,6 +301,118 @@ func (p *LogsPump) pumpLogs(event *docker.APIEvents, backlog bool, inactivityTim
 	}()
 }
 
+// drainLogs writes the lines a container that died logged after the last
+// line received, which a broken log stream can miss, to stdout and stderr.
+// The last lines before a crash are the ones wanted most, so they are fetched
+// once more without following, with their timestamps to skip the lines
+// already received.
+func (p *LogsPump) drainLogs(id string, after time.Time, tail string, rawTerminal bool, stdout, stderr io.Writer) {
+	timeout := getDrainTimeoutFromEnv()
+	if timeout <= 0 {
+		return
+	}
+	var out, errs bytes.Buffer
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := p.client.Logs(docker.LogsOptions{
+		Context:      ctx,
+		Container:    id,
+		OutputStream: &out,
+		ErrorStream:  &errs,
+		Stdout:       true,
+		Stderr:       true,
+		Tail:         tail,
+		Since:        after.Unix(),
+		Timestamps:   true,
+		RawTerminal:  rawTerminal,
+	})
+	if err != nil {
+		debug("pump.drainLogs():", id, "stopped with error:", err)
+	}
+	if n := writeLinesAfter(stdout, out.Bytes(), after) + writeLinesAfter(stderr, errs.Bytes(), after); n > 0 {
+		debug("pump.drainLogs():", id, "drained", n, "lines")
+	}
+}
+
+// receivedWriters record when the log streams of a container were last
+// written to. Every line written was logged before then.
+type receivedWriters struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+type receivedWriter struct {
+	w        io.Writer
+	received *receivedWriters
+}
+
+func (r *receivedWriters) writer(w io.Writer) io.Writer {
+	return &receivedWriter{w: w, received: r}
+}
+
+func (w *receivedWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.received.mu.Lock()
+	w.received.last = time.Now()
+	w.received.mu.Unlock()
+	return n, err
+}
+
+// after returns when the streams were last written to, or since when that
+// was later
+func (r *receivedWriters) after(since time.Time) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last.Before(since) {
+		return since
+	}
+	return r.last
+}
+
+// writeLinesAfter writes the lines of data, prefixed with their timestamps,
+// that were logged after after to w without the timestamps, returning how
+// many it wrote
+func writeLinesAfter(w io.Writer, data []byte, after time.Time) int {
+	n := 0
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		space := bytes.IndexByte(line, ' ')
+		if space < 0 || line[len(line)-1] != '\n' {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, string(line[:space]))
+		if err != nil || !t.After(after) {
+			continue
+		}
+		if _, err = w.Write(line[space+1:]); err != nil {
+			return n
+		}
+		n++
+	}
+	return n
+}
+
+// tailLogs reads the logs of a container from the file of the json-file log
+// driver until the container stops
+func (p *LogsPump) tailLogs(id string, container *docker.Container, backlog bool) {
+	path := jsonFilePath(container)
+	debug("pump.tailLogs():", id, "started, file:", path)
+	p.mu.Lock()
+	cp := p.pumps[id]
+	p.mu.Unlock()
+	err := tailJSONFile(cp, path, backlog, func() bool {
+		container, err := p.client.InspectContainerWithOptions(docker.InspectContainerOptions{ID: id})
+		return err == nil && container.State.Running
+	})
+	if err != nil {
+		log.Println("pump.tailLogs():", id, err)
+	}
+	debug("pump.tailLogs():", id, "dead")
+	close(cp.drained)
+	p.mu.Lock()
+	delete(p.pumps, id)
+	p.mu.Unlock()
+}
+
 func (p *LogsPump) update(event *docker.APIEvents) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -317,13 +439,14 @@ func (p *LogsPump) RoutingFrom(id string) bool {
 
 // Route takes a logstream and routes it according to the supplied Route
 func (p *LogsPump) Route(route *Route, logstream chan *Message) {
+	if interval, _ := statsEventInterval(route); interval > 0 {
+		p.routeStats(route, logstream, interval)
+		route.closed = true
+		return
+	}
 	p.mu.Lock()
 	for _, pump := range p.pumps {
-		if route.MatchContainer(
-			normalID(pump.container.ID),
-			normalName(pump.container.Name),
-			pump.container.Config.Labels,
-		) {
+		if matchPump(route, pump) {
 			pump.add(logstream, route)
 			defer pump.remove(logstream)
 		}
@@ -331,6 +454,10 @@ func (p *LogsPump) Route(route *Route, logstream chan *Message) {
 	updates := make(chan *update)
 	p.routes[updates] = struct{}{}
 	p.mu.Unlock()
+	// drained and holdDown are set when the container of a route for a
+	// single container dies, to stop once its last lines are routed
+	var drained <-chan struct{}
+	var holdDown <-chan time.Time
 	defer func() {
 		p.mu.Lock()
 		delete(p.routes, updates)
@@ -342,39 +469,62 @@ func (p *LogsPump) Route(route *Route, logstream chan *Message) {
 		case event := <-updates:
 			switch event.Status {
 			case pumpEventStatusStartName, pumpEventStatusRestartName:
-				if route.MatchContainer(
-					normalID(event.pump.container.ID),
-					normalName(event.pump.container.Name),
-					event.pump.container.Config.Labels,
-				) {
+				if matchPump(route, event.pump) {
 					event.pump.add(logstream, route)
 					defer event.pump.remove(logstream)
 				}
 			case pumpEventStatusDieName:
 				if strings.HasPrefix(route.FilterID, event.ID) {
 					// If the route is just about a single container,
-					// we can stop routing when it dies.
-					return
+					// we can stop routing when it dies, once its pump
+					// drained the lines it logged last.
+					drainTimeout := getDrainTimeoutFromEnv()
+					if drainTimeout <= 0 {
+						return
+					}
+					drained = event.pump.drained
+					holdDown = time.After(drainTimeout)
 				}
 			}
+		case <-drained:
+			return
+		case <-holdDown:
+			return
 		case <-route.Closer():
 			return
 		}
 	}
 }
 
+func matchPump(route *Route, pump *containerPump) bool {
+	return matchDockerContainer(route, pump.container)
+}
+
+func matchDockerContainer(route *Route, container *docker.Container) bool {
+	return route.MatchContainer(
+		normalID(container.ID),
+		normalName(container.Name),
+		container.Config.Labels,
+	) && route.MatchContainerNetworks(container.NetworkSettings)
+}
+
 type containerPump struct {
 	sync.Mutex
 	container  *docker.Container
 	logstreams map[chan *Message]*Route
+	// drained is closed once the logs of the container are read to their end
+	drained chan struct{}
 }
 
 func newContainerPump(container *docker.Container, stdout, stderr io.Reader) *containerPump {
 	cp := &containerPump{
 		container:  container,
 		logstreams: make(map[chan *Message]*Route),
+		drained:    make(chan struct{}),
 	}
+	var readers sync.WaitGroup
 	pump := func(source string, input io.Reader) {
+		defer readers.Done()
 		buf := bufio.NewReader(input)
 		for {
 			line, err := buf.ReadString('\n')
@@ -392,8 +542,21 @@ func newContainerPump(container *docker.Container, stdout, stderr io.Reader) *co
 			})
 		}
 	}
-	go pump("stdout", stdout)
-	go pump("stderr", stderr)
+	if stdout != nil {
+		readers.Add(1)
+		go pump("stdout", stdout)
+	}
+	if stderr != nil {
+		readers.Add(1)
+		go pump("stderr", stderr)
+	}
+	if stdout != nil || stderr != nil {
+		// json-file pumps without readers are drained when tailLogs ends
+		go func() {
+			readers.Wait()
+			close(cp.drained)
+		}()
+	}
 	return cp
 }
 
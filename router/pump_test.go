@@ -3,12 +3,14 @@ package router
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 )
@@ -287,3 +289,59 @@ func TestPumpBacklog(t *testing.T) {
 		t.Errorf("expected backlog() to return 'false'")
 	}
 }
+
+func TestPumpWriteLinesAfter(t *testing.T) {
+	after := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := "2024-01-02T03:04:04.900000000Z received\n" +
+		"2024-01-02T03:04:05.000000001Z missed\n" +
+		"not a timestamp\n" +
+		"2024-01-02T03:04:06Z last\n" +
+		"2024-01-02T03:04:07Z partial"
+	var out bytes.Buffer
+	if n := writeLinesAfter(&out, []byte(data), after); n != 2 || out.String() != "missed\nlast\n" {
+		t.Errorf("expected the 2 lines logged after, got %d: %q", n, out.String())
+	}
+}
+
+func TestPumpRouteDrainsDeadContainer(t *testing.T) {
+	os.Setenv("DRAIN_TIMEOUT", "10s")
+	defer os.Unsetenv("DRAIN_TIMEOUT")
+	id := "8dfafdbc3a40"
+	outrd, outwr := io.Pipe()
+	container := &docker.Container{ID: id, Name: "/foo", Config: &docker.Config{}}
+	p := &LogsPump{
+		pumps:  map[string]*containerPump{id: newContainerPump(container, outrd, nil)},
+		routes: make(map[chan *update]struct{}),
+	}
+	route := &Route{FilterID: id, closer: make(chan struct{})}
+	logstream := make(chan *Message, 1)
+	done := make(chan struct{})
+	go func() {
+		p.Route(route, logstream)
+		close(done)
+	}()
+	for registered := false; !registered; {
+		p.mu.Lock()
+		registered = len(p.routes) == 1
+		p.mu.Unlock()
+	}
+	p.update(&docker.APIEvents{ID: id, Status: pumpEventStatusDieName})
+	// the lines logged last are still routed after the container died
+	if _, err := outwr.Write([]byte("last words\n")); err != nil {
+		t.Fatal(err)
+	}
+	if m := <-logstream; m.Data != "last words" {
+		t.Errorf("expected the last line, got %q", m.Data)
+	}
+	select {
+	case <-done:
+		t.Fatal("expected the route to wait for the pump to drain")
+	case <-time.After(10 * time.Millisecond):
+	}
+	outwr.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the route to stop once the pump drained")
+	}
+}
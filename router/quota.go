@@ -0,0 +1,200 @@
+package router
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteUnits are the units of quota_bytes, decimal like the ingestion volumes
+// backends bill for, and binary
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12}, {"B", 1},
+}
+
+// quota is a cap on the bytes or messages a route ships per hour or per day,
+// in UTC
+type quota struct {
+	option string
+	spec   string
+	limit  int64
+	per    time.Duration
+	start  time.Time
+	used   int64
+}
+
+// parseQuota parses a quota such as 10GB/day or 100000/hour. Sizes are only
+// allowed for bytes.
+func parseQuota(option, spec string, bytes bool) (*quota, error) {
+	parts := strings.SplitN(spec, "/", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("bad " + option + ": " + spec)
+	}
+	q := &quota{option: option, spec: spec}
+	switch parts[1] {
+	case "hour":
+		q.per = time.Hour
+	case "day":
+		q.per = 24 * time.Hour
+	default:
+		return nil, errors.New("bad " + option + " period: " + spec)
+	}
+	amount, unit := parts[0], int64(1)
+	if bytes {
+		for _, u := range byteUnits {
+			if strings.HasSuffix(amount, u.suffix) {
+				amount, unit = strings.TrimSuffix(amount, u.suffix), u.size
+				break
+			}
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(amount), 10, 64)
+	if err != nil || n < 1 {
+		return nil, errors.New("bad " + option + ": " + spec)
+	}
+	q.limit = n * unit
+	return q, nil
+}
+
+// allows returns whether n more fit in the quota at now, starting a new
+// period when the last one ended
+func (q *quota) allows(now time.Time, n int64) bool {
+	if start := now.UTC().Truncate(q.per); !start.Equal(q.start) {
+		q.start = start
+		q.used = 0
+	}
+	return q.used+n <= q.limit
+}
+
+// quotaStage drops, samples or reroutes the messages of a route once it
+// shipped its quota of bytes or messages for the hour or day
+type quotaStage struct {
+	route   *Route
+	quotas  []*quota
+	action  string
+	sample  int
+	reroute string
+	now     func() time.Time
+
+	over    *quota
+	skipped int
+}
+
+const (
+	quotaDrop    = "drop"
+	quotaSample  = "sample"
+	quotaReroute = "reroute"
+)
+
+// newQuotaStage returns the quota stage of route, or nil when it has no quota
+func newQuotaStage(route *Route) (stage, error) {
+	s := &quotaStage{route: route, action: quotaDrop, now: time.Now}
+	if spec := route.Options["quota_bytes"]; spec != "" {
+		q, err := parseQuota("quota_bytes", spec, true)
+		if err != nil {
+			return nil, err
+		}
+		s.quotas = append(s.quotas, q)
+	}
+	if spec := route.Options["quota_messages"]; spec != "" {
+		q, err := parseQuota("quota_messages", spec, false)
+		if err != nil {
+			return nil, err
+		}
+		s.quotas = append(s.quotas, q)
+	}
+	action := route.Options["quota_action"]
+	if len(s.quotas) == 0 {
+		if action != "" {
+			return nil, errors.New("quota_action without quota_bytes or quota_messages")
+		}
+		return nil, nil
+	}
+	parts := strings.SplitN(action, ":", 2)
+	switch {
+	case action == "" || action == quotaDrop:
+	case parts[0] == quotaSample && len(parts) == 2:
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			return nil, errors.New("bad quota_action: " + action)
+		}
+		s.action, s.sample = quotaSample, n
+	case parts[0] == quotaReroute && len(parts) == 2 && routeNameRe.MatchString(parts[1]):
+		s.action, s.reroute = quotaReroute, parts[1]
+	default:
+		return nil, errors.New("bad quota_action: " + action)
+	}
+	return s, nil
+}
+
+func (s *quotaStage) process(message *Message) *Message {
+	now := s.now()
+	size := int64(len(message.Data))
+	var over *quota
+	for _, q := range s.quotas {
+		n := int64(1)
+		if q.option == "quota_bytes" {
+			n = size
+		}
+		if !q.allows(now, n) && over == nil {
+			over = q
+		}
+	}
+	if over == nil {
+		if s.over != nil {
+			log.Printf("quota: route %s is within %s %s again after skipping %d messages", s.route, s.over.option, s.over.spec, s.skipped)
+			s.over, s.skipped = nil, 0
+		}
+		for _, q := range s.quotas {
+			if q.option == "quota_bytes" {
+				q.used += size
+			} else {
+				q.used++
+			}
+		}
+		return message
+	}
+	if s.over == nil {
+		action := "dropping messages"
+		switch s.action {
+		case quotaSample:
+			action = "sampling 1 in " + strconv.Itoa(s.sample) + " messages"
+		case quotaReroute:
+			action = "rerouting messages to " + s.reroute
+		}
+		log.Printf("quota: route %s exceeded %s %s, %s until %s", s.route, over.option, over.spec, action,
+			over.start.Add(over.per).Format(time.RFC3339))
+		s.over = over
+	}
+	s.skipped++
+	switch s.action {
+	case quotaSample:
+		if (s.skipped-1)%s.sample == 0 {
+			return message
+		}
+	case quotaReroute:
+		s.send(message)
+	}
+	return nil
+}
+
+// send passes message on to the route messages are rerouted to, dropping it
+// when that route doesn't exist or doesn't take it within a second
+func (s *quotaStage) send(message *Message) {
+	route, err := Routes.Get(s.reroute)
+	if err != nil || route.input == nil {
+		debug("quota: no route to reroute to:", s.reroute)
+		return
+	}
+	select {
+	case route.input <- message:
+	case <-time.After(time.Second):
+		debug("quota: route timeout, dropping:", s.reroute)
+	}
+}
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuota(t *testing.T) {
+	for spec, expected := range map[string]int64{
+		"10GB/day":    10e9,
+		"512MiB/hour": 512 << 20,
+		"100/hour":    100,
+	} {
+		q, err := parseQuota("quota_bytes", spec, true)
+		if err != nil || q.limit != expected {
+			t.Errorf("expected %d for %s, got %v %v", expected, spec, q, err)
+		}
+	}
+	for _, spec := range []string{"10GB", "10GB/week", "lots/day", "0/day"} {
+		if _, err := parseQuota("quota_bytes", spec, true); err == nil {
+			t.Errorf("expected error for %s", spec)
+		}
+	}
+	if _, err := parseQuota("quota_messages", "10MB/day", false); err == nil {
+		t.Error("expected error for a size in quota_messages")
+	}
+}
+
+func newTestQuotaStage(t *testing.T, options map[string]string, now *time.Time) *quotaStage {
+	s, err := newQuotaStage(&Route{ID: "capped", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := s.(*quotaStage)
+	q.now = func() time.Time { return *now }
+	return q
+}
+
+func TestQuotaStage(t *testing.T) {
+	now := time.Date(2026, 10, 14, 23, 0, 0, 0, time.UTC)
+	s := newTestQuotaStage(t, map[string]string{"quota_bytes": "10B/day", "quota_messages": "3/hour"}, &now)
+	passed := func(data string) bool {
+		return s.process(&Message{Data: data}) != nil
+	}
+	if !passed("12345") || !passed("12345") || passed("1") {
+		t.Error("expected the messages within 10 bytes to pass")
+	}
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if !passed("1") {
+			t.Fatal("expected the quota to start over the next day")
+		}
+	}
+	if passed("1") {
+		t.Error("expected the 4th message of the hour to be dropped")
+	}
+
+	s = newTestQuotaStage(t, map[string]string{"quota_messages": "1/hour", "quota_action": "sample:3"}, &now)
+	var sampled []bool
+	for i := 0; i < 8; i++ {
+		sampled = append(sampled, passed("x"))
+	}
+	for i, expected := range []bool{true, true, false, false, true, false, false, true} {
+		if sampled[i] != expected {
+			t.Fatalf("expected 1 in 3 messages past the quota, got %v", sampled)
+		}
+	}
+
+	if s, err := newQuotaStage(&Route{}); s != nil || err != nil {
+		t.Errorf("expected no stage without a quota, got %v %v", s, err)
+	}
+	for _, action := range []string{"sample:0", "reroute:", "forward"} {
+		if _, err := newQuotaStage(&Route{Options: map[string]string{"quota_bytes": "1GB/day", "quota_action": action}}); err == nil {
+			t.Errorf("expected error for quota_action %s", action)
+		}
+	}
+}
+
+func TestQuotaStageReroute(t *testing.T) {
+	overflow := &Route{ID: "overflow", input: make(chan *Message, 1)}
+	Routes.Lock()
+	Routes.routes[overflow.ID] = overflow
+	Routes.Unlock()
+	defer func() {
+		Routes.Lock()
+		delete(Routes.routes, overflow.ID)
+		Routes.Unlock()
+	}()
+
+	now := time.Now()
+	s := newTestQuotaStage(t, map[string]string{"quota_messages": "1/day", "quota_action": "reroute:overflow"}, &now)
+	s.process(&Message{Data: "first"})
+	if message := s.process(&Message{Data: "second"}); message != nil {
+		t.Error("expected the message past the quota not to pass")
+	}
+	if message := <-overflow.input; message.Data != "second" {
+		t.Errorf("expected the message past the quota to be rerouted, got %v", message)
+	}
+}
@@ -0,0 +1,170 @@
+package router
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const defaultRedactMask = "[REDACTED]"
+
+func init() {
+	TransformFactories.Register(newRedactTransform, "redact")
+}
+
+// redactPattern matches sensitive data to mask: all of a match, or its group
+// named secret when it has one, when check, if any, accepts it
+type redactPattern struct {
+	re    *regexp.Regexp
+	group int
+	check func(string) bool
+}
+
+// redactPatterns are the patterns named in the transform.redact option
+var redactPatterns = map[string]string{
+	"credit_card": `\b\d(?:[ -]?\d){12,18}\b`,
+	"bearer":      `(?i)\bbearer\s+(?P<secret>[A-Za-z0-9\-._~+/]+=*)`,
+	"email":       `\b[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}\b`,
+	"password":    `(?i)\b(?:password|passwd|pwd|secret|api_?key|access_?token)["']?\s*[=:]\s*["']?(?P<secret>[^\s"'&,;]+)`,
+}
+
+// redactChecks rule out matches of the patterns that aren't what they look
+// like, as numbers that aren't card numbers
+var redactChecks = map[string]func(string) bool{
+	"credit_card": luhn,
+}
+
+func newRedactPattern(name, expr string) (*redactPattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.New("bad redact pattern " + name + ": " + err.Error())
+	}
+	p := &redactPattern{re: re, check: redactChecks[name]}
+	for i, group := range re.SubexpNames() {
+		if group == "secret" {
+			p.group = i
+		}
+	}
+	return p, nil
+}
+
+// redact returns s with the matches of p replaced by mask
+func (p *redactPattern) redact(s, mask string) string {
+	matches := p.re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var b strings.Builder
+	last, masked := 0, false
+	for _, match := range matches {
+		start, end := match[2*p.group], match[2*p.group+1]
+		if start < 0 || (p.check != nil && !p.check(s[start:end])) {
+			continue
+		}
+		b.WriteString(s[last:start])
+		b.WriteString(mask)
+		last, masked = end, true
+	}
+	if !masked {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// newRedactTransform masks sensitive data in the text and fields of messages,
+// before they leave the host, whatever the adapter. transform.redact names
+// the patterns to mask, of credit_card, bearer, email and password, and each
+// transform.redact.NAME option adds a regular expression of its own.
+// transform.redact_mask replaces the matches, [REDACTED] by default.
+func newRedactTransform(route *Route) (Transform, error) {
+	var names []string
+	expressions := make(map[string]string)
+	if s := route.Options["transform.redact"]; s != "" {
+		for _, name := range strings.Split(s, ",") {
+			name = strings.TrimSpace(name)
+			expr, ok := redactPatterns[name]
+			if !ok {
+				return nil, errors.New("unknown redact pattern: " + name)
+			}
+			names = append(names, name)
+			expressions[name] = expr
+		}
+	}
+	var custom []string
+	for option, expr := range route.Options {
+		if name := strings.TrimPrefix(option, "transform.redact."); name != option {
+			// replacing a built-in pattern of the same name
+			if _, listed := expressions[name]; !listed {
+				custom = append(custom, name)
+			}
+			expressions[name] = expr
+		}
+	}
+	// map order isn't stable, and patterns can overlap
+	sort.Strings(custom)
+	names = append(names, custom...)
+	if len(names) == 0 {
+		return nil, errors.New("needs transform.redact or transform.redact.NAME")
+	}
+	var patterns []*redactPattern
+	for _, name := range names {
+		p, err := newRedactPattern(name, expressions[name])
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, p)
+	}
+	mask := route.Options["transform.redact_mask"]
+	if mask == "" {
+		mask = defaultRedactMask
+	}
+	redact := func(s string) string {
+		for _, p := range patterns {
+			s = p.redact(s, mask)
+		}
+		return s
+	}
+	return TransformFunc(func(message *Message) *Message {
+		data := redact(message.Data)
+		var fields map[string]string
+		for key, value := range message.Fields {
+			if redacted := redact(value); redacted != value {
+				if fields == nil {
+					fields = make(map[string]string)
+				}
+				fields[key] = redacted
+			}
+		}
+		if data == message.Data && fields == nil {
+			return message
+		}
+		redacted := message.WithFields(fields)
+		if redacted == message {
+			copied := *message
+			redacted = &copied
+		}
+		redacted.Data = data
+		return redacted
+	}), nil
+}
+
+// luhn returns whether the digits of s pass the Luhn check of card numbers
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
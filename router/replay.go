@@ -0,0 +1,192 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// ErrContainerNotRouted is returned when replaying a container the route
+// doesn't select with its filters
+var ErrContainerNotRouted = errors.New("container is not routed by this route")
+
+// ErrNoBuffer is returned when replaying the buffer of a route whose adapter
+// doesn't keep the messages it sent
+var ErrNoBuffer = errors.New("route keeps no buffer of the messages it sent")
+
+// BufferReplayer is implemented by LogAdapters that keep the messages they
+// sent on disk, so they can send them once more
+type BufferReplayer interface {
+	// ReplayBuffer sends the messages sent between since and until once
+	// more, at most rate per second or unthrottled for 0, and returns their
+	// number
+	ReplayBuffer(since, until time.Time, rate int) (int, error)
+}
+
+// replayer is implemented by LogRouters that can fetch past logs of a container
+type replayer interface {
+	Replay(route *Route, containerID string, since, until time.Time, logstream chan *Message) (int, error)
+}
+
+// Replay sends the logs a container wrote between since and until once more
+// through the route with the given id. Replayed messages have Replay set, so
+// adapters can tag them. Only containers and messages matching the filters of
+// the route are replayed.
+func (rm *RouteManager) Replay(routeID, containerID string, since, until time.Time) (int, error) {
+	rm.Lock()
+	route, ok := rm.routes[routeID]
+	routing := rm.routing
+	rm.Unlock()
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	if !routing {
+		return 0, errors.New("route is not running")
+	}
+	var replayed int
+	var rejected bool
+	logstream := make(chan *Message)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for message := range logstream {
+			if !matchDockerContainer(route, message.Container) {
+				rejected = true
+				continue
+			}
+			if route.MatchMessage(message) {
+				route.input <- message
+				replayed++
+			}
+		}
+	}()
+	for _, router := range LogRouters.All() {
+		if r, ok := router.(replayer); ok {
+			_, err := r.Replay(route, containerID, since, until, logstream)
+			close(logstream)
+			<-done
+			if err == nil && rejected {
+				err = ErrContainerNotRouted
+			}
+			return replayed, err
+		}
+	}
+	close(logstream)
+	<-done
+	return 0, errors.New("no log router supports replay")
+}
+
+// ReplayBuffer sends the messages the adapter of the route with the given id
+// sent between since and until once more, from its buffer, at most rate per
+// second. Unlike Replay the messages aren't routed again, so they are sent
+// as they were.
+func (rm *RouteManager) ReplayBuffer(routeID string, since, until time.Time, rate int) (int, error) {
+	rm.Lock()
+	route, ok := rm.routes[routeID]
+	var adapter LogAdapter
+	if ok {
+		adapter = route.adapter
+	}
+	rm.Unlock()
+	if !ok {
+		return 0, os.ErrNotExist
+	}
+	r, ok := adapter.(BufferReplayer)
+	if !ok {
+		return 0, ErrNoBuffer
+	}
+	return r.ReplayBuffer(since, until, rate)
+}
+
+// Replay fetches the logs of a container between since and until from Docker
+// and sends them to logstream, returning the number of messages sent. The
+// container has to match the filters of route.
+func (p *LogsPump) Replay(route *Route, containerID string, since, until time.Time, logstream chan *Message) (int, error) {
+	container, err := p.client.InspectContainerWithOptions(docker.InspectContainerOptions{ID: containerID})
+	if err != nil {
+		return 0, err
+	}
+	if !matchDockerContainer(route, container) {
+		return 0, ErrContainerNotRouted
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	outrd, outwr := io.Pipe()
+	errrd, errwr := io.Pipe()
+	go func() {
+		err := p.client.Logs(docker.LogsOptions{
+			Context:      ctx,
+			Container:    container.ID,
+			OutputStream: outwr,
+			ErrorStream:  errwr,
+			Stdout:       true,
+			Stderr:       true,
+			Timestamps:   true,
+			Since:        since.Unix(),
+			RawTerminal:  allowTTY && container.Config.Tty,
+		})
+		outwr.CloseWithError(err)
+		errwr.CloseWithError(err)
+	}()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	count := 0
+	replay := func(source string, input io.Reader) {
+		defer wg.Done()
+		buf := bufio.NewReader(input)
+		for {
+			line, err := buf.ReadString('\n')
+			if err != nil {
+				if err != io.EOF && err != context.Canceled {
+					debug("pump.Replay():", normalID(container.ID), source+":", err)
+				}
+				return
+			}
+			t, data := splitTimestamp(strings.TrimSuffix(line, "\n"))
+			if t.Before(since) {
+				continue
+			}
+			if t.After(until) {
+				// logs are ordered, the rest is out of range as well. Keep
+				// draining the pipe so the Docker client can return.
+				cancel()
+				io.Copy(ioutil.Discard, input) //nolint:errcheck
+				return
+			}
+			logstream <- &Message{
+				Data:      data,
+				Container: container,
+				Time:      t,
+				Source:    source,
+				Replay:    true,
+			}
+			mu.Lock()
+			count++
+			mu.Unlock()
+		}
+	}
+	wg.Add(2)
+	go replay("stdout", outrd)
+	go replay("stderr", errrd)
+	wg.Wait()
+	return count, nil
+}
+
+// splitTimestamp splits the timestamp Docker prefixes log lines with from the line
+func splitTimestamp(line string) (time.Time, string) {
+	parts := strings.SplitN(line, " ", 2)
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil || len(parts) < 2 {
+		return time.Now(), line
+	}
+	return t, parts[1]
+}
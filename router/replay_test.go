@@ -0,0 +1,119 @@
+package router
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestSplitTimestamp(t *testing.T) {
+	ts, data := splitTimestamp("2021-03-01T10:11:12.123456789Z hello world")
+	if data != "hello world" {
+		t.Errorf("expected line without timestamp, got %q", data)
+	}
+	expected := time.Date(2021, 3, 1, 10, 11, 12, 123456789, time.UTC)
+	if !ts.Equal(expected) {
+		t.Errorf("expected %s, got %s", expected, ts)
+	}
+
+	if _, data = splitTimestamp("no timestamp"); data != "no timestamp" {
+		t.Errorf("expected line to be kept without timestamp, got %q", data)
+	}
+}
+
+type fakeReplayer struct {
+	messages []*Message
+}
+
+func (r *fakeReplayer) RoutingFrom(containerID string) bool { return false }
+
+func (r *fakeReplayer) Route(route *Route, logstream chan *Message) {}
+
+func (r *fakeReplayer) Replay(route *Route, containerID string, since, until time.Time,
+	logstream chan *Message) (int, error) {
+	for _, message := range r.messages {
+		logstream <- message
+	}
+	return len(r.messages), nil
+}
+
+func TestRouteManagerReplay(t *testing.T) {
+	pump, _ := LogRouters.Lookup(defaultPumpName)
+	LogRouters.Unregister(defaultPumpName)
+	defer LogRouters.Register(pump, defaultPumpName)
+
+	app := &docker.Container{ID: "app", Name: "/app", Config: &docker.Config{}}
+	db := &docker.Container{ID: "db", Name: "/db", Config: &docker.Config{}}
+	replayer := &fakeReplayer{}
+	LogRouters.Register(replayer, "fake")
+	defer LogRouters.Unregister("fake")
+
+	rm := &RouteManager{routes: make(map[string]*Route)}
+	route := &Route{ID: "r", FilterName: "app", FilterSources: []string{"stdout"}}
+	route.input = make(chan *Message, 10)
+	rm.routes[route.ID] = route
+
+	if _, err := rm.Replay("missing", "app", time.Time{}, time.Now()); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for unknown route, got %v", err)
+	}
+	if _, err := rm.Replay("r", "app", time.Time{}, time.Now()); err == nil {
+		t.Error("expected error when the route is not running")
+	}
+	rm.routing = true
+
+	replayer.messages = []*Message{
+		{Container: app, Source: "stdout", Data: "1", Replay: true},
+		{Container: app, Source: "stderr", Data: "2", Replay: true},
+		{Container: app, Source: "stdout", Data: "3", Replay: true},
+	}
+	n, err := rm.Replay("r", "app", time.Time{}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(route.input) != 2 {
+		t.Fatalf("expected 2 replayed messages, got %d", n)
+	}
+	for i := 0; i < 2; i++ {
+		if message := <-route.input; !message.Replay || message.Source != "stdout" {
+			t.Errorf("unexpected replayed message %+v", message)
+		}
+	}
+
+	replayer.messages = []*Message{{Container: db, Source: "stdout", Data: "1", Replay: true}}
+	if _, err = rm.Replay("r", "db", time.Time{}, time.Now()); err != ErrContainerNotRouted {
+		t.Errorf("expected ErrContainerNotRouted, got %v", err)
+	}
+	if len(route.input) != 0 {
+		t.Error("expected messages of a container not matching the route to be dropped")
+	}
+}
+
+// bufferAdapter replays the messages it was sent
+type bufferAdapter struct {
+	DummyAdapter
+	rate int
+}
+
+func (a *bufferAdapter) ReplayBuffer(since, until time.Time, rate int) (int, error) {
+	a.rate = rate
+	return 3, nil
+}
+
+func TestRouteManagerReplayBuffer(t *testing.T) {
+	rm := &RouteManager{routes: make(map[string]*Route)}
+	adapter := &bufferAdapter{}
+	rm.routes["buffered"] = &Route{ID: "buffered", adapter: adapter}
+	rm.routes["plain"] = &Route{ID: "plain", adapter: &DummyAdapter{}}
+
+	if n, err := rm.ReplayBuffer("buffered", time.Time{}, time.Now(), 50); n != 3 || err != nil || adapter.rate != 50 {
+		t.Errorf("expected 3 messages replayed at 50 per second, got %d, %v at %d", n, err, adapter.rate)
+	}
+	if _, err := rm.ReplayBuffer("plain", time.Time{}, time.Now(), 50); err != ErrNoBuffer {
+		t.Errorf("expected ErrNoBuffer, got %v", err)
+	}
+	if _, err := rm.ReplayBuffer("missing", time.Time{}, time.Now(), 50); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error for unknown route, got %v", err)
+	}
+}
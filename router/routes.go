@@ -8,9 +8,11 @@ import (
 	"log"
 	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gliderlabs/logspout/cfg"
@@ -19,6 +21,9 @@ import (
 // Routes is all the configured routes
 var Routes *RouteManager
 
+// route IDs, and names that double as IDs, end up in URLs and file names
+var routeNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
 func init() {
 	Routes = &RouteManager{routes: make(map[string]*Route)}
 	Jobs.Register(Routes, "routes")
@@ -78,7 +83,12 @@ func (rm *RouteManager) Remove(id string) bool {
 	if ok && route.closer != nil {
 		route.closer <- struct{}{}
 	}
+	if ok {
+		go closeStages(route.stages)
+	}
 	delete(rm.routes, id)
+	removeConnState(id)
+	removeMetrics(id)
 	if rm.persistor != nil {
 		rm.persistor.Remove(id)
 	}
@@ -87,14 +97,23 @@ func (rm *RouteManager) Remove(id string) bool {
 
 // AddFromURI creates a new route from an URI string and adds it to the RouteManager
 func (rm *RouteManager) AddFromURI(uri string) error {
-	expandedRoute := os.ExpandEnv(uri)
-	u, err := url.Parse(expandedRoute)
+	r, err := ParseRouteURI(os.ExpandEnv(uri))
 	if err != nil {
 		return err
 	}
+	return rm.Add(r)
+}
+
+// ParseRouteURI returns the route of an URI string, like those of ROUTE_URIS
+func ParseRouteURI(uri string) (*Route, error) {
+	expandedRoute, restore := extractTemplates(uri)
+	u, err := url.Parse(expandedRoute)
+	if err != nil {
+		return nil, err
+	}
 	r := &Route{
-		Address: u.Host,
-		Path:    u.Path,
+		Address: restore(u.Host),
+		Path:    restore(u.Path),
 		Adapter: u.Scheme,
 		User:    u.User,
 		Options: make(map[string]string),
@@ -102,11 +121,17 @@ func (rm *RouteManager) AddFromURI(uri string) error {
 	if u.RawQuery != "" {
 		params, err := url.ParseQuery(u.RawQuery)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		for key := range params {
 			value := params.Get(key)
 			switch key {
+			case "route.id":
+				r.ID = value
+			case "route.name":
+				r.Name = value
+			case "route.description":
+				r.Description = value
 			case "filter.id":
 				r.FilterID = value
 			case "filter.name":
@@ -115,37 +140,220 @@ func (rm *RouteManager) AddFromURI(uri string) error {
 				r.FilterLabels = strings.Split(value, ",")
 			case "filter.sources":
 				r.FilterSources = strings.Split(value, ",")
+			case "filter.networks":
+				r.FilterNetworks = strings.Split(value, ",")
+			case "filter.ips":
+				r.FilterIPs = strings.Split(value, ",")
+			case "filter.compose.project":
+				r.FilterComposeProject = value
+			case "filter.compose.service":
+				r.FilterComposeService = value
 			default:
-				r.Options[key] = value
+				r.Options[key] = restore(value)
 			}
 		}
 	}
-	return rm.Add(r)
+	return r, nil
 }
 
 // Add adds a route to the RouteManager
 func (rm *RouteManager) Add(route *Route) error {
 	rm.Lock()
 	defer rm.Unlock()
+	assignRouteID(route)
+	adapter, err := newAdapter(route)
+	if err != nil {
+		rm.discardMetrics(route)
+		return err
+	}
+	rm.install(route, adapter)
+	return nil
+}
+
+// Replace replaces all routes of the RouteManager. The new routes are all
+// validated before any existing route is touched, so either every route is
+// replaced or nothing changes.
+func (rm *RouteManager) Replace(routes []*Route) error {
+	rm.Lock()
+	defer rm.Unlock()
+	ids := make(map[string]bool)
+	adapters := make([]LogAdapter, 0, len(routes))
+	// the adapters already created for earlier routes are closed when a
+	// later route turns out to be invalid
+	fail := func(err error) error {
+		for i, adapter := range adapters {
+			closeAdapter(adapter)
+			closeStages(routes[i].stages)
+		}
+		for _, route := range routes {
+			rm.discardMetrics(route)
+		}
+		return err
+	}
+	// the stages of the current routes, before newAdapter builds new ones for
+	// routes given again
+	previous := make(map[string][]stage, len(rm.routes))
+	for id, route := range rm.routes {
+		previous[id] = route.stages
+	}
+	for i, route := range routes {
+		if id := route.stableID(); id != "" {
+			if ids[id] {
+				return fail(fmt.Errorf("route %d: duplicate id %s", i, id))
+			}
+			ids[id] = true
+		}
+		assignRouteID(route)
+		adapter, err := newAdapter(route)
+		if err != nil {
+			return fail(fmt.Errorf("route %d: %s", i, err))
+		}
+		adapters = append(adapters, adapter)
+	}
+	for id, route := range rm.routes {
+		if rm.routing && route.closer != nil {
+			route.closer <- struct{}{}
+		}
+		go closeStages(previous[id])
+		delete(rm.routes, id)
+		removeConnState(id)
+		removeMetrics(id)
+		if rm.persistor != nil {
+			rm.persistor.Remove(id)
+		}
+	}
+	for i, route := range routes {
+		rm.install(route, adapters[i])
+	}
+	return nil
+}
+
+// Pause stops forwarding the logs of a route to its adapter, without removing
+// the route. Depending on the pause_policy option logs are dropped or
+// buffered until the route is resumed.
+func (rm *RouteManager) Pause(id string) (*Route, error) {
+	return rm.setPaused(id, true)
+}
+
+// Resume continues forwarding the logs of a paused route
+func (rm *RouteManager) Resume(id string) (*Route, error) {
+	return rm.setPaused(id, false)
+}
+
+func (rm *RouteManager) setPaused(id string, paused bool) (*Route, error) {
+	rm.Lock()
+	defer rm.Unlock()
+	route, ok := rm.routes[id]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	route.Paused = paused
+	route.pause.set(paused)
+	if rm.persistor != nil {
+		if err := rm.persistor.Add(route); err != nil {
+			log.Println("persistor:", err)
+		}
+	}
+	return route, nil
+}
+
+func newAdapter(route *Route) (LogAdapter, error) {
 	factory, found := AdapterFactories.Lookup(route.AdapterType())
 	if !found {
-		return errors.New("bad adapter: " + route.Adapter)
+		return nil, errors.New("bad adapter: " + route.Adapter)
+	}
+	if route.ID != "" && !routeNameRe.MatchString(route.ID) {
+		return nil, errors.New("bad route id: " + route.ID)
+	}
+	if route.Name != "" && !routeNameRe.MatchString(route.Name) {
+		return nil, errors.New("bad route name: " + route.Name)
 	}
-	adapter, err := factory(route)
+	for _, cidr := range route.FilterIPs {
+		if _, err := parseCIDR(cidr); err != nil {
+			return nil, err
+		}
+	}
+	route.filter = compileFilter(route)
+	pause, err := newPauseControl(route)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	route.pause = pause
+	if route.stages, err = newStages(route); err != nil {
+		return nil, err
+	}
+	var adapter LogAdapter
+	if _, err = statsEventInterval(route); err == nil {
+		if route.Options["canary_address"] != "" {
+			adapter, err = newCanaryAdapter(route, factory)
+		} else {
+			adapter, err = newAddressAdapter(route, factory)
+		}
+	}
+	if err != nil {
+		closeStages(route.stages)
+		route.stages = nil
+		return nil, err
+	}
+	return adapter, nil
+}
+
+func newAddressAdapter(route *Route, factory AdapterFactory) (LogAdapter, error) {
+	if isAddressTemplate(route.Address) {
+		return newTemplatedAdapter(route, factory)
+	}
+	return factory(route)
+}
+
+// closeAdapter releases the connections and goroutines of an adapter that is
+// discarded before it is streamed to. Adapters holding such resources
+// implement io.Closer.
+func closeAdapter(adapter LogAdapter) {
+	if closer, ok := adapter.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			debug("routes: closing adapter:", err)
+		}
 	}
+}
+
+// routeSeq tells apart the IDs generated in the same nanosecond
+var routeSeq uint64
+
+// assignRouteID sets the ID of route to its stable ID, or to a new one. It is
+// set before the adapter of the route is created, as adapters take the
+// metrics of their route by its ID.
+func assignRouteID(route *Route) {
+	route.ID = route.stableID()
 	if route.ID == "" {
 		h := sha1.New() //nolint:gosec
-		io.WriteString(h, strconv.Itoa(int(time.Now().UnixNano())))
+		io.WriteString(h, strconv.Itoa(int(time.Now().UnixNano()))+"-"+strconv.FormatUint(atomic.AddUint64(&routeSeq, 1), 10))
 		route.ID = fmt.Sprintf("%x", h.Sum(nil))[:12]
 	}
-	route.closer = make(chan struct{})
-	route.adapter = adapter
+}
+
+// discardMetrics removes the metrics the adapter of a route that couldn't be
+// added may have taken, unless they are those of a current route with its ID
+func (rm *RouteManager) discardMetrics(route *Route) {
+	if route.ID != "" && rm.routes[route.ID] == nil {
+		removeMetrics(route.ID)
+	}
+}
+
+func (rm *RouteManager) install(route *Route, adapter LogAdapter) {
+	assignRouteID(route)
 	// Stop any existing route with this ID:
-	if rm.routes[route.ID] != nil {
-		rm.routes[route.ID].closer <- struct{}{}
+	if old := rm.routes[route.ID]; old != nil {
+		if rm.routing && old.closer != nil {
+			old.closer <- struct{}{}
+		}
+		// a route added again already has new stages
+		if old != route {
+			go closeStages(old.stages)
+		}
 	}
+	route.closer = make(chan struct{})
+	route.input = make(chan *Message)
+	route.adapter = adapter
 
 	rm.routes[route.ID] = route
 	if rm.persistor != nil {
@@ -156,14 +364,26 @@ func (rm *RouteManager) Add(route *Route) error {
 	if rm.routing {
 		go rm.route(route)
 	}
-	return nil
 }
 
 func (rm *RouteManager) route(route *Route) {
-	logstream := make(chan *Message)
+	logstream := route.input
 	defer route.Close()
 	rm.Route(route, logstream)
-	route.adapter.Stream(logstream)
+	var stream <-chan *Message = logstream
+	if len(route.stages) > 0 {
+		staged := make(chan *Message)
+		go runStages(route.stages, logstream, staged)
+		stream = staged
+	}
+	adapterstream := make(chan *Message)
+	go route.pause.relay(stream, adapterstream)
+	if ledger != nil {
+		counted := make(chan *Message)
+		go countShipped(route, adapterstream, counted)
+		adapterstream = counted
+	}
+	route.adapter.Stream(adapterstream)
 }
 
 // Route takes a logstream and route and passes them off to all configure LogRouters
@@ -210,6 +430,9 @@ func (rm *RouteManager) Name() string {
 
 // Setup configures the RouteManager
 func (rm *RouteManager) Setup() error {
+	if _, err := chaosEnabled(); err != nil {
+		return err
+	}
 	var uris string
 	if os.Getenv("ROUTE_URIS") != "" {
 		uris = os.Getenv("ROUTE_URIS")
@@ -226,7 +449,14 @@ func (rm *RouteManager) Setup() error {
 		}
 	}
 
-	persistPath := cfg.GetEnvDefault("ROUTESPATH", "/mnt/routes")
+	persistPath := cfg.RoutesPath()
+	if cfg.DataDir() != "" {
+		// the data directory is writable by definition, so the routes
+		// directory is made in it rather than required to exist
+		if err := os.MkdirAll(persistPath, 0700); err != nil {
+			log.Println("persistor:", err)
+		}
+	}
 	if _, err := os.Stat(persistPath); err == nil {
 		return rm.Load(RouteFileStore(persistPath))
 	}
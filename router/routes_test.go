@@ -1,8 +1,15 @@
 package router
 
 import (
+	"encoding/json"
+	"errors"
+	"net/url"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
 )
 
 type DummyAdapter struct{}
@@ -57,3 +64,202 @@ func TestRouterNoDuplicateIds(t *testing.T) {
 		t.Errorf("route1 was not closed after route2 added.")
 	}
 }
+
+func TestRouteMatchContainerNetworks(t *testing.T) {
+	settings := &docker.NetworkSettings{
+		Networks: map[string]docker.ContainerNetwork{
+			"shop_frontend": {IPAddress: "172.20.0.5"},
+			"shop_backend":  {IPAddress: "10.1.2.3"},
+		},
+	}
+	tests := []struct {
+		route *Route
+		out   bool
+	}{
+		{&Route{}, true},
+		{&Route{FilterNetworks: []string{"*_frontend"}}, true},
+		{&Route{FilterNetworks: []string{"monitoring"}}, false},
+		{&Route{FilterIPs: []string{"10.0.0.0/8"}}, true},
+		{&Route{FilterIPs: []string{"172.20.0.5"}}, true},
+		{&Route{FilterIPs: []string{"192.168.0.0/16"}}, false},
+		{&Route{FilterNetworks: []string{"*_frontend"}, FilterIPs: []string{"192.168.0.0/16"}}, false},
+	}
+	for _, test := range tests {
+		if actual := test.route.MatchContainerNetworks(settings); actual != test.out {
+			t.Errorf("networks %v ips %v: expected %v got %v",
+				test.route.FilterNetworks, test.route.FilterIPs, test.out, actual)
+		}
+	}
+	if (&Route{FilterNetworks: []string{"*"}}).MatchContainerNetworks(nil) {
+		t.Error("expected container without network settings not to match")
+	}
+}
+
+func TestRouteManagerReplace(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "syslog")
+	rm := &RouteManager{routes: make(map[string]*Route)}
+	if err := rm.Add(&Route{ID: "old", Adapter: "syslog"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := rm.Replace([]*Route{{ID: "a", Adapter: "syslog"}, {ID: "b", Adapter: "nope"}})
+	if err == nil {
+		t.Fatal("expected error for unknown adapter")
+	}
+	if _, err = rm.Get("old"); err != nil {
+		t.Error("expected routes to be unchanged after a failed replace")
+	}
+
+	if err = rm.Replace([]*Route{{ID: "a", Adapter: "syslog"}, {Adapter: "syslog"}}); err != nil {
+		t.Fatal(err)
+	}
+	routes, _ := rm.GetAll()
+	if len(routes) != 2 {
+		t.Errorf("expected 2 routes, got %d", len(routes))
+	}
+	if _, err = rm.Get("old"); err == nil {
+		t.Error("expected old route to be removed")
+	}
+}
+
+func TestRouteNameAsID(t *testing.T) {
+	AdapterFactories.Register(newDummyAdapter, "syslog")
+	rm := &RouteManager{routes: make(map[string]*Route)}
+	if err := rm.AddFromURI("syslog://logs:514?route.name=papertrail&route.description=all+app+logs"); err != nil {
+		t.Fatal(err)
+	}
+	route, err := rm.Get("papertrail")
+	if err != nil {
+		t.Fatal("expected route to be stored under its name")
+	}
+	if route.Description != "all app logs" || route.String() != "papertrail" {
+		t.Errorf("unexpected route %#v", route)
+	}
+	if err := rm.Add(&Route{Name: "../etc", Adapter: "syslog"}); err == nil {
+		t.Error("expected error for invalid route name")
+	}
+	if err := rm.AddFromURI("syslog://logs:514?route.id=../x"); err == nil {
+		t.Error("expected error for invalid route id")
+	}
+}
+
+func TestRouteJSONUser(t *testing.T) {
+	route := &Route{ID: "abc", Adapter: "loki", User: url.UserPassword("user", "secret")}
+	var decoded Route
+	if err := json.Unmarshal(marshal(route), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.User.String() != "user:secret" {
+		t.Errorf("expected user info to survive a round trip, got %v", decoded.User)
+	}
+
+	if err := json.Unmarshal(marshal(&Route{ID: "abc"}), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.User != nil {
+		t.Errorf("expected no user info, got %v", decoded.User)
+	}
+
+	redacted := route.Redacted()
+	if redacted.User.String() != "user:xxxxx" || route.User.String() != "user:secret" {
+		t.Errorf("expected a copy with the password redacted, got %v and %v", redacted.User, route.User)
+	}
+	if unprotected := (&Route{User: url.User("user")}); unprotected.Redacted() != unprotected {
+		t.Error("expected a route without a password to be returned as is")
+	}
+}
+
+type closingAdapter struct {
+	DummyAdapter
+	closed *int
+}
+
+func (a *closingAdapter) Close() error {
+	*a.closed++
+	return nil
+}
+
+func TestRouteManagerReplaceClosesAdapters(t *testing.T) {
+	closed := 0
+	AdapterFactories.Register(func(route *Route) (LogAdapter, error) {
+		return &closingAdapter{closed: &closed}, nil
+	}, "closing")
+	defer AdapterFactories.Unregister("closing")
+	rm := &RouteManager{routes: make(map[string]*Route)}
+
+	err := rm.Replace([]*Route{{Adapter: "closing"}, {Adapter: "closing"}, {Adapter: "nope"}})
+	if err == nil {
+		t.Fatal("expected error for unknown adapter")
+	}
+	if closed != 2 {
+		t.Errorf("expected the 2 adapters created before the error to be closed, got %d", closed)
+	}
+}
+
+// closingCount is a transform counting how often it is closed
+type closingCount struct {
+	closed *int32
+}
+
+func (t closingCount) Transform(message *Message) *Message {
+	return message
+}
+
+func (t closingCount) Close() error {
+	atomic.AddInt32(t.closed, 1)
+	return nil
+}
+
+func TestRouteManagerClosesStages(t *testing.T) {
+	var closed int32
+	TransformFactories.Register(func(*Route) (Transform, error) { return closingCount{&closed}, nil }, "closing")
+	defer TransformFactories.Unregister("closing")
+	AdapterFactories.Register(newDummyAdapter, "syslog")
+	AdapterFactories.Register(func(route *Route) (LogAdapter, error) {
+		if route.Address == "canary:514" {
+			return nil, errors.New("unreachable")
+		}
+		return &DummyAdapter{}, nil
+	}, "flaky")
+	defer AdapterFactories.Unregister("flaky")
+	expect := func(what string, n int32) {
+		t.Helper()
+		// stages of routes that are removed are closed in the background
+		for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&closed) != n && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		if got := atomic.LoadInt32(&closed); got != n {
+			t.Errorf("%s: expected %d closed stages, got %d", what, n, got)
+		}
+	}
+	transforms := func(options map[string]string) map[string]string {
+		options["transforms"] = "closing"
+		return options
+	}
+	rm := &RouteManager{routes: make(map[string]*Route)}
+
+	if err := rm.Add(&Route{Adapter: "syslog", Options: transforms(map[string]string{"sign": "rot13"})}); err == nil {
+		t.Fatal("expected error for a bad stage")
+	}
+	expect("failed stage", 1)
+	if err := rm.Add(&Route{Adapter: "flaky", Address: "primary:514", Options: transforms(map[string]string{"canary_address": "canary:514"})}); err == nil {
+		t.Fatal("expected error for a failed canary")
+	}
+	expect("failed canary", 2)
+	if err := rm.Replace([]*Route{{Adapter: "syslog", Options: transforms(map[string]string{})}, {Adapter: "nope"}}); err == nil {
+		t.Fatal("expected error for unknown adapter")
+	}
+	expect("failed replace", 3)
+
+	if err := rm.Add(&Route{ID: "a", Adapter: "syslog", Options: transforms(map[string]string{})}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.Add(&Route{ID: "a", Adapter: "syslog", Options: transforms(map[string]string{})}); err != nil {
+		t.Fatal(err)
+	}
+	expect("route added again", 4)
+	if err := rm.Replace([]*Route{{ID: "b", Adapter: "syslog"}}); err != nil {
+		t.Fatal(err)
+	}
+	expect("replaced route", 5)
+}
@@ -0,0 +1,64 @@
+package router
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// OutputSchema validates the messages an adapter rendered against the JSON
+// Schema file set with the schema route option. Messages that don't validate
+// go to the dead-letter path of the route instead of the backend.
+type OutputSchema struct {
+	schema     *gojsonschema.Schema
+	deadLetter *DeadLetter
+}
+
+// NewOutputSchema loads the output schema of route. It returns nil when the
+// route has no schema.
+func NewOutputSchema(route *Route) (*OutputSchema, error) {
+	path := route.Options["schema"]
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return nil, errors.New("bad schema " + path + ": " + err.Error())
+	}
+	deadLetter, err := NewDeadLetter(route)
+	if err != nil {
+		return nil, err
+	}
+	return &OutputSchema{schema: schema, deadLetter: deadLetter}, nil
+}
+
+// Valid returns whether rendered, the output of an adapter for message, is
+// valid. Invalid output is written to the dead-letter path with the reason.
+func (s *OutputSchema) Valid(message *Message, rendered []byte) bool {
+	result, err := s.schema.Validate(gojsonschema.NewBytesLoader(rendered))
+	if err != nil {
+		// not JSON at all
+		s.deadLetter.Write(message, rendered, err)
+		return false
+	}
+	if result.Valid() {
+		return true
+	}
+	reasons := make([]string, 0, len(result.Errors()))
+	for _, e := range result.Errors() {
+		reasons = append(reasons, e.String())
+	}
+	s.deadLetter.Write(message, rendered, errors.New(strings.Join(reasons, "; ")))
+	return false
+}
+
+// Close closes the dead-letter path
+func (s *OutputSchema) Close() error {
+	return s.deadLetter.Close()
+}
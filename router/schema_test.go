@@ -0,0 +1,105 @@
+package router
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestOutputSchemaDeadLetter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspout-schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	schemaPath := filepath.Join(dir, "schema.json")
+	deadLetterPath := filepath.Join(dir, "dead.log")
+	schemaDoc := `{"type": "object", "required": ["message"], "properties": {"message": {"type": "string"}}}`
+	if err = ioutil.WriteFile(schemaPath, []byte(schemaDoc), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	route := &Route{ID: "abc", Options: map[string]string{"schema": schemaPath, "dead_letter": deadLetterPath}}
+	schema, err := NewOutputSchema(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := &Message{Container: &docker.Container{Name: "/app"}, Data: "hello", Source: "stdout"}
+	if !schema.Valid(message, []byte(`{"message": "hello"}`+"\n")) {
+		t.Error("expected valid output to pass")
+	}
+	if schema.Valid(message, []byte(`{"msg": "hello"}`)) {
+		t.Error("expected output without message to fail")
+	}
+	if schema.Valid(message, []byte(`hello`)) {
+		t.Error("expected output that isn't JSON to fail")
+	}
+	if err = schema.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(deadLetterPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", len(lines))
+	}
+	var entry deadLetterEntry
+	if err = json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Route != "abc" || entry.Container != "/app" || entry.Data != "hello" ||
+		!strings.Contains(entry.Error, "message") {
+		t.Errorf("unexpected dead letter: %+v", entry)
+	}
+}
+
+func TestDeadLetterMaxBytes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspout-deadletter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dead.log")
+	route := &Route{Options: map[string]string{"dead_letter": path, "dead_letter_max_bytes": "300"}}
+	deadLetter, err := NewDeadLetter(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		deadLetter.Write(&Message{Data: "some data"}, nil, os.ErrInvalid)
+	}
+	deadLetter.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 300 {
+		t.Errorf("expected the dead-letter file to stay below 300 bytes, got %d", info.Size())
+	}
+	if deadLetter.Rejected() != 10 {
+		t.Errorf("expected 10 rejected messages, got %d", deadLetter.Rejected())
+	}
+}
+
+func TestOutputSchemaBadSchema(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logspout-schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "schema.json")
+	if err = ioutil.WriteFile(path, []byte(`{"type": 5}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewOutputSchema(&Route{Options: map[string]string{"schema": path}}); err == nil {
+		t.Error("expected error for an invalid schema")
+	}
+}
@@ -0,0 +1,110 @@
+package router
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"strconv"
+)
+
+const (
+	signHMACSHA256 = "hmac-sha256"
+	signEd25519    = "ed25519"
+)
+
+// newSignStage returns a stage that signs messages with HMAC-SHA256 or
+// Ed25519, so the backend can check they came from a logspout holding the
+// key. The signature covers the container ID, source, time and text, joined
+// by newlines, with the time as the Unix nanoseconds in the signed_time
+// field. It is set base64 encoded in the signature field, together with
+// signature_alg and signature_key_id.
+func newSignStage(mode, keyFile, keyID string) (stage, error) {
+	var sign func(payload []byte) []byte
+	switch mode {
+	case signHMACSHA256:
+		key, err := readKey("sign_key_file", keyFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) < 16 {
+			return nil, errors.New("sign_key_file: HMAC keys need at least 16 bytes")
+		}
+		sign = func(payload []byte) []byte {
+			mac := hmac.New(sha256.New, key)
+			mac.Write(payload) //nolint:errcheck
+			return mac.Sum(nil)
+		}
+	case signEd25519:
+		key, err := readEd25519Key(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		sign = func(payload []byte) []byte {
+			return ed25519.Sign(key, payload)
+		}
+	default:
+		return nil, errors.New("bad sign: " + mode)
+	}
+	return stageFunc(func(message *Message) *Message {
+		signedTime := strconv.FormatInt(message.Time.UnixNano(), 10)
+		fields := map[string]string{
+			"signature":     base64.StdEncoding.EncodeToString(sign(signedPayload(message, signedTime))),
+			"signature_alg": mode,
+			"signed_time":   signedTime,
+		}
+		if keyID != "" {
+			fields["signature_key_id"] = keyID
+		}
+		return message.WithFields(fields)
+	}), nil
+}
+
+// signedPayload returns the bytes signed for message
+func signedPayload(message *Message, signedTime string) []byte {
+	var id string
+	if message.Container != nil {
+		id = message.Container.ID
+	}
+	payload := make([]byte, 0, len(id)+len(message.Source)+len(signedTime)+len(message.Data)+3)
+	payload = append(payload, id...)
+	payload = append(payload, '\n')
+	payload = append(payload, message.Source...)
+	payload = append(payload, '\n')
+	payload = append(payload, signedTime...)
+	payload = append(payload, '\n')
+	return append(payload, message.Data...)
+}
+
+// readEd25519Key reads a PKCS #8 PEM private key, as written by
+// openssl genpkey -algorithm ed25519, or a hex or base64 encoded seed or key
+func readEd25519Key(filename string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		if block, _ := pem.Decode(data); block != nil {
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, errors.New("sign_key_file: " + err.Error())
+			}
+			if private, ok := key.(ed25519.PrivateKey); ok {
+				return private, nil
+			}
+			return nil, errors.New("sign_key_file: not an Ed25519 key")
+		}
+	}
+	key, err := readKey("sign_key_file", filename)
+	if err != nil {
+		return nil, err
+	}
+	switch len(key) {
+	case ed25519.SeedSize:
+		return ed25519.NewKeyFromSeed(key), nil
+	case ed25519.PrivateKeySize:
+		return ed25519.PrivateKey(key), nil
+	}
+	return nil, errors.New("sign_key_file: Ed25519 keys have 32 or 64 bytes")
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSignStage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sign")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	message := &Message{
+		Container: imageContainer("abc", "app"),
+		Source:    "stdout",
+		Data:      "hello",
+		Time:      time.Unix(0, 1760432400000000001),
+	}
+	payload := []byte("abc\nstdout\n1760432400000000001\nhello")
+
+	key := []byte("0123456789abcdef")
+	s, err := newSignStage("hmac-sha256", writeKeyFile(t, dir, base64.StdEncoding.EncodeToString(key)), "host-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := s.process(message)
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	if signed.Fields["signature"] != base64.StdEncoding.EncodeToString(mac.Sum(nil)) ||
+		signed.Fields["signature_alg"] != "hmac-sha256" || signed.Fields["signature_key_id"] != "host-1" ||
+		signed.Fields["signed_time"] != "1760432400000000001" {
+		t.Errorf("unexpected HMAC signature fields %v", signed.Fields)
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = newSignStage("ed25519", writeKeyFile(t, dir, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed = s.process(message)
+	signature, _ := base64.StdEncoding.DecodeString(signed.Fields["signature"])
+	if !ed25519.Verify(public, payload, signature) {
+		t.Errorf("expected a valid Ed25519 signature, got %v", signed.Fields)
+	}
+	if _, ok := signed.Fields["signature_key_id"]; ok {
+		t.Error("expected no key id when none is set")
+	}
+
+	for _, test := range []struct{ mode, key string }{
+		{"md5", "0123456789abcdef0123456789abcdef"},
+		{"hmac-sha256", "0011"},
+		{"ed25519", "0011"},
+	} {
+		if _, err := newSignStage(test.mode, writeKeyFile(t, dir, test.key), ""); err == nil {
+			t.Errorf("expected error for %s with key %q", test.mode, test.key)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package router
+
+import (
+	"io"
+	"time"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+// flushTick is how often runStages flushes the stages holding messages back
+const flushTick = 100 * time.Millisecond
+
+// stage processes the messages of a route before they reach its adapter. It
+// returns the message to pass on, or nil to drop or hold it. Messages are
+// shared between routes, so a stage that changes a message works on a copy.
+type stage interface {
+	process(message *Message) *Message
+}
+
+// stageFunc adapts a function to a stage
+type stageFunc func(message *Message) *Message
+
+func (f stageFunc) process(message *Message) *Message {
+	return f(message)
+}
+
+// flushingStage is a stage that holds messages back, like the multiline
+// joining of parse profiles. flush returns the held messages due at now; a
+// zero now returns all of them.
+type flushingStage interface {
+	stage
+	flush(now time.Time) []*Message
+}
+
+// runStages passes the messages from in through stages to out, closing out
+// when in closes
+func runStages(stages []stage, in <-chan *Message, out chan<- *Message) {
+	defer close(out)
+	var tick <-chan time.Time
+	for _, s := range stages {
+		if _, ok := s.(flushingStage); ok {
+			ticker := time.NewTicker(flushTick)
+			defer ticker.Stop()
+			tick = ticker.C
+			break
+		}
+	}
+	for {
+		select {
+		case message, ok := <-in:
+			if !ok {
+				flushStages(stages, time.Time{}, out)
+				return
+			}
+			if message = processStages(stages, message); message != nil {
+				out <- message
+			}
+		case now := <-tick:
+			flushStages(stages, now, out)
+		}
+	}
+}
+
+// closeStages closes the stages holding resources, like processes, when their
+// route is removed
+func closeStages(stages []stage) {
+	for _, s := range stages {
+		if closer, ok := s.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+func processStages(stages []stage, message *Message) *Message {
+	for _, s := range stages {
+		if message = s.process(message); message == nil {
+			return nil
+		}
+	}
+	return message
+}
+
+// flushStages sends the messages released by flushing stages on through the
+// stages after them
+func flushStages(stages []stage, now time.Time, out chan<- *Message) {
+	for i, s := range stages {
+		f, ok := s.(flushingStage)
+		if !ok {
+			continue
+		}
+		for _, message := range f.flush(now) {
+			if message = processStages(stages[i+1:], message); message != nil {
+				out <- message
+			}
+		}
+	}
+}
+
+// WithFields returns a copy of the message with fields added to its Fields,
+// or the message itself when fields is empty. Fields maps are never modified
+// once they are set on a message, so a message without fields shares the map
+// of fields instead of copying it; callers don't modify fields afterwards.
+func (m *Message) WithFields(fields map[string]string) *Message {
+	if len(fields) == 0 {
+		return m
+	}
+	message := *m
+	if len(m.Fields) == 0 {
+		message.Fields = fields
+		return &message
+	}
+	message.Fields = make(map[string]string, len(m.Fields)+len(fields))
+	for k, v := range m.Fields {
+		message.Fields[k] = v
+	}
+	for k, v := range fields {
+		message.Fields[k] = v
+	}
+	return &message
+}
+
+// newStages returns the stages configured with the options of route. When a
+// stage fails, those built before it are closed.
+func newStages(route *Route) (_ []stage, err error) {
+	var stages []stage
+	defer func() {
+		if err != nil {
+			closeStages(stages)
+		}
+	}()
+	if route.Options["leader_only"] != "" {
+		leader, err := newLeaderStage(route)
+		if err != nil {
+			return nil, err
+		}
+		if leader != nil {
+			stages = append(stages, leader)
+		}
+	}
+	offset := route.Options["time_offset"]
+	if offset == "" {
+		offset = cfg.GetEnvDefault("TIME_OFFSET", "")
+	}
+	if offset != "" {
+		clock, err := newClockStage(route, offset)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, clock)
+	}
+	if route.Options["order"] != "" {
+		order, err := newOrderStage(route)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, order)
+	}
+	if s := route.Options["binary"]; s != "" {
+		binary, err := newBinaryStage(s, route.Options["binary_field"])
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, binary)
+	}
+	if s := route.Options["strip_prefix"]; s != "" {
+		prefix, err := newPrefixStage(s, route.Options["strip_prefix_field"])
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, prefix)
+	}
+	if route.Options["crash_loop"] != "" {
+		crashLoop, err := newCrashLoopStage(route)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, crashLoop)
+	}
+	parse := route.Options["parse"]
+	if parse == "" {
+		parse = cfg.GetEnvDefault("PARSE", "")
+	}
+	if parse != "" && parse != "false" {
+		parser, err := newParseStage(parse)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, parser)
+	}
+	if s := route.Options["filter.record"]; s != "" {
+		filter, err := newRecordFilterStage(s)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, countFiltered(route, filter))
+	}
+	if s := route.Options["filter.min_level"]; s != "" {
+		level, err := newMinLevelStage(s)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, countFiltered(route, level))
+	}
+	if match, exclude := route.Options["filter.match"], route.Options["filter.exclude"]; match != "" || exclude != "" {
+		content, err := newContentFilterStage(match, exclude)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, countFiltered(route, content))
+	}
+	if route.Options["container_fields"] != "" {
+		fields, err := newContainerFieldsStage(route)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, fields)
+	}
+	instance, err := newInstanceFieldsStage(route)
+	if err != nil {
+		return nil, err
+	}
+	if instance != nil {
+		stages = append(stages, instance)
+	}
+	if s := route.Options["stats_interval"]; s != "" {
+		stats, err := newStatsStage(s)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stats)
+	}
+	if route.Options["exec"] != "" {
+		exec, err := newExecStage(route)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, exec)
+	}
+	if route.Options["lua"] != "" {
+		script, err := newLuaStage(route)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, script)
+	}
+	if s := route.Options["transforms"]; s != "" {
+		transforms, err := newTransformStage(route, s)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, countFiltered(route, transforms))
+	}
+	if route.Options["dedup"] != "" {
+		dedup, err := newDedupStage(route)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, dedup)
+	}
+	quota, err := newQuotaStage(route)
+	if err != nil {
+		return nil, err
+	}
+	if quota != nil {
+		stages = append(stages, quota)
+	}
+	if s := route.Options["encrypt"]; s != "" {
+		encrypt, err := newEncryptStage(s, route.Options["encrypt_key_file"])
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, encrypt)
+	}
+	if s := route.Options["sign"]; s != "" {
+		sign, err := newSignStage(s, route.Options["sign_key_file"], route.Options["sign_key_id"])
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, sign)
+	}
+	return stages, nil
+}
@@ -0,0 +1,63 @@
+package router
+
+import (
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// fanOutRoutes returns the stages of n routes shipping the same messages
+// with stats and binary payload handling
+func fanOutRoutes(b *testing.B, n int) [][]stage {
+	cache := newStatsCache(time.Hour, func(containerID string) (*docker.Stats, error) {
+		stats := &docker.Stats{}
+		stats.MemoryStats.Usage = 42
+		return stats, nil
+	})
+	statsCaches.Lock()
+	statsCaches.caches[time.Hour] = cache
+	statsCaches.Unlock()
+	cache.fields("abc")
+	waitSampled(cache, "abc")
+	var routes [][]stage
+	for i := 0; i < n; i++ {
+		stages, err := newStages(&Route{Options: map[string]string{"stats_interval": "1h", "binary": "base64"}})
+		if err != nil {
+			b.Fatal(err)
+		}
+		routes = append(routes, stages)
+	}
+	return routes
+}
+
+func BenchmarkFanOut(b *testing.B) {
+	routes := fanOutRoutes(b, 5)
+	message := &Message{Container: imageContainer("abc", "app"), Source: "stdout", Data: "hello"}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, stages := range routes {
+			processStages(stages, message)
+		}
+	}
+}
+
+func TestWithFieldsSharesFields(t *testing.T) {
+	fields := map[string]string{"team": "core"}
+	message := (&Message{Data: "hello"}).WithFields(fields)
+	if message.Fields["team"] != "core" {
+		t.Fatalf("expected the added field, got %v", message.Fields)
+	}
+	merged := message.WithFields(map[string]string{"tier": "web"})
+	if merged.Fields["team"] != "core" || merged.Fields["tier"] != "web" {
+		t.Errorf("expected both fields, got %v", merged.Fields)
+	}
+	if len(fields) != 1 || len(message.Fields) != 1 {
+		t.Error("expected the fields of the first message to be left alone")
+	}
+
+	if sharedStatsCache(time.Minute) != sharedStatsCache(time.Minute) {
+		t.Error("expected routes with the same stats interval to share their cache")
+	}
+}
@@ -0,0 +1,288 @@
+package router
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	statsSource        = "stats"
+	maxStatsContainers = 1024
+	statsTimeout       = 5 * time.Second
+)
+
+// errStatsUnavailable is returned for stats when the Docker API doesn't serve
+// them, as behind a docker-socket-proxy that only allows logs and events
+var errStatsUnavailable = errors.New("stats are not available from the Docker API")
+
+// statser is implemented by LogRouters that can sample the resource usage of
+// a container
+type statser interface {
+	Stats(containerID string) (*docker.Stats, error)
+}
+
+// Stats samples the resource usage of a container once
+func (p *LogsPump) Stats(containerID string) (*docker.Stats, error) {
+	if atomic.LoadInt32(&p.statsDenied) != 0 {
+		return nil, errStatsUnavailable
+	}
+	stats := make(chan *docker.Stats, 1)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- p.client.Stats(docker.StatsOptions{
+			ID:      containerID,
+			Stats:   stats,
+			Stream:  false,
+			Timeout: statsTimeout,
+		})
+	}()
+	sample, ok := <-stats
+	if err := <-errc; err != nil {
+		if endpointDenied(err) {
+			if atomic.CompareAndSwapInt32(&p.statsDenied, 0, 1) {
+				log.Println("pump:", errStatsUnavailable, "("+err.Error()+"), sending messages without stats")
+			}
+			return nil, errStatsUnavailable
+		}
+		return nil, err
+	}
+	if !ok || sample == nil {
+		return nil, errors.New("no stats for container " + normalID(containerID))
+	}
+	return sample, nil
+}
+
+// statsFields turns a stats sample into message fields
+func statsFields(stats *docker.Stats) map[string]string {
+	fields := make(map[string]string, 6)
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemCPUUsage) - float64(stats.PreCPUStats.SystemCPUUsage)
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta >= 0 && systemDelta > 0 {
+		fields["stats_cpu_percent"] = strconv.FormatFloat(cpuDelta/systemDelta*cpus*100, 'f', 2, 64)
+	}
+	// the page cache can be reclaimed, leave it out like docker stats does
+	memory := stats.MemoryStats.Usage
+	cache := stats.MemoryStats.Stats.TotalInactiveFile
+	if cache == 0 {
+		// cgroup v2
+		cache = stats.MemoryStats.Stats.InactiveFile
+	}
+	if cache < memory {
+		memory -= cache
+	}
+	fields["stats_memory_bytes"] = strconv.FormatUint(memory, 10)
+	if limit := stats.MemoryStats.Limit; limit > 0 {
+		fields["stats_memory_limit_bytes"] = strconv.FormatUint(limit, 10)
+		fields["stats_memory_percent"] = strconv.FormatFloat(float64(memory)/float64(limit)*100, 'f', 2, 64)
+	}
+	if len(stats.Networks) > 0 {
+		var rx, tx uint64
+		for _, network := range stats.Networks {
+			rx += network.RxBytes
+			tx += network.TxBytes
+		}
+		fields["stats_net_rx_bytes"] = strconv.FormatUint(rx, 10)
+		fields["stats_net_tx_bytes"] = strconv.FormatUint(tx, 10)
+	}
+	return fields
+}
+
+// statsCache keeps the last stats sample of each container. Samples older
+// than the interval are refreshed in the background, so messages never wait
+// for the Docker API.
+type statsCache struct {
+	interval time.Duration
+	sample   func(containerID string) (*docker.Stats, error)
+
+	mu      sync.Mutex
+	entries map[string]*statsEntry
+}
+
+type statsEntry struct {
+	fields   map[string]string
+	sampled  time.Time
+	sampling bool
+	lastUsed time.Time
+}
+
+func newStatsCache(interval time.Duration, sample func(containerID string) (*docker.Stats, error)) *statsCache {
+	return &statsCache{
+		interval: interval,
+		sample:   sample,
+		entries:  make(map[string]*statsEntry),
+	}
+}
+
+// fields returns the last known stats fields of a container, starting a new
+// sample when they are out of date
+func (c *statsCache) fields(containerID string) map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	entry, ok := c.entries[containerID]
+	if !ok {
+		if len(c.entries) >= maxStatsContainers && !c.expire(now) {
+			return nil
+		}
+		entry = &statsEntry{}
+		c.entries[containerID] = entry
+	}
+	entry.lastUsed = now
+	if !entry.sampling && now.Sub(entry.sampled) >= c.interval {
+		entry.sampling = true
+		go c.refresh(containerID, entry)
+	}
+	return entry.fields
+}
+
+func (c *statsCache) refresh(containerID string, entry *statsEntry) {
+	stats, err := c.sample(containerID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry.sampling = false
+	entry.sampled = time.Now()
+	if err != nil {
+		debug("stats:", normalID(containerID), err)
+		return
+	}
+	entry.fields = statsFields(stats)
+}
+
+// expire forgets containers that didn't log for a while, returning whether
+// that made room
+func (c *statsCache) expire(now time.Time) bool {
+	for id, entry := range c.entries {
+		if !entry.sampling && now.Sub(entry.lastUsed) > 2*c.interval {
+			delete(c.entries, id)
+		}
+	}
+	return len(c.entries) < maxStatsContainers
+}
+
+// statsCaches are the stats caches of the routes by interval, shared so
+// routes with the same interval sample each container once
+var statsCaches = struct {
+	sync.Mutex
+	caches map[time.Duration]*statsCache
+}{caches: make(map[time.Duration]*statsCache)}
+
+func sharedStatsCache(interval time.Duration) *statsCache {
+	statsCaches.Lock()
+	defer statsCaches.Unlock()
+	cache, ok := statsCaches.caches[interval]
+	if !ok {
+		cache = newStatsCache(interval, sampleStats)
+		statsCaches.caches[interval] = cache
+	}
+	return cache
+}
+
+func sampleStats(containerID string) (*docker.Stats, error) {
+	for _, router := range LogRouters.All() {
+		if s, ok := router.(statser); ok {
+			return s.Stats(containerID)
+		}
+	}
+	return nil, errors.New("no log router supports stats")
+}
+
+// newStatsStage returns a stage that adds the recent stats of the container
+// to messages, sampled at most once per interval
+func newStatsStage(interval string) (stage, error) {
+	d, err := time.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		return nil, errors.New("bad stats_interval: " + interval)
+	}
+	cache := sharedStatsCache(d)
+	return stageFunc(func(message *Message) *Message {
+		if message.Container == nil {
+			return message
+		}
+		return message.WithFields(cache.fields(message.Container.ID))
+	}), nil
+}
+
+// statsEventInterval returns the interval of the stats events of a route, or
+// zero when the route ships logs
+func statsEventInterval(route *Route) (time.Duration, error) {
+	s := route.Options["stats_events"]
+	if s == "" {
+		return 0, nil
+	}
+	interval, err := time.ParseDuration(s)
+	if err != nil || interval <= 0 {
+		return 0, errors.New("bad stats_events: " + s)
+	}
+	return interval, nil
+}
+
+// routeStats sends a stats event for every container matching route each
+// interval, until the route is closed
+func (p *LogsPump) routeStats(route *Route, logstream chan *Message, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.sendStats(route, logstream)
+		case <-route.Closer():
+			return
+		}
+	}
+}
+
+func (p *LogsPump) sendStats(route *Route, logstream chan *Message) {
+	p.mu.Lock()
+	var containers []*docker.Container
+	for _, pump := range p.pumps {
+		if matchPump(route, pump) {
+			containers = append(containers, pump.container)
+		}
+	}
+	p.mu.Unlock()
+	for _, container := range containers {
+		stats, err := p.Stats(container.ID)
+		if err == errStatsUnavailable {
+			return
+		}
+		if err != nil {
+			debug("stats:", normalID(container.ID), err)
+			continue
+		}
+		fields := statsFields(stats)
+		select {
+		case logstream <- &Message{
+			Container: container,
+			Source:    statsSource,
+			Data:      statsSummary(fields),
+			Time:      time.Now(),
+			Fields:    fields,
+		}:
+		case <-route.Closer():
+			return
+		}
+	}
+}
+
+// statsSummary renders stats fields as the text of a stats event
+func statsSummary(fields map[string]string) string {
+	var parts []string
+	for _, name := range []string{"stats_cpu_percent", "stats_memory_bytes", "stats_memory_limit_bytes",
+		"stats_memory_percent", "stats_net_rx_bytes", "stats_net_tx_bytes"} {
+		if value, ok := fields[name]; ok {
+			parts = append(parts, strings.TrimPrefix(name, "stats_")+"="+value)
+		}
+	}
+	return "stats " + strings.Join(parts, " ")
+}
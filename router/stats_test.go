@@ -0,0 +1,148 @@
+package router
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestStatsFields(t *testing.T) {
+	stats := &docker.Stats{}
+	stats.CPUStats.CPUUsage.TotalUsage = 300
+	stats.PreCPUStats.CPUUsage.TotalUsage = 100
+	stats.CPUStats.SystemCPUUsage = 2000
+	stats.PreCPUStats.SystemCPUUsage = 1000
+	stats.CPUStats.OnlineCPUs = 2
+	stats.MemoryStats.Usage = 600
+	stats.MemoryStats.Stats.InactiveFile = 100
+	stats.MemoryStats.Limit = 1000
+
+	fields := statsFields(stats)
+	for name, expected := range map[string]string{
+		"stats_cpu_percent":        "40.00",
+		"stats_memory_bytes":       "500",
+		"stats_memory_limit_bytes": "1000",
+		"stats_memory_percent":     "50.00",
+	} {
+		if fields[name] != expected {
+			t.Errorf("expected %s=%s, got %q", name, expected, fields[name])
+		}
+	}
+}
+
+func TestStatsCacheSamplesOncePerInterval(t *testing.T) {
+	var mu sync.Mutex
+	samples := 0
+	cache := newStatsCache(time.Hour, func(containerID string) (*docker.Stats, error) {
+		mu.Lock()
+		samples++
+		mu.Unlock()
+		if containerID == "broken" {
+			return nil, errors.New("no such container")
+		}
+		stats := &docker.Stats{}
+		stats.MemoryStats.Usage = 42
+		return stats, nil
+	})
+
+	if fields := cache.fields("abc"); fields != nil {
+		t.Errorf("expected no fields before the first sample, got %v", fields)
+	}
+	waitSampled(cache, "abc")
+	for i := 0; i < 10; i++ {
+		if fields := cache.fields("abc"); fields["stats_memory_bytes"] != "42" {
+			t.Fatalf("expected the sampled memory usage, got %v", fields)
+		}
+	}
+	cache.fields("broken")
+	waitSampled(cache, "broken")
+	if fields := cache.fields("broken"); fields != nil {
+		t.Errorf("expected no fields for a container that can't be sampled, got %v", fields)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if samples != 2 {
+		t.Errorf("expected one sample per container, got %d", samples)
+	}
+}
+
+// waitSampled waits for the first sample of a container to finish
+func waitSampled(cache *statsCache, containerID string) {
+	for i := 0; i < 1000; i++ {
+		cache.mu.Lock()
+		done := !cache.entries[containerID].sampled.IsZero()
+		cache.mu.Unlock()
+		if done {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRunStagesCopiesMessages(t *testing.T) {
+	addField := func(message *Message) *Message {
+		return message.WithFields(map[string]string{"team": "core"})
+	}
+	dropStderr := func(message *Message) *Message {
+		if message.Source == "stderr" {
+			return nil
+		}
+		return message
+	}
+	in := make(chan *Message, 2)
+	out := make(chan *Message, 2)
+	original := &Message{Source: "stdout", Data: "hello"}
+	in <- original
+	in <- &Message{Source: "stderr", Data: "dropped"}
+	close(in)
+	runStages([]stage{stageFunc(addField), stageFunc(dropStderr)}, in, out)
+
+	var got []*Message
+	for message := range out {
+		got = append(got, message)
+	}
+	if len(got) != 1 || got[0].Fields["team"] != "core" {
+		t.Fatalf("expected one message with the added field, got %v", got)
+	}
+	if original.Fields != nil {
+		t.Error("expected the original message to be left alone")
+	}
+}
+
+func TestNewStagesBadStatsInterval(t *testing.T) {
+	if _, err := newStages(&Route{Options: map[string]string{"stats_interval": "often"}}); err == nil {
+		t.Error("expected error for a bad stats_interval")
+	}
+}
+
+func TestStatsSummary(t *testing.T) {
+	stats := &docker.Stats{}
+	stats.MemoryStats.Usage = 500
+	stats.Networks = map[string]docker.NetworkStats{
+		"eth0": {RxBytes: 10, TxBytes: 20},
+		"eth1": {RxBytes: 1, TxBytes: 2},
+	}
+	fields := statsFields(stats)
+	if fields["stats_net_rx_bytes"] != "11" || fields["stats_net_tx_bytes"] != "22" {
+		t.Errorf("expected network usage summed over networks, got %v", fields)
+	}
+	expected := "stats memory_bytes=500 net_rx_bytes=11 net_tx_bytes=22"
+	if got := statsSummary(fields); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestStatsEventInterval(t *testing.T) {
+	if interval, err := statsEventInterval(&Route{}); interval != 0 || err != nil {
+		t.Errorf("expected a log route without stats_events, got %v %v", interval, err)
+	}
+	if interval, _ := statsEventInterval(&Route{Options: map[string]string{"stats_events": "30s"}}); interval != 30*time.Second {
+		t.Errorf("expected 30s, got %v", interval)
+	}
+	if _, err := statsEventInterval(&Route{Options: map[string]string{"stats_events": "-1s"}}); err == nil {
+		t.Error("expected error for a bad stats_events")
+	}
+}
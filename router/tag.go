@@ -0,0 +1,185 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// DefaultTag is the tag of the Docker logging drivers
+	DefaultTag    = "{{.ID}}"
+	maxCachedTags = 1024
+	shortIDLength = 12
+)
+
+// tagFuncs are the functions Docker offers in tag templates
+var tagFuncs = template.FuncMap{
+	"json": func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(b)
+	},
+	"split": strings.Split,
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"pad": func(s string, prefix, suffix int) string {
+		return strings.Repeat(" ", prefix) + s + strings.Repeat(" ", suffix)
+	},
+	"truncate": func(s string, n int) string {
+		if n < len(s) {
+			return s[:n]
+		}
+		return s
+	},
+}
+
+// TagInfo is what tag templates are executed with, the same as the Info of
+// the Docker logging drivers, so the tag option of a driver works unchanged
+type TagInfo struct {
+	ContainerID         string
+	ContainerName       string
+	ContainerEntrypoint string
+	ContainerArgs       []string
+	ContainerImageID    string
+	ContainerImageName  string
+	ContainerCreated    time.Time
+	ContainerEnv        []string
+	ContainerLabels     map[string]string
+	DaemonName          string
+}
+
+// ID returns the short ID of the container
+func (i TagInfo) ID() string {
+	return truncateID(i.ContainerID)
+}
+
+// FullID returns the ID of the container
+func (i TagInfo) FullID() string {
+	return i.ContainerID
+}
+
+// Name returns the name of the container
+func (i TagInfo) Name() string {
+	return strings.TrimPrefix(i.ContainerName, "/")
+}
+
+// ImageID returns the short ID of the image of the container
+func (i TagInfo) ImageID() string {
+	return truncateID(i.ContainerImageID)
+}
+
+// ImageFullID returns the ID of the image of the container
+func (i TagInfo) ImageFullID() string {
+	return i.ContainerImageID
+}
+
+// ImageName returns the name of the image of the container
+func (i TagInfo) ImageName() string {
+	return i.ContainerImageName
+}
+
+// Hostname returns the host the container runs on
+func (i TagInfo) Hostname() (string, error) {
+	if host := Hostname(); host != "" {
+		return host, nil
+	}
+	return os.Hostname()
+}
+
+// Command returns the entrypoint and arguments of the container
+func (i TagInfo) Command() string {
+	return strings.Join(append([]string{i.ContainerEntrypoint}, i.ContainerArgs...), " ")
+}
+
+// truncateID returns the first 12 characters of an ID, without the algorithm
+// of a digest
+func truncateID(id string) string {
+	if i := strings.IndexByte(id, ':'); i >= 0 {
+		id = id[i+1:]
+	}
+	if len(id) > shortIDLength {
+		id = id[:shortIDLength]
+	}
+	return id
+}
+
+// Tag renders the tag template of the Docker logging drivers, like
+// {{.Name}}/{{.ID}}, for the messages of a route. The tag of each container
+// is rendered once.
+type Tag struct {
+	tmpl *template.Template
+
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// NewTag parses text, in the syntax of the tag option of the Docker logging
+// drivers
+func NewTag(text string) (*Tag, error) {
+	tmpl, err := template.New("tag").Funcs(tagFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &Tag{tmpl: tmpl, cache: make(map[string]string)}, nil
+}
+
+// RouteTag returns the tag of the tag option of route, or nil when it has
+// none
+func RouteTag(route *Route) (*Tag, error) {
+	text := route.Options["tag"]
+	if text == "" {
+		return nil, nil
+	}
+	return NewTag(text)
+}
+
+// Tag returns the tag of message, or "" for messages without a container or
+// when the template fails
+func (t *Tag) Tag(message *Message) string {
+	container := message.Container
+	if container == nil {
+		return ""
+	}
+	key := container.ID + container.Name
+	t.mu.Lock()
+	tag, ok := t.cache[key]
+	t.mu.Unlock()
+	if ok {
+		return tag
+	}
+	info := TagInfo{
+		ContainerID:         container.ID,
+		ContainerName:       container.Name,
+		ContainerEntrypoint: container.Path,
+		ContainerArgs:       container.Args,
+		ContainerImageID:    container.Image,
+		ContainerCreated:    container.Created,
+		DaemonName:          "docker",
+	}
+	if config := container.Config; config != nil {
+		info.ContainerImageName = config.Image
+		info.ContainerEnv = config.Env
+		info.ContainerLabels = config.Labels
+	}
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, info); err != nil {
+		debug("tag: failed to render:", err)
+	} else {
+		tag = buf.String()
+	}
+	t.mu.Lock()
+	if len(t.cache) >= maxCachedTags {
+		t.cache = make(map[string]string)
+	}
+	t.cache[key] = tag
+	t.mu.Unlock()
+	return tag
+}
@@ -0,0 +1,46 @@
+package router
+
+import (
+	"testing"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+func TestTag(t *testing.T) {
+	container := &docker.Container{
+		ID:    "8dfafdbc3a40c1a2b3c4d5e6f708192a3b4c5d6e7f8091a2b3c4d5e6f7081920",
+		Name:  "/web",
+		Image: "sha256:4e38e38c8ce0b8d9041a9c4fefe786631d1416225e13b0bfe8cfa2321aec4bba",
+		Path:  "nginx",
+		Args:  []string{"-g", "daemon off;"},
+		Config: &docker.Config{
+			Image:  "nginx:1.25",
+			Labels: map[string]string{"com.docker.compose.service": "frontend"},
+		},
+	}
+	for _, tt := range []struct {
+		text, tag string
+	}{
+		{DefaultTag, "8dfafdbc3a40"},
+		{"{{.Name}}/{{.ID}}", "web/8dfafdbc3a40"},
+		{"{{.ImageName}}@{{.ImageID}}", "nginx:1.25@4e38e38c8ce0"},
+		{"{{.DaemonName}}/{{.Command}}", "docker/nginx -g daemon off;"},
+		{`{{index .ContainerLabels "com.docker.compose.service"}}`, "frontend"},
+		{"{{upper .Name}}-{{truncate .FullID 4}}", "WEB-8dfa"},
+	} {
+		tag, err := NewTag(tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tag.Tag(&Message{Container: container}); got != tt.tag {
+			t.Errorf("%s: expected %q, got %q", tt.text, tt.tag, got)
+		}
+	}
+
+	if tag, err := RouteTag(&Route{Options: map[string]string{}}); tag != nil || err != nil {
+		t.Errorf("expected no tag without the option, got %v, %v", tag, err)
+	}
+	if _, err := RouteTag(&Route{Options: map[string]string{"tag": "{{.Name"}}); err == nil {
+		t.Error("expected an error for a bad template")
+	}
+}
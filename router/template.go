@@ -0,0 +1,338 @@
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+const (
+	maxCachedAddresses   = 1024
+	maxTemplateAdapters  = 64
+	templateStreamBuffer = 100
+	templateRetryBackoff = 10 * time.Second
+)
+
+var addressTemplateRe = regexp.MustCompile(`{{.*?}}`)
+
+// isAddressTemplate returns whether a route address has to be resolved per container
+func isAddressTemplate(address string) bool {
+	return strings.Contains(address, "{{")
+}
+
+// extractTemplates replaces the template actions in uri by placeholders that
+// survive URL parsing. The returned function puts them back into a string.
+func extractTemplates(uri string) (string, func(string) string) {
+	var actions []string
+	uri = addressTemplateRe.ReplaceAllStringFunc(uri, func(action string) string {
+		actions = append(actions, action)
+		return fmt.Sprintf("logspout-template-%d-", len(actions)-1)
+	})
+	return uri, func(s string) string {
+		for i, action := range actions {
+			s = strings.Replace(s, fmt.Sprintf("logspout-template-%d-", i), action, -1)
+		}
+		return s
+	}
+}
+
+// addressContext is the data a route address template is executed against
+type addressContext struct {
+	Container *docker.Container
+}
+
+// templatedAdapter resolves the route address for every container and hands
+// messages to an adapter created for each distinct resolved address. At most
+// maxTemplateAdapters adapters are kept; the least recently used one is closed
+// to make room for a new address.
+type templatedAdapter struct {
+	route   *Route
+	factory AdapterFactory
+	tmpl    *template.Template
+
+	mu        sync.Mutex
+	addresses map[string]string
+	streams   map[string]*addressStream
+	failed    map[string]time.Time
+}
+
+type addressStream struct {
+	messages chan *Message
+	lastUsed time.Time
+}
+
+func newTemplatedAdapter(route *Route, factory AdapterFactory) (*templatedAdapter, error) {
+	tmpl, err := template.New("address").Option("missingkey=zero").Parse(route.Address)
+	if err != nil {
+		return nil, err
+	}
+	return &templatedAdapter{
+		route:     route,
+		factory:   factory,
+		tmpl:      tmpl,
+		addresses: make(map[string]string),
+		streams:   make(map[string]*addressStream),
+		failed:    make(map[string]time.Time),
+	}, nil
+}
+
+// Stream implements the router.LogAdapter interface
+func (a *templatedAdapter) Stream(logstream chan *Message) {
+	defer a.close()
+	for message := range logstream {
+		address := a.resolve(message.Container)
+		// a missing label typically leaves only the port
+		if address == "" || strings.HasPrefix(address, ":") {
+			debug("template: no address for container", normalID(message.Container.ID))
+			continue
+		}
+		stream := a.stream(address)
+		if stream == nil {
+			continue
+		}
+		// a slow or stopped adapter must not hold up the other addresses
+		select {
+		case stream <- message:
+		default:
+			debug("template: adapter for", address, "is not keeping up, dropping message")
+		}
+	}
+}
+
+func (a *templatedAdapter) resolve(container *docker.Container) string {
+	key := container.ID + container.Name
+	if address, ok := a.addresses[key]; ok {
+		return address
+	}
+	var buf bytes.Buffer
+	if err := a.tmpl.Execute(&buf, &addressContext{Container: container}); err != nil {
+		log.Println("template:", a.route, "failed to resolve address:", err)
+	}
+	address := strings.TrimSpace(buf.String())
+	if len(a.addresses) >= maxCachedAddresses {
+		a.addresses = make(map[string]string)
+	}
+	a.addresses[key] = address
+	return address
+}
+
+func (a *templatedAdapter) stream(address string) chan *Message {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if stream, ok := a.streams[address]; ok {
+		stream.lastUsed = time.Now()
+		return stream.messages
+	}
+	// don't retry a failing address for every message
+	if t, ok := a.failed[address]; ok && time.Since(t) < templateRetryBackoff {
+		return nil
+	}
+	if len(a.streams) >= maxTemplateAdapters {
+		a.evict()
+	}
+	adapter, err := a.factory(a.route.withAddress(address))
+	if err != nil {
+		log.Println("template:", a.route, "adapter for", address, "failed:", err)
+		a.failed[address] = time.Now()
+		return nil
+	}
+	delete(a.failed, address)
+	stream := &addressStream{
+		messages: make(chan *Message, templateStreamBuffer),
+		lastUsed: time.Now(),
+	}
+	a.streams[address] = stream
+	go func() {
+		adapter.Stream(stream.messages)
+		a.ended(address, stream)
+	}()
+	return stream.messages
+}
+
+// evict closes the adapter of the least recently used address
+func (a *templatedAdapter) evict() {
+	var oldest string
+	var oldestUsed time.Time
+	for address, stream := range a.streams {
+		if oldest == "" || stream.lastUsed.Before(oldestUsed) {
+			oldest, oldestUsed = address, stream.lastUsed
+		}
+	}
+	debug("template: closing adapter for least recently used address", oldest)
+	close(a.streams[oldest].messages)
+	delete(a.streams, oldest)
+}
+
+// ended forgets the adapter of an address once its Stream returned, so a new
+// one is created after the retry backoff
+func (a *templatedAdapter) ended(address string, stream *addressStream) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.streams[address] != stream {
+		// closed by evict or close
+		return
+	}
+	log.Println("template:", a.route, "adapter for", address, "stopped")
+	delete(a.streams, address)
+	a.failed[address] = time.Now()
+}
+
+// Close closes the adapters created for the resolved addresses
+func (a *templatedAdapter) Close() error {
+	a.close()
+	return nil
+}
+
+func (a *templatedAdapter) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for address, stream := range a.streams {
+		close(stream.messages)
+		delete(a.streams, address)
+	}
+}
+
+// withAddress returns a copy of the route targeting address
+func (r *Route) withAddress(address string) *Route {
+	route := *r
+	route.Address = address
+	route.Options = make(map[string]string, len(r.Options))
+	for k, v := range r.Options {
+		route.Options[k] = v
+	}
+	return &route
+}
+
+// TargetTemplate resolves a per message target, such as a topic, index or
+// stream, for adapters that support one. Templates only using container
+// fields are resolved once per container. The number of distinct targets is
+// capped; messages resolving to a new target once the cap has been reached
+// are sent to the fallback target instead.
+type TargetTemplate struct {
+	tmpl         *template.Template
+	fallback     string
+	max          int
+	perContainer bool
+
+	mu      sync.Mutex
+	targets map[string]struct{}
+	cache   map[string]string
+	capped  bool
+}
+
+// NewTargetTemplate parses text into a TargetTemplate allowing at most max
+// distinct targets, or any number of targets when max is zero
+func NewTargetTemplate(text, fallback string, max int) (*TargetTemplate, error) {
+	tmpl, err := template.New("target").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TargetTemplate{
+		tmpl:         tmpl,
+		fallback:     fallback,
+		max:          max,
+		perContainer: containerOnly(tmpl.Tree.Root),
+		targets:      make(map[string]struct{}),
+		cache:        make(map[string]string),
+	}, nil
+}
+
+// Target returns the target for message. The fallback is returned when the
+// template fails, resolves to an empty string or exceeds the target cap.
+func (t *TargetTemplate) Target(message *Message) string {
+	var key string
+	if t.perContainer && message.Container != nil {
+		key = message.Container.ID + message.Container.Name
+		t.mu.Lock()
+		target, ok := t.cache[key]
+		t.mu.Unlock()
+		if ok {
+			return target
+		}
+	}
+	target := t.resolve(message)
+	if key != "" {
+		t.mu.Lock()
+		if len(t.cache) >= maxCachedAddresses {
+			t.cache = make(map[string]string)
+		}
+		t.cache[key] = target
+		t.mu.Unlock()
+	}
+	return target
+}
+
+func (t *TargetTemplate) resolve(message *Message) string {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, message); err != nil {
+		debug("template: failed to resolve target:", err)
+		return t.fallback
+	}
+	target := strings.TrimSpace(buf.String())
+	if target == "" {
+		return t.fallback
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.targets[target]; ok {
+		return target
+	}
+	if t.max > 0 && len(t.targets) >= t.max {
+		if !t.capped {
+			log.Printf("template: reached the maximum of %d targets, using %q for new targets", t.max, t.fallback)
+			t.capped = true
+		}
+		return t.fallback
+	}
+	t.targets[target] = struct{}{}
+	return target
+}
+
+// containerOnly returns whether a template only uses fields of .Container, so
+// its result is the same for all messages of a container. Any construct it
+// doesn't know is assumed to depend on the message.
+func containerOnly(node parse.Node) bool {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		for _, child := range n.Nodes {
+			if !containerOnly(child) {
+				return false
+			}
+		}
+		return true
+	case *parse.TextNode:
+		return true
+	case *parse.ActionNode:
+		return containerOnly(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return true
+		}
+		for _, cmd := range n.Cmds {
+			if !containerOnly(cmd) {
+				return false
+			}
+		}
+		return true
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if !containerOnly(arg) {
+				return false
+			}
+		}
+		return true
+	case *parse.FieldNode:
+		return n.Ident[0] == "Container"
+	case *parse.IdentifierNode, *parse.StringNode, *parse.NumberNode, *parse.BoolNode, *parse.NilNode:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,179 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+type addressRecorder struct {
+	sync.Mutex
+	wg       sync.WaitGroup
+	messages map[string][]string
+}
+
+func (r *addressRecorder) factory(route *Route) (LogAdapter, error) {
+	r.wg.Add(1)
+	return &recordingAdapter{recorder: r, address: route.Address}, nil
+}
+
+type recordingAdapter struct {
+	recorder *addressRecorder
+	address  string
+}
+
+func (a *recordingAdapter) Stream(logstream chan *Message) {
+	defer a.recorder.wg.Done()
+	for message := range logstream {
+		a.recorder.Lock()
+		a.recorder.messages[a.address] = append(a.recorder.messages[a.address], message.Data)
+		a.recorder.Unlock()
+	}
+}
+
+func TestExtractTemplates(t *testing.T) {
+	uri := `gelf://{{ index .Container.Config.Labels "graylog_host" }}:12201?tag={{ .Container.Name }}`
+	extracted, restore := extractTemplates(uri)
+	if isAddressTemplate(extracted) {
+		t.Fatalf("expected template actions to be replaced, got %s", extracted)
+	}
+	if restore(extracted) != uri {
+		t.Errorf("expected %s, got %s", uri, restore(extracted))
+	}
+}
+
+func TestTemplatedAdapterPerAddress(t *testing.T) {
+	recorder := &addressRecorder{messages: make(map[string][]string)}
+	route := &Route{Address: `{{ index .Container.Config.Labels "graylog_host" }}:12201`}
+	adapter, err := newTemplatedAdapter(route, recorder.factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := func(id, host string) *docker.Container {
+		return &docker.Container{ID: id, Config: &docker.Config{Labels: map[string]string{"graylog_host": host}}}
+	}
+	a, b, none := container("a", "graylog-a"), container("b", "graylog-b"), container("c", "")
+
+	logstream := make(chan *Message)
+	done := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(done)
+	}()
+	logstream <- &Message{Container: a, Data: "1"}
+	logstream <- &Message{Container: b, Data: "2"}
+	logstream <- &Message{Container: a, Data: "3"}
+	logstream <- &Message{Container: none, Data: "4"}
+	close(logstream)
+	<-done
+	recorder.wg.Wait()
+
+	recorder.Lock()
+	defer recorder.Unlock()
+	if len(recorder.messages) != 2 {
+		t.Fatalf("expected 2 adapters, got %v", recorder.messages)
+	}
+	if got := recorder.messages["graylog-a:12201"]; len(got) != 2 {
+		t.Errorf("expected 2 messages for graylog-a, got %v", got)
+	}
+	if got := recorder.messages["graylog-b:12201"]; len(got) != 1 {
+		t.Errorf("expected 1 message for graylog-b, got %v", got)
+	}
+}
+
+func TestTargetTemplateCap(t *testing.T) {
+	target, err := NewTargetTemplate(`{{ index .Container.Config.Labels "team" }}`, "default", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := func(team string) *Message {
+		return &Message{Container: &docker.Container{
+			ID:     "container-" + team,
+			Config: &docker.Config{Labels: map[string]string{"team": team}},
+		}}
+	}
+	for team, expected := range map[string]string{"a": "a", "b": "b", "": "default"} {
+		if got := target.Target(message(team)); got != expected {
+			t.Errorf("expected target %q for team %q, got %q", expected, team, got)
+		}
+	}
+	if got := target.Target(message("c")); got != "default" {
+		t.Errorf("expected fallback once the cap is reached, got %q", got)
+	}
+	if got := target.Target(message("a")); got != "a" {
+		t.Errorf("expected known target to be kept, got %q", got)
+	}
+}
+
+type stoppedAdapter struct{}
+
+func (a *stoppedAdapter) Stream(logstream chan *Message) {}
+
+func TestTemplatedAdapterEvictsLeastRecentlyUsed(t *testing.T) {
+	recorder := &addressRecorder{messages: make(map[string][]string)}
+	route := &Route{Address: `{{ .Container.Name }}:514`}
+	adapter, err := newTemplatedAdapter(route, recorder.factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= maxTemplateAdapters; i++ {
+		adapter.stream(fmt.Sprintf("host%d:514", i))
+	}
+	adapter.mu.Lock()
+	defer adapter.mu.Unlock()
+	if len(adapter.streams) != maxTemplateAdapters {
+		t.Errorf("expected %d adapters, got %d", maxTemplateAdapters, len(adapter.streams))
+	}
+	if _, ok := adapter.streams["host0:514"]; ok {
+		t.Error("expected the least recently used adapter to be closed")
+	}
+}
+
+func TestTemplatedAdapterStoppedAdapterDoesNotBlock(t *testing.T) {
+	route := &Route{Address: `{{ .Container.Name }}:514`}
+	factory := func(route *Route) (LogAdapter, error) { return &stoppedAdapter{}, nil }
+	adapter, err := newTemplatedAdapter(route, factory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logstream := make(chan *Message)
+	done := make(chan struct{})
+	go func() {
+		adapter.Stream(logstream)
+		close(done)
+	}()
+	container := &docker.Container{ID: "a", Name: "a", Config: &docker.Config{}}
+	for i := 0; i < 2*templateStreamBuffer; i++ {
+		select {
+		case logstream <- &Message{Container: container}:
+		case <-time.After(time.Second):
+			t.Fatal("route blocked on a stopped adapter")
+		}
+	}
+	close(logstream)
+	<-done
+}
+
+func TestTargetTemplateCache(t *testing.T) {
+	perContainer, _ := NewTargetTemplate(`{{ .Container.Name }}-{{ index .Container.Config.Labels "team" }}`, "default", 0)
+	perMessage, _ := NewTargetTemplate(`{{ .Source }}`, "default", 0)
+	if !perContainer.perContainer || perMessage.perContainer {
+		t.Fatal("expected only the template using container fields to be cached per container")
+	}
+	container := &docker.Container{ID: "a", Name: "app", Config: &docker.Config{Labels: map[string]string{"team": "x"}}}
+	if got := perContainer.Target(&Message{Container: container}); got != "app-x" {
+		t.Fatalf("expected app-x, got %q", got)
+	}
+	container.Config.Labels["team"] = "y"
+	if got := perContainer.Target(&Message{Container: container}); got != "app-x" {
+		t.Errorf("expected cached target app-x, got %q", got)
+	}
+	for _, source := range []string{"stdout", "stderr"} {
+		if got := perMessage.Target(&Message{Container: container, Source: source}); got != source {
+			t.Errorf("expected %s, got %q", source, got)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package router
+
+import (
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	TransformFactories.Register(newRenameTransform, "rename")
+	TransformFactories.Register(newTagTransform, "tag")
+	TransformFactories.Register(newRewriteTransform, "rewrite")
+	TransformFactories.Register(newDropTransform, "drop")
+}
+
+// transformStage passes messages through the transforms named in the
+// transforms option of a route, in order
+type transformStage []Transform
+
+// newTransformStage returns the stage of the comma separated transforms
+// names, created with the options of route
+func newTransformStage(route *Route, names string) (transformStage, error) {
+	var s transformStage
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		factory, ok := TransformFactories.Lookup(name)
+		if !ok {
+			s.Close()
+			return nil, errors.New("unknown transform: " + name)
+		}
+		transform, err := factory(route)
+		if err != nil {
+			s.Close()
+			return nil, errors.New("transform " + name + ": " + err.Error())
+		}
+		s = append(s, transform)
+	}
+	return s, nil
+}
+
+func (s transformStage) process(message *Message) *Message {
+	for _, t := range s {
+		if message = t.Transform(message); message == nil {
+			return nil
+		}
+	}
+	return message
+}
+
+// Close closes the transforms holding resources
+func (s transformStage) Close() error {
+	for _, t := range s {
+		if closer, ok := t.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	return nil
+}
+
+// fieldPairs parses the comma separated key:value pairs of the option name
+// of route
+func fieldPairs(route *Route, name string) (map[string]string, error) {
+	s := route.Options[name]
+	if s == "" {
+		return nil, errors.New("needs " + name)
+	}
+	pairs := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.New("bad " + name + ": " + s)
+		}
+		pairs[parts[0]] = parts[1]
+	}
+	return pairs, nil
+}
+
+// newRenameTransform renames the fields of messages, by the old:new pairs of
+// the transform.rename option
+func newRenameTransform(route *Route) (Transform, error) {
+	names, err := fieldPairs(route, "transform.rename")
+	if err != nil {
+		return nil, err
+	}
+	return TransformFunc(func(message *Message) *Message {
+		renamed := false
+		for field := range message.Fields {
+			if _, renamed = names[field]; renamed {
+				break
+			}
+		}
+		if !renamed {
+			return message
+		}
+		copied := *message
+		copied.Fields = make(map[string]string, len(message.Fields))
+		for field, value := range message.Fields {
+			if name, ok := names[field]; ok {
+				field = name
+			}
+			copied.Fields[field] = value
+		}
+		return &copied
+	}), nil
+}
+
+// newTagTransform adds the key:value pairs of the transform.tags option to
+// the fields of messages
+func newTagTransform(route *Route) (Transform, error) {
+	tags, err := fieldPairs(route, "transform.tags")
+	if err != nil {
+		return nil, err
+	}
+	return TransformFunc(func(message *Message) *Message {
+		return message.WithFields(tags)
+	}), nil
+}
+
+// newRewriteTransform replaces the matches of the transform.rewrite regular
+// expression in the text of messages with transform.rewrite_replacement, in
+// which $1 or ${name} stand for the text of a group
+func newRewriteTransform(route *Route) (Transform, error) {
+	pattern := route.Options["transform.rewrite"]
+	if pattern == "" {
+		return nil, errors.New("needs transform.rewrite")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.New("bad transform.rewrite: " + err.Error())
+	}
+	replacement := route.Options["transform.rewrite_replacement"]
+	return TransformFunc(func(message *Message) *Message {
+		if !re.MatchString(message.Data) {
+			return message
+		}
+		rewritten := *message
+		rewritten.Data = re.ReplaceAllString(message.Data, replacement)
+		return &rewritten
+	}), nil
+}
+
+// newDropTransform drops the messages matching all of the accessor:pattern
+// pairs of the transform.drop option, as filter.record takes them
+func newDropTransform(route *Route) (Transform, error) {
+	s := route.Options["transform.drop"]
+	if s == "" {
+		return nil, errors.New("needs transform.drop")
+	}
+	matches, err := parseRecordMatches(s)
+	if err != nil {
+		return nil, errors.New("bad transform.drop: " + s)
+	}
+	return TransformFunc(func(message *Message) *Message {
+		if matches.match(message) {
+			return nil
+		}
+		return message
+	}), nil
+}
@@ -0,0 +1,78 @@
+package router
+
+import (
+	"strings"
+	"testing"
+)
+
+// closingTransform upper-cases messages and records whether it was closed
+type closingTransform struct {
+	closed bool
+}
+
+func (t *closingTransform) Transform(message *Message) *Message {
+	upper := *message
+	upper.Data = strings.ToUpper(message.Data)
+	return &upper
+}
+
+func (t *closingTransform) Close() error {
+	t.closed = true
+	return nil
+}
+
+func TestTransformStage(t *testing.T) {
+	custom := &closingTransform{}
+	TransformFactories.Register(func(*Route) (Transform, error) { return custom, nil }, "upper")
+	defer TransformFactories.Unregister("upper")
+
+	route := &Route{ID: "transforms", Options: map[string]string{
+		"transforms":                    "rename,tag,rewrite,drop,upper",
+		"transform.rename":              "client:remote_addr",
+		"transform.tags":                "env:prod,team:core",
+		"transform.rewrite":             `password=\S+`,
+		"transform.rewrite_replacement": "password=***",
+		"transform.drop":                "$log:GET /healthz*",
+	}}
+	defer removeMetrics("transforms")
+	stages, err := newStages(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := &Message{Data: "login password=hunter2", Fields: map[string]string{"client": "10.0.0.1"}}
+	got := processStages(stages, original)
+	if got == nil {
+		t.Fatal("expected the message to pass")
+	}
+	if got.Data != "LOGIN PASSWORD=***" {
+		t.Errorf("expected the rewritten text, got %q", got.Data)
+	}
+	if got.Fields["remote_addr"] != "10.0.0.1" || got.Fields["client"] != "" || got.Fields["env"] != "prod" || got.Fields["team"] != "core" {
+		t.Errorf("expected renamed and tagged fields, got %v", got.Fields)
+	}
+	if original.Data != "login password=hunter2" || original.Fields["client"] != "10.0.0.1" {
+		t.Errorf("expected the original message unchanged, got %+v", original)
+	}
+	if processStages(stages, &Message{Data: "GET /healthz 200"}) != nil {
+		t.Error("expected the drop transform to drop health checks")
+	}
+	if filtered := route.Metrics().Snapshot().Filtered; filtered != 1 {
+		t.Errorf("expected 1 filtered message, got %d", filtered)
+	}
+	closeStages(stages)
+	if !custom.closed {
+		t.Error("expected the transform to be closed with its route")
+	}
+
+	for _, options := range []map[string]string{
+		{"transforms": "missing"},
+		{"transforms": "tag"},
+		{"transforms": "rename", "transform.rename": "client"},
+		{"transforms": "rewrite", "transform.rewrite": "("},
+		{"transforms": "drop", "transform.drop": "log:x"},
+	} {
+		if _, err := newStages(&Route{Options: options}); err == nil {
+			t.Errorf("%v: expected an error", options)
+		}
+	}
+}
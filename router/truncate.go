@@ -0,0 +1,73 @@
+package router
+
+import (
+	"errors"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gliderlabs/logspout/cfg"
+)
+
+const defaultEllipsis = "..."
+
+// Truncation cuts text to at most MaxBytes bytes, ending in Ellipsis when it
+// was cut. The cut is made at a rune boundary, so multibyte characters are
+// never split. A MaxBytes of 0 keeps text as is.
+type Truncation struct {
+	MaxBytes int
+	Ellipsis string
+}
+
+// NewTruncation returns the truncation configured with the route option key,
+// or the environment variable env, as the maximum number of bytes. The
+// ellipsis is the truncate_ellipsis option or TRUNCATE_ELLIPSIS, "..." by
+// default.
+func NewTruncation(route *Route, key, env string) (Truncation, error) {
+	t := Truncation{Ellipsis: defaultEllipsis}
+	s := route.Options[key]
+	if s == "" {
+		s = cfg.GetEnvDefault(env, "")
+	}
+	if s != "" {
+		var err error
+		if t.MaxBytes, err = strconv.Atoi(s); err != nil || t.MaxBytes < 0 {
+			return t, errors.New("bad " + key + ": " + s)
+		}
+	}
+	if ellipsis, ok := route.Options["truncate_ellipsis"]; ok {
+		t.Ellipsis = ellipsis
+	} else {
+		t.Ellipsis = cfg.GetEnvDefault("TRUNCATE_ELLIPSIS", defaultEllipsis)
+	}
+	return t, nil
+}
+
+// Truncate returns s cut to the maximum number of bytes
+func (t Truncation) Truncate(s string) string {
+	if t.MaxBytes <= 0 || len(s) <= t.MaxBytes {
+		return s
+	}
+	ellipsis := t.Ellipsis
+	if len(ellipsis) >= t.MaxBytes {
+		// no room for any text, so cut the ellipsis instead
+		s, ellipsis = ellipsis, ""
+	}
+	return TruncateBytes(s, t.MaxBytes-len(ellipsis)) + ellipsis
+}
+
+// TruncateBytes returns the longest prefix of s of at most max bytes that
+// doesn't end in part of a multibyte character. Invalid UTF-8 is cut at max.
+func TruncateBytes(s string, max int) string {
+	if max <= 0 {
+		return ""
+	}
+	if len(s) <= max {
+		return s
+	}
+	for i := max; i > 0 && i > max-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			return s[:i]
+		}
+	}
+	return s[:max]
+}
@@ -0,0 +1,56 @@
+package router
+
+import (
+	"os"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	for _, tt := range []struct {
+		in       string
+		max      int
+		ellipsis string
+		out      string
+	}{
+		{"hello", 0, "...", "hello"},
+		{"hello", 5, "...", "hello"},
+		{"hello world", 8, "...", "hello..."},
+		{"hello world", 8, "", "hello wo"},
+		{"héllo", 2, "", "h"},
+		{"日本語", 7, "", "日本"},
+		{"日本語", 7, "…", "日…"},
+		{"😀😀", 5, "", "😀"},
+		{"hello", 2, "...", ".."},
+		{"\xff\xff\xff\xff\xff", 3, "", "\xff\xff\xff"},
+	} {
+		out := Truncation{MaxBytes: tt.max, Ellipsis: tt.ellipsis}.Truncate(tt.in)
+		if out != tt.out {
+			t.Errorf("expected %q for %q cut to %d, got %q", tt.out, tt.in, tt.max, out)
+		}
+		if tt.max > 0 && len(out) > tt.max {
+			t.Errorf("expected at most %d bytes, got %d", tt.max, len(out))
+		}
+		if utf8.ValidString(tt.in) && !utf8.ValidString(out) {
+			t.Errorf("expected valid UTF-8 for %q, got %q", tt.in, out)
+		}
+	}
+}
+
+func TestNewTruncation(t *testing.T) {
+	os.Setenv("TEST_MAX_BYTES", "100")
+	os.Setenv("TRUNCATE_ELLIPSIS", "…")
+	defer os.Unsetenv("TEST_MAX_BYTES")
+	defer os.Unsetenv("TRUNCATE_ELLIPSIS")
+	tr, err := NewTruncation(&Route{}, "max_bytes", "TEST_MAX_BYTES")
+	if err != nil || tr.MaxBytes != 100 || tr.Ellipsis != "…" {
+		t.Errorf("expected the environment settings, got %+v, %v", tr, err)
+	}
+	tr, err = NewTruncation(&Route{Options: map[string]string{"max_bytes": "10", "truncate_ellipsis": ""}}, "max_bytes", "TEST_MAX_BYTES")
+	if err != nil || tr.MaxBytes != 10 || tr.Ellipsis != "" {
+		t.Errorf("expected the route options, got %+v, %v", tr, err)
+	}
+	if _, err = NewTruncation(&Route{Options: map[string]string{"max_bytes": "-1"}}, "max_bytes", "TEST_MAX_BYTES"); err == nil {
+		t.Error("expected error for a negative size")
+	}
+}
@@ -1,11 +1,12 @@
-//go:generate go-extpoints . AdapterFactory HttpHandler AdapterTransport LogRouter Job
+//go:generate go-extpoints . AdapterFactory HttpHandler AdapterTransport LogRouter Job TransformFactory
 package router
 
 import (
+	"encoding/json"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
-	"path"
 	"strings"
 	"time"
 
@@ -18,6 +19,27 @@ type HTTPHandler func() http.Handler
 // AdapterFactory is an extension type for adding new log adapters
 type AdapterFactory func(route *Route) (LogAdapter, error)
 
+// TransformFactory is an extension type for adding message transforms, which
+// routes name in their transforms option
+type TransformFactory func(route *Route) (Transform, error)
+
+// Transform changes the messages of a route before they reach its adapter,
+// like renaming fields, adding tags or rewriting the text. It returns the
+// message to pass on, or nil to drop it. Messages are shared between routes,
+// so a transform that changes a message works on a copy, as WithFields
+// returns. A Transform implementing io.Closer is closed with its route.
+type Transform interface {
+	Transform(message *Message) *Message
+}
+
+// TransformFunc adapts a function to a Transform
+type TransformFunc func(message *Message) *Message
+
+// Transform returns f(message)
+func (f TransformFunc) Transform(message *Message) *Message {
+	return f(message)
+}
+
 // AdapterTransport is an extension type for connection transports used by adapters
 type AdapterTransport interface {
 	Dial(addr string, options map[string]string) (net.Conn, error)
@@ -55,24 +77,124 @@ type Message struct {
 	Source    string
 	Data      string
 	Time      time.Time
+	Replay    bool
+	// Fields holds data added to the message by the route, such as container
+	// stats, for adapters to include. It may be shared between messages and
+	// routes, so it must not be modified.
+	Fields map[string]string
 }
 
 // Route represents what subset of logs should go where
 type Route struct {
-	ID            string   `json:"id"`
-	FilterID      string   `json:"filter_id,omitempty"`
-	FilterName    string   `json:"filter_name,omitempty"`
-	FilterSources []string `json:"filter_sources,omitempty"`
-	FilterLabels  []string `json:"filter_labels,omitempty"`
-	Adapter       string   `json:"adapter"`
-	Address       string   `json:"address"`
-	Path          string   `json:"path"`
-	User          *url.Userinfo
-	Options       map[string]string `json:"options,omitempty"`
-	adapter       LogAdapter
-	closed        bool
-	closer        chan struct{}
-	closerRcv     <-chan struct{} // used instead of closer when set
+	ID             string   `json:"id"`
+	Name           string   `json:"name,omitempty"`
+	Description    string   `json:"description,omitempty"`
+	FilterID       string   `json:"filter_id,omitempty"`
+	FilterName     string   `json:"filter_name,omitempty"`
+	FilterSources  []string `json:"filter_sources,omitempty"`
+	FilterLabels   []string `json:"filter_labels,omitempty"`
+	FilterNetworks []string `json:"filter_networks,omitempty"`
+	FilterIPs      []string `json:"filter_ips,omitempty"`
+	// FilterComposeProject and FilterComposeService match the
+	// com.docker.compose labels Docker Compose gives the containers it runs
+	FilterComposeProject string            `json:"filter_compose_project,omitempty"`
+	FilterComposeService string            `json:"filter_compose_service,omitempty"`
+	Adapter              string            `json:"adapter"`
+	Address              string            `json:"address"`
+	Path                 string            `json:"path"`
+	User                 *url.Userinfo     `json:"-"`
+	Options              map[string]string `json:"options,omitempty"`
+	Paused               bool              `json:"paused,omitempty"`
+	adapter              LogAdapter
+	pause                *pauseControl
+	stages               []stage
+	filter               *routeFilter
+	input                chan *Message
+	closed               bool
+	closer               chan struct{}
+	closerRcv            <-chan struct{} // used instead of closer when set
+}
+
+// routeUser is the JSON form of the user info of a route, which url.Userinfo
+// doesn't have as it has no exported fields
+type routeUser struct {
+	Username string  `json:"username"`
+	Password *string `json:"password,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, including the user info of the
+// route so routes can be persisted and exported without losing credentials
+func (r *Route) MarshalJSON() ([]byte, error) {
+	type route Route
+	aux := struct {
+		*route
+		User *routeUser `json:"user,omitempty"`
+	}{route: (*route)(r)}
+	if r.User != nil {
+		aux.User = &routeUser{Username: r.User.Username()}
+		if password, ok := r.User.Password(); ok {
+			aux.User.Password = &password
+		}
+	}
+	return json.Marshal(aux)
+}
+
+// redactedPassword replaces the password of the user info of redacted
+// routes, as url.URL.Redacted does
+const redactedPassword = "xxxxx"
+
+// Redacted returns a copy of the route with the password of its user info
+// replaced, for API responses that anyone reaching the API can read, or the
+// route itself when it has no password. The routes persisted and exported
+// keep it.
+func (r *Route) Redacted() *Route {
+	if r.User == nil {
+		return r
+	}
+	if _, ok := r.User.Password(); !ok {
+		return r
+	}
+	copied := *r
+	copied.User = url.UserPassword(r.User.Username(), redactedPassword)
+	return &copied
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *Route) UnmarshalJSON(data []byte) error {
+	type route Route
+	aux := struct {
+		*route
+		User *routeUser `json:"user,omitempty"`
+	}{route: (*route)(r)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	r.User = nil
+	switch {
+	case aux.User == nil || (aux.User.Username == "" && aux.User.Password == nil):
+	case aux.User.Password != nil:
+		r.User = url.UserPassword(aux.User.Username, *aux.User.Password)
+	default:
+		r.User = url.User(aux.User.Username)
+	}
+	return nil
+}
+
+// String returns the name of the route, or its ID when it has no name
+func (r *Route) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.ID
+}
+
+// stableID returns the ID the route will be stored under, which is its name
+// when no ID has been given
+func (r *Route) stableID() string {
+	if r.ID != "" {
+		return r.ID
+	}
+	return r.Name
 }
 
 // AdapterType returns a route's adapter type string
@@ -108,7 +230,8 @@ func (r *Route) Close() {
 }
 
 func (r *Route) matchAll() bool {
-	if r.FilterID == "" && r.FilterName == "" && len(r.FilterSources) == 0 && len(r.FilterLabels) == 0 {
+	if r.FilterID == "" && r.FilterName == "" && len(r.FilterSources) == 0 && len(r.FilterLabels) == 0 &&
+		len(r.FilterNetworks) == 0 && len(r.FilterIPs) == 0 && r.FilterComposeProject == "" && r.FilterComposeService == "" {
 		return true
 	}
 	return false
@@ -121,47 +244,51 @@ func (r *Route) MultiContainer() bool {
 
 // MatchContainer returns whether the Route is responsible for a given container
 func (r *Route) MatchContainer(id, name string, labels map[string]string) bool {
-	if r.matchAll() {
-		return true
-	}
-	if r.FilterID != "" && !strings.HasPrefix(id, r.FilterID) {
-		return false
-	}
-	match, err := path.Match(r.FilterName, name)
-	if err != nil || (r.FilterName != "" && !match) {
-		return false
-	}
-	for _, label := range r.FilterLabels {
-		labelParts := strings.SplitN(label, ":", 2)
-		if len(labelParts) > 1 {
-			labelKey := labelParts[0]
-			labelValue := labelParts[1]
-			labelMatch, labelErr := path.Match(labelValue, labels[labelKey])
-			if labelErr != nil || (labelValue != "" && !labelMatch) {
-				return false
-			}
-		}
-	}
+	return r.matchFilter().matchContainer(id, name, labels)
+}
 
-	return true
+// MatchContainerNetworks returns whether the Route is responsible for a container
+// attached to the given networks. A container matches when it is attached to at
+// least one network matching FilterNetworks and has at least one address within
+// FilterIPs.
+func (r *Route) MatchContainerNetworks(settings *docker.NetworkSettings) bool {
+	return r.matchFilter().matchNetworks(settings)
 }
 
-// MatchMessage returns whether the Route is responsible for a given Message
-func (r *Route) MatchMessage(message *Message) bool {
-	if r.matchAll() {
-		return true
-	}
-	if len(r.FilterSources) > 0 && !contains(r.FilterSources, message.Source) {
-		return false
+// parseCIDR parses a CIDR, treating a single address as a host network
+func parseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip = ip.To4()
+			bits = 8 * net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
 	}
-	return true
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
 }
 
-func contains(strs []string, str string) bool {
-	for _, s := range strs {
-		if s == str {
-			return true
+func containerIPs(settings *docker.NetworkSettings) []net.IP {
+	var addrs []string
+	addrs = append(addrs, settings.IPAddress, settings.GlobalIPv6Address)
+	for _, network := range settings.Networks {
+		addrs = append(addrs, network.IPAddress, network.GlobalIPv6Address)
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil {
+			ips = append(ips, ip)
 		}
 	}
-	return false
+	return ips
+}
+
+// MatchMessage returns whether the Route is responsible for a given Message
+func (r *Route) MatchMessage(message *Message) bool {
+	return r.matchFilter().matchMessage(message)
 }
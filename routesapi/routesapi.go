@@ -3,14 +3,28 @@ package routesapi
 import (
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
 
 	"github.com/gliderlabs/logspout/router"
 )
 
+const (
+	defaultReplayPeriod = time.Hour
+	// defaultBufferReplayRate is the messages per second a buffer is
+	// replayed at, so the replay doesn't flood the backend
+	defaultBufferReplayRate = 1000
+)
+
 func init() {
 	router.HTTPHandlers.Register(RoutesAPI, "routes")
 }
@@ -20,6 +34,91 @@ func RoutesAPI() http.Handler {
 	routes := router.Routes
 	r := mux.NewRouter()
 
+	r.HandleFunc("/routes/export", func(w http.ResponseWriter, req *http.Request) {
+		rts, _ := routes.GetAll()
+		if !wantsYAML(req.URL.Query().Get("format"), req.Header.Get("Accept")) {
+			w.Header().Add("Content-Type", "application/json")
+			w.Write(append(marshal(rts), '\n'))
+			return
+		}
+		doc, err := marshalYAML(rts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/yaml")
+		w.Write(doc)
+	}).Methods("GET")
+
+	r.HandleFunc("/routes/import", func(w http.ResponseWriter, req *http.Request) {
+		var rts []*router.Route
+		if err := unmarshalDocument(req, &rts); err != nil {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := routes.Replace(rts); err != nil {
+			http.Error(w, "Bad route: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(append(marshal(redacted(rts)), '\n'))
+	}).Methods("POST")
+
+	r.HandleFunc("/routes/{id}/pause", func(w http.ResponseWriter, req *http.Request) {
+		params := mux.Vars(req)
+		route, err := routes.Pause(params["id"])
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(append(marshal(route.Redacted()), '\n'))
+	}).Methods("POST")
+
+	r.HandleFunc("/routes/{id}/resume", func(w http.ResponseWriter, req *http.Request) {
+		params := mux.Vars(req)
+		route, err := routes.Resume(params["id"])
+		if err != nil {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(append(marshal(route.Redacted()), '\n'))
+	}).Methods("POST")
+
+	r.HandleFunc("/routes/{id}/replay", func(w http.ResponseWriter, req *http.Request) {
+		params := mux.Vars(req)
+		query := req.URL.Query()
+		if query.Get("buffer") == "true" {
+			replayBuffer(w, req, params["id"])
+			return
+		}
+		container := query.Get("container")
+		if container == "" {
+			http.Error(w, "Bad request: container is required", http.StatusBadRequest)
+			return
+		}
+		since, until, ok := replayWindow(w, query)
+		if !ok {
+			return
+		}
+		n, err := routes.Replay(params["id"], container, since, until)
+		if os.IsNotExist(err) {
+			http.NotFound(w, req)
+			return
+		}
+		if err == router.ErrContainerNotRouted {
+			http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Replay failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.Write(append(marshal(map[string]int{"replayed": n}), '\n'))
+	}).Methods("POST")
+
 	r.HandleFunc("/routes/{id}", func(w http.ResponseWriter, req *http.Request) {
 		params := mux.Vars(req)
 		route, _ := routes.Get(params["id"])
@@ -27,7 +126,7 @@ func RoutesAPI() http.Handler {
 			http.NotFound(w, req)
 			return
 		}
-		w.Write(append(marshal(route), '\n'))
+		w.Write(append(marshal(route.Redacted()), '\n'))
 	}).Methods("GET")
 
 	r.HandleFunc("/routes/{id}", func(w http.ResponseWriter, req *http.Request) {
@@ -40,7 +139,7 @@ func RoutesAPI() http.Handler {
 	r.HandleFunc("/routes", func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
 		rts, _ := routes.GetAll()
-		w.Write(append(marshal(rts), '\n'))
+		w.Write(append(marshal(redacted(rts)), '\n'))
 	}).Methods("GET")
 
 	r.HandleFunc("/routes", func(w http.ResponseWriter, req *http.Request) {
@@ -56,12 +155,22 @@ func RoutesAPI() http.Handler {
 		}
 		w.Header().Add("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
-		w.Write(append(marshal(route), '\n'))
+		w.Write(append(marshal(route.Redacted()), '\n'))
 	}).Methods("POST")
 
 	return r
 }
 
+// redacted returns the routes with their passwords redacted, for responses
+// other than the export
+func redacted(routes []*router.Route) []*router.Route {
+	copies := make([]*router.Route, len(routes))
+	for i, route := range routes {
+		copies[i] = route.Redacted()
+	}
+	return copies
+}
+
 func marshal(obj interface{}) []byte {
 	bytes, err := json.MarshalIndent(obj, "", "  ")
 	if err != nil {
@@ -74,3 +183,104 @@ func unmarshal(input io.Reader, obj interface{}) error {
 	dec := json.NewDecoder(input)
 	return dec.Decode(obj)
 }
+
+func wantsYAML(format, accept string) bool {
+	if format != "" {
+		return format == "yaml"
+	}
+	return strings.Contains(accept, "yaml")
+}
+
+// marshalYAML converts obj through its JSON form, so the YAML document uses the
+// same field names as the JSON API
+func marshalYAML(obj interface{}) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(marshal(obj), &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+// unmarshalDocument decodes a JSON or, based on the content type, YAML request body
+func unmarshalDocument(req *http.Request, obj interface{}) error {
+	if !strings.Contains(req.Header.Get("Content-Type"), "yaml") {
+		return unmarshal(req.Body, obj)
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err = yaml.Unmarshal(body, &doc); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, obj)
+}
+
+// replayBuffer answers a replay of the buffer of the route with the given id
+func replayBuffer(w http.ResponseWriter, req *http.Request, id string) {
+	query := req.URL.Query()
+	since, until, ok := replayWindow(w, query)
+	if !ok {
+		return
+	}
+	rate := defaultBufferReplayRate
+	if s := query.Get("rate"); s != "" {
+		var err error
+		if rate, err = strconv.Atoi(s); err != nil || rate < 0 {
+			http.Error(w, "Bad request: rate: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+	n, err := router.Routes.ReplayBuffer(id, since, until, rate)
+	if os.IsNotExist(err) {
+		http.NotFound(w, req)
+		return
+	}
+	if err == router.ErrNoBuffer {
+		http.Error(w, "Bad request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Replay failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(append(marshal(map[string]int{"replayed": n}), '\n'))
+}
+
+// replayWindow returns the since and until parameters of a replay, by
+// default the last hour, or answers a bad request and returns false
+func replayWindow(w http.ResponseWriter, query url.Values) (since, until time.Time, ok bool) {
+	now := time.Now()
+	since, err := parseTime(query.Get("since"), now.Add(-defaultReplayPeriod), now)
+	if err != nil {
+		http.Error(w, "Bad request: since: "+err.Error(), http.StatusBadRequest)
+		return since, until, false
+	}
+	if until, err = parseTime(query.Get("until"), now, now); err != nil {
+		http.Error(w, "Bad request: until: "+err.Error(), http.StatusBadRequest)
+		return since, until, false
+	}
+	if until.Before(since) {
+		http.Error(w, "Bad request: since is after until", http.StatusBadRequest)
+		return since, until, false
+	}
+	return since, until, true
+}
+
+// parseTime parses s as a RFC 3339 time or as a duration before now, or
+// returns dfault when it is empty
+func parseTime(s string, dfault, now time.Time) (time.Time, error) {
+	if s == "" {
+		return dfault, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,310 @@
+// Package relp provides a transport speaking RELP, the Reliable Event Logging
+// Protocol of rsyslog. Every write is sent as a syslog command and
+// acknowledged by the receiver. Messages the receiver didn't acknowledge yet
+// are sent again, in order, when the connection has to be reopened.
+package relp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	defaultWindow  = 128
+	defaultTimeout = 10 * time.Second
+	maxTxnr        = 999999999
+	maxFrameData   = 1 << 20
+	offers         = "relp_version=0\nrelp_software=logspout\ncommands=syslog"
+)
+
+var errClosed = errors.New("relp: connection closed")
+
+type relpTransport int
+
+func init() {
+	router.AdapterTransports.Register(new(relpTransport), "relp")
+}
+
+func (t *relpTransport) Dial(addr string, options map[string]string) (net.Conn, error) {
+	window := defaultWindow
+	if s := options["relp_window"]; s != "" {
+		var err error
+		if window, err = strconv.Atoi(s); err != nil || window < 1 {
+			return nil, errors.New("relp: bad relp_window: " + s)
+		}
+	}
+	timeout := defaultTimeout
+	if s := options["relp_timeout"]; s != "" {
+		var err error
+		if timeout, err = time.ParseDuration(s); err != nil || timeout <= 0 {
+			return nil, errors.New("relp: bad relp_timeout: " + s)
+		}
+	}
+	c := &conn{
+		dial: func() (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, timeout)
+		},
+		window:  window,
+		timeout: timeout,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	if err := c.open(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+type frame struct {
+	txnr int
+	data []byte
+}
+
+// conn is a RELP session on top of a TCP connection. It implements net.Conn
+// so it can be used by adapters like any other transport.
+type conn struct {
+	net.Conn
+
+	dial    func() (net.Conn, error)
+	window  int
+	timeout time.Duration
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	txnr    int
+	pending []frame
+	err     error
+	closed  bool
+}
+
+// open connects and opens a session, then sends the messages the previous
+// session left unacknowledged. Must be called with c.mu held, or before the
+// conn is shared.
+func (c *conn) open() error {
+	nc, err := c.dial()
+	if err != nil {
+		return err
+	}
+	nc.SetDeadline(time.Now().Add(c.timeout)) //nolint:errcheck
+	reader := bufio.NewReader(nc)
+	if _, err = fmt.Fprintf(nc, "1 open %d %s\n", len(offers), offers); err != nil {
+		nc.Close()
+		return err
+	}
+	txnr, command, data, err := readFrame(reader)
+	if err == nil && (txnr != 1 || command != "rsp" || !strings.HasPrefix(data, "200")) {
+		err = fmt.Errorf("relp: session refused: %s %s", command, firstLine(data))
+	}
+	if err != nil {
+		nc.Close()
+		return err
+	}
+	nc.SetDeadline(time.Time{}) //nolint:errcheck
+	c.Conn = nc
+	c.txnr = 1
+	c.err = nil
+	go c.readAcks(nc, reader)
+	// transaction numbers start over in the new session
+	for i := range c.pending {
+		c.pending[i].txnr = c.nextTxnr()
+	}
+	for _, f := range c.pending {
+		if err = c.write(f); err != nil {
+			c.err = err
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *conn) nextTxnr() int {
+	c.txnr++
+	if c.txnr > maxTxnr {
+		c.txnr = 1
+	}
+	return c.txnr
+}
+
+// send writes data as a syslog command. Must be called with c.mu held.
+func (c *conn) send(data []byte) error {
+	f := frame{txnr: c.nextTxnr(), data: data}
+	c.pending = append(c.pending, f)
+	return c.write(f)
+}
+
+func (c *conn) write(f frame) error {
+	c.Conn.SetReadDeadline(time.Now().Add(c.timeout)) //nolint:errcheck
+	_, err := fmt.Fprintf(c.Conn, "%d syslog %d %s\n", f.txnr, len(f.data), f.data)
+	return err
+}
+
+// readAcks removes frames from pending as the receiver acknowledges them
+func (c *conn) readAcks(nc net.Conn, reader *bufio.Reader) {
+	for {
+		txnr, command, data, err := readFrame(reader)
+		c.mu.Lock()
+		if c.Conn != nc {
+			c.mu.Unlock()
+			return
+		}
+		switch {
+		case err != nil:
+		case command == "serverclose":
+			err = errors.New("relp: server closed the session")
+		case command != "rsp":
+			err = errors.New("relp: unexpected command " + command)
+		case !strings.HasPrefix(data, "200"):
+			err = fmt.Errorf("relp: message %d not accepted: %s", txnr, firstLine(data))
+		}
+		if err != nil {
+			c.err = err
+			nc.Close()
+			c.cond.Broadcast()
+			c.mu.Unlock()
+			return
+		}
+		for i, f := range c.pending {
+			if f.txnr == txnr {
+				c.pending = append(c.pending[:i], c.pending[i+1:]...)
+				break
+			}
+		}
+		if len(c.pending) == 0 {
+			nc.SetReadDeadline(time.Time{}) //nolint:errcheck
+		}
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	}
+}
+
+// Write sends p as one syslog message. It blocks while the window of
+// unacknowledged messages is full. When the session broke it is reopened once
+// before an error is returned.
+func (c *conn) Write(p []byte) (int, error) {
+	data := make([]byte, len(p))
+	copy(data, p)
+	data = []byte(strings.TrimRight(string(data), "\n"))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.err == nil && !c.closed && len(c.pending) >= c.window {
+		c.cond.Wait()
+	}
+	if c.closed {
+		return 0, errClosed
+	}
+	if c.err != nil {
+		if err := c.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	if len(c.pending) >= c.window {
+		return 0, errors.New("relp: too many unacknowledged messages")
+	}
+	if err := c.send(data); err != nil {
+		c.err = err
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// reopen replaces a broken session. Must be called with c.mu held.
+func (c *conn) reopen() error {
+	cause := c.err
+	c.Conn.Close()
+	if err := c.open(); err != nil {
+		return fmt.Errorf("%v, reconnect failed: %v", cause, err)
+	}
+	return nil
+}
+
+// Close closes the session, waiting up to the timeout for outstanding
+// acknowledgements
+func (c *conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	if c.err == nil {
+		deadline := time.Now().Add(c.timeout)
+		timer := time.AfterFunc(c.timeout, func() {
+			c.mu.Lock()
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		})
+		for c.err == nil && len(c.pending) > 0 && time.Now().Before(deadline) {
+			c.cond.Wait()
+		}
+		timer.Stop()
+		fmt.Fprintf(c.Conn, "%d close 0\n", c.nextTxnr()) //nolint:errcheck
+	}
+	broken := c.err != nil
+	c.closed = true
+	c.cond.Broadcast()
+	nc := c.Conn
+	c.mu.Unlock()
+	if broken {
+		// closed already when the session broke
+		return nil
+	}
+	return nc.Close()
+}
+
+// readFrame reads a RELP frame: TXNR SP COMMAND SP DATALEN [SP DATA] LF
+func readFrame(reader *bufio.Reader) (int, string, string, error) {
+	fields := make([]string, 0, 3)
+	var field []byte
+	var last byte
+	for len(fields) < 3 {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, "", "", err
+		}
+		if b == ' ' || (b == '\n' && len(fields) == 2) {
+			fields = append(fields, string(field))
+			field = field[:0]
+			last = b
+			continue
+		}
+		if len(field) > 32 {
+			return 0, "", "", errors.New("relp: bad frame header")
+		}
+		field = append(field, b)
+	}
+	txnr, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, "", "", errors.New("relp: bad transaction number " + fields[0])
+	}
+	datalen, err := strconv.Atoi(fields[2])
+	if err != nil || datalen < 0 || datalen > maxFrameData {
+		return 0, "", "", errors.New("relp: bad data length " + fields[2])
+	}
+	if last == '\n' {
+		// no data, the trailer has been read already
+		return txnr, fields[1], "", nil
+	}
+	data := make([]byte, datalen+1)
+	if _, err = io.ReadFull(reader, data); err != nil {
+		return 0, "", "", err
+	}
+	if data[datalen] != '\n' {
+		return 0, "", "", errors.New("relp: missing frame trailer")
+	}
+	return txnr, fields[1], string(data[:datalen]), nil
+}
+
+func firstLine(s string) string {
+	return strings.SplitN(s, "\n", 2)[0]
+}
@@ -0,0 +1,146 @@
+package relp
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// relpServer is a RELP receiver acknowledging syslog messages. A session is
+// dropped without acknowledging after dropAfter messages when it is set.
+type relpServer struct {
+	listener  net.Listener
+	dropAfter int
+
+	mu       sync.Mutex
+	messages []string
+	sessions int
+}
+
+func newRelpServer(t *testing.T, dropAfter int) *relpServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &relpServer{listener: listener, dropAfter: dropAfter}
+	go func() {
+		for {
+			nc, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(nc)
+		}
+	}()
+	return s
+}
+
+func (s *relpServer) serve(nc net.Conn) {
+	defer nc.Close()
+	s.mu.Lock()
+	s.sessions++
+	drop := s.dropAfter > 0 && s.sessions == 1
+	s.mu.Unlock()
+	reader := bufio.NewReader(nc)
+	received := 0
+	for {
+		txnr, command, data, err := readFrame(reader)
+		if err != nil {
+			return
+		}
+		switch command {
+		case "open":
+			fmt.Fprintf(nc, "%d rsp 6 200 OK\n", txnr)
+		case "syslog":
+			if drop && received == s.dropAfter {
+				return
+			}
+			received++
+			s.mu.Lock()
+			s.messages = append(s.messages, data)
+			s.mu.Unlock()
+			fmt.Fprintf(nc, "%d rsp 6 200 OK\n", txnr)
+		case "close":
+			fmt.Fprintf(nc, "%d rsp 0\n", txnr)
+			return
+		}
+	}
+}
+
+func (s *relpServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func TestRelpDelivery(t *testing.T) {
+	server := newRelpServer(t, 0)
+	defer server.listener.Close()
+
+	conn, err := new(relpTransport).Dial(server.listener.Addr().String(), map[string]string{"relp_window": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err = conn.Write([]byte(fmt.Sprintf("<14>message %d\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	messages := server.received()
+	if len(messages) != 10 {
+		t.Fatalf("expected 10 messages, got %d", len(messages))
+	}
+	for i, message := range messages {
+		if message != fmt.Sprintf("<14>message %d", i) {
+			t.Errorf("unexpected message %d: %q", i, message)
+		}
+	}
+}
+
+func TestRelpResendsUnacknowledged(t *testing.T) {
+	server := newRelpServer(t, 3)
+	defer server.listener.Close()
+
+	conn, err := new(relpTransport).Dial(server.listener.Addr().String(), map[string]string{"relp_timeout": "2s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		if _, err = conn.Write([]byte(fmt.Sprintf("message %d", i))); err != nil {
+			t.Fatal(err)
+		}
+		// let the acknowledgements of the first session arrive
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	messages := server.received()
+	if got := strings.Join(messages, ","); got != "message 0,message 1,message 2,message 3,message 4,message 5" {
+		t.Errorf("expected every message once and in order, got %s", got)
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("1 rsp 6 200 OK\n2 close 0\n3 rsp 15 200 OK\nline two\n"))
+	for _, expected := range []struct {
+		txnr    int
+		command string
+		data    string
+	}{{1, "rsp", "200 OK"}, {2, "close", ""}, {3, "rsp", "200 OK\nline two"}} {
+		txnr, command, data, err := readFrame(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if txnr != expected.txnr || command != expected.command || data != expected.data {
+			t.Errorf("expected %v, got %d %s %q", expected, txnr, command, data)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"strings"
 
 	"github.com/gliderlabs/logspout/adapters/raw"
+	"github.com/gliderlabs/logspout/cfg"
 	"github.com/gliderlabs/logspout/router"
 )
 
@@ -104,6 +105,8 @@ func createTLSConfig() (tlsConfig *tls.Config, err error) {
 		tlsConfig.CipherSuites = hardenedCiphers
 		tlsConfig.CurvePreferences = hardenedCurvePreferences
 	}
+	// FIPS mode takes precedence over the hardened settings
+	cfg.RestrictTLS(tlsConfig)
 
 	// load possible TLS CA chain(s) for server certificate validation
 	// starting with an empty pool
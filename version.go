@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// Commit is the git commit logspout was built from, set like Version with
+// -ldflags "-X main.Commit=..."
+var Commit string
+
+func init() {
+	router.Version = Version
+	router.HTTPHandlers.Register(versionHandler, "version")
+}
+
+// versionString returns the version, followed by the commit when known
+func versionString() string {
+	if Commit == "" {
+		return Version
+	}
+	return Version + " (" + Commit + ")"
+}
+
+// versionHandler returns a http.Handler for the version and platform of the
+// build
+func versionHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{ //nolint:errcheck
+			"version":  Version,
+			"commit":   Commit,
+			"go":       runtime.Version(),
+			"platform": runtime.GOOS + "/" + runtime.GOARCH,
+		})
+	})
+}